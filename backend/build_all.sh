@@ -6,7 +6,16 @@ echo "🔨 Rebuilding Go Lambda functions using 'provided.al2'..."
 
 for dir in components/AIChat components/Auth components/ChatHistory; do
   echo "📁 Building: $dir"
-  (cd "$dir" && GOOS=linux GOARCH=amd64 go build -o bootstrap main.go)
+  (cd "$dir" && GOOS=linux GOARCH=amd64 go build -o bootstrap .)
 done
 
+echo "📁 Building: components/AIChat/cmd/worker"
+(cd components/AIChat && GOOS=linux GOARCH=amd64 go build -o cmd/worker/bootstrap ./cmd/worker)
+
+echo "📁 Building: components/AIChat/cmd/websocket"
+(cd components/AIChat && GOOS=linux GOARCH=amd64 go build -o cmd/websocket/bootstrap ./cmd/websocket)
+
+echo "📁 Building: components/AIChat/cmd/purge"
+(cd components/AIChat && GOOS=linux GOARCH=amd64 go build -o cmd/purge/bootstrap ./cmd/purge)
+
 echo "✅ All bootstrap binaries successfully built."
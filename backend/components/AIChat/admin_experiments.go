@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const adminExperimentsPath = "/api/AIchat/admin/experiments"
+
+// lambdaAdminExperiments serves the A/B experiment API:
+//
+//	GET    /admin/experiments              list
+//	POST   /admin/experiments              create (status defaults to draft)
+//	GET    /admin/experiments/{id}         definition
+//	PUT    /admin/experiments/{id}         replace definition / change status
+//	DELETE /admin/experiments/{id}         delete definition and outcomes
+//	GET    /admin/experiments/{id}/report  per-variant outcomes
+func lambdaAdminExperiments(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAdmin(req) {
+		return errorResponse(403, "Admin access required"), nil
+	}
+	if services.Experiments == nil {
+		return errorResponse(503, "Experiment store not configured"), nil
+	}
+	ctx := context.Background()
+	rest := strings.Trim(strings.TrimPrefix(req.Path, adminExperimentsPath), "/")
+	parts := strings.Split(rest, "/")
+	id := parts[0]
+
+	switch {
+	case id == "" && req.HTTPMethod == "GET":
+		page, err := services.Experiments.ListExperiments(ctx, queryLimit(req, 50), req.QueryStringParameters["nextToken"])
+		if err != nil {
+			return pageErrorResponse(err), nil
+		}
+		return jsonResponse(200, page), nil
+
+	case id == "" && req.HTTPMethod == "POST":
+		e, errResp := decodeExperiment(req, "")
+		if errResp != nil {
+			return *errResp, nil
+		}
+		created, err := services.Experiments.CreateExperiment(ctx, e)
+		if err != nil {
+			return experimentErrorResponse(err), nil
+		}
+		return jsonResponse(201, created), nil
+
+	case len(parts) == 1 && req.HTTPMethod == "GET":
+		e, err := services.Experiments.GetExperiment(ctx, id)
+		if err != nil {
+			return experimentErrorResponse(err), nil
+		}
+		return jsonResponse(200, e), nil
+
+	case len(parts) == 1 && req.HTTPMethod == "PUT":
+		e, errResp := decodeExperiment(req, id)
+		if errResp != nil {
+			return *errResp, nil
+		}
+		updated, err := services.Experiments.UpdateExperiment(ctx, e)
+		if err != nil {
+			return experimentErrorResponse(err), nil
+		}
+		return jsonResponse(200, updated), nil
+
+	case len(parts) == 1 && req.HTTPMethod == "DELETE":
+		if err := services.Experiments.DeleteExperiment(ctx, id); err != nil {
+			return experimentErrorResponse(err), nil
+		}
+		return jsonResponse(200, map[string]string{"experimentId": id}), nil
+
+	case len(parts) == 2 && parts[1] == "report" && req.HTTPMethod == "GET":
+		e, err := services.Experiments.GetExperiment(ctx, id)
+		if err != nil {
+			return experimentErrorResponse(err), nil
+		}
+		outcomes, err := services.Experiments.Outcomes(ctx, id)
+		if err != nil {
+			return errorResponse(500, err.Error()), nil
+		}
+		return jsonResponse(200, map[string]interface{}{
+			"experiment": e,
+			"variants":   e.Report(outcomes),
+		}), nil
+	}
+	return errorResponse(404, "Route not found"), nil
+}
+
+// decodeExperiment parses and validates an experiment body. The store
+// enforces that only one experiment runs at a time.
+func decodeExperiment(req events.APIGatewayProxyRequest, pathID string) (services.Experiment, *events.APIGatewayProxyResponse) {
+	var e services.Experiment
+	if err := json.Unmarshal([]byte(req.Body), &e); err != nil {
+		resp := errorResponse(400, "Invalid JSON body")
+		return e, &resp
+	}
+	if pathID != "" {
+		e.ID = pathID
+	}
+	if e.Status == "" {
+		e.Status = services.ExperimentDraft
+	}
+	if e.Unit == "" {
+		e.Unit = services.UnitUser
+	}
+	if err := e.Validate(); err != nil {
+		resp := errorResponse(400, err.Error())
+		return e, &resp
+	}
+	return e, nil
+}
+
+func experimentErrorResponse(err error) events.APIGatewayProxyResponse {
+	switch {
+	case errors.Is(err, services.ErrExperimentNotFound):
+		return errorResponse(404, err.Error())
+	case errors.Is(err, services.ErrExperimentExists), errors.Is(err, services.ErrExperimentRunning):
+		return errorResponse(409, err.Error())
+	}
+	return errorResponse(500, err.Error())
+}
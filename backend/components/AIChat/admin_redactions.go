@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const adminRedactionsPath = "/api/AIchat/admin/redactions"
+
+// lambdaAdminListRedactions serves GET /admin/redactions with optional
+// since, until (RFC3339), limit and nextToken. Events carry per-recognizer
+// counts only; the redacted values are never stored.
+func lambdaAdminListRedactions(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAdmin(req) {
+		return errorResponse(403, "Admin access required"), nil
+	}
+	if req.HTTPMethod != "GET" {
+		return errorResponse(404, "Route not found"), nil
+	}
+	if services.RedactionEvents == nil {
+		return errorResponse(503, "Redaction log not configured"), nil
+	}
+	q := req.QueryStringParameters
+	var since, until time.Time
+	for name, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := q[name]; v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return errorResponse(400, name+" must be an RFC3339 timestamp"), nil
+			}
+			*dst = t
+		}
+	}
+	page, err := services.RedactionEvents.ListRedactions(context.Background(), since, until, queryLimit(req, 50), q["nextToken"])
+	if err != nil {
+		return pageErrorResponse(err), nil
+	}
+	return jsonResponse(200, page), nil
+}
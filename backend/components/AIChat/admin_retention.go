@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const adminRetentionPath = "/api/AIchat/admin/retention"
+
+// lambdaAdminRetention serves the retention policy API:
+//
+//	GET    /admin/retention               list
+//	GET    /admin/retention/{scope}/{id}  policy
+//	PUT    /admin/retention/{scope}/{id}  create or replace {days, members}
+//	DELETE /admin/retention/{scope}/{id}  delete
+//
+// scope is user, group or course. Changes reach existing chats on the next
+// purge run, which re-stamps their expiry.
+func lambdaAdminRetention(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAdmin(req) {
+		return errorResponse(403, "Admin access required"), nil
+	}
+	if services.Retention == nil {
+		return errorResponse(503, "Retention policies need the DynamoDB backend"), nil
+	}
+	ctx := context.Background()
+	rest := strings.Trim(strings.TrimPrefix(req.Path, adminRetentionPath), "/")
+
+	if rest == "" {
+		if req.HTTPMethod != "GET" {
+			return errorResponse(404, "Route not found"), nil
+		}
+		page, err := services.Retention.ListPolicies(ctx, queryLimit(req, 50), req.QueryStringParameters["nextToken"])
+		if err != nil {
+			return pageErrorResponse(err), nil
+		}
+		return jsonResponse(200, page), nil
+	}
+	scope, id, ok := strings.Cut(rest, "/")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		return errorResponse(404, "Route not found"), nil
+	}
+
+	switch req.HTTPMethod {
+	case "GET":
+		p, err := services.Retention.GetPolicy(ctx, scope, id)
+		if err != nil {
+			return retentionErrorResponse(err), nil
+		}
+		return jsonResponse(200, p), nil
+
+	case "PUT":
+		var p services.RetentionPolicy
+		if err := json.Unmarshal([]byte(req.Body), &p); err != nil {
+			return errorResponse(400, "Invalid JSON body"), nil
+		}
+		p.Scope, p.ID = scope, id
+		if err := p.Validate(); err != nil {
+			return errorResponse(400, err.Error()), nil
+		}
+		saved, err := services.Retention.PutPolicy(ctx, p)
+		if err != nil {
+			return retentionErrorResponse(err), nil
+		}
+		return jsonResponse(200, saved), nil
+
+	case "DELETE":
+		if err := services.Retention.DeletePolicy(ctx, scope, id); err != nil {
+			return retentionErrorResponse(err), nil
+		}
+		return jsonResponse(200, map[string]string{"scope": scope, "id": id}), nil
+	}
+	return errorResponse(404, "Route not found"), nil
+}
+
+func retentionErrorResponse(err error) events.APIGatewayProxyResponse {
+	if errors.Is(err, services.ErrPolicyNotFound) {
+		return errorResponse(404, err.Error())
+	}
+	return errorResponse(500, err.Error())
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const adminTemplatesPath = "/api/AIchat/admin/templates"
+
+// lambdaAdminTemplates serves the prompt-template CRUD API:
+//
+//	GET    /admin/templates                 list (latest version of each)
+//	POST   /admin/templates                 create version 1
+//	GET    /admin/templates/{id}            latest, or ?version=N
+//	GET    /admin/templates/{id}/versions   version history, newest first
+//	PUT    /admin/templates/{id}            publish a new version
+//	POST   /admin/templates/{id}/render     preview with {"variables": {...}}
+//	DELETE /admin/templates/{id}            delete (history is kept)
+func lambdaAdminTemplates(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAdmin(req) {
+		return errorResponse(403, "Admin access required"), nil
+	}
+	if services.Templates == nil {
+		return errorResponse(503, "Template store not configured"), nil
+	}
+	ctx := context.Background()
+	rest := strings.Trim(strings.TrimPrefix(req.Path, adminTemplatesPath), "/")
+	parts := strings.Split(rest, "/")
+	id := parts[0]
+
+	switch {
+	case id == "" && req.HTTPMethod == "GET":
+		page, err := services.Templates.ListTemplates(ctx, queryLimit(req, 50), req.QueryStringParameters["nextToken"])
+		if err != nil {
+			return pageErrorResponse(err), nil
+		}
+		return jsonResponse(200, page), nil
+
+	case id == "" && req.HTTPMethod == "POST":
+		t, errResp := decodeTemplate(req, "")
+		if errResp != nil {
+			return *errResp, nil
+		}
+		created, err := services.Templates.CreateTemplate(ctx, t)
+		if err != nil {
+			return templateErrorResponse(err), nil
+		}
+		return jsonResponse(201, created), nil
+
+	case len(parts) == 1 && req.HTTPMethod == "GET":
+		version := 0
+		if v := req.QueryStringParameters["version"]; v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return errorResponse(400, "version must be a positive integer"), nil
+			}
+			version = n
+		}
+		t, err := services.Templates.GetTemplate(ctx, id, version)
+		if err != nil {
+			return templateErrorResponse(err), nil
+		}
+		return jsonResponse(200, t), nil
+
+	case len(parts) == 2 && parts[1] == "versions" && req.HTTPMethod == "GET":
+		page, err := services.Templates.ListTemplateVersions(ctx, id, queryLimit(req, 20), req.QueryStringParameters["nextToken"])
+		if err != nil {
+			return pageErrorResponse(err), nil
+		}
+		return jsonResponse(200, page), nil
+
+	case len(parts) == 1 && req.HTTPMethod == "PUT":
+		t, errResp := decodeTemplate(req, id)
+		if errResp != nil {
+			return *errResp, nil
+		}
+		updated, err := services.Templates.AddTemplateVersion(ctx, t)
+		if err != nil {
+			return templateErrorResponse(err), nil
+		}
+		return jsonResponse(200, updated), nil
+
+	case len(parts) == 2 && parts[1] == "render" && req.HTTPMethod == "POST":
+		var body struct {
+			Version   int            `json:"version"`
+			Variables map[string]any `json:"variables"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			return errorResponse(400, "Invalid JSON body"), nil
+		}
+		t, err := services.ResolveTemplate(ctx, id, body.Version)
+		if err != nil {
+			return templateErrorResponse(err), nil
+		}
+		text, err := t.Render(body.Variables)
+		if err != nil {
+			return templateErrorResponse(err), nil
+		}
+		return jsonResponse(200, map[string]interface{}{
+			"templateId":      t.ID,
+			"templateVersion": t.Version,
+			"text":            text,
+		}), nil
+
+	case len(parts) == 1 && req.HTTPMethod == "DELETE":
+		if err := services.Templates.DeleteTemplate(ctx, id); err != nil {
+			return templateErrorResponse(err), nil
+		}
+		return jsonResponse(200, map[string]string{"templateId": id}), nil
+	}
+	return errorResponse(404, "Route not found"), nil
+}
+
+// decodeTemplate parses and validates a template body. pathID, when set,
+// overrides any id in the body.
+func decodeTemplate(req events.APIGatewayProxyRequest, pathID string) (services.PromptTemplate, *events.APIGatewayProxyResponse) {
+	var t services.PromptTemplate
+	if err := json.Unmarshal([]byte(req.Body), &t); err != nil {
+		resp := errorResponse(400, "Invalid JSON body")
+		return t, &resp
+	}
+	if pathID != "" {
+		t.ID = pathID
+	}
+	if t.Variables == nil {
+		t.Variables = []services.TemplateVariable{}
+	}
+	t.CreatedBy = callerID(req)
+	if err := t.Validate(); err != nil {
+		resp := errorResponse(400, err.Error())
+		return t, &resp
+	}
+	return t, nil
+}
+
+func templateErrorResponse(err error) events.APIGatewayProxyResponse {
+	var missing *services.MissingVariablesError
+	switch {
+	case errors.As(err, &missing):
+		return jsonResponse(400, map[string]interface{}{"error": err.Error(), "missingVariables": missing.Names})
+	case errors.Is(err, services.ErrTemplateNotFound):
+		return errorResponse(404, err.Error())
+	case errors.Is(err, services.ErrTemplateExists), errors.Is(err, services.ErrTemplateConflict):
+		return errorResponse(409, err.Error())
+	case errors.Is(err, services.ErrInvalidVariable):
+		return errorResponse(400, err.Error())
+	}
+	return errorResponse(500, err.Error())
+}
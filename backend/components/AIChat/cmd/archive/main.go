@@ -0,0 +1,51 @@
+// Command archive is the scheduled Lambda that moves conversations without
+// activity for ARCHIVE_AFTER_MONTHS months (default 6) to the ARCHIVE_BUCKET
+// object store (services.ArchiveInactive). They are put back when opened.
+// With -once it runs a single pass and exits, for local use against MinIO:
+//
+//	TABLE_NAME=... ARCHIVE_BUCKET=chat-archive ARCHIVE_ENDPOINT=http://localhost:9000 \
+//	AWS_ACCESS_KEY_ID=minioadmin AWS_SECRET_ACCESS_KEY=minioadmin go run ./cmd/archive -once
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/joho/godotenv"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+func main() {
+	once := flag.Bool("once", false, "archive once and exit instead of starting the Lambda handler")
+	flag.Parse()
+
+	_ = godotenv.Load(".env")
+	if err := services.InitDAL(); err != nil {
+		panic("DAL init failed: " + err.Error())
+	}
+	if *once {
+		if err := handler(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	lambda.Start(handler)
+}
+
+func handler(ctx context.Context) error {
+	if services.Archive == nil {
+		log.Printf("📦 ARCHIVE_BUCKET is not set, nothing to do")
+		return nil
+	}
+	months, err := services.ArchiveAfter()
+	if err != nil {
+		return err
+	}
+	res, err := services.ArchiveInactive(ctx, time.Now().AddDate(0, -months, 0))
+	log.Printf("📦 Archived %d conversation(s) with %d message(s)", res.Conversations, res.Messages)
+	return err
+}
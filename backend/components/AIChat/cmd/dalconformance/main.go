@@ -0,0 +1,73 @@
+// Command dalconformance runs the DAL conformance suite against one storage
+// backend, configured as for the service (see services.InitDAL):
+//
+//	go run ./cmd/dalconformance -backend memory
+//	go run ./cmd/dalconformance -backend sqlite           # temporary database unless SQLITE_PATH is set
+//	DATABASE_URL=postgres://... go run ./cmd/dalconformance -backend postgres
+//
+// Against DynamoDB Local (docker run -p 8000:8000 amazon/dynamodb-local):
+//
+//	AWS_ENDPOINT_URL_DYNAMODB=http://localhost:8000 AWS_REGION=us-east-1 \
+//	AWS_ACCESS_KEY_ID=local AWS_SECRET_ACCESS_KEY=local TABLE_NAME=conformance \
+//	go run ./cmd/dalconformance -backend dynamo -create-table
+//
+// It exits non-zero if any case fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services/dalconformance"
+)
+
+func main() {
+	backend := flag.String("backend", "memory", "memory, sqlite, postgres or dynamo")
+	run := flag.String("run", "", "only run cases whose name contains this")
+	createTable := flag.Bool("create-table", false, "dynamo: create TABLE_NAME with the chat key schema if it does not exist")
+	flag.Parse()
+
+	ctx := context.Background()
+	os.Setenv("DAL_BACKEND", *backend)
+	switch *backend {
+	case "sqlite":
+		if os.Getenv("SQLITE_PATH") == "" {
+			dir, err := os.MkdirTemp("", "dalconformance")
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			os.Setenv("SQLITE_PATH", filepath.Join(dir, "conformance.db"))
+		}
+	case "dynamo":
+		if *createTable {
+			if err := services.EnsureTable(ctx, os.Getenv("TABLE_NAME")); err != nil {
+				log.Fatalf("❌ Creating table: %v", err)
+			}
+		}
+	}
+	if err := services.InitDAL(); err != nil {
+		log.Fatalf("❌ DAL init failed: %v", err)
+	}
+
+	failed := 0
+	for _, r := range dalconformance.Run(ctx, services.Store, *run) {
+		if r.Err != nil {
+			failed++
+			fmt.Printf("❌ %s (%v)\n   %v\n", r.Name, r.Duration.Round(time.Millisecond), r.Err)
+			continue
+		}
+		fmt.Printf("✅ %s (%v)\n", r.Name, r.Duration.Round(time.Millisecond))
+	}
+	if failed > 0 {
+		fmt.Printf("\n%d case(s) failed on %s\n", failed, *backend)
+		os.Exit(1)
+	}
+	fmt.Printf("\nAll cases passed on %s\n", *backend)
+}
@@ -0,0 +1,52 @@
+// Command import loads a user's history from another chat tool, for
+// exports too large to upload through the API:
+//
+//	DAL_BACKEND=sqlite go run ./cmd/import -user u123 -format chatgpt conversations.json
+//	TABLE_NAME=... go run ./cmd/import -user u123 -format jsonl history.jsonl
+//
+// The formats are described on services.ImportChatGPT and
+// services.ImportJSONL. Running it again on the same file only adds what
+// is missing, so an interrupted import can simply be restarted.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+func main() {
+	user := flag.String("user", "", "user ID to import the conversations for")
+	format := flag.String("format", services.ImportChatGPT, "file format: chatgpt or jsonl")
+	flag.Parse()
+	if *user == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	_ = godotenv.Load(".env")
+	if err := services.InitDAL(); err != nil {
+		log.Fatalf("❌ DAL init failed: %v", err)
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer f.Close()
+
+	p, err := services.Import(context.Background(), *user, f, *format, func(p services.ImportProgress) {
+		if p.Conversations%50 == 0 && !p.Done {
+			log.Printf("📥 %d conversation(s) read, %d message(s) stored", p.Conversations, p.Messages)
+		}
+	})
+	log.Printf("📥 Read %d conversation(s): %d new, %d skipped; stored %d message(s), %d already imported",
+		p.Conversations, p.Created, p.Skipped, p.Messages, p.Duplicates)
+	if err != nil {
+		log.Fatalf("❌ Import failed: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Command migrate runs one-off data migrations for the DynamoDB table;
+// the SQL backends migrate themselves on startup.
+//
+// -activity fills in conversation activity (messageCount, token totals,
+// lastMessageAt, lastMessagePreview) and the GSI2 keys ListConversations
+// queries, for headers written before they existed. Add the GSI2 index
+// (GSI2PK/GSI2SK, projection ALL) to the table first:
+//
+//	TABLE_NAME=... go run ./cmd/migrate -activity
+//
+// -message-ids stores messageId on messages written before it was an
+// attribute, so GSI3 (PK/messageId, keys only) can find them:
+//
+//	TABLE_NAME=... go run ./cmd/migrate -message-ids
+//
+// -message-keys rewrites message sort keys (SK, GSI1SK) written with
+// variable-width RFC3339Nano timestamps to the fixed-width form, so that
+// whole-second messages list in time order. Run it right after deploying;
+// until it finishes, edits and deletes of older messages miss their items:
+//
+//	TABLE_NAME=... go run ./cmd/migrate -message-keys
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/joho/godotenv"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+func main() {
+	activity := flag.Bool("activity", false, "backfill conversation activity and GSI2 keys")
+	messageIDs := flag.Bool("message-ids", false, "backfill the messageId attribute for GSI3")
+	messageKeys := flag.Bool("message-keys", false, "rewrite message sort keys to fixed-width timestamps")
+	flag.Parse()
+
+	_ = godotenv.Load(".env")
+	if err := services.InitDAL(); err != nil {
+		log.Fatalf("❌ DAL init failed: %v", err)
+	}
+	if !*activity && !*messageIDs && !*messageKeys {
+		flag.Usage()
+		return
+	}
+	if *activity {
+		n, err := services.BackfillConversationActivity(context.Background())
+		log.Printf("🔁 Backfilled activity for %d conversation(s)", n)
+		if err != nil {
+			log.Fatalf("❌ Backfill failed: %v", err)
+		}
+	}
+	if *messageIDs {
+		n, err := services.BackfillMessageIDs(context.Background())
+		log.Printf("🔁 Backfilled messageId for %d message(s)", n)
+		if err != nil {
+			log.Fatalf("❌ Backfill failed: %v", err)
+		}
+	}
+	if *messageKeys {
+		n, err := services.BackfillMessageKeys(context.Background())
+		log.Printf("🔁 Rewrote the sort keys of %d message(s)", n)
+		if err != nil {
+			log.Fatalf("❌ Backfill failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Command mockllm is a local stand-in for the OpenAI and Azure OpenAI chat
+// completions APIs. It answers "You said: <last user message>" and reports
+// word-count usage, streaming word by word when asked, so the real HTTP
+// client can be exercised offline:
+//
+//	go run ./cmd/mockllm -addr :8089
+//	OPENAI_API_KEY=test OPENAI_BASE_URL=http://localhost:8089/v1 ...
+//	AI_PROVIDER=azure AZURE_OPENAI_ENDPOINT=http://localhost:8089 AZURE_OPENAI_API_KEY=test ...
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+func main() {
+	addr := flag.String("addr", ":8089", "listen address")
+	flag.Parse()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			httpError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		complete(w, r, "")
+	})
+	mux.HandleFunc("POST /openai/deployments/{deployment}/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("api-key") == "" {
+			httpError(w, http.StatusUnauthorized, "missing api-key header")
+			return
+		}
+		if r.URL.Query().Get("api-version") == "" {
+			httpError(w, http.StatusBadRequest, "missing api-version query parameter")
+			return
+		}
+		complete(w, r, r.PathValue("deployment"))
+	})
+
+	log.Printf("🧪 Mock chat completions API listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+// complete answers like the fake provider. Azure reports the deployment's
+// model; here that is the deployment name.
+func complete(w http.ResponseWriter, r *http.Request, deployment string) {
+	var req services.ChatGPTRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := (&services.FakeProvider{}).Complete(r.Context(), services.CompletionRequest{
+		Model:    req.Model,
+		Messages: req.Messages,
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deployment != "" {
+		res.Model = deployment
+	}
+	log.Printf("📥 %s model=%q stream=%v org=%q project=%q", r.URL.Path, req.Model, req.Stream, r.Header.Get("OpenAI-Organization"), r.Header.Get("OpenAI-Project"))
+
+	if req.Stream {
+		stream(w, res)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(services.ChatGPTResponse{
+		Model:   res.Model,
+		Choices: []services.Choice{{Message: services.Message{Role: "assistant", Content: res.Content}}},
+		Usage:   services.Usage{PromptTokens: res.PromptTokens, CompletionTokens: res.CompletionTokens},
+	})
+}
+
+// stream writes res as server-sent events: one chunk per word, then a usage
+// chunk and [DONE].
+func stream(w http.ResponseWriter, res services.CompletionResult) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	send := func(v any) {
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	type delta struct {
+		Delta services.Message `json:"delta"`
+	}
+	for _, word := range strings.SplitAfter(res.Content, " ") {
+		if word == "" {
+			continue
+		}
+		send(map[string]any{"model": res.Model, "choices": []delta{{Delta: services.Message{Content: word}}}})
+		time.Sleep(20 * time.Millisecond)
+	}
+	send(map[string]any{"model": res.Model, "choices": []delta{}, "usage": services.Usage{PromptTokens: res.PromptTokens, CompletionTokens: res.CompletionTokens}})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": msg}})
+}
@@ -0,0 +1,50 @@
+// Command purge is the scheduled Lambda that empties the conversation
+// trash: conversations deleted more than TRASH_RETENTION_DAYS ago are
+// removed with all of their messages (services.PurgeTrash). On DynamoDB it
+// then applies the retention policies (services.PurgeExpired) and deletes
+// archive objects whose conversation has gone (services.PurgeArchives),
+// such as those of stubs the retention TTL removed. With -once it
+// runs a single purge and exits, for local use:
+//
+//	DAL_BACKEND=sqlite go run ./cmd/purge -once
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/joho/godotenv"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+func main() {
+	once := flag.Bool("once", false, "purge once and exit instead of starting the Lambda handler")
+	flag.Parse()
+
+	_ = godotenv.Load(".env")
+	if err := services.InitDAL(); err != nil {
+		panic("DAL init failed: " + err.Error())
+	}
+	if *once {
+		if err := handler(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	lambda.Start(handler)
+}
+
+func handler(ctx context.Context) error {
+	n, err := services.PurgeTrash(ctx)
+	log.Printf("🗑️ Purged %d conversation(s) from the trash", n)
+	res, rerr := services.PurgeExpired(ctx)
+	log.Printf("🗑️ Retention: re-stamped %d user(s), purged %d conversation(s) and %d message(s)",
+		res.Backfilled, res.Conversations, res.Messages)
+	orphans, aerr := services.PurgeArchives(ctx)
+	log.Printf("📦 Deleted %d orphaned archive(s)", orphans)
+	return errors.Join(err, rerr, aerr)
+}
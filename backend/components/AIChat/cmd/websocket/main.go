@@ -0,0 +1,223 @@
+// Command websocket is the WebSocket chat API. As a Lambda it serves the
+// API Gateway WebSocket routes $connect, $disconnect, sendMessage and typing
+// ($default also accepts any action). $connect is guarded by
+// cmd/wsauthorizer, which supplies the user ID from the Cognito token. With
+// -local it runs a plain WebSocket server instead, keeping connections in
+// memory and trusting ?userId= for development:
+//
+//	go run ./cmd/websocket -local :5005
+//	websocat 'ws://localhost:5005/ws?userId=u1'
+//	{"action": "sendMessage", "conversationId": "...", "content": "hi"}
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/gorilla/websocket"
+	"github.com/joho/godotenv"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+func main() {
+	local := flag.String("local", "", "serve WebSockets on this address instead of running as a Lambda")
+	flag.Parse()
+
+	_ = godotenv.Load(".env")
+	if err := services.InitDAL(); err != nil {
+		panic("DAL init failed: " + err.Error())
+	}
+	if err := services.InitProvider(); err != nil {
+		panic("AI provider init failed: " + err.Error())
+	}
+	if *local != "" {
+		serveLocal(*local)
+		return
+	}
+	if err := services.InitRealtime(); err != nil {
+		panic("Realtime init failed: " + err.Error())
+	}
+	lambda.Start(handler)
+}
+
+// ========== Lambda ==========
+
+var pusherOnce sync.Once
+
+func handler(ctx context.Context, req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	rc := req.RequestContext
+	// Without WEBSOCKET_ENDPOINT, push back through the API that called us.
+	pusherOnce.Do(func() {
+		if services.Sockets != nil {
+			return
+		}
+		p, err := services.NewAPIGatewayPusher(ctx, "https://"+rc.DomainName+"/"+rc.Stage)
+		if err != nil {
+			log.Printf("❌ Failed to create WebSocket pusher: %v", err)
+			return
+		}
+		services.Sockets = p
+	})
+
+	switch rc.RouteKey {
+	case "$connect":
+		userID := socketUserID(req)
+		if userID == "" {
+			return events.APIGatewayProxyResponse{StatusCode: 401, Body: "Unauthorized"}, nil
+		}
+		err := services.Connections.PutConnection(ctx, services.Connection{ID: rc.ConnectionID, UserID: userID, ConnectedAt: time.Now().UTC()})
+		if err != nil {
+			log.Printf("❌ Failed to register connection %s: %v", rc.ConnectionID, err)
+			return events.APIGatewayProxyResponse{StatusCode: 500}, nil
+		}
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+
+	case "$disconnect":
+		if err := services.Connections.DeleteConnection(ctx, rc.ConnectionID); err != nil {
+			log.Printf("⚠️ Failed to remove connection %s: %v", rc.ConnectionID, err)
+		}
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	}
+
+	conn, err := services.Connections.GetConnection(ctx, rc.ConnectionID)
+	if err != nil {
+		log.Printf("⚠️ Message on unknown connection %s: %v", rc.ConnectionID, err)
+		return events.APIGatewayProxyResponse{StatusCode: 403}, nil
+	}
+	services.HandleSocketMessage(ctx, conn, req.Body)
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
+
+// socketUserID is the identity the $connect authorizer vouched for. A
+// client-supplied ?userId= is never trusted here; only serveLocal reads it.
+func socketUserID(req events.APIGatewayWebsocketProxyRequest) string {
+	if auth, ok := req.RequestContext.Authorizer.(map[string]interface{}); ok {
+		for _, k := range []string{"sub", "principalId"} {
+			if v, _ := auth[k].(string); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// ========== Local server ==========
+
+// localHub is both the connection store and the pusher for -local mode.
+type localHub struct {
+	mu    sync.Mutex
+	conns map[string]*localConn
+}
+
+type localConn struct {
+	services.Connection
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+}
+
+func (h *localHub) PutConnection(ctx context.Context, c services.Connection) error {
+	return nil // registered by serveWS, which owns the socket
+}
+
+func (h *localHub) GetConnection(ctx context.Context, id string) (services.Connection, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := h.conns[id]; ok {
+		return c.Connection, nil
+	}
+	return services.Connection{}, services.ErrConnectionNotFound
+}
+
+func (h *localHub) DeleteConnection(ctx context.Context, id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, id)
+	return nil
+}
+
+func (h *localHub) ListUserConnections(ctx context.Context, userID string) ([]services.Connection, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []services.Connection
+	for _, c := range h.conns {
+		if c.UserID == userID {
+			out = append(out, c.Connection)
+		}
+	}
+	return out, nil
+}
+
+func (h *localHub) Push(ctx context.Context, connectionID string, payload []byte) error {
+	h.mu.Lock()
+	c, ok := h.conns[connectionID]
+	h.mu.Unlock()
+	if !ok {
+		return services.ErrConnectionGone
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+		return services.ErrConnectionGone
+	}
+	return nil
+}
+
+var upgrader = websocket.Upgrader{
+	// Local development only: the frontend runs on another port.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func serveLocal(addr string) {
+	hub := &localHub{conns: map[string]*localConn{}}
+	services.Connections = hub
+	services.Sockets = hub
+
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			http.Error(w, "Missing userId", http.StatusUnauthorized)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("❌ Upgrade failed: %v", err)
+			return
+		}
+		c := &localConn{
+			Connection: services.Connection{ID: services.GenerateULID(), UserID: userID, ConnectedAt: time.Now().UTC()},
+			ws:         ws,
+		}
+		hub.mu.Lock()
+		hub.conns[c.ID] = c
+		hub.mu.Unlock()
+		log.Printf("🔌 %s connected as %s", userID, c.ID)
+
+		defer func() {
+			_ = hub.DeleteConnection(context.Background(), c.ID)
+			ws.Close()
+			log.Printf("🔌 %s disconnected", c.ID)
+		}()
+		for {
+			_, body, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			// Actions run concurrently so typing events flow during a long answer.
+			go services.HandleSocketMessage(context.Background(), c.Connection, string(body))
+		}
+	})
+
+	log.Printf("🌐 Local WebSocket server on %s/ws", addr)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		log.Println("❌ Server error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,48 @@
+// Command worker is the generation worker Lambda. SQS delivers job IDs
+// queued by the AIChat send endpoint in async mode; each is answered with
+// services.ProcessJob. Failed records are reported individually so only
+// they are redelivered (the event source mapping must enable
+// ReportBatchItemFailures).
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/joho/godotenv"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+func main() {
+	_ = godotenv.Load(".env")
+	if err := services.InitDAL(); err != nil {
+		panic("DAL init failed: " + err.Error())
+	}
+	if err := services.InitProvider(); err != nil {
+		panic("AI provider init failed: " + err.Error())
+	}
+	if err := services.InitRealtime(); err != nil {
+		panic("Realtime init failed: " + err.Error())
+	}
+	lambda.Start(handler)
+}
+
+func handler(ctx context.Context, ev events.SQSEvent) (events.SQSEventResponse, error) {
+	var resp events.SQSEventResponse
+	for _, rec := range ev.Records {
+		jobID, err := services.ParseJobMessage(rec.Body)
+		if err != nil {
+			// A malformed message will never succeed; drop it.
+			log.Printf("⚠️ Skipping message %s: %v", rec.MessageId, err)
+			continue
+		}
+		if err := services.ProcessJob(ctx, jobID); err != nil {
+			log.Printf("❌ Job %s: %v", jobID, err)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: rec.MessageId})
+		}
+	}
+	return resp, nil
+}
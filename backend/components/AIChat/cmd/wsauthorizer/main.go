@@ -0,0 +1,107 @@
+// Command wsauthorizer is the Lambda REQUEST authorizer for the WebSocket
+// API's $connect route. Browsers cannot set headers on a WebSocket, so the
+// Cognito token comes in the query string:
+//
+//	wss://<api>.execute-api.<region>.amazonaws.com/Prod?token=<id or access token>
+//
+// A valid token's sub becomes the connection's principalId, which
+// cmd/websocket takes as the user ID.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	jwks     *keyfunc.JWKS
+	issuer   = os.Getenv("COGNITO_ISSUER")
+	audience = os.Getenv("COGNITO_AUDIENCE")
+)
+
+// errUnauthorized makes API Gateway answer 401; any other error is a 500.
+var errUnauthorized = errors.New("Unauthorized")
+
+func init() {
+	jwksURL := os.Getenv("COGNITO_JWKS_URL")
+	if jwksURL == "" {
+		log.Fatal("COGNITO_JWKS_URL not set in environment")
+	}
+
+	var err error
+	jwks, err = keyfunc.Get(jwksURL, keyfunc.Options{
+		RefreshInterval: time.Hour,
+	})
+	if err != nil {
+		log.Fatalf("Failed to get JWKS: %v", err)
+	}
+}
+
+func handler(ctx context.Context, req events.APIGatewayCustomAuthorizerRequestTypeRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+	sub, err := verify(req.QueryStringParameters["token"])
+	if err != nil {
+		log.Printf("⚠️ Rejected WebSocket connect: %v", err)
+		return events.APIGatewayCustomAuthorizerResponse{}, errUnauthorized
+	}
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID: sub,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version: "2012-10-17",
+			Statement: []events.IAMPolicyStatement{{
+				Action:   []string{"execute-api:Invoke"},
+				Effect:   "Allow",
+				Resource: []string{req.MethodArn},
+			}},
+		},
+		Context: map[string]interface{}{"sub": sub},
+	}, nil
+}
+
+// verify checks a Cognito ID or access token and returns its subject. ID
+// tokens carry the app client in aud, access tokens in client_id.
+func verify(tokenStr string) (string, error) {
+	if tokenStr == "" {
+		return "", errors.New("missing token")
+	}
+	token, err := jwt.Parse(tokenStr, jwks.Keyfunc, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil || !token.Valid {
+		return "", fmt.Errorf("invalid token: %v", err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", errors.New("invalid claims")
+	}
+	if issuer != "" && !claims.VerifyIssuer(issuer, true) {
+		return "", fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	switch claims["token_use"] {
+	case "id":
+		if audience != "" && !claims.VerifyAudience(audience, true) {
+			return "", fmt.Errorf("unexpected audience %v", claims["aud"])
+		}
+	case "access":
+		if audience != "" && claims["client_id"] != audience {
+			return "", fmt.Errorf("unexpected client %v", claims["client_id"])
+		}
+	default:
+		return "", fmt.Errorf("unexpected token_use %v", claims["token_use"])
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", errors.New("token has no sub")
+	}
+	return sub, nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
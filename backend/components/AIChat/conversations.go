@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const conversationsPath = "/api/AIchat/conversations"
+
+// conversationPath splits /conversations/{id}[/{sub}...] into id and the
+// rest of the path.
+func conversationPath(path string) (id, sub string, ok bool) {
+	if !strings.HasPrefix(path, conversationsPath+"/") {
+		return "", "", false
+	}
+	rest := strings.Trim(strings.TrimPrefix(path, conversationsPath), "/")
+	id, sub, _ = strings.Cut(rest, "/")
+	return id, sub, id != ""
+}
+
+// messagePathID extracts {messageId} from the sub path messages/{messageId}.
+func messagePathID(sub string) (string, bool) {
+	id, ok := strings.CutPrefix(sub, "messages/")
+	return id, ok && id != "" && !strings.Contains(id, "/")
+}
+
+// conversationPathID extracts {id} from /conversations/{id}, rejecting
+// deeper paths.
+func conversationPathID(path string) (string, bool) {
+	id, sub, ok := conversationPath(path)
+	return id, ok && sub == ""
+}
+
+// lambdaGetConversation serves GET /conversations/{id}: the caller's
+// conversation with its activity and settings. The ETag is its version,
+// for PATCH's If-Match.
+func lambdaGetConversation(req events.APIGatewayProxyRequest, id string) (events.APIGatewayProxyResponse, error) {
+	userID := requestUserID(req, req.QueryStringParameters["userId"])
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
+	c, err := services.Store.GetConversation(context.Background(), userID, id)
+	if err != nil {
+		return conversationErrorResponse(err), nil
+	}
+	return conversationResponse(c), nil
+}
+
+// lambdaUpdateConversation serves PATCH /conversations/{id} with
+// {"title": ..., "settings": {"language": ...}}; omitted fields are kept.
+// An If-Match header (or "version" in the body) makes the update fail with
+// 412 if the conversation changed since it was read.
+func lambdaUpdateConversation(req events.APIGatewayProxyRequest, id string) (events.APIGatewayProxyResponse, error) {
+	var body struct {
+		UserID string `json:"userId"`
+		services.ConversationUpdate
+	}
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		return errorResponse(400, "Invalid JSON body"), nil
+	}
+	userID := requestUserID(req, body.UserID)
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
+	if v := header(req, "If-Match"); v != "" {
+		n, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(v, "W/"), `"`))
+		if err != nil {
+			return errorResponse(400, "If-Match must be a conversation version"), nil
+		}
+		body.IfVersion = &n
+	}
+	u := body.ConversationUpdate
+	if err := u.Normalize(); err != nil {
+		return conversationErrorResponse(err), nil
+	}
+	c, err := services.Store.UpdateConversation(context.Background(), userID, id, u)
+	if err != nil {
+		return conversationErrorResponse(err), nil
+	}
+	return conversationResponse(c), nil
+}
+
+// lambdaListMessages serves GET /conversations/{id}/messages, a page of the
+// caller's conversation. direction=backward (the default) pages from the
+// newest message towards older ones, as an infinite-scroll client loads
+// history; forward pages oldest first. before/after take message IDs and
+// limit the listing to messages older/newer than those, e.g. after=<the
+// newest message the client has> to catch up.
+func lambdaListMessages(req events.APIGatewayProxyRequest, id string) (events.APIGatewayProxyResponse, error) {
+	q := req.QueryStringParameters
+	userID := requestUserID(req, q["userId"])
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
+	var newestFirst bool
+	switch q["direction"] {
+	case "", "backward":
+		newestFirst = true
+	case "forward":
+	default:
+		return errorResponse(400, `direction must be "backward" or "forward"`), nil
+	}
+
+	ctx := context.Background()
+	if _, err := services.Store.GetConversation(ctx, userID, id); err != nil {
+		return conversationErrorResponse(err), nil
+	}
+	r := services.MessageRange{After: q["after"], Before: q["before"]}
+	page, err := services.Store.ListMessagesRange(ctx, id, r, queryLimit(req, 50), q["nextToken"], newestFirst)
+	if errors.Is(err, services.ErrMessageNotFound) {
+		return errorResponse(400, "before/after must be messages of this conversation"), nil
+	}
+	if err != nil {
+		return pageErrorResponse(err), nil
+	}
+	content := map[string]interface{}{"data": page.Items}
+	if page.NextToken != "" {
+		content["nextToken"] = page.NextToken
+	}
+	return jsonResponse(200, map[string]interface{}{"content": content}), nil
+}
+
+// lambdaMessage serves /conversations/{id}/messages/{messageId}: GET a
+// message, PATCH {"content": ...} to edit one of the student's own
+// messages (the previous content is kept in its edits), DELETE to remove
+// it. The caller's other devices are told about edits and deletions.
+func lambdaMessage(req events.APIGatewayProxyRequest, conversationID, messageID string) (events.APIGatewayProxyResponse, error) {
+	var body struct {
+		UserID  string `json:"userId"`
+		Content string `json:"content"`
+	}
+	if req.HTTPMethod == "PATCH" {
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			return errorResponse(400, "Invalid JSON body"), nil
+		}
+	}
+	if body.UserID == "" {
+		body.UserID = req.QueryStringParameters["userId"]
+	}
+	userID := requestUserID(req, body.UserID)
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
+	ctx := context.Background()
+
+	switch req.HTTPMethod {
+	case "GET":
+		if _, err := services.Store.GetConversation(ctx, userID, conversationID); err != nil {
+			return conversationErrorResponse(err), nil
+		}
+		m, err := services.Store.GetMessage(ctx, conversationID, messageID)
+		if err != nil {
+			return conversationErrorResponse(err), nil
+		}
+		return jsonResponse(200, m), nil
+
+	case "PATCH":
+		if strings.TrimSpace(body.Content) == "" {
+			return errorResponse(400, "content is required"), nil
+		}
+		if _, err := services.Store.GetConversation(ctx, userID, conversationID); err != nil {
+			return conversationErrorResponse(err), nil
+		}
+		m, err := services.Store.GetMessage(ctx, conversationID, messageID)
+		if err != nil {
+			return conversationErrorResponse(err), nil
+		}
+		if m.Role != "user" {
+			return errorResponse(403, "Only your own messages can be edited"), nil
+		}
+		m, err = services.Store.UpdateMessage(ctx, userID, conversationID, messageID, body.Content, time.Now())
+		if err != nil {
+			return conversationErrorResponse(err), nil
+		}
+		services.Broadcast(ctx, userID, services.SocketEvent{Type: services.EventMessageUpdated, ConversationID: conversationID, Message: &m}, "")
+		return jsonResponse(200, m), nil
+
+	case "DELETE":
+		if err := services.Store.DeleteMessage(ctx, userID, conversationID, messageID); err != nil {
+			return conversationErrorResponse(err), nil
+		}
+		gone := services.ChatMessage{ID: messageID, ConversationID: conversationID}
+		services.Broadcast(ctx, userID, services.SocketEvent{Type: services.EventMessageDeleted, ConversationID: conversationID, Message: &gone}, "")
+		return jsonResponse(200, map[string]string{"conversationId": conversationID, "messageId": messageID}), nil
+	}
+	return errorResponse(404, "Route not found"), nil
+}
+
+func conversationResponse(c services.Conversation) events.APIGatewayProxyResponse {
+	resp := jsonResponse(200, c)
+	resp.Headers["ETag"] = strconv.Quote(strconv.Itoa(c.Version))
+	return resp
+}
+
+func conversationErrorResponse(err error) events.APIGatewayProxyResponse {
+	switch {
+	case errors.Is(err, services.ErrConversationNotFound):
+		return errorResponse(404, "Conversation not found")
+	case errors.Is(err, services.ErrMessageNotFound):
+		return errorResponse(404, "Message not found")
+	case errors.Is(err, services.ErrVersionConflict):
+		return errorResponse(412, err.Error())
+	case errors.Is(err, services.ErrInvalidConversation):
+		return errorResponse(400, err.Error())
+	}
+	return errorResponse(500, err.Error())
+}
+
+// header reads a request header regardless of the case the client sent.
+func header(req events.APIGatewayProxyRequest, name string) string {
+	if v, ok := req.Headers[name]; ok {
+		return v
+	}
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
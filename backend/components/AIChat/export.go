@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+// maxExportBytes caps an export returned through API Gateway, whose Lambda
+// responses cannot be streamed and are limited to 6 MB (PDFs also grow by
+// a third as base64). The local server streams exports of any size.
+const maxExportBytes = 4 << 20
+
+var errExportTooLarge = errors.New("export too large")
+
+// exportRequest checks GET /conversations/{id}/export?format=... and
+// returns the conversation and format, or the error response.
+func exportRequest(req events.APIGatewayProxyRequest, id string) (services.Conversation, string, *events.APIGatewayProxyResponse) {
+	q := req.QueryStringParameters
+	userID := requestUserID(req, q["userId"])
+	if userID == "" {
+		resp := errorResponse(400, "Missing userId")
+		return services.Conversation{}, "", &resp
+	}
+	format := q["format"]
+	if format == "" {
+		format = services.ExportMarkdown
+	}
+	if _, _, err := services.ExportContentType(format); err != nil {
+		resp := errorResponse(400, err.Error())
+		return services.Conversation{}, "", &resp
+	}
+	c, err := services.Store.GetConversation(context.Background(), userID, id)
+	if err != nil {
+		resp := conversationErrorResponse(err)
+		return services.Conversation{}, "", &resp
+	}
+	return c, format, nil
+}
+
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// exportHeaders are the content type and download file name of an export.
+func exportHeaders(c services.Conversation, format string) map[string]string {
+	contentType, ext, _ := services.ExportContentType(format)
+	name := strings.Trim(unsafeFilename.ReplaceAllString(c.Title, "-"), "-")
+	if name == "" {
+		name = "conversation-" + c.ID
+	}
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	return map[string]string{
+		"Content-Type":        contentType,
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s.%s"`, name, ext),
+	}
+}
+
+// lambdaExportConversation serves GET /conversations/{id}/export: the
+// transcript as format markdown (default), html, json or pdf, as a
+// download. API Gateway only decodes the PDF for Accept: application/pdf.
+func lambdaExportConversation(req events.APIGatewayProxyRequest, id string) (events.APIGatewayProxyResponse, error) {
+	c, format, errResp := exportRequest(req, id)
+	if errResp != nil {
+		return *errResp, nil
+	}
+	var buf bytes.Buffer
+	err := services.ExportConversation(context.Background(), &cappedWriter{w: &buf, left: maxExportBytes}, c, format)
+	if errors.Is(err, errExportTooLarge) {
+		return errorResponse(413, "Conversation is too large to export in one response"), nil
+	}
+	if err != nil {
+		return errorResponse(500, "Failed to export conversation: "+err.Error()), nil
+	}
+	resp := events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    exportHeaders(c, format),
+		Body:       buf.String(),
+	}
+	if format == services.ExportPDF {
+		resp.Body = base64.StdEncoding.EncodeToString(buf.Bytes())
+		resp.IsBase64Encoded = true
+	}
+	return resp, nil
+}
+
+// serveExport streams an export straight to the local server's client.
+func serveExport(w http.ResponseWriter, req events.APIGatewayProxyRequest, id string) {
+	c, format, errResp := exportRequest(req, id)
+	if errResp != nil {
+		for k, v := range errResp.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(errResp.StatusCode)
+		_, _ = w.Write([]byte(errResp.Body))
+		return
+	}
+	for k, v := range exportHeaders(c, format) {
+		w.Header().Set(k, v)
+	}
+	if err := services.ExportConversation(context.Background(), w, c, format); err != nil {
+		// The status is sent already; the client sees a truncated file.
+		log.Printf("❌ Exporting conversation %s: %v", id, err)
+	}
+}
+
+// cappedWriter fails with errExportTooLarge once more than left bytes
+// are written.
+type cappedWriter struct {
+	w    *bytes.Buffer
+	left int
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if len(p) > c.left {
+		return 0, errExportTooLarge
+	}
+	c.left -= len(p)
+	return c.w.Write(p)
+}
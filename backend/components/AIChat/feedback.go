@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const adminFeedbackPath = "/api/AIchat/admin/feedback"
+
+// lambdaMessageFeedback serves
+//
+//	GET        /conversations/{id}/messages/{messageId}/feedback
+//	PUT | POST /conversations/{id}/messages/{messageId}/feedback
+//
+// with body {"rating": "up"|"down", "reason": "...", "comment": "..."}.
+func lambdaMessageFeedback(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if services.FeedbackItems == nil {
+		return errorResponse(503, "Feedback store not configured"), nil
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.Path, "/api/AIchat/conversations/"), "/"), "/")
+	if len(parts) != 4 || parts[1] != "messages" || parts[3] != "feedback" || parts[0] == "" || parts[2] == "" {
+		return errorResponse(404, "Route not found"), nil
+	}
+	conversationID, messageID := parts[0], parts[2]
+	ctx := context.Background()
+
+	switch req.HTTPMethod {
+	case "GET":
+		userID := requestUserID(req, req.QueryStringParameters["userId"])
+		f, err := services.FeedbackItems.GetFeedback(ctx, conversationID, messageID)
+		if err != nil {
+			return feedbackErrorResponse(err), nil
+		}
+		if f.UserID != userID && !isAdmin(req) {
+			return errorResponse(404, services.ErrFeedbackNotFound.Error()), nil
+		}
+		return jsonResponse(200, f), nil
+
+	case "PUT", "POST":
+		var body struct {
+			UserID  string `json:"userId"`
+			Rating  string `json:"rating"`
+			Reason  string `json:"reason"`
+			Comment string `json:"comment"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			return errorResponse(400, "Invalid JSON body"), nil
+		}
+		userID := requestUserID(req, body.UserID)
+		if userID == "" {
+			return errorResponse(400, "Missing userId"), nil
+		}
+		f, err := services.SubmitFeedback(ctx, userID, services.Feedback{
+			ConversationID: conversationID,
+			MessageID:      messageID,
+			Rating:         body.Rating,
+			Reason:         body.Reason,
+			Comment:        strings.TrimSpace(body.Comment),
+		})
+		if err != nil {
+			return feedbackErrorResponse(err), nil
+		}
+		return jsonResponse(200, f), nil
+	}
+	return errorResponse(404, "Route not found"), nil
+}
+
+// lambdaAdminListFeedback serves GET /admin/feedback with optional filters
+// rating, reason, userId, experimentId, since, until (RFC3339), limit, nextToken.
+func lambdaAdminListFeedback(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAdmin(req) {
+		return errorResponse(403, "Admin access required"), nil
+	}
+	if req.HTTPMethod != "GET" {
+		return errorResponse(404, "Route not found"), nil
+	}
+	if services.FeedbackItems == nil {
+		return errorResponse(503, "Feedback store not configured"), nil
+	}
+	q := req.QueryStringParameters
+	filter := services.FeedbackFilter{
+		Rating:       q["rating"],
+		Reason:       q["reason"],
+		UserID:       q["userId"],
+		ExperimentID: q["experimentId"],
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q[name]; v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return errorResponse(400, name+" must be an RFC3339 timestamp"), nil
+			}
+			*dst = t
+		}
+	}
+	if err := filter.Validate(); err != nil {
+		return errorResponse(400, err.Error()), nil
+	}
+	page, err := services.FeedbackItems.ListFeedback(context.Background(), filter, queryLimit(req, 50), q["nextToken"])
+	if err != nil {
+		return pageErrorResponse(err), nil
+	}
+	return jsonResponse(200, page), nil
+}
+
+func feedbackErrorResponse(err error) events.APIGatewayProxyResponse {
+	switch {
+	case errors.Is(err, services.ErrFeedbackNotFound), errors.Is(err, services.ErrMessageNotFound):
+		return errorResponse(404, err.Error())
+	case errors.Is(err, services.ErrNotMessageAuthor):
+		// As for conversations: another user's message is not found.
+		return errorResponse(404, services.ErrMessageNotFound.Error())
+	case errors.Is(err, services.ErrNotBotMessage), errors.Is(err, services.ErrInvalidFeedback):
+		return errorResponse(400, err.Error())
+	}
+	return errorResponse(500, err.Error())
+}
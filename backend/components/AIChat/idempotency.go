@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	maxIdempotencyKey = 255
+)
+
+// idempotencyOff logs the first request whose key is ignored.
+var idempotencyOff sync.Once
+
+// idempotent runs handle at most once per Idempotency-Key of userID. A
+// retry gets the first response back, marked Idempotent-Replayed: true; a
+// retry while the first is still running gets 409, and reusing a key for a
+// different request 422. Server errors are not kept, so the client can
+// retry them. Without the header handle just runs; so it does without a
+// store (InitDAL has not run), which is logged once.
+func idempotent(req events.APIGatewayProxyRequest, userID string, handle func() (events.APIGatewayProxyResponse, error)) (events.APIGatewayProxyResponse, error) {
+	key := header(req, idempotencyHeader)
+	if key == "" || userID == "" {
+		return handle()
+	}
+	if services.Idempotency == nil {
+		idempotencyOff.Do(func() {
+			log.Printf("⚠️ Ignoring %s headers: no idempotency store is configured", idempotencyHeader)
+		})
+		return handle()
+	}
+	if len(key) > maxIdempotencyKey {
+		return errorResponse(400, "Idempotency-Key is too long"), nil
+	}
+	ctx := context.Background()
+	sum := sha256.Sum256([]byte(req.HTTPMethod + " " + req.Path + "\n" + req.Body))
+	stored, err := services.Idempotency.Begin(ctx, userID, key, hex.EncodeToString(sum[:]))
+	switch {
+	case errors.Is(err, services.ErrIdempotencyMismatch):
+		return errorResponse(422, err.Error()), nil
+	case errors.Is(err, services.ErrIdempotencyInProgress):
+		resp := errorResponse(409, err.Error())
+		resp.Headers["Retry-After"] = "1"
+		return resp, nil
+	case err != nil:
+		return errorResponse(500, "Failed to check Idempotency-Key: "+err.Error()), nil
+	case stored != nil:
+		headers := map[string]string{}
+		for k, v := range stored.Headers {
+			headers[k] = v
+		}
+		headers["Idempotent-Replayed"] = "true"
+		return events.APIGatewayProxyResponse{StatusCode: stored.StatusCode, Headers: headers, Body: stored.Body}, nil
+	}
+
+	resp, err := handle()
+	if err != nil || resp.StatusCode >= 500 {
+		if rerr := services.Idempotency.Release(ctx, userID, key); rerr != nil {
+			log.Printf("⚠️ Releasing Idempotency-Key %q: %v", key, rerr)
+		}
+		return resp, err
+	}
+	if err := services.Idempotency.Complete(ctx, userID, key, services.StoredResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Headers,
+		Body:       resp.Body,
+	}); err != nil {
+		// The request is done; a retry will see the claim until it goes stale.
+		log.Printf("⚠️ Storing response for Idempotency-Key %q: %v", key, err)
+	}
+	return resp, nil
+}
+
+// bodyUserID is the userId field of a JSON request body, which is how
+// create-conversation and send-message name the user.
+func bodyUserID(req events.APIGatewayProxyRequest) string {
+	var body struct {
+		UserID string `json:"userId"`
+	}
+	_ = json.Unmarshal([]byte(req.Body), &body)
+	return body.UserID
+}
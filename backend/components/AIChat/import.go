@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const importPath = "/api/AIchat/import"
+
+// importProgressInterval limits how often progress is pushed to the
+// user's sockets.
+const importProgressInterval = time.Second
+
+// lambdaImport serves POST /import?format=chatgpt|jsonl with the file as the
+// body (base64 for binary uploads). Progress goes to the user's sockets as
+// import.progress events, tagged with the requestId query parameter if one
+// is given, and the response is the final count. API Gateway limits the
+// body to 10 MB; larger exports go through cmd/import. Importing a file
+// again only adds what is missing.
+func lambdaImport(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	q := req.QueryStringParameters
+	userID := requestUserID(req, q["userId"])
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
+	body := req.Body
+	if req.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return errorResponse(400, "Invalid base64 body"), nil
+		}
+		body = string(b)
+	}
+
+	ctx := context.Background()
+	var last time.Time
+	progress := func(p services.ImportProgress) {
+		if !p.Done && time.Since(last) < importProgressInterval {
+			return
+		}
+		last = time.Now()
+		services.Broadcast(ctx, userID, services.SocketEvent{Type: services.EventImportProgress, RequestID: q["requestId"], Import: &p}, "")
+	}
+	p, err := services.Import(ctx, userID, strings.NewReader(body), q["format"], progress)
+	switch {
+	case errors.Is(err, services.ErrUnknownImportFormat), errors.Is(err, services.ErrInvalidImport):
+		return jsonResponse(400, map[string]any{"error": err.Error(), "progress": p}), nil
+	case err != nil:
+		return jsonResponse(500, map[string]any{"error": "Import failed: " + err.Error(), "progress": p}), nil
+	}
+	return jsonResponse(200, p), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const jobsPath = "/api/AIchat/jobs/"
+
+// sendMessageAsync stores the student's message and queues its answer,
+// returning 202 with the job to poll. Long answers then are not cut off by
+// API Gateway's 29-second limit.
+func sendMessageAsync(ctx context.Context, userID string, msg services.ChatMessage) (events.APIGatewayProxyResponse, error) {
+	if services.Jobs == nil || services.Queue == nil {
+		return errorResponse(503, "Async generation not configured"), nil
+	}
+	userMsg := services.ChatMessage{
+		ID:             generateULID(),
+		ConversationID: msg.ConversationID,
+		UserID:         userID,
+		Role:           "user",
+		Content:        msg.Content,
+		CreatedAt:      time.Now().UTC(),
+		Language:       services.DetectLanguage(msg.Content),
+	}
+	err := services.Store.PutMessage(ctx, userMsg)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return errorResponse(404, "Conversation not found"), nil
+	}
+	if err != nil {
+		return errorResponse(500, "Failed to save message: "+err.Error()), nil
+	}
+	job, err := services.EnqueueGeneration(ctx, userMsg)
+	if err != nil {
+		return errorResponse(500, "Failed to queue reply: "+err.Error()), nil
+	}
+	return jsonResponse(202, map[string]string{
+		"jobId":         job.ID,
+		"status":        job.Status,
+		"userMessageId": userMsg.ID,
+	}), nil
+}
+
+// lambdaGetJob serves GET /jobs/{id}: the job's status and, once done, the
+// chatbot message.
+func lambdaGetJob(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if req.HTTPMethod != "GET" {
+		return errorResponse(404, "Route not found"), nil
+	}
+	if services.Jobs == nil {
+		return errorResponse(503, "Job store not configured"), nil
+	}
+	id := strings.Trim(strings.TrimPrefix(req.Path, jobsPath), "/")
+	if id == "" || strings.Contains(id, "/") {
+		return errorResponse(404, "Route not found"), nil
+	}
+	ctx := context.Background()
+	job, err := services.Jobs.GetJob(ctx, id)
+	if errors.Is(err, services.ErrJobNotFound) {
+		return errorResponse(404, err.Error()), nil
+	}
+	if err != nil {
+		return errorResponse(500, err.Error()), nil
+	}
+	userID := requestUserID(req, req.QueryStringParameters["userId"])
+	if job.UserID != userID && !isAdmin(req) {
+		return errorResponse(404, services.ErrJobNotFound.Error()), nil
+	}
+
+	resp := map[string]interface{}{"job": job}
+	if job.Status == services.JobDone {
+		msg, err := services.Store.GetMessage(ctx, job.ConversationID, job.MessageID)
+		if err != nil {
+			return errorResponse(500, "Failed to load reply: "+err.Error()), nil
+		}
+		resp["message"] = msg
+		resp["response"] = msg.Content
+	}
+	return jsonResponse(200, resp), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// serveLocal runs the Lambda handler behind a plain HTTP server, for the
+// local services workflow (start_services.sh). Requests carry no Cognito
+// claims, so handlers fall back to the client-supplied userId.
+func serveLocal(addr string) {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// Local development only: the frontend runs on another port.
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Content-Disposition, Idempotent-Replayed")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod:            r.Method,
+			Path:                  r.URL.Path,
+			Body:                  string(body),
+			Headers:               map[string]string{},
+			QueryStringParameters: map[string]string{},
+		}
+		for k := range r.Header {
+			req.Headers[k] = r.Header.Get(k)
+		}
+		for k := range r.URL.Query() {
+			req.QueryStringParameters[k] = r.URL.Query().Get(k)
+		}
+		if id, sub, ok := conversationPath(req.Path); ok && sub == "export" && r.Method == http.MethodGet {
+			serveExport(w, req, id)
+			return
+		}
+
+		resp, err := handler(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for k, v := range resp.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.WriteString(w, resp.Body)
+	})
+
+	log.Printf("🌐 Local AI Chat API on %s", addr)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		log.Println("❌ Server error:", err)
+		os.Exit(1)
+	}
+}
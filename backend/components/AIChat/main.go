@@ -5,7 +5,9 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"flag"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,28 +19,97 @@ import (
 )
 
 func main() {
+	local := flag.String("local", "", "serve the API over HTTP on this address instead of running as a Lambda")
+	flag.Parse()
+
 	_ = godotenv.Load(".env")
 	if err := services.InitDAL(); err != nil {
 		panic("DAL init failed: " + err.Error())
 	}
+	if err := services.InitProvider(); err != nil {
+		panic("AI provider init failed: " + err.Error())
+	}
+	if err := services.InitQueue(); err != nil {
+		panic("Job queue init failed: " + err.Error())
+	}
+	if err := services.InitRealtime(); err != nil {
+		panic("Realtime init failed: " + err.Error())
+	}
+	if *local != "" {
+		serveLocal(*local)
+		return
+	}
 	lambda.Start(handler)
 }
 
 func handler(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if strings.HasPrefix(req.Path, adminTemplatesPath) {
+		return lambdaAdminTemplates(req)
+	}
+	if strings.HasPrefix(req.Path, adminExperimentsPath) {
+		return lambdaAdminExperiments(req)
+	}
+	if strings.HasPrefix(req.Path, adminFeedbackPath) {
+		return lambdaAdminListFeedback(req)
+	}
+	if strings.HasPrefix(req.Path, adminRedactionsPath) {
+		return lambdaAdminListRedactions(req)
+	}
+	if strings.HasPrefix(req.Path, adminRetentionPath) {
+		return lambdaAdminRetention(req)
+	}
+	if strings.HasPrefix(req.Path, jobsPath) {
+		return lambdaGetJob(req)
+	}
+	if strings.TrimSuffix(req.Path, "/") == preferencesPath {
+		return lambdaPreferences(req)
+	}
+	if strings.HasSuffix(strings.TrimSuffix(req.Path, "/"), "/feedback") {
+		return lambdaMessageFeedback(req)
+	}
+	if id, sub, ok := conversationPath(req.Path); ok {
+		if messageID, ok := messagePathID(sub); ok {
+			return lambdaMessage(req, id, messageID)
+		}
+	}
 	switch req.HTTPMethod {
 	case "GET":
 		if req.Path == "/api/AIchat/conversations" {
 			return lambdaFetchConversations(req)
 		}
+		if id, sub, ok := conversationPath(req.Path); ok {
+			switch sub {
+			case "":
+				return lambdaGetConversation(req, id)
+			case "messages":
+				return lambdaListMessages(req, id)
+			case "export":
+				return lambdaExportConversation(req, id)
+			}
+		}
 		if req.Path == "/api/AIchat/history/" {
 			return lambdaFetchChatHistory(req)
 		}
 	case "POST":
+		if strings.TrimSuffix(req.Path, "/") == importPath {
+			return lambdaImport(req)
+		}
 		if req.Path == "/api/AIchat/conversations" {
-			return lambdaCreateConversation(req)
+			return idempotent(req, requestUserID(req, bodyUserID(req)), func() (events.APIGatewayProxyResponse, error) {
+				return lambdaCreateConversation(req)
+			})
+		}
+		if strings.HasSuffix(req.Path, "/restore") {
+			return lambdaRestoreConversation(req)
 		}
 		if strings.Contains(req.Path, "/messages") {
-			return lambdaSendMessage(req)
+			return idempotent(req, requestUserID(req, bodyUserID(req)), func() (events.APIGatewayProxyResponse, error) {
+				return lambdaSendMessage(req)
+			})
+		}
+	case "PATCH":
+		if id, ok := conversationPathID(req.Path); ok {
+			return lambdaUpdateConversation(req, id)
 		}
 	case "DELETE":
 		if strings.Contains(req.Path, "/conversations/") {
@@ -55,24 +126,39 @@ func lambdaCreateConversation(req events.APIGatewayProxyRequest) (events.APIGate
 		UserID string `json:"userId"`
 	}
 	_ = json.Unmarshal([]byte(req.Body), &body)
+	userID := requestUserID(req, body.UserID)
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
 
-	id, err := services.Store.CreateConversation(context.Background(), body.UserID, "New Academic Chat")
+	id, err := services.Store.CreateConversation(context.Background(), userID, "New Academic Chat")
 	if err != nil {
 		return errorResponse(500, err.Error()), nil
 	}
 
-	greeting := "This is your personal AiChatBot, what can I help you study today?"
+	greeting, tmpl, err := services.RenderTemplate(context.Background(), services.TemplateConversationGreeting, nil)
+	if err != nil {
+		return errorResponse(500, "Failed to render greeting: "+err.Error()), nil
+	}
+	greeting, language := services.LocalizeGreeting(context.Background(), userID, greeting)
+	if language == "" {
+		language = services.DetectLanguage(greeting)
+	}
 	err = services.Store.PutMessage(context.Background(), services.ChatMessage{
-		ID:             generateULID(),
-		ConversationID: id,
-		UserID:         body.UserID,
-		Role:           "chatbot",
-		Content:        greeting,
-		CreatedAt:      time.Now().UTC(),
+		ID:              generateULID(),
+		ConversationID:  id,
+		UserID:          userID,
+		Role:            "chatbot",
+		Content:         greeting,
+		CreatedAt:       time.Now().UTC(),
+		TemplateID:      tmpl.ID,
+		TemplateVersion: tmpl.Version,
+		Language:        language,
 	})
 	if err != nil {
 		return errorResponse(500, "Failed to save greeting message"), nil
 	}
+	services.Broadcast(context.Background(), userID, services.SocketEvent{Type: services.EventConversationCreated, ConversationID: id}, "")
 
 	return jsonResponse(200, map[string]interface{}{
 		"conversationId": id,
@@ -80,69 +166,167 @@ func lambdaCreateConversation(req events.APIGatewayProxyRequest) (events.APIGate
 	}), nil
 }
 
+// lambdaFetchConversations lists the user's conversations, most recently
+// active first (?trash=true: the trash), ?limit= per page (default 20) from
+// ?nextToken=.
 func lambdaFetchConversations(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userId := req.QueryStringParameters["userId"]
+	userId := requestUserID(req, req.QueryStringParameters["userId"])
 	if userId == "" {
 		return errorResponse(400, "Missing userId"), nil
 	}
 
-	page, err := services.Store.ListConversations(context.Background(), userId, 20, "")
+	list := services.Store.ListConversations
+	if req.QueryStringParameters["trash"] == "true" {
+		list = services.Store.ListTrashedConversations
+	}
+	page, err := list(context.Background(), userId, queryLimit(req, 20), req.QueryStringParameters["nextToken"])
 	if err != nil {
-		return errorResponse(500, err.Error()), nil
+		return pageErrorResponse(err), nil
+	}
+	content := map[string]interface{}{"data": page.Items}
+	if page.NextToken != "" {
+		content["nextToken"] = page.NextToken
 	}
-	return jsonResponse(200, map[string]interface{}{"content": map[string]interface{}{"data": page.Items}}), nil
+	return jsonResponse(200, map[string]interface{}{"content": content}), nil
 }
 
 func lambdaSendMessage(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var body struct {
 		UserID  string               `json:"userId"`
 		Message services.ChatMessage `json:"message"`
+		Async   bool                 `json:"async"`
 	}
 	_ = json.Unmarshal([]byte(req.Body), &body)
 
+	userID := requestUserID(req, body.UserID)
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
+	ctx := services.WithChatScope(context.Background(), services.ChatScope{Institution: institution(req)})
+	// Check ownership before anything is read, recorded or billed.
+	conv, err := services.Store.GetConversation(ctx, userID, body.Message.ConversationID)
+	if err == nil && conv.DeletedAt != nil {
+		err = services.ErrConversationNotFound
+	}
+	if err != nil {
+		return conversationErrorResponse(err), nil
+	}
+	if body.Async || req.QueryStringParameters["async"] == "true" {
+		return sendMessageAsync(ctx, userID, body.Message)
+	}
+	// A message right after an experiment answer counts as a follow-up for that variant.
+	if prev, err := services.Store.ListMessages(ctx, body.Message.ConversationID, 1, "", true); err == nil && len(prev.Items) > 0 {
+		services.RecordFollowUp(ctx, prev.Items[0])
+	}
+
+	reply, err := services.GenerateReply(ctx, userID, body.Message.ConversationID, body.Message.Content)
+	if err != nil {
+		return errorResponse(502, "Failed to generate reply: "+err.Error()), nil
+	}
+
 	now := time.Now().UTC()
 	userMsg := services.ChatMessage{
 		ID:             generateULID(),
 		ConversationID: body.Message.ConversationID,
-		UserID:         body.UserID,
+		UserID:         userID,
 		Role:           "user",
 		Content:        body.Message.Content,
 		CreatedAt:      now,
+		Language:       reply.MessageLanguage,
 	}
-	_ = services.Store.PutMessage(context.Background(), userMsg)
+	reply.StampVariant(&userMsg)
 
-	// Simulated reply for now
-	botReply := fmt.Sprintf("You said: %s", body.Message.Content)
 	botMsg := services.ChatMessage{
 		ID:             generateULID(),
 		ConversationID: body.Message.ConversationID,
-		UserID:         body.UserID,
+		UserID:         userID,
 		Role:           "chatbot",
-		Content:        botReply,
+		Content:        reply.Content,
 		CreatedAt:      now.Add(time.Millisecond),
 	}
-	_ = services.Store.PutMessage(context.Background(), botMsg)
+	reply.Stamp(&botMsg)
+	err = services.Store.PutExchange(ctx, userMsg, botMsg)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return errorResponse(404, "Conversation not found"), nil
+	}
+	if err != nil {
+		return errorResponse(500, "Failed to save messages: "+err.Error()), nil
+	}
+	services.NotifyMessage(ctx, userMsg, "")
+	services.NotifyMessage(ctx, botMsg, "")
 
-	return jsonResponse(200, map[string]string{"response": botReply}), nil
+	resp := map[string]string{
+		"response":      reply.Content,
+		"messageId":     botMsg.ID,
+		"userMessageId": userMsg.ID,
+	}
+	if reply.Language != "" {
+		resp["language"] = reply.Language
+	}
+	if reply.Translation != "" {
+		resp["translation"] = reply.Translation
+		resp["translationLanguage"] = reply.TranslationLanguage
+	}
+	return jsonResponse(200, resp), nil
 }
 
+// lambdaDeleteConversation moves a conversation to the trash; it can be
+// restored until the purge removes it after services.TrashRetention.
 func lambdaDeleteConversation(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	conversationID := strings.TrimPrefix(req.Path, "/api/AIchat/conversations/")
-	err := services.Store.DeleteConversationCascade(context.Background(), conversationID)
+	userID := requestUserID(req, req.QueryStringParameters["userId"])
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
+	retention, err := services.TrashRetention()
+	if err != nil {
+		return errorResponse(500, err.Error()), nil
+	}
+	deletedAt := time.Now().UTC()
+	err = services.Store.TrashConversation(context.Background(), userID, conversationID, deletedAt)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return errorResponse(404, "Conversation not found"), nil
+	}
+	if err != nil {
+		return errorResponse(500, err.Error()), nil
+	}
+	services.Broadcast(context.Background(), userID, services.SocketEvent{Type: services.EventConversationDeleted, ConversationID: conversationID}, "")
+	return jsonResponse(200, map[string]interface{}{
+		"conversationId":  conversationID,
+		"deletedAt":       deletedAt,
+		"restorableUntil": deletedAt.Add(retention),
+	}), nil
+}
+
+func lambdaRestoreConversation(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	conversationID := strings.TrimSuffix(strings.TrimPrefix(req.Path, "/api/AIchat/conversations/"), "/restore")
+	var body struct {
+		UserID string `json:"userId"`
+	}
+	_ = json.Unmarshal([]byte(req.Body), &body)
+	userID := requestUserID(req, body.UserID)
+	if userID == "" {
+		return errorResponse(400, "Missing userId"), nil
+	}
+	err := services.RestoreConversation(context.Background(), userID, conversationID)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		return errorResponse(404, "Conversation not found in trash"), nil
+	}
 	if err != nil {
 		return errorResponse(500, err.Error()), nil
 	}
+	services.Broadcast(context.Background(), userID, services.SocketEvent{Type: services.EventConversationRestored, ConversationID: conversationID}, "")
 	return jsonResponse(200, map[string]string{"conversationId": conversationID}), nil
 }
 
 func lambdaFetchChatHistory(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userId := req.QueryStringParameters["userId"]
+	userId := requestUserID(req, req.QueryStringParameters["userId"])
 	if userId == "" {
 		return errorResponse(400, "Missing userId"), nil
 	}
 	page, err := services.Store.ListUserMessagesSince(context.Background(), userId, time.Now().Add(-24*time.Hour), 50, "")
 	if err != nil {
-		return errorResponse(500, err.Error()), nil
+		return pageErrorResponse(err), nil
 	}
 
 	var history []map[string]string
@@ -179,6 +363,66 @@ func errorResponse(status int, msg string) events.APIGatewayProxyResponse {
 	return jsonResponse(status, map[string]string{"error": msg})
 }
 
+// pageErrorResponse reports a failed list call: 400 for a nextToken that
+// is not a valid cursor for the query, 500 otherwise.
+func pageErrorResponse(err error) events.APIGatewayProxyResponse {
+	if errors.Is(err, services.ErrInvalidCursor) {
+		return errorResponse(400, err.Error())
+	}
+	return errorResponse(500, err.Error())
+}
+
+// claims returns the Cognito claims the API Gateway authorizer attached to req.
+func claims(req events.APIGatewayProxyRequest) map[string]interface{} {
+	c, _ := req.RequestContext.Authorizer["claims"].(map[string]interface{})
+	return c
+}
+
+// callerID is the authenticated user's Cognito sub, if any.
+func callerID(req events.APIGatewayProxyRequest) string {
+	sub, _ := claims(req)["sub"].(string)
+	return sub
+}
+
+// requestUserID prefers the authenticated caller over a client-supplied userId.
+func requestUserID(req events.APIGatewayProxyRequest, fallback string) string {
+	if sub := callerID(req); sub != "" {
+		return sub
+	}
+	return fallback
+}
+
+// institution is the caller's custom:institution Cognito attribute, which
+// selects institution-specific PII recognizers.
+func institution(req events.APIGatewayProxyRequest) string {
+	v, _ := claims(req)["custom:institution"].(string)
+	return v
+}
+
+// isAdmin reports whether the caller is in the Cognito "admins" group.
+// REST API authorizers flatten cognito:groups to "a,b" (or "[a b]").
+func isAdmin(req events.APIGatewayProxyRequest) bool {
+	groups, _ := claims(req)["cognito:groups"].(string)
+	for _, g := range strings.FieldsFunc(strings.Trim(groups, "[]"), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if g == "admins" {
+			return true
+		}
+	}
+	return false
+}
+
+// queryLimit reads ?limit=, clamped to [1, 100].
+func queryLimit(req events.APIGatewayProxyRequest, def int32) int32 {
+	n, err := strconv.Atoi(req.QueryStringParameters["limit"])
+	if err != nil || n < 1 {
+		return def
+	}
+	if n > 100 {
+		n = 100
+	}
+	return int32(n)
+}
+
 func generateULID() string {
-	return services.GenerateULID() // you can implement this helper in dynamo_dal.go if needed
+	return services.GenerateULID()
 }
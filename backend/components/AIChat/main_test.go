@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+// setupHandlers runs the handlers against the in-memory DAL and a fake
+// provider; nothing else (jobs, queue, sockets) is configured.
+func setupHandlers(t *testing.T) {
+	t.Helper()
+	oldStore, oldLLM := services.Store, services.LLM
+	t.Cleanup(func() { services.Store, services.LLM = oldStore, oldLLM })
+	services.Store = services.NewMemoryDAL()
+	services.LLM = &services.FakeProvider{}
+}
+
+// call sends a request through handler as the Cognito user sub ("" for an
+// unauthenticated caller) and decodes the JSON response into out.
+func call(t *testing.T, method, path, sub, body string, query map[string]string, out interface{}) int {
+	t.Helper()
+	req := events.APIGatewayProxyRequest{HTTPMethod: method, Path: path, Body: body, QueryStringParameters: query}
+	if sub != "" {
+		req.RequestContext.Authorizer = map[string]interface{}{"claims": map[string]interface{}{"sub": sub}}
+	}
+	resp, err := handler(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal([]byte(resp.Body), out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, path, resp.Body, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func createConversation(t *testing.T, sub string) string {
+	t.Helper()
+	var created struct {
+		ConversationID string `json:"conversationId"`
+	}
+	if code := call(t, "POST", "/api/AIchat/conversations", sub, `{}`, nil, &created); code != 200 || created.ConversationID == "" {
+		t.Fatalf("create conversation: %d %+v", code, created)
+	}
+	return created.ConversationID
+}
+
+type messagesPage struct {
+	Content struct {
+		Data      []services.ChatMessage `json:"data"`
+		NextToken string                 `json:"nextToken"`
+	} `json:"content"`
+}
+
+func TestSendMessageStoresTheExchange(t *testing.T) {
+	setupHandlers(t)
+	cid := createConversation(t, "student-1")
+
+	body := `{"message": {"conversationId": "` + cid + `", "content": "What is osmosis?"}}`
+	var sent map[string]interface{}
+	if code := call(t, "POST", "/api/AIchat/conversations/"+cid+"/messages", "student-1", body, nil, &sent); code != 200 {
+		t.Fatalf("send: %d", code)
+	}
+
+	var page messagesPage
+	if code := call(t, "GET", "/api/AIchat/conversations/"+cid+"/messages", "student-1", "", map[string]string{"direction": "forward"}, &page); code != 200 {
+		t.Fatalf("list messages: %d", code)
+	}
+	var roles []string
+	for _, m := range page.Content.Data {
+		roles = append(roles, m.Role)
+		if m.UserID != "student-1" {
+			t.Errorf("message %s stored for %q", m.ID, m.UserID)
+		}
+	}
+	if got := strings.Join(roles, ","); got != "chatbot,user,chatbot" {
+		t.Errorf("roles = %s, want the greeting, the question and the answer", got)
+	}
+}
+
+func TestHandlersUseTheAuthenticatedUser(t *testing.T) {
+	setupHandlers(t)
+	cid := createConversation(t, "student-1")
+	path := "/api/AIchat/conversations/" + cid
+
+	// A body or query userId cannot reach another student's conversation.
+	body := `{"userId": "student-1", "message": {"conversationId": "` + cid + `", "content": "hi"}}`
+	if code := call(t, "POST", path+"/messages", "student-2", body, nil, nil); code != 404 {
+		t.Errorf("send to another user's conversation: %d, want 404", code)
+	}
+	if code := call(t, "GET", path+"/messages", "student-2", "", map[string]string{"userId": "student-1"}, nil); code != 404 {
+		t.Errorf("list another user's messages: %d, want 404", code)
+	}
+	if code := call(t, "DELETE", path, "student-2", "", map[string]string{"userId": "student-1"}, nil); code != 404 {
+		t.Errorf("trash another user's conversation: %d, want 404", code)
+	}
+	if code := call(t, "POST", path+"/messages", "", `{"message": {"conversationId": "`+cid+`", "content": "hi"}}`, nil, nil); code != 400 {
+		t.Errorf("send without a user: %d, want 400", code)
+	}
+}
+
+func TestChatHistoryBelongsToTheCaller(t *testing.T) {
+	setupHandlers(t)
+	cid := createConversation(t, "student-1")
+	body := `{"message": {"conversationId": "` + cid + `", "content": "What is osmosis?"}}`
+	if code := call(t, "POST", "/api/AIchat/conversations/"+cid+"/messages", "student-1", body, nil, nil); code != 200 {
+		t.Fatalf("send: %d", code)
+	}
+
+	var history struct {
+		History []map[string]string `json:"history"`
+	}
+	if code := call(t, "GET", "/api/AIchat/history/", "student-1", "", nil, &history); code != 200 {
+		t.Fatalf("own history: %d", code)
+	}
+	if len(history.History) != 1 || history.History[0]["userMessage"] != "What is osmosis?" {
+		t.Errorf("own history = %+v", history.History)
+	}
+
+	// The query userId is only a fallback for unauthenticated local calls.
+	history.History = nil
+	if code := call(t, "GET", "/api/AIchat/history/", "student-2", "", map[string]string{"userId": "student-1"}, &history); code != 200 {
+		t.Fatalf("history as another user: %d", code)
+	}
+	if len(history.History) != 0 {
+		t.Errorf("student-2 read student-1's history: %+v", history.History)
+	}
+}
+
+func TestTrashAndRestoreConversation(t *testing.T) {
+	setupHandlers(t)
+	cid := createConversation(t, "student-1")
+	path := "/api/AIchat/conversations/" + cid
+
+	if code := call(t, "DELETE", path, "student-1", "", nil, nil); code != 200 {
+		t.Fatalf("trash: %d", code)
+	}
+	if code := call(t, "POST", path+"/messages", "student-1", `{"message": {"conversationId": "`+cid+`", "content": "hi"}}`, nil, nil); code != 404 {
+		t.Errorf("send to a trashed conversation: %d, want 404", code)
+	}
+	var list struct {
+		Content struct {
+			Data []services.Conversation `json:"data"`
+		} `json:"content"`
+	}
+	call(t, "GET", "/api/AIchat/conversations", "student-1", "", map[string]string{"trash": "true"}, &list)
+	if len(list.Content.Data) != 1 || list.Content.Data[0].ID != cid {
+		t.Errorf("trash = %+v", list.Content.Data)
+	}
+
+	if code := call(t, "POST", path+"/restore", "student-1", "", nil, nil); code != 200 {
+		t.Fatalf("restore: %d", code)
+	}
+	if code := call(t, "POST", path+"/restore", "student-1", "", nil, nil); code != 404 {
+		t.Errorf("restore twice: %d, want 404", code)
+	}
+}
+
+func TestListConversationsPaginates(t *testing.T) {
+	setupHandlers(t)
+	want := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		want[createConversation(t, "student-1")] = true
+	}
+	createConversation(t, "student-2")
+
+	seen := map[string]bool{}
+	query := map[string]string{"limit": "2"}
+	for pages := 1; ; pages++ {
+		var list struct {
+			Content struct {
+				Data      []services.Conversation `json:"data"`
+				NextToken string                  `json:"nextToken"`
+			} `json:"content"`
+		}
+		if code := call(t, "GET", "/api/AIchat/conversations", "student-1", "", query, &list); code != 200 {
+			t.Fatalf("page %d: %d", pages, code)
+		}
+		if len(list.Content.Data) > 2 {
+			t.Errorf("page %d has %d items, limit 2", pages, len(list.Content.Data))
+		}
+		for _, c := range list.Content.Data {
+			if !want[c.ID] || seen[c.ID] {
+				t.Errorf("page %d: unexpected or repeated conversation %s", pages, c.ID)
+			}
+			seen[c.ID] = true
+		}
+		if list.Content.NextToken == "" {
+			break
+		}
+		query["nextToken"] = list.Content.NextToken
+	}
+	if len(seen) != len(want) {
+		t.Errorf("listed %d conversations, want %d", len(seen), len(want))
+	}
+
+	query["nextToken"] = "not-a-cursor"
+	if code := call(t, "GET", "/api/AIchat/conversations", "student-1", "", query, nil); code != 400 {
+		t.Errorf("bad nextToken: %d, want 400", code)
+	}
+}
+
+func TestPDFExportShowsChineseText(t *testing.T) {
+	setupHandlers(t)
+	cid := createConversation(t, "student-1")
+	path := "/api/AIchat/conversations/" + cid
+	body := `{"message": {"conversationId": "` + cid + `", "content": "光合作用是什么？"}}`
+	if code := call(t, "POST", path+"/messages", "student-1", body, nil, nil); code != 200 {
+		t.Fatalf("send: %d", code)
+	}
+	req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: path + "/export", QueryStringParameters: map[string]string{"format": "pdf"}}
+	req.RequestContext.Authorizer = map[string]interface{}{"claims": map[string]interface{}{"sub": "student-1"}}
+	resp, err := handler(req)
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("export Chinese text as PDF: %d, %v", resp.StatusCode, err)
+	}
+	pdf, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil || !resp.IsBase64Encoded || !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Errorf("body is not a base64 PDF: %v", err)
+	}
+}
+
+// memoryFeedback keeps one feedback per message, owned by its first author.
+type memoryFeedback struct {
+	mu    sync.Mutex
+	items map[string]services.Feedback
+}
+
+func (m *memoryFeedback) PutFeedback(ctx context.Context, f services.Feedback) (services.Feedback, *services.Feedback, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f.CreatedAt, f.UpdatedAt = time.Now(), time.Now()
+	prev, ok := m.items[f.MessageID]
+	if !ok {
+		m.items[f.MessageID] = f
+		return f, nil, nil
+	}
+	if prev.UserID != f.UserID {
+		return services.Feedback{}, nil, services.ErrNotMessageAuthor
+	}
+	f.CreatedAt = prev.CreatedAt
+	m.items[f.MessageID] = f
+	return f, &prev, nil
+}
+
+func (m *memoryFeedback) GetFeedback(ctx context.Context, conversationID, messageID string) (services.Feedback, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.items[messageID]
+	if !ok {
+		return services.Feedback{}, services.ErrFeedbackNotFound
+	}
+	return f, nil
+}
+
+func (m *memoryFeedback) ListFeedback(ctx context.Context, filter services.FeedbackFilter, limit int32, nextToken string) (services.ListPage[services.Feedback], error) {
+	return services.ListPage[services.Feedback]{}, nil
+}
+
+func TestMessageFeedback(t *testing.T) {
+	setupHandlers(t)
+	old := services.FeedbackItems
+	t.Cleanup(func() { services.FeedbackItems = old })
+	services.FeedbackItems = &memoryFeedback{items: map[string]services.Feedback{}}
+
+	cid := createConversation(t, "student-1")
+	body := `{"message": {"conversationId": "` + cid + `", "content": "What is osmosis?"}}`
+	if code := call(t, "POST", "/api/AIchat/conversations/"+cid+"/messages", "student-1", body, nil, nil); code != 200 {
+		t.Fatalf("send: %d", code)
+	}
+	var page messagesPage
+	call(t, "GET", "/api/AIchat/conversations/"+cid+"/messages", "student-1", "", map[string]string{"direction": "forward"}, &page)
+	if len(page.Content.Data) != 3 {
+		t.Fatalf("messages = %+v", page.Content.Data)
+	}
+	question, answer := page.Content.Data[1], page.Content.Data[2]
+	path := "/api/AIchat/conversations/" + cid + "/messages/" + answer.ID + "/feedback"
+
+	var rated services.Feedback
+	if code := call(t, "PUT", path, "student-1", `{"rating": "up"}`, nil, &rated); code != 200 || rated.Rating != "up" {
+		t.Fatalf("rate: %d %+v", code, rated)
+	}
+	var changed services.Feedback
+	if code := call(t, "PUT", path, "student-1", `{"rating": "down", "reason": "incorrect"}`, nil, &changed); code != 200 {
+		t.Fatalf("change rating: %d", code)
+	}
+	var got services.Feedback
+	call(t, "GET", path, "student-1", "", nil, &got)
+	if got.Rating != "down" || got.Reason != "incorrect" || !got.CreatedAt.Equal(rated.CreatedAt) {
+		t.Errorf("after the change: %+v, want down/incorrect created at %v", got, rated.CreatedAt)
+	}
+
+	// Another student's message is not found, whether rated or read.
+	if code := call(t, "PUT", path, "student-2", `{"rating": "up"}`, nil, nil); code != 404 {
+		t.Errorf("rate another student's message: %d, want 404", code)
+	}
+	if code := call(t, "GET", path, "student-2", "", nil, nil); code != 404 {
+		t.Errorf("read another student's feedback: %d, want 404", code)
+	}
+
+	questionPath := "/api/AIchat/conversations/" + cid + "/messages/" + question.ID + "/feedback"
+	if code := call(t, "PUT", questionPath, "student-1", `{"rating": "up"}`, nil, nil); code != 400 {
+		t.Errorf("rate the student's own question: %d, want 400", code)
+	}
+	if code := call(t, "PUT", path, "student-1", `{"rating": "meh"}`, nil, nil); code != 400 {
+		t.Errorf("unknown rating: %d, want 400", code)
+	}
+}
+
+func TestIdempotencyKeysBelongToTheCaller(t *testing.T) {
+	setupHandlers(t)
+	old := services.Idempotency
+	t.Cleanup(func() { services.Idempotency = old })
+	services.Idempotency = services.NewMemoryIdempotency()
+
+	create := func(sub string) (string, bool) {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Path:       "/api/AIchat/conversations",
+			Headers:    map[string]string{"Idempotency-Key": "k1"},
+			Body:       `{"userId": "student-1"}`,
+		}
+		req.RequestContext.Authorizer = map[string]interface{}{"claims": map[string]interface{}{"sub": sub}}
+		resp, err := handler(req)
+		if err != nil || resp.StatusCode != 200 {
+			t.Fatalf("create as %s: %d %v", sub, resp.StatusCode, err)
+		}
+		var created struct {
+			ConversationID string `json:"conversationId"`
+		}
+		_ = json.Unmarshal([]byte(resp.Body), &created)
+		return created.ConversationID, resp.Headers["Idempotent-Replayed"] == "true"
+	}
+
+	first, _ := create("student-1")
+	if again, replayed := create("student-1"); !replayed || again != first {
+		t.Errorf("retry by the same caller: %s replayed=%v, want %s replayed", again, replayed, first)
+	}
+	// The body names student-1, but the key is student-2's own.
+	if other, replayed := create("student-2"); replayed || other == first {
+		t.Errorf("another caller with the same key and body got %s replayed=%v", other, replayed)
+	}
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/api/AIchat/conversations",
+		Headers:    map[string]string{"Idempotency-Key": "k1"},
+		Body:       `{"title": "Something else"}`,
+	}
+	req.RequestContext.Authorizer = map[string]interface{}{"claims": map[string]interface{}{"sub": "student-1"}}
+	if resp, _ := handler(req); resp.StatusCode != 422 {
+		t.Errorf("same key, different request: %d, want 422", resp.StatusCode)
+	}
+}
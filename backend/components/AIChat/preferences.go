@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+const preferencesPath = "/api/AIchat/preferences"
+
+// lambdaPreferences serves the caller's language settings:
+//
+//	GET /preferences
+//	PUT /preferences  {"preferredLanguage": "es", "nativeLanguage": "zh", "translationMode": true}
+//
+// Languages are ISO 639-1 codes; an empty preferredLanguage means "reply in
+// the language of each message".
+func lambdaPreferences(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if services.UserPreferences == nil {
+		return errorResponse(503, "Preference store not configured"), nil
+	}
+	ctx := context.Background()
+	switch req.HTTPMethod {
+	case "GET":
+		userID := requestUserID(req, req.QueryStringParameters["userId"])
+		if userID == "" {
+			return errorResponse(400, "Missing userId"), nil
+		}
+		p, err := services.UserPreferences.GetPreferences(ctx, userID)
+		if err != nil {
+			return errorResponse(500, err.Error()), nil
+		}
+		return jsonResponse(200, p), nil
+
+	case "PUT":
+		var body services.Preferences
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			return errorResponse(400, "Invalid JSON body"), nil
+		}
+		body.UserID = requestUserID(req, body.UserID)
+		if body.UserID == "" {
+			return errorResponse(400, "Missing userId"), nil
+		}
+		body.PreferredLanguage = strings.ToLower(strings.TrimSpace(body.PreferredLanguage))
+		body.NativeLanguage = strings.ToLower(strings.TrimSpace(body.NativeLanguage))
+		if err := body.Validate(); err != nil {
+			if errors.Is(err, services.ErrInvalidPreferences) {
+				return errorResponse(400, err.Error()), nil
+			}
+			return errorResponse(500, err.Error()), nil
+		}
+		p, err := services.UserPreferences.PutPreferences(ctx, body)
+		if err != nil {
+			return errorResponse(500, err.Error()), nil
+		}
+		return jsonResponse(200, p), nil
+	}
+	return errorResponse(404, "Route not found"), nil
+}
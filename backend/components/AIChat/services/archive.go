@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cold storage. ArchiveInactive moves the messages of conversations without
+// activity for ARCHIVE_AFTER_MONTHS months to one gzipped JSONL object each
+// in the ARCHIVE_BUCKET bucket. The header stays behind as a stub with
+// ArchivedAt set, so listings still show the conversation with its title
+// and activity; opening it (GetConversation, or listing its messages) puts
+// the messages back first. Feedback and redaction events are small and
+// stay in the table. Only the DynamoDB backend archives.
+//
+// Archives follow the retention policies: PurgeExpired deletes them with
+// their conversation and rewrites them without expired messages, and
+// PurgeArchives removes those left behind when the TTL takes the stub.
+//
+// ObjectStore holds the archive objects.
+type ObjectStore interface {
+	PutObject(ctx context.Context, key string, body []byte) error
+	// GetObject returns ErrObjectNotFound for a missing key.
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	// DeleteObject succeeds for a missing key.
+	DeleteObject(ctx context.Context, key string) error
+	// ListObjects lists the keys under prefix in key order, a page at a
+	// time from token.
+	ListObjects(ctx context.Context, prefix, token string) (ListPage[ObjectInfo], error)
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	LastModified time.Time
+}
+
+// Archive is the ARCHIVE_BUCKET store; without it archiving is off.
+var Archive ObjectStore
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrArchiveLost is returned, once, when an archived conversation is opened
+// but its archive object is gone. The conversation then opens without the
+// archived messages and has ArchiveLostAt set.
+var ErrArchiveLost = errors.New("archived messages are lost")
+
+// ArchiveSchema identifies the archive object format: a first line
+//
+//	{"schema": "aichat.conversation-archive/v1", "archivedAt": "...", "conversation": {...}}
+//
+// with the header as archived, then one ChatMessage per line, oldest first.
+const ArchiveSchema = "aichat.conversation-archive/v1"
+
+type archiveHeader struct {
+	Schema       string       `json:"schema"`
+	ArchivedAt   time.Time    `json:"archivedAt"`
+	Conversation Conversation `json:"conversation"`
+}
+
+// ArchiveAfter is how long a conversation must be inactive to be archived:
+// ARCHIVE_AFTER_MONTHS, default 6.
+func ArchiveAfter() (int, error) {
+	months := 6
+	if v := os.Getenv("ARCHIVE_AFTER_MONTHS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("ARCHIVE_AFTER_MONTHS must be a positive integer, got %q", v)
+		}
+		months = n
+	}
+	return months, nil
+}
+
+// ArchiveResult counts what ArchiveInactive moved to cold storage.
+type ArchiveResult struct {
+	Conversations int
+	Messages      int
+}
+
+// ArchiveInactive archives every conversation last active before cutoff.
+// A conversation that fails is logged and left for the next run; one that
+// gets a message while it is being archived is left as it is.
+func ArchiveInactive(ctx context.Context, cutoff time.Time) (ArchiveResult, error) {
+	if Archive == nil {
+		return ArchiveResult{}, nil
+	}
+	d, ok := Store.(*dynamoDAL)
+	if !ok {
+		return ArchiveResult{}, errors.New("archiving only applies to the DynamoDB backend")
+	}
+	return d.archiveInactive(ctx, cutoff)
+}
+
+// orphanGrace is how old an object must be before PurgeArchives may delete
+// it: archiving uploads the object before it writes the marker.
+const orphanGrace = time.Hour
+
+// PurgeArchives deletes archive objects that no conversation refers to any
+// more: those whose stub expired through the retention TTL, and leftovers
+// of rehydrations that could not clean up. It returns how many it deleted.
+// The bucket has no expiry of its own, since retention is per user.
+func PurgeArchives(ctx context.Context) (int, error) {
+	if Archive == nil {
+		return 0, nil
+	}
+	d, ok := Store.(*dynamoDAL)
+	if !ok {
+		return 0, errors.New("archiving only applies to the DynamoDB backend")
+	}
+	return d.purgeArchives(ctx, time.Now().Add(-orphanGrace))
+}
+
+// archiveKey is where c's messages archived at are kept. Each archiving
+// gets its own object, so cleaning up after a rehydration cannot remove a
+// newer archive of the same conversation.
+func archiveKey(c Conversation, at time.Time) string {
+	return fmt.Sprintf("conversations/%s/%s/%d.jsonl.gz", url.PathEscape(c.UserID), c.ID, at.UnixMilli())
+}
+
+// archiveKeyConversation is the conversation ID in an archiveKey.
+func archiveKeyConversation(key string) (string, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 || parts[0] != "conversations" || parts[2] == "" {
+		return "", false
+	}
+	return parts[2], true
+}
+
+func encodeArchive(c Conversation, msgs []ChatMessage, at time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(archiveHeader{Schema: ArchiveSchema, ArchivedAt: at, Conversation: c}); err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeArchive(b []byte) (archiveHeader, []ChatMessage, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return archiveHeader{}, nil, err
+	}
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	var h archiveHeader
+	var msgs []ChatMessage
+	for sc.Scan() {
+		if h.Schema == "" {
+			if err := json.Unmarshal(sc.Bytes(), &h); err != nil {
+				return h, nil, err
+			}
+			if h.Schema != ArchiveSchema {
+				return h, nil, fmt.Errorf("unknown archive schema %q", h.Schema)
+			}
+			continue
+		}
+		var m ChatMessage
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			return h, nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	if err := sc.Err(); err != nil {
+		return h, nil, err
+	}
+	if h.Schema == "" {
+		return h, nil, errors.New("empty archive")
+	}
+	return h, msgs, nil
+}
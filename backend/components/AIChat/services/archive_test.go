@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	c := Conversation{ID: GenerateULID(), UserID: "eu-west-1:a/b", Title: "Cells", MessageCount: 2}
+	msgs := []ChatMessage{
+		{ID: GenerateULID(), ConversationID: c.ID, UserID: c.UserID, Role: "user", Content: "What is a cell?", CreatedAt: at.Add(-time.Hour)},
+		{ID: GenerateULID(), ConversationID: c.ID, UserID: c.UserID, Role: "chatbot", Content: "The unit of life.", CreatedAt: at.Add(-time.Minute), PromptTokens: 3},
+	}
+
+	key := archiveKey(c, at)
+	if id, ok := archiveKeyConversation(key); !ok || id != c.ID {
+		t.Errorf("archiveKeyConversation(%q) = %q, %v", key, id, ok)
+	}
+	for _, other := range []string{"exports/x.json", "conversations/u/c", "conversations/u//1.jsonl.gz"} {
+		if _, ok := archiveKeyConversation(other); ok {
+			t.Errorf("%q taken for an archive key", other)
+		}
+	}
+
+	b, err := encodeArchive(c, msgs, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, got, err := decodeArchive(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.ArchivedAt.Equal(at) || h.Conversation.ID != c.ID || len(got) != 2 || got[1].Content != msgs[1].Content || got[1].PromptTokens != 3 {
+		t.Errorf("decoded %+v, %+v", h, got)
+	}
+}
@@ -1,19 +1,32 @@
 package services
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 )
 
 // Structs to represent request and response payloads
 type ChatGPTRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// StreamOptions asks for a final usage chunk on streamed responses.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // Message here represents a chat message formatted for openai api
@@ -23,14 +36,64 @@ type Message struct {
 }
 
 type ChatGPTResponse struct {
+	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
 }
 
 type Choice struct {
 	Message Message `json:"message"`
 }
 
-// GetChatGPTResponse interacts with the OpenAI API and retrieves the response
+// ChatGPTStreamChunk is one server-sent event of a streamed response.
+type ChatGPTStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta Message `json:"delta"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// OpenAIProvider is the Provider backed by the OpenAI chat completions API
+// or any server that speaks it (Azure OpenAI, a local mock).
+type OpenAIProvider struct {
+	APIKey       string
+	DefaultModel string
+	// BaseURL is the API root, e.g. https://api.openai.com/v1 (the default)
+	// or http://localhost:8089/v1 for a mock server.
+	BaseURL      string
+	Organization string // OpenAI-Organization header
+	Project      string // OpenAI-Project header
+	// Azure switches to Azure OpenAI routing and api-key auth.
+	Azure *AzureConfig
+	// HTTPClient carries proxy and timeout settings; nil means a default client.
+	HTTPClient *http.Client
+}
+
+// AzureConfig routes requests to Azure OpenAI deployments:
+//
+//	{BaseURL}/deployments/{deployment}/chat/completions?api-version={APIVersion}
+//
+// where BaseURL is https://{resource}.openai.azure.com/openai.
+type AzureConfig struct {
+	APIVersion string
+	// Deployments maps model names to deployment names. Models without an
+	// entry use DefaultDeployment, or their own name if that is empty.
+	Deployments       map[string]string
+	DefaultDeployment string
+}
+
+const (
+	defaultOpenAIBaseURL   = "https://api.openai.com/v1"
+	defaultAzureAPIVersion = "2024-06-01"
+)
+
+// GetChatGPTResponse sends a single user message to OpenAI with the default model.
 func GetChatGPTResponse(message string) (string, error) {
 	// Load API key from environment
 	apiKey := os.Getenv("OPENAI_API_KEY")
@@ -48,45 +111,32 @@ func GetChatGPTResponse(message string) (string, error) {
 		return "", fmt.Errorf("OPENAI_API_KEY is not set in environment variables")
 	}
 
-	// OpenAI API URL
-	apiURL := "https://api.openai.com/v1/chat/completions"
-
-	// Construct the request payload
-	requestPayload := ChatGPTRequest{
-		Model: "gpt-4",
-		Messages: []Message{
-			{Role: "user", Content: message},
-		},
+	op, err := NewOpenAIProviderFromEnv()
+	if err != nil {
+		return "", err
 	}
-
-	// Serialize the request payload to JSON
-	requestBody, err := json.Marshal(requestPayload)
+	p, err := withRedaction(op)
 	if err != nil {
-		log.Printf("❌ Failed to marshal request payload: %v", err)
-		return "", fmt.Errorf("failed to marshal request payload: %v", err)
+		return "", err
 	}
+	res, err := p.Complete(context.Background(), CompletionRequest{
+		Messages: []Message{{Role: "user", Content: message}},
+	})
+	return res.Content, err
+}
 
-	log.Printf("📤 Sending request to OpenAI: %s", string(requestBody))
-
-	// Create the HTTP request
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(requestBody))
+// Complete interacts with the OpenAI API and retrieves the response
+func (p *OpenAIProvider) Complete(ctx context.Context, cr CompletionRequest) (CompletionResult, error) {
+	req, err := p.newRequest(ctx, cr, false)
 	if err != nil {
-		log.Printf("❌ Failed to create HTTP request: %v", err)
-		return "", fmt.Errorf("failed to create HTTP request: %v", err)
+		return CompletionResult{}, err
 	}
 
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	log.Printf("🌐 Making HTTP request to: %s", apiURL)
-
 	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.client().Do(req)
 	if err != nil {
 		log.Printf("❌ Failed to make HTTP request: %v", err)
-		return "", fmt.Errorf("failed to make HTTP request: %v", err)
+		return CompletionResult{}, fmt.Errorf("failed to make HTTP request: %v", err)
 	}
 	defer resp.Body.Close()
 
@@ -96,7 +146,7 @@ func GetChatGPTResponse(message string) (string, error) {
 	responseBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("❌ Failed to read response body: %v", err)
-		return "", fmt.Errorf("failed to read response body: %v", err)
+		return CompletionResult{}, fmt.Errorf("failed to read response body: %v", err)
 	}
 
 	log.Printf("📥 Raw response body: %s", string(responseBody))
@@ -104,7 +154,7 @@ func GetChatGPTResponse(message string) (string, error) {
 	// Check for API errors
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("❌ OpenAI API returned %d: %s", resp.StatusCode, string(responseBody))
-		return "", fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(responseBody))
+		return CompletionResult{}, fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(responseBody))
 	}
 
 	// Parse the response JSON
@@ -113,7 +163,7 @@ func GetChatGPTResponse(message string) (string, error) {
 	if err != nil {
 		log.Printf("❌ Failed to unmarshal response: %v", err)
 		log.Printf("❌ Response body was: %s", string(responseBody))
-		return "", fmt.Errorf("failed to unmarshal response: %v", err)
+		return CompletionResult{}, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
 	log.Printf("🔍 Parsed response: %+v", chatResponse)
@@ -123,9 +173,261 @@ func GetChatGPTResponse(message string) (string, error) {
 	if len(chatResponse.Choices) > 0 {
 		content := chatResponse.Choices[0].Message.Content
 		log.Printf("✅ Extracted content: '%s'", content)
-		return content, nil
+		return CompletionResult{
+			Content:          content,
+			Model:            chatResponse.Model,
+			PromptTokens:     chatResponse.Usage.PromptTokens,
+			CompletionTokens: chatResponse.Usage.CompletionTokens,
+		}, nil
 	}
 
 	log.Printf("❌ No choices found in response")
-	return "", fmt.Errorf("no response received from ChatGPT API")
+	return CompletionResult{}, fmt.Errorf("no response received from ChatGPT API")
+}
+
+// CompleteStream is Complete with stream=true: content deltas go to onDelta
+// as the server-sent events arrive.
+func (p *OpenAIProvider) CompleteStream(ctx context.Context, cr CompletionRequest, onDelta func(string)) (CompletionResult, error) {
+	req, err := p.newRequest(ctx, cr, true)
+	if err != nil {
+		return CompletionResult{}, err
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		log.Printf("❌ Failed to make HTTP request: %v", err)
+		return CompletionResult{}, fmt.Errorf("failed to make HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		log.Printf("❌ OpenAI API returned %d: %s", resp.StatusCode, string(body))
+		return CompletionResult{}, fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var res CompletionResult
+	var content strings.Builder
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data:")
+		if !ok {
+			continue // blank separators, comments, event names
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var chunk ChatGPTStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return CompletionResult{}, fmt.Errorf("failed to parse stream chunk: %v", err)
+		}
+		if chunk.Model != "" {
+			res.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			res.PromptTokens = chunk.Usage.PromptTokens
+			res.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				content.WriteString(c.Delta.Content)
+				onDelta(c.Delta.Content)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return CompletionResult{}, fmt.Errorf("failed to read stream: %v", err)
+	}
+	res.Content = content.String()
+	log.Printf("✅ Streamed %d characters", len(res.Content))
+	return res, nil
+}
+
+// newRequest builds the chat completions HTTP request for cr.
+func (p *OpenAIProvider) newRequest(ctx context.Context, cr CompletionRequest, stream bool) (*http.Request, error) {
+	// Construct the request payload
+	requestPayload := ChatGPTRequest{
+		Model:       cr.Model,
+		Messages:    cr.Messages,
+		Temperature: cr.Temperature,
+	}
+	if requestPayload.Model == "" {
+		requestPayload.Model = p.DefaultModel
+	}
+	if stream {
+		requestPayload.Stream = true
+		requestPayload.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+
+	// Serialize the request payload to JSON
+	requestBody, err := json.Marshal(requestPayload)
+	if err != nil {
+		log.Printf("❌ Failed to marshal request payload: %v", err)
+		return nil, fmt.Errorf("failed to marshal request payload: %v", err)
+	}
+
+	log.Printf("📤 Sending request to OpenAI: %s", string(requestBody))
+
+	apiURL, err := p.endpoint(requestPayload.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the HTTP request
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Printf("❌ Failed to create HTTP request: %v", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+
+	// Set headers
+	if p.Azure != nil {
+		req.Header.Set("api-key", p.APIKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	if p.Organization != "" {
+		req.Header.Set("OpenAI-Organization", p.Organization)
+	}
+	if p.Project != "" {
+		req.Header.Set("OpenAI-Project", p.Project)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Printf("🌐 Making HTTP request to: %s", apiURL)
+	return req, nil
+}
+
+func (p *OpenAIProvider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// endpoint is the chat completions URL for model.
+func (p *OpenAIProvider) endpoint(model string) (string, error) {
+	base := strings.TrimRight(p.BaseURL, "/")
+	if base == "" {
+		if p.Azure != nil {
+			return "", fmt.Errorf("azure openai: base URL is required")
+		}
+		base = defaultOpenAIBaseURL
+	}
+	if p.Azure == nil {
+		return base + "/chat/completions", nil
+	}
+	deployment := p.Azure.Deployments[model]
+	if deployment == "" {
+		deployment = p.Azure.DefaultDeployment
+	}
+	if deployment == "" {
+		deployment = model
+	}
+	version := p.Azure.APIVersion
+	if version == "" {
+		version = defaultAzureAPIVersion
+	}
+	return base + "/deployments/" + url.PathEscape(deployment) + "/chat/completions?api-version=" + url.QueryEscape(version), nil
+}
+
+// NewOpenAIProviderFromEnv builds the OpenAI provider from
+//
+//	OPENAI_API_KEY, OPENAI_MODEL (default gpt-4)
+//	OPENAI_BASE_URL            API root (default https://api.openai.com/v1)
+//	OPENAI_ORGANIZATION, OPENAI_PROJECT
+//	OPENAI_PROXY               proxy URL (default: HTTPS_PROXY/NO_PROXY)
+//	OPENAI_TIMEOUT             request timeout, e.g. 25s (default none)
+func NewOpenAIProviderFromEnv() (*OpenAIProvider, error) {
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY is not set in environment variables")
+	}
+	client, err := llmHTTPClient("OPENAI_PROXY", "OPENAI_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAIProvider{
+		APIKey:       apiKey,
+		DefaultModel: envOr("OPENAI_MODEL", "gpt-4"),
+		BaseURL:      os.Getenv("OPENAI_BASE_URL"),
+		Organization: os.Getenv("OPENAI_ORGANIZATION"),
+		Project:      os.Getenv("OPENAI_PROJECT"),
+		HTTPClient:   client,
+	}, nil
+}
+
+// NewAzureProviderFromEnv builds an Azure OpenAI provider from
+//
+//	AZURE_OPENAI_ENDPOINT      https://{resource}.openai.azure.com (the /openai suffix is added)
+//	AZURE_OPENAI_API_KEY
+//	AZURE_OPENAI_API_VERSION   default 2024-06-01
+//	AZURE_OPENAI_DEPLOYMENT    deployment for models without a mapping
+//	AZURE_OPENAI_DEPLOYMENTS   model=deployment pairs, comma-separated
+//	OPENAI_MODEL               model name used for pricing and routing (default gpt-4)
+//	AZURE_OPENAI_PROXY, AZURE_OPENAI_TIMEOUT  as for OpenAI
+func NewAzureProviderFromEnv() (*OpenAIProvider, error) {
+	endpoint := strings.TrimRight(os.Getenv("AZURE_OPENAI_ENDPOINT"), "/")
+	apiKey := os.Getenv("AZURE_OPENAI_API_KEY")
+	if endpoint == "" || apiKey == "" {
+		return nil, fmt.Errorf("AI_PROVIDER=azure requires AZURE_OPENAI_ENDPOINT and AZURE_OPENAI_API_KEY")
+	}
+	if !strings.HasSuffix(endpoint, "/openai") {
+		endpoint += "/openai"
+	}
+	deployments := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("AZURE_OPENAI_DEPLOYMENTS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		model, deployment, ok := strings.Cut(pair, "=")
+		if !ok || model == "" || deployment == "" {
+			return nil, fmt.Errorf("AZURE_OPENAI_DEPLOYMENTS: %q is not model=deployment", pair)
+		}
+		deployments[strings.TrimSpace(model)] = strings.TrimSpace(deployment)
+	}
+	client, err := llmHTTPClient("AZURE_OPENAI_PROXY", "AZURE_OPENAI_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAIProvider{
+		APIKey:       apiKey,
+		DefaultModel: envOr("OPENAI_MODEL", "gpt-4"),
+		BaseURL:      endpoint,
+		HTTPClient:   client,
+		Azure: &AzureConfig{
+			APIVersion:        envOr("AZURE_OPENAI_API_VERSION", defaultAzureAPIVersion),
+			Deployments:       deployments,
+			DefaultDeployment: os.Getenv("AZURE_OPENAI_DEPLOYMENT"),
+		},
+	}, nil
+}
+
+// llmHTTPClient reads a proxy URL and timeout from the named variables.
+// Without a proxy variable the standard HTTPS_PROXY/NO_PROXY apply.
+func llmHTTPClient(proxyVar, timeoutVar string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if raw := os.Getenv(proxyVar); raw != "" {
+		proxy, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", proxyVar, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	client := &http.Client{Transport: transport}
+	if raw := os.Getenv(timeoutVar); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", timeoutVar, err)
+		}
+		client.Timeout = d
+	}
+	return client, nil
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
 }
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// mockCompletions stands in for the chat completions API. It records the
+// last request and answers "You said: ..." like cmd/mockllm.
+type mockCompletions struct {
+	t       *testing.T
+	lastReq *http.Request
+	lastURL string
+	body    ChatGPTRequest
+}
+
+func (m *mockCompletions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lastReq, m.lastURL = r, r.URL.String()
+	if err := json.NewDecoder(r.Body).Decode(&m.body); err != nil {
+		m.t.Errorf("decode request: %v", err)
+	}
+	if strings.Contains(m.body.Messages[len(m.body.Messages)-1].Content, "fail") {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"message": "rate limited"}}`)
+		return
+	}
+	answer := "You said: " + m.body.Messages[len(m.body.Messages)-1].Content
+	if m.body.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range strings.SplitAfter(answer, " ") {
+			fmt.Fprintf(w, "data: {\"model\": %q, \"choices\": [{\"delta\": {\"content\": %q}}]}\n\n", m.body.Model, word)
+		}
+		fmt.Fprint(w, "data: {\"choices\": [], \"usage\": {\"prompt_tokens\": 3, \"completion_tokens\": 4}}\n\ndata: [DONE]\n\n")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(ChatGPTResponse{
+		Model:   m.body.Model,
+		Choices: []Choice{{Message: Message{Role: "assistant", Content: answer}}},
+		Usage:   Usage{PromptTokens: 3, CompletionTokens: 4},
+	})
+}
+
+func newMockCompletions(t *testing.T) (*mockCompletions, *httptest.Server) {
+	m := &mockCompletions{t: t}
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	return m, srv
+}
+
+func userMessage(content string) CompletionRequest {
+	return CompletionRequest{Messages: []Message{{Role: "user", Content: content}}}
+}
+
+func TestOpenAIProviderAgainstMockServer(t *testing.T) {
+	m, srv := newMockCompletions(t)
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_BASE_URL", srv.URL+"/v1/")
+	t.Setenv("OPENAI_MODEL", "gpt-4o-mini")
+	t.Setenv("OPENAI_ORGANIZATION", "org-1")
+	t.Setenv("OPENAI_PROJECT", "proj-1")
+	p, err := NewOpenAIProviderFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := p.Complete(context.Background(), userMessage("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Content != "You said: hello" || res.Model != "gpt-4o-mini" || res.PromptTokens != 3 || res.CompletionTokens != 4 {
+		t.Errorf("result = %+v", res)
+	}
+	if m.lastURL != "/v1/chat/completions" {
+		t.Errorf("path = %s", m.lastURL)
+	}
+	for name, want := range map[string]string{
+		"Authorization":       "Bearer sk-test",
+		"OpenAI-Organization": "org-1",
+		"OpenAI-Project":      "proj-1",
+	} {
+		if got := m.lastReq.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	var deltas []string
+	res, err = p.CompleteStream(context.Background(), userMessage("stream me"), func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Content != "You said: stream me" || len(deltas) != 4 || res.CompletionTokens != 4 {
+		t.Errorf("streamed %+v in %q", res, deltas)
+	}
+	if !m.body.Stream || m.body.StreamOptions == nil || !m.body.StreamOptions.IncludeUsage {
+		t.Errorf("stream request = %+v", m.body)
+	}
+
+	if _, err := p.Complete(context.Background(), userMessage("please fail")); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("error = %v, want the 429 status", err)
+	}
+}
+
+func TestAzureProviderAgainstMockServer(t *testing.T) {
+	m, srv := newMockCompletions(t)
+	t.Setenv("AZURE_OPENAI_ENDPOINT", srv.URL)
+	t.Setenv("AZURE_OPENAI_API_KEY", "az-key")
+	t.Setenv("AZURE_OPENAI_API_VERSION", "2024-10-21")
+	t.Setenv("AZURE_OPENAI_DEPLOYMENT", "default-dep")
+	t.Setenv("AZURE_OPENAI_DEPLOYMENTS", "gpt-4o=chat-4o, gpt-4o-mini = chat-mini")
+	t.Setenv("OPENAI_MODEL", "gpt-4o")
+	p, err := NewAzureProviderFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for model, path := range map[string]string{
+		"":            "/openai/deployments/chat-4o/chat/completions?api-version=2024-10-21",
+		"gpt-4o-mini": "/openai/deployments/chat-mini/chat/completions?api-version=2024-10-21",
+		"gpt-35":      "/openai/deployments/default-dep/chat/completions?api-version=2024-10-21",
+	} {
+		req := userMessage("hi")
+		req.Model = model
+		if _, err := p.Complete(context.Background(), req); err != nil {
+			t.Fatalf("%q: %v", model, err)
+		}
+		if m.lastURL != path {
+			t.Errorf("%q: url = %s, want %s", model, m.lastURL, path)
+		}
+		if m.lastReq.Header.Get("api-key") != "az-key" || m.lastReq.Header.Get("Authorization") != "" {
+			t.Errorf("%q: auth headers = %v", model, m.lastReq.Header)
+		}
+	}
+
+	t.Setenv("AZURE_OPENAI_DEPLOYMENTS", "gpt-4o")
+	if _, err := NewAzureProviderFromEnv(); err == nil {
+		t.Error("expected an error for a deployment mapping without '='")
+	}
+}
+
+func TestOpenAIProxyFromEnv(t *testing.T) {
+	// The mock server is the proxy: a proxied request arrives with the
+	// absolute URL of the real API.
+	m, srv := newMockCompletions(t)
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_BASE_URL", "http://api.openai.invalid/v1")
+	t.Setenv("OPENAI_PROXY", srv.URL)
+	t.Setenv("OPENAI_TIMEOUT", "5s")
+	p, err := NewOpenAIProviderFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.HTTPClient.Timeout.String() != "5s" {
+		t.Errorf("timeout = %v", p.HTTPClient.Timeout)
+	}
+	if _, err := p.Complete(context.Background(), userMessage("via proxy")); err != nil {
+		t.Fatal(err)
+	}
+	if m.lastURL != "http://api.openai.invalid/v1/chat/completions" {
+		t.Errorf("proxied url = %s", m.lastURL)
+	}
+
+	t.Setenv("OPENAI_TIMEOUT", "soon")
+	if _, err := NewOpenAIProviderFromEnv(); err == nil {
+		t.Error("expected an error for a bad OPENAI_TIMEOUT")
+	}
+}
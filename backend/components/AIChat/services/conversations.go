@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidConversation = errors.New("invalid conversation update")
+
+const maxTitleLength = 200
+
+// Normalize trims the update's values and checks them.
+func (u *ConversationUpdate) Normalize() error {
+	if u.Title == nil && u.Settings == nil {
+		return fmt.Errorf("%w: nothing to update", ErrInvalidConversation)
+	}
+	if u.Title != nil {
+		t := strings.TrimSpace(*u.Title)
+		if t == "" || utf8.RuneCountInString(t) > maxTitleLength {
+			return fmt.Errorf("%w: title must be 1 to %d characters", ErrInvalidConversation, maxTitleLength)
+		}
+		u.Title = &t
+	}
+	if u.Settings != nil {
+		u.Settings.Language = strings.ToLower(strings.TrimSpace(u.Settings.Language))
+		if u.Settings.Language != "" && !ValidLanguage(u.Settings.Language) {
+			return fmt.Errorf("%w: settings.language %q is not a supported ISO 639-1 code", ErrInvalidConversation, u.Settings.Language)
+		}
+	}
+	if u.IfVersion != nil && *u.IfVersion < 0 {
+		return fmt.Errorf("%w: version must not be negative", ErrInvalidConversation)
+	}
+	return nil
+}
+
+// loadConversationSettings never fails an answer: an unreadable
+// conversation means no overrides.
+func loadConversationSettings(ctx context.Context, userID, conversationID string) ConversationSettings {
+	if Store == nil || userID == "" || conversationID == "" {
+		return ConversationSettings{}
+	}
+	c, err := Store.GetConversation(ctx, userID, conversationID)
+	if err != nil {
+		if !errors.Is(err, ErrConversationNotFound) {
+			log.Printf("⚠️ Failed to load settings of conversation %s: %v", conversationID, err)
+		}
+		return ConversationSettings{}
+	}
+	return c.Settings
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pagination cursors. A backend's raw position (a DynamoDB LastEvaluatedKey,
+// an SQL row key) is sealed before it leaves the service, so a client
+// cannot edit one to point into another user's data:
+//
+//	v1.<base64url(expiry || position)>.<base64url(HMAC-SHA256)>
+//	v1e.<base64url(nonce || AES-GCM(expiry || position))>.<base64url(HMAC-SHA256)>
+//
+// The MAC covers the version, the body and the cursor's scope: the query
+// type and what it is bound to (the user, the conversation, the filter).
+// The scope itself is not in the token, so a cursor only opens for the
+// query that issued it. The encrypted form is used when
+// CURSOR_ENCRYPTION_KEY is set; it also hides the keys from clients.
+const (
+	cursorVersion          = "v1"
+	cursorVersionEncrypted = "v1e"
+	cursorTTL              = 24 * time.Hour
+)
+
+// ErrInvalidCursor is returned by list methods for a nextToken that is
+// malformed, tampered with, expired or issued for another query.
+var ErrInvalidCursor = errors.New("invalid nextToken")
+
+// cursorScope identifies the query a cursor belongs to.
+type cursorScope string
+
+// newCursorScope binds cursors to query and the values that define its
+// result set.
+func newCursorScope(query string, bindings ...string) cursorScope {
+	b, _ := json.Marshal(append([]string{query}, bindings...))
+	return cursorScope(b)
+}
+
+var cursorKeys struct {
+	once sync.Once
+	sign []byte
+	aead cipher.AEAD
+}
+
+// loadCursorKeys derives the keys from CURSOR_SIGNING_KEY and the optional
+// CURSOR_ENCRYPTION_KEY. Without a signing key a random one is used, so
+// cursors only work on the instance that issued them.
+func loadCursorKeys() {
+	if secret := os.Getenv("CURSOR_SIGNING_KEY"); secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		cursorKeys.sign = sum[:]
+	} else {
+		cursorKeys.sign = make([]byte, 32)
+		if _, err := rand.Read(cursorKeys.sign); err != nil {
+			panic(err)
+		}
+		log.Println("⚠️ CURSOR_SIGNING_KEY not set; pagination cursors are only valid on this instance")
+	}
+	if secret := os.Getenv("CURSOR_ENCRYPTION_KEY"); secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			panic(err)
+		}
+		if cursorKeys.aead, err = cipher.NewGCM(block); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func cursorMAC(version string, scope cursorScope, body []byte) []byte {
+	mac := hmac.New(sha256.New, cursorKeys.sign)
+	mac.Write([]byte(version))
+	mac.Write([]byte{0})
+	mac.Write([]byte(scope))
+	mac.Write([]byte{0})
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// sealCursor turns a backend position into a nextToken for scope.
+func sealCursor(scope cursorScope, position []byte) string {
+	cursorKeys.once.Do(loadCursorKeys)
+	plain := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(cursorTTL).Unix()))
+	plain = append(plain, position...)
+
+	version, body := cursorVersion, plain
+	if cursorKeys.aead != nil {
+		nonce := make([]byte, cursorKeys.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			panic(err)
+		}
+		version, body = cursorVersionEncrypted, cursorKeys.aead.Seal(nonce, nonce, plain, nil)
+	}
+	enc := base64.RawURLEncoding
+	return version + "." + enc.EncodeToString(body) + "." + enc.EncodeToString(cursorMAC(version, scope, body))
+}
+
+// openCursor checks a nextToken issued by sealCursor for the same scope and
+// returns its position. All failures wrap ErrInvalidCursor.
+func openCursor(scope cursorScope, token string) ([]byte, error) {
+	cursorKeys.once.Do(loadCursorKeys)
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	version := parts[0]
+	if version != cursorVersion && version != cursorVersionEncrypted {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrInvalidCursor, version)
+	}
+	enc := base64.RawURLEncoding
+	body, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	mac, err := enc.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(mac, cursorMAC(version, scope, body)) {
+		return nil, fmt.Errorf("%w: signature mismatch (altered, or issued for another query)", ErrInvalidCursor)
+	}
+
+	plain := body
+	if version == cursorVersionEncrypted {
+		aead := cursorKeys.aead
+		if aead == nil || len(body) < aead.NonceSize() {
+			return nil, fmt.Errorf("%w: cannot decrypt", ErrInvalidCursor)
+		}
+		n := aead.NonceSize()
+		if plain, err = aead.Open(nil, body[:n], body[n:], nil); err != nil {
+			return nil, fmt.Errorf("%w: cannot decrypt", ErrInvalidCursor)
+		}
+	}
+	if len(plain) < 8 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	if time.Now().Unix() > int64(binary.BigEndian.Uint64(plain)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidCursor)
+	}
+	return plain[8:], nil
+}
+
+// Scopes of the DAL's list queries, shared by the backends.
+
+func conversationsCursor(userID string) cursorScope {
+	return newCursorScope("conversations", userID)
+}
+
+func trashCursor(userID string) cursorScope {
+	return newCursorScope("trash", userID)
+}
+
+func expiredTrashCursor(deletedBefore time.Time) cursorScope {
+	return newCursorScope("expired-trash", deletedBefore.UTC().Format(time.RFC3339Nano))
+}
+
+func messagesCursor(conversationID string, r MessageRange, newestFirst bool) cursorScope {
+	return newCursorScope("messages", conversationID, r.After, r.Before, fmt.Sprint(newestFirst))
+}
+
+func userMessagesCursor(userID string, since time.Time) cursorScope {
+	return newCursorScope("user-messages", userID, since.UTC().Format(time.RFC3339Nano))
+}
@@ -2,26 +2,118 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
 	"time"
 )
 
+type Conversation struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Activity, kept up to date by every message write: the newest
+	// message's time and start of its content, and running totals.
+	LastMessageAt      *time.Time `json:"lastMessageAt,omitempty"`
+	LastMessagePreview string     `json:"lastMessagePreview,omitempty"`
+	MessageCount       int        `json:"messageCount"`
+	PromptTokens       int        `json:"promptTokens,omitempty"`
+	CompletionTokens   int        `json:"completionTokens,omitempty"`
+	// Set while the conversation is in the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Set while the messages are in cold storage (see ArchiveInactive);
+	// opening the conversation brings them back.
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+	// Set if the archived messages could not be found when the
+	// conversation was opened; they are gone.
+	ArchiveLostAt *time.Time           `json:"archiveLostAt,omitempty"`
+	Settings      ConversationSettings `json:"settings"`
+	// Version counts UpdateConversation changes, for optimistic locking.
+	Version int `json:"version"`
+}
 
+// ConversationSettings are per-conversation overrides of the student's
+// preferences.
+type ConversationSettings struct {
+	// Language answers are given in (ISO 639-1), instead of the preferred
+	// or detected one.
+	Language string `json:"language,omitempty"`
+}
 
-type Conversation struct {
-	ID		string    `json:"id"`
-	UserID		string    `json:"userId"`
-	Title		string    `json:"title"`
-	CreatedAt	time.Time `json:"createdAt"`
+// ConversationUpdate changes the fields that are set.
+type ConversationUpdate struct {
+	Title    *string               `json:"title,omitempty"`
+	Settings *ConversationSettings `json:"settings,omitempty"`
+	// IfVersion makes the update conditional on the stored version.
+	IfVersion *int `json:"version,omitempty"`
+}
+
+// ActivityAt orders conversations by recent activity: the newest message,
+// or creation for a conversation without messages.
+func (c Conversation) ActivityAt() time.Time {
+	if c.LastMessageAt != nil {
+		return *c.LastMessageAt
+	}
+	return c.CreatedAt
+}
 
+// previewLength is how many characters of the newest message a
+// conversation keeps as its preview.
+const previewLength = 120
+
+func messagePreview(content string) string {
+	r := []rune(strings.TrimSpace(content))
+	if len(r) <= previewLength {
+		return string(r)
+	}
+	return string(r[:previewLength-1]) + "…"
 }
 
 type ChatMessage struct {
 	ID             string    `json:"id"`
 	ConversationID string    `json:"conversationId"`
-	UserID 	       string    `json:"userId"`
+	UserID         string    `json:"userId"`
 	Role           string    `json:"role"`
 	Content        string    `json:"content"`
 	CreatedAt      time.Time `json:"createdAt"`
+	// Prompt template that produced a generated message; empty for user messages.
+	TemplateID      string `json:"templateId,omitempty"`
+	TemplateVersion int    `json:"templateVersion,omitempty"`
+	// A/B experiment arm the exchange was assigned to, if any.
+	ExperimentID string `json:"experimentId,omitempty"`
+	Variant      string `json:"variant,omitempty"`
+	// Model and token usage of a generated message.
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int    `json:"promptTokens,omitempty"`
+	CompletionTokens int    `json:"completionTokens,omitempty"`
+	// Detected (user) or requested (chatbot) ISO 639-1 language, and the
+	// native-language copy of a chatbot answer in translation mode.
+	Language            string `json:"language,omitempty"`
+	Translation         string `json:"translation,omitempty"`
+	TranslationLanguage string `json:"translationLanguage,omitempty"`
+	// Set once the message has been edited; Edits holds the replaced
+	// contents, oldest first.
+	EditedAt *time.Time    `json:"editedAt,omitempty"`
+	Edits    []MessageEdit `json:"edits,omitempty"`
+}
+
+// MessageEdit is an earlier content of an edited message and when it was
+// replaced.
+type MessageEdit struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"editedAt"`
+}
+
+// MessageRange narrows a message listing to the messages strictly after
+// After and before Before, each a message ID in the same conversation.
+// Empty bounds are open.
+type MessageRange struct {
+	After  string
+	Before string
 }
 
 // Generic paged list (Dynamo uses a "cursor" token, not offset)
@@ -32,10 +124,153 @@ type ListPage[T any] struct {
 
 type DAL interface {
 	CreateConversation(ctx context.Context, userID, title string) (string, error)
+	// ImportConversation stores c with its given ID and CreatedAt unless a
+	// conversation with that ID exists, and reports whether it did. An
+	// existing one is left as it is, so imports can be repeated; it returns
+	// ErrConversationNotFound if the ID is another user's.
+	ImportConversation(ctx context.Context, c Conversation) (bool, error)
+	// GetConversation returns one of userID's conversations, including
+	// trashed ones, or ErrConversationNotFound.
+	GetConversation(ctx context.Context, userID, conversationID string) (Conversation, error)
+	// UpdateConversation applies u to one of userID's active conversations
+	// in a single conditional write, bumps its version and returns the
+	// result. It fails with ErrConversationNotFound, or ErrVersionConflict
+	// when u.IfVersion no longer matches.
+	UpdateConversation(ctx context.Context, userID, conversationID string, u ConversationUpdate) (Conversation, error)
+	// ListConversations returns the user's conversations, most recently
+	// active first (see Conversation.ActivityAt).
 	ListConversations(ctx context.Context, userID string, limit int32, nextToken string) (ListPage[Conversation], error)
+	// PutMessage stores m and updates its conversation's activity in the
+	// same write. m.UserID must own the conversation and it must not be in
+	// the trash, else it returns ErrConversationNotFound. Rewriting an existing message replaces it
+	// without counting it again; a message older than the newest one counts
+	// but leaves lastMessageAt and the preview alone.
 	PutMessage(ctx context.Context, m ChatMessage) error
+	// PutExchange stores a student message and its answer together with the
+	// conversation's activity, all or nothing. Both messages must be in the
+	// same conversation and by its owner; it returns ErrConversationNotFound
+	// if the owner has no such active conversation.
+	PutExchange(ctx context.Context, userMsg, botMsg ChatMessage) error
+	// PutMessages stores many messages, e.g. an import, and adds them to
+	// their conversations' activity. When only some are written the error
+	// is a *PartialWriteError.
+	PutMessages(ctx context.Context, msgs []ChatMessage) error
 	ListMessages(ctx context.Context, conversationID string, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error)
-	DeleteConversationCascade(ctx context.Context, conversationID string) error
+	// ListMessagesRange is ListMessages within r; nextToken pages stay
+	// inside it. It returns ErrMessageNotFound when a bound is not one of
+	// the conversation's messages.
+	ListMessagesRange(ctx context.Context, conversationID string, r MessageRange, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error)
 	ListUserMessagesSince(ctx context.Context, userID string, since time.Time, limit int32, nextToken string) (ListPage[ChatMessage], error)
+
+	// GetMessage returns a message by ID, or ErrMessageNotFound.
+	GetMessage(ctx context.Context, conversationID, messageID string) (ChatMessage, error)
+	// UpdateMessage replaces a message's content, appending the old one to
+	// its edit history, and refreshes the conversation preview when it is
+	// the newest message. DeleteMessage removes a message and takes it out
+	// of the conversation's activity. Both need one of userID's active
+	// conversations (else ErrConversationNotFound) and return
+	// ErrMessageNotFound for an unknown message.
+	UpdateMessage(ctx context.Context, userID, conversationID, messageID, content string, at time.Time) (ChatMessage, error)
+	DeleteMessage(ctx context.Context, userID, conversationID, messageID string) error
+
+	// Deletion is two-step. TrashConversation hides a conversation from
+	// ListConversations until RestoreConversation brings it back or the
+	// purge calls DeleteConversationCascade. These take the owner and return
+	// ErrConversationNotFound when userID has no such conversation: none
+	// active to trash, none trashed after deletedAfter to restore.
+	TrashConversation(ctx context.Context, userID, conversationID string, at time.Time) error
+	RestoreConversation(ctx context.Context, userID, conversationID string, deletedAfter time.Time) error
+	ListTrashedConversations(ctx context.Context, userID string, limit int32, nextToken string) (ListPage[Conversation], error)
+	// ListExpiredTrash returns every user's conversations trashed before
+	// deletedBefore, oldest first.
+	ListExpiredTrash(ctx context.Context, deletedBefore time.Time, limit int32, nextToken string) (ListPage[Conversation], error)
+	// DeleteConversationCascade permanently removes a conversation, trashed
+	// or not, with all its messages.
+	DeleteConversationCascade(ctx context.Context, userID, conversationID string) error
 }
 
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrMessageNotFound      = errors.New("message not found")
+	ErrVersionConflict      = errors.New("conversation was modified concurrently")
+)
+
+// PartialWriteError reports a PutMessages call that stored some messages
+// but not all. Retrying with just the failed messages is safe.
+type PartialWriteError struct {
+	Written   int
+	FailedIDs []string
+	Err       error
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%d message(s) written, %d failed: %v", e.Written, len(e.FailedIDs), e.Err)
+}
+
+func (e *PartialWriteError) Unwrap() error { return e.Err }
+
+// editMessage applies an UpdateMessage to m.
+func editMessage(m ChatMessage, content string, at time.Time) ChatMessage {
+	at = at.UTC()
+	m.Edits = append(slices.Clip(m.Edits), MessageEdit{Content: m.Content, EditedAt: at})
+	m.Content = content
+	m.EditedAt = &at
+	return m
+}
+
+// checkExchange validates the pair given to PutExchange.
+func checkExchange(userMsg, botMsg ChatMessage) error {
+	if userMsg.ConversationID != botMsg.ConversationID || userMsg.UserID != botMsg.UserID {
+		return errors.New("exchange messages must share a conversation and user")
+	}
+	return nil
+}
+
+// Global, used by your handlers/services.
+var Store DAL
+
+// InitDAL picks the storage backend from DAL_BACKEND: "dynamo" (the
+// default), "postgres" (DATABASE_URL), "sqlite" (file SQLITE_PATH, default
+// chatbot.db) or "memory". Call once during cold start.
+//
+// The SQL and memory backends only provide Store; templates, experiments,
+// feedback and the other stores stay nil, which disables those features.
+func InitDAL() error {
+	switch backend := os.Getenv("DAL_BACKEND"); backend {
+	case "", "dynamo":
+		return initDynamoDAL()
+	case "postgres":
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			return errors.New("DATABASE_URL env var is required for DAL_BACKEND=postgres")
+		}
+		d, err := NewPostgresDAL(context.Background(), dsn)
+		if err != nil {
+			return err
+		}
+		Store = d
+		Idempotency = &sqlIdempotencyStore{d: d}
+		log.Println("🐘 Using PostgreSQL storage")
+		return nil
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "chatbot.db"
+		}
+		d, err := NewSQLiteDAL(context.Background(), path)
+		if err != nil {
+			return err
+		}
+		Store = d
+		Idempotency = &sqlIdempotencyStore{d: d}
+		log.Printf("💾 Using SQLite storage at %s", path)
+		return nil
+	case "memory":
+		Store = NewMemoryDAL()
+		Idempotency = NewMemoryIdempotency()
+		log.Println("🧠 Using in-memory storage; data is lost on exit")
+		return nil
+	default:
+		return fmt.Errorf("unknown DAL_BACKEND %q", backend)
+	}
+}
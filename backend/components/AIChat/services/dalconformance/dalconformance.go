@@ -0,0 +1,1184 @@
+// Package dalconformance is the behavioural spec every services.DAL
+// implementation must meet. Each Case runs against a live DAL and returns
+// an error describing the first violation. Cases create their own users and
+// conversations, so they can share one (even persistent) backend.
+//
+// cmd/dalconformance runs the suite against any configured backend; go test
+// runs it on memory and SQLite, and on PostgreSQL and DynamoDB Local when
+// DATABASE_URL or AWS_ENDPOINT_URL_DYNAMODB is set.
+//
+// Paging rules all backends share: pages hold at most limit items, limit
+// must be at least 1, and following NextToken until it is empty visits
+// every item exactly once in order. A backend may return a NextToken on the
+// last full page followed by an empty page (DynamoDB does).
+package dalconformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+)
+
+type Case struct {
+	Name string
+	Run  func(ctx context.Context, d services.DAL) error
+}
+
+var Cases = []Case{
+	{"MessageFieldsRoundTrip", messageFieldsRoundTrip},
+	{"Limits", limits},
+	{"InvalidNextToken", invalidNextToken},
+	{"ForeignNextToken", foreignNextToken},
+	{"ListMessagesNewestFirst", func(ctx context.Context, d services.DAL) error { return listMessagesOrder(ctx, d, true) }},
+	{"ListMessagesOldestFirst", func(ctx context.Context, d services.DAL) error { return listMessagesOrder(ctx, d, false) }},
+	{"ListMessagesRange", listMessagesRange},
+	{"WholeSecondOrder", wholeSecondOrder},
+	{"ListConversationsPaging", listConversationsPaging},
+	{"ConversationActivity", conversationActivity},
+	{"ListUserMessagesSinceBoundary", listUserMessagesSinceBoundary},
+	{"ListUserMessagesSincePaging", listUserMessagesSincePaging},
+	{"DeleteConversationCascade", deleteConversationCascade},
+	{"TrashAndRestore", trashAndRestore},
+	{"GetAndUpdateConversation", getAndUpdateConversation},
+	{"PutExchange", putExchange},
+	{"MessageCRUD", messageCRUD},
+	{"PutMessagesBatch", putMessagesBatch},
+	{"ImportConversation", importConversation},
+	{"ListExpiredTrash", listExpiredTrash},
+	{"ConcurrentWrites", concurrentWrites},
+}
+
+// Result is the outcome of one case.
+type Result struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Run runs the cases whose names contain filter (all when empty).
+func Run(ctx context.Context, d services.DAL, filter string) []Result {
+	var results []Result
+	for _, c := range Cases {
+		if filter != "" && !strings.Contains(c.Name, filter) {
+			continue
+		}
+		start := time.Now()
+		err := c.Run(ctx, d)
+		results = append(results, Result{Name: c.Name, Err: err, Duration: time.Since(start)})
+	}
+	return results
+}
+
+// ---------- fixtures ----------
+
+func newUser() string { return "conformance-" + services.GenerateULID() }
+
+// baseTime is a fixed-width millisecond timestamp in the past; cases step
+// from it in whole seconds.
+func baseTime() time.Time {
+	return time.Now().UTC().Add(-time.Hour).Truncate(time.Second).Add(123 * time.Millisecond)
+}
+
+func message(conversationID, userID string, at time.Time, content string) services.ChatMessage {
+	return services.ChatMessage{
+		ID:             services.GenerateULID(),
+		ConversationID: conversationID,
+		UserID:         userID,
+		Role:           "user",
+		Content:        content,
+		CreatedAt:      at,
+	}
+}
+
+// putMessages stores n messages one second apart from start and returns
+// them oldest first.
+func putMessages(ctx context.Context, d services.DAL, conversationID, userID string, start time.Time, n int) ([]services.ChatMessage, error) {
+	var out []services.ChatMessage
+	for i := 0; i < n; i++ {
+		m := message(conversationID, userID, start.Add(time.Duration(i)*time.Second), fmt.Sprintf("message %d", i))
+		if err := d.PutMessage(ctx, m); err != nil {
+			return nil, fmt.Errorf("PutMessage: %w", err)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// findConversation looks up one of userID's active conversations.
+func findConversation(ctx context.Context, d services.DAL, userID, conversationID string) (services.Conversation, error) {
+	convs, err := collect(100, func(token string) (services.ListPage[services.Conversation], error) {
+		return d.ListConversations(ctx, userID, 100, token)
+	})
+	if err != nil {
+		return services.Conversation{}, fmt.Errorf("ListConversations: %w", err)
+	}
+	for _, c := range convs {
+		if c.ID == conversationID {
+			return c, nil
+		}
+	}
+	return services.Conversation{}, fmt.Errorf("ListConversations did not return %s", conversationID)
+}
+
+// collect follows NextToken from the first page to the end.
+func collect[T any](limit int32, list func(token string) (services.ListPage[T], error)) ([]T, error) {
+	var all []T
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 1000 {
+			return nil, errors.New("NextToken never ran out")
+		}
+		page, err := list(token)
+		if err != nil {
+			return nil, err
+		}
+		if int32(len(page.Items)) > limit {
+			return nil, fmt.Errorf("page has %d items, limit is %d", len(page.Items), limit)
+		}
+		all = append(all, page.Items...)
+		if page.NextToken == "" {
+			return all, nil
+		}
+		if page.NextToken == token {
+			return nil, errors.New("NextToken did not advance")
+		}
+		token = page.NextToken
+	}
+}
+
+func ids(msgs []services.ChatMessage) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.ID
+	}
+	return out
+}
+
+func reversed(s []string) []string {
+	out := make([]string, len(s))
+	for i, v := range s {
+		out[len(s)-1-i] = v
+	}
+	return out
+}
+
+func sameOrder(what string, got, want []string) error {
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%s: got %v, want %v", what, got, want)
+	}
+	return nil
+}
+
+// ---------- cases ----------
+
+func messageFieldsRoundTrip(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Round trip")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	want := services.ChatMessage{
+		ID:                  services.GenerateULID(),
+		ConversationID:      cid,
+		UserID:              user,
+		Role:                "chatbot",
+		Content:             "Photosynthesis turns light into chemical energy. 🌱",
+		CreatedAt:           baseTime(),
+		TemplateID:          "chat-system",
+		TemplateVersion:     3,
+		ExperimentID:        "exp-1",
+		Variant:             "b",
+		Model:               "gpt-4o-mini",
+		PromptTokens:        120,
+		CompletionTokens:    45,
+		Language:            "en",
+		Translation:         "La fotosíntesis convierte la luz en energía química. 🌱",
+		TranslationLanguage: "es",
+	}
+	if err := d.PutMessage(ctx, want); err != nil {
+		return fmt.Errorf("PutMessage: %w", err)
+	}
+	page, err := d.ListMessages(ctx, cid, 10, "", false)
+	if err != nil {
+		return fmt.Errorf("ListMessages: %w", err)
+	}
+	if len(page.Items) != 1 {
+		return fmt.Errorf("ListMessages returned %d messages, want 1", len(page.Items))
+	}
+	got := page.Items[0]
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		return fmt.Errorf("CreatedAt: got %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+	got.CreatedAt = want.CreatedAt
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("message changed in storage:\n got %+v\nwant %+v", got, want)
+	}
+
+	convs, err := d.ListConversations(ctx, user, 10, "")
+	if err != nil {
+		return fmt.Errorf("ListConversations: %w", err)
+	}
+	if len(convs.Items) != 1 || convs.Items[0].ID != cid || convs.Items[0].UserID != user || convs.Items[0].Title != "Round trip" || convs.Items[0].CreatedAt.IsZero() {
+		return fmt.Errorf("ListConversations: got %+v", convs.Items)
+	}
+	return nil
+}
+
+func limits(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Limits")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	if _, err := putMessages(ctx, d, cid, user, baseTime(), 5); err != nil {
+		return err
+	}
+	for _, limit := range []int32{1, 2, 5, 100} {
+		page, err := d.ListMessages(ctx, cid, limit, "", true)
+		if err != nil {
+			return fmt.Errorf("ListMessages(limit %d): %w", limit, err)
+		}
+		want := int(limit)
+		if want > 5 {
+			want = 5
+		}
+		if len(page.Items) != want {
+			return fmt.Errorf("ListMessages(limit %d) returned %d messages, want %d", limit, len(page.Items), want)
+		}
+		if limit < 5 && page.NextToken == "" {
+			return fmt.Errorf("ListMessages(limit %d) returned no NextToken with more messages left", limit)
+		}
+	}
+	if _, err := d.ListMessages(ctx, cid, 0, "", true); err == nil {
+		return errors.New("ListMessages(limit 0) succeeded, want an error")
+	}
+	if _, err := d.ListConversations(ctx, user, 0, ""); err == nil {
+		return errors.New("ListConversations(limit 0) succeeded, want an error")
+	}
+	return nil
+}
+
+func invalidNextToken(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	if _, err := d.ListMessages(ctx, services.GenerateULID(), 10, "not a token!", true); !errors.Is(err, services.ErrInvalidCursor) {
+		return fmt.Errorf("ListMessages with a garbage NextToken: got %v, want ErrInvalidCursor", err)
+	}
+	if _, err := d.ListConversations(ctx, user, 10, "not a token!"); !errors.Is(err, services.ErrInvalidCursor) {
+		return fmt.Errorf("ListConversations with a garbage NextToken: got %v, want ErrInvalidCursor", err)
+	}
+	if _, err := d.ListUserMessagesSince(ctx, user, baseTime(), 10, "not a token!"); !errors.Is(err, services.ErrInvalidCursor) {
+		return fmt.Errorf("ListUserMessagesSince with a garbage NextToken: got %v, want ErrInvalidCursor", err)
+	}
+	return nil
+}
+
+// foreignNextToken checks that a NextToken only continues the query that
+// issued it: not another user's or conversation's, not another direction,
+// and not once edited.
+func foreignNextToken(ctx context.Context, d services.DAL) error {
+	user, other := newUser(), newUser()
+	for _, u := range []string{user, other} {
+		for i := 0; i < 2; i++ {
+			if _, err := d.CreateConversation(ctx, u, "Cursor"); err != nil {
+				return fmt.Errorf("CreateConversation: %w", err)
+			}
+		}
+	}
+	page, err := d.ListConversations(ctx, user, 1, "")
+	if err != nil || page.NextToken == "" {
+		return fmt.Errorf("ListConversations returned no NextToken (err %v)", err)
+	}
+	if _, err := d.ListConversations(ctx, user, 1, page.NextToken); err != nil {
+		return fmt.Errorf("ListConversations with its own NextToken: %w", err)
+	}
+	if _, err := d.ListConversations(ctx, other, 1, page.NextToken); !errors.Is(err, services.ErrInvalidCursor) {
+		return fmt.Errorf("ListConversations with another user's NextToken: got %v, want ErrInvalidCursor", err)
+	}
+	if _, err := d.ListTrashedConversations(ctx, user, 1, page.NextToken); !errors.Is(err, services.ErrInvalidCursor) {
+		return fmt.Errorf("ListTrashedConversations with a ListConversations NextToken: got %v, want ErrInvalidCursor", err)
+	}
+	tampered := []byte(page.NextToken)
+	if k := strings.Index(page.NextToken, ".") + 1; tampered[k] == 'A' {
+		tampered[k] = 'B'
+	} else {
+		tampered[k] = 'A'
+	}
+	if _, err := d.ListConversations(ctx, user, 1, string(tampered)); !errors.Is(err, services.ErrInvalidCursor) {
+		return fmt.Errorf("ListConversations with an edited NextToken: got %v, want ErrInvalidCursor", err)
+	}
+
+	cid, err := d.CreateConversation(ctx, user, "Cursor messages")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	if _, err := putMessages(ctx, d, cid, user, baseTime(), 3); err != nil {
+		return err
+	}
+	msgs, err := d.ListMessages(ctx, cid, 1, "", true)
+	if err != nil || msgs.NextToken == "" {
+		return fmt.Errorf("ListMessages returned no NextToken (err %v)", err)
+	}
+	if _, err := d.ListMessages(ctx, cid, 1, msgs.NextToken, false); !errors.Is(err, services.ErrInvalidCursor) {
+		return fmt.Errorf("ListMessages in the other direction: got %v, want ErrInvalidCursor", err)
+	}
+	if _, err := d.ListMessages(ctx, services.GenerateULID(), 1, msgs.NextToken, true); !errors.Is(err, services.ErrInvalidCursor) {
+		return fmt.Errorf("ListMessages of another conversation: got %v, want ErrInvalidCursor", err)
+	}
+	return nil
+}
+
+// listMessagesOrder pages through 23 messages, two of which share a
+// timestamp and so are ordered by ID.
+func listMessagesOrder(ctx context.Context, d services.DAL, newestFirst bool) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Order")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	start := baseTime()
+	msgs, err := putMessages(ctx, d, cid, user, start, 22)
+	if err != nil {
+		return err
+	}
+	tie := message(cid, user, msgs[10].CreatedAt, "same time as message 10")
+	if err := d.PutMessage(ctx, tie); err != nil {
+		return fmt.Errorf("PutMessage: %w", err)
+	}
+	// tie's ULID is newer than msgs[10]'s, so it sorts right after it.
+	want := append(ids(msgs[:11]), tie.ID)
+	want = append(want, ids(msgs[11:])...)
+	if newestFirst {
+		want = reversed(want)
+	}
+
+	for _, limit := range []int32{1, 5, 23, 50} {
+		got, err := collect(limit, func(token string) (services.ListPage[services.ChatMessage], error) {
+			return d.ListMessages(ctx, cid, limit, token, newestFirst)
+		})
+		if err != nil {
+			return fmt.Errorf("ListMessages(limit %d): %w", limit, err)
+		}
+		if err := sameOrder(fmt.Sprintf("ListMessages(limit %d, newestFirst %v)", limit, newestFirst), ids(got), want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wholeSecondOrder mixes whole-second and fractional timestamps, whose
+// RFC3339Nano strings ("…:05Z" and "…:05.1Z") do not sort in time order.
+func wholeSecondOrder(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Whole seconds")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	start := baseTime().Truncate(time.Second)
+	offsets := []time.Duration{
+		0,
+		100 * time.Millisecond,
+		time.Second,
+		time.Second + time.Nanosecond,
+		2*time.Second + 50*time.Millisecond,
+		3 * time.Second,
+	}
+	want := make([]string, len(offsets))
+	// Store them out of order, so insertion order cannot pass for time order.
+	for _, i := range []int{3, 0, 5, 1, 4, 2} {
+		m := message(cid, user, start.Add(offsets[i]), fmt.Sprintf("message %d", i))
+		if err := d.PutMessage(ctx, m); err != nil {
+			return fmt.Errorf("PutMessage: %w", err)
+		}
+		want[i] = m.ID
+	}
+
+	for _, newestFirst := range []bool{false, true} {
+		got, err := collect(2, func(token string) (services.ListPage[services.ChatMessage], error) {
+			return d.ListMessages(ctx, cid, 2, token, newestFirst)
+		})
+		if err != nil {
+			return fmt.Errorf("ListMessages: %w", err)
+		}
+		w := want
+		if newestFirst {
+			w = reversed(want)
+		}
+		if err := sameOrder(fmt.Sprintf("ListMessages(newestFirst %v)", newestFirst), ids(got), w); err != nil {
+			return err
+		}
+	}
+	got, err := collect(2, func(token string) (services.ListPage[services.ChatMessage], error) {
+		return d.ListUserMessagesSince(ctx, user, start, 2, token)
+	})
+	if err != nil {
+		return fmt.Errorf("ListUserMessagesSince: %w", err)
+	}
+	if err := sameOrder("ListUserMessagesSince(since a whole second)", ids(got), want); err != nil {
+		return err
+	}
+	got, err = collect(2, func(token string) (services.ListPage[services.ChatMessage], error) {
+		return d.ListUserMessagesSince(ctx, user, start.Add(time.Second), 2, token)
+	})
+	if err != nil {
+		return fmt.Errorf("ListUserMessagesSince: %w", err)
+	}
+	return sameOrder("ListUserMessagesSince(since message 2)", ids(got), want[2:])
+}
+
+// listMessagesRange pages between bounds in both directions; the bounds
+// themselves are excluded.
+func listMessagesRange(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Range")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	msgs, err := putMessages(ctx, d, cid, user, baseTime(), 10)
+	if err != nil {
+		return err
+	}
+	cases := []struct {
+		r    services.MessageRange
+		want []string
+	}{
+		{services.MessageRange{After: msgs[2].ID, Before: msgs[7].ID}, ids(msgs[3:7])},
+		{services.MessageRange{Before: msgs[6].ID}, ids(msgs[:6])},
+		{services.MessageRange{After: msgs[6].ID}, ids(msgs[7:])},
+		{services.MessageRange{After: msgs[9].ID}, ids(nil)},
+		{services.MessageRange{After: msgs[4].ID, Before: msgs[5].ID}, ids(nil)},
+	}
+	for _, c := range cases {
+		for _, newestFirst := range []bool{false, true} {
+			want := c.want
+			if newestFirst {
+				want = reversed(want)
+			}
+			for _, limit := range []int32{1, 3, 20} {
+				got, err := collect(limit, func(token string) (services.ListPage[services.ChatMessage], error) {
+					return d.ListMessagesRange(ctx, cid, c.r, limit, token, newestFirst)
+				})
+				if err != nil {
+					return fmt.Errorf("ListMessagesRange(%+v, limit %d): %w", c.r, limit, err)
+				}
+				if err := sameOrder(fmt.Sprintf("ListMessagesRange(%+v, limit %d, newestFirst %v)", c.r, limit, newestFirst), ids(got), want); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, r := range []services.MessageRange{{After: "no-such-message"}, {Before: "no-such-message"}} {
+		if _, err := d.ListMessagesRange(ctx, cid, r, 10, "", true); !errors.Is(err, services.ErrMessageNotFound) {
+			return fmt.Errorf("ListMessagesRange(%+v): got %v, want ErrMessageNotFound", r, err)
+		}
+	}
+	return nil
+}
+
+func listConversationsPaging(ctx context.Context, d services.DAL) error {
+	user, other := newUser(), newUser()
+	var created []string
+	for i := 0; i < 7; i++ {
+		id, err := d.CreateConversation(ctx, user, fmt.Sprintf("Conversation %d", i))
+		if err != nil {
+			return fmt.Errorf("CreateConversation: %w", err)
+		}
+		created = append(created, id)
+	}
+	if _, err := d.CreateConversation(ctx, other, "Someone else's"); err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	want := reversed(created) // newest first
+	for _, limit := range []int32{1, 3, 7, 20} {
+		got, err := collect(limit, func(token string) (services.ListPage[services.Conversation], error) {
+			return d.ListConversations(ctx, user, limit, token)
+		})
+		if err != nil {
+			return fmt.Errorf("ListConversations(limit %d): %w", limit, err)
+		}
+		gotIDs := make([]string, len(got))
+		for i, c := range got {
+			gotIDs[i] = c.ID
+		}
+		if err := sameOrder(fmt.Sprintf("ListConversations(limit %d)", limit), gotIDs, want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conversationActivity checks the header totals PutMessage keeps and that
+// ListConversations orders by them.
+func conversationActivity(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	var a, b, c string
+	for _, p := range []struct {
+		id    *string
+		title string
+	}{{&a, "A"}, {&b, "B"}, {&c, "C"}} {
+		id, err := d.CreateConversation(ctx, user, p.title)
+		if err != nil {
+			return fmt.Errorf("CreateConversation: %w", err)
+		}
+		*p.id = id
+	}
+	order := func(what string, want ...string) error {
+		for _, limit := range []int32{1, 10} {
+			got, err := collect(limit, func(token string) (services.ListPage[services.Conversation], error) {
+				return d.ListConversations(ctx, user, limit, token)
+			})
+			if err != nil {
+				return fmt.Errorf("ListConversations: %w", err)
+			}
+			gotIDs := make([]string, len(got))
+			for i, c := range got {
+				gotIDs[i] = c.ID
+			}
+			if err := sameOrder(fmt.Sprintf("%s (limit %d)", what, limit), gotIDs, want); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := order("ListConversations without messages", c, b, a); err != nil {
+		return err
+	}
+
+	// A message moves A to the top; an old one moves C to the bottom.
+	newest := time.Now().UTC().Add(time.Minute).Truncate(time.Millisecond)
+	latest := message(a, user, newest, strings.Repeat("Mitochondria are the powerhouse of the cell. ", 10))
+	latest.Role, latest.PromptTokens, latest.CompletionTokens = "chatbot", 100, 20
+	if err := d.PutMessage(ctx, latest); err != nil {
+		return fmt.Errorf("PutMessage: %w", err)
+	}
+	if err := d.PutMessage(ctx, message(c, user, baseTime(), "an old message")); err != nil {
+		return fmt.Errorf("PutMessage: %w", err)
+	}
+	if err := order("ListConversations after messages", a, b, c); err != nil {
+		return err
+	}
+
+	// An older message counts but does not replace the newest, and
+	// rewriting a message does not count it again.
+	older := message(a, user, newest.Add(-10*time.Second), "an earlier question")
+	older.PromptTokens = 7
+	if err := d.PutMessage(ctx, older); err != nil {
+		return fmt.Errorf("PutMessage: %w", err)
+	}
+	older.Content = "an earlier question, edited"
+	if err := d.PutMessage(ctx, older); err != nil {
+		return fmt.Errorf("PutMessage rewrite: %w", err)
+	}
+	got, err := findConversation(ctx, d, user, a)
+	if err != nil {
+		return err
+	}
+	if got.MessageCount != 2 || got.PromptTokens != 107 || got.CompletionTokens != 20 {
+		return fmt.Errorf("totals are %d messages, %d/%d tokens, want 2 messages, 107/20 tokens",
+			got.MessageCount, got.PromptTokens, got.CompletionTokens)
+	}
+	if got.LastMessageAt == nil || !got.LastMessageAt.Equal(newest) {
+		return fmt.Errorf("lastMessageAt is %v, want %v", got.LastMessageAt, newest)
+	}
+	preview := []rune(got.LastMessagePreview)
+	if len(preview) == 0 || len(preview) > 120 || !strings.HasPrefix(latest.Content, strings.TrimSuffix(string(preview), "…")) {
+		return fmt.Errorf("lastMessagePreview %q is not a prefix of at most 120 characters of the newest message", got.LastMessagePreview)
+	}
+
+	if err := d.PutMessage(ctx, message(a, newUser(), newest, "not yours")); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("PutMessage by another user: got %v, want ErrConversationNotFound", err)
+	}
+	return nil
+}
+
+func listUserMessagesSinceBoundary(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Since")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	msgs, err := putMessages(ctx, d, cid, user, baseTime(), 5)
+	if err != nil {
+		return err
+	}
+	since := msgs[2].CreatedAt
+	page, err := d.ListUserMessagesSince(ctx, user, since, 10, "")
+	if err != nil {
+		return fmt.Errorf("ListUserMessagesSince: %w", err)
+	}
+	if err := sameOrder("ListUserMessagesSince(since = message 2)", ids(page.Items), ids(msgs[2:])); err != nil {
+		return fmt.Errorf("%w (since is inclusive)", err)
+	}
+	page, err = d.ListUserMessagesSince(ctx, user, since.Add(time.Millisecond), 10, "")
+	if err != nil {
+		return fmt.Errorf("ListUserMessagesSince: %w", err)
+	}
+	if err := sameOrder("ListUserMessagesSince(since just after message 2)", ids(page.Items), ids(msgs[3:])); err != nil {
+		return err
+	}
+	page, err = d.ListUserMessagesSince(ctx, user, msgs[4].CreatedAt.Add(time.Second), 10, "")
+	if err != nil {
+		return fmt.Errorf("ListUserMessagesSince: %w", err)
+	}
+	if len(page.Items) != 0 {
+		return fmt.Errorf("ListUserMessagesSince after the last message returned %d messages", len(page.Items))
+	}
+	return nil
+}
+
+// listUserMessagesSincePaging interleaves two conversations and checks the
+// user's messages come back oldest first across both, without another
+// user's messages.
+func listUserMessagesSincePaging(ctx context.Context, d services.DAL) error {
+	user, other := newUser(), newUser()
+	a, err := d.CreateConversation(ctx, user, "A")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	b, err := d.CreateConversation(ctx, user, "B")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	o, err := d.CreateConversation(ctx, other, "Other")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	start := baseTime()
+	var want []string
+	for i := 0; i < 12; i++ {
+		cid := a
+		if i%2 == 1 {
+			cid = b
+		}
+		m := message(cid, user, start.Add(time.Duration(i)*time.Second), fmt.Sprintf("message %d", i))
+		if err := d.PutMessage(ctx, m); err != nil {
+			return fmt.Errorf("PutMessage: %w", err)
+		}
+		want = append(want, m.ID)
+		if err := d.PutMessage(ctx, message(o, other, m.CreatedAt, "not yours")); err != nil {
+			return fmt.Errorf("PutMessage: %w", err)
+		}
+	}
+	for _, limit := range []int32{1, 5, 12} {
+		got, err := collect(limit, func(token string) (services.ListPage[services.ChatMessage], error) {
+			return d.ListUserMessagesSince(ctx, user, start, limit, token)
+		})
+		if err != nil {
+			return fmt.Errorf("ListUserMessagesSince(limit %d): %w", limit, err)
+		}
+		if err := sameOrder(fmt.Sprintf("ListUserMessagesSince(limit %d)", limit), ids(got), want); err != nil {
+			return err
+		}
+		for _, m := range got {
+			if m.ConversationID != a && m.ConversationID != b {
+				return fmt.Errorf("ListUserMessagesSince returned message %s with conversationId %q", m.ID, m.ConversationID)
+			}
+		}
+	}
+	return nil
+}
+
+func deleteConversationCascade(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	doomed, err := d.CreateConversation(ctx, user, "Doomed")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	kept, err := d.CreateConversation(ctx, user, "Kept")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	start := baseTime()
+	// More than one DynamoDB batch (25) and one query page of keys.
+	if _, err := putMessages(ctx, d, doomed, user, start, 60); err != nil {
+		return err
+	}
+	keptMsgs, err := putMessages(ctx, d, kept, user, start.Add(time.Hour/2), 3)
+	if err != nil {
+		return err
+	}
+
+	if err := d.DeleteConversationCascade(ctx, newUser(), doomed); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("DeleteConversationCascade by another user: got %v, want ErrConversationNotFound", err)
+	}
+	if page, err := d.ListMessages(ctx, doomed, 1, "", false); err != nil || len(page.Items) == 0 {
+		return fmt.Errorf("DeleteConversationCascade by another user removed messages (err %v)", err)
+	}
+	if err := d.DeleteConversationCascade(ctx, user, doomed); err != nil {
+		return fmt.Errorf("DeleteConversationCascade: %w", err)
+	}
+	if err := d.DeleteConversationCascade(ctx, user, doomed); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("second DeleteConversationCascade: got %v, want ErrConversationNotFound", err)
+	}
+
+	msgs, err := collect(100, func(token string) (services.ListPage[services.ChatMessage], error) {
+		return d.ListMessages(ctx, doomed, 100, token, false)
+	})
+	if err != nil {
+		return fmt.Errorf("ListMessages: %w", err)
+	}
+	if len(msgs) != 0 {
+		return fmt.Errorf("%d messages of the deleted conversation remain", len(msgs))
+	}
+	convs, err := collect(100, func(token string) (services.ListPage[services.Conversation], error) {
+		return d.ListConversations(ctx, user, 100, token)
+	})
+	if err != nil {
+		return fmt.Errorf("ListConversations: %w", err)
+	}
+	if len(convs) != 1 || convs[0].ID != kept {
+		return fmt.Errorf("after delete, ListConversations returned %+v, want only %s", convs, kept)
+	}
+	since, err := collect(100, func(token string) (services.ListPage[services.ChatMessage], error) {
+		return d.ListUserMessagesSince(ctx, user, start, 100, token)
+	})
+	if err != nil {
+		return fmt.Errorf("ListUserMessagesSince: %w", err)
+	}
+	if err := sameOrder("ListUserMessagesSince after delete", ids(since), ids(keptMsgs)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func trashAndRestore(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Trashed")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	if _, err := putMessages(ctx, d, cid, user, baseTime(), 3); err != nil {
+		return err
+	}
+	at := baseTime()
+	if err := d.TrashConversation(ctx, newUser(), cid, at); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("TrashConversation by another user: got %v, want ErrConversationNotFound", err)
+	}
+	if err := d.TrashConversation(ctx, user, cid, at); err != nil {
+		return fmt.Errorf("TrashConversation: %w", err)
+	}
+	if err := d.TrashConversation(ctx, user, cid, at); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("second TrashConversation: got %v, want ErrConversationNotFound", err)
+	}
+
+	active, err := collect(100, func(token string) (services.ListPage[services.Conversation], error) {
+		return d.ListConversations(ctx, user, 100, token)
+	})
+	if err != nil {
+		return fmt.Errorf("ListConversations: %w", err)
+	}
+	if len(active) != 0 {
+		return fmt.Errorf("ListConversations returned trashed conversations %+v", active)
+	}
+	trashed, err := collect(100, func(token string) (services.ListPage[services.Conversation], error) {
+		return d.ListTrashedConversations(ctx, user, 100, token)
+	})
+	if err != nil {
+		return fmt.Errorf("ListTrashedConversations: %w", err)
+	}
+	if len(trashed) != 1 || trashed[0].ID != cid || trashed[0].DeletedAt == nil || !trashed[0].DeletedAt.Equal(at) {
+		return fmt.Errorf("ListTrashedConversations returned %+v, want %s deleted at %v", trashed, cid, at)
+	}
+	// Messages stay until the purge.
+	if page, err := d.ListMessages(ctx, cid, 1, "", false); err != nil || len(page.Items) == 0 {
+		return fmt.Errorf("TrashConversation removed messages (err %v)", err)
+	}
+
+	if err := d.RestoreConversation(ctx, user, cid, at.Add(time.Second)); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("RestoreConversation past retention: got %v, want ErrConversationNotFound", err)
+	}
+	if err := d.RestoreConversation(ctx, newUser(), cid, at); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("RestoreConversation by another user: got %v, want ErrConversationNotFound", err)
+	}
+	if err := d.RestoreConversation(ctx, user, cid, at); err != nil {
+		return fmt.Errorf("RestoreConversation: %w", err)
+	}
+	active, err = collect(100, func(token string) (services.ListPage[services.Conversation], error) {
+		return d.ListConversations(ctx, user, 100, token)
+	})
+	if err != nil {
+		return fmt.Errorf("ListConversations: %w", err)
+	}
+	if len(active) != 1 || active[0].ID != cid || active[0].DeletedAt != nil {
+		return fmt.Errorf("after restore, ListConversations returned %+v, want %s", active, cid)
+	}
+	return nil
+}
+
+// getAndUpdateConversation checks ownership, version bumps and the
+// conditional update.
+func getAndUpdateConversation(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Before")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	if _, err := d.GetConversation(ctx, newUser(), cid); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("GetConversation by another user: got %v, want ErrConversationNotFound", err)
+	}
+	c, err := d.GetConversation(ctx, user, cid)
+	if err != nil {
+		return fmt.Errorf("GetConversation: %w", err)
+	}
+	if c.ID != cid || c.UserID != user || c.Title != "Before" || c.Version != 0 {
+		return fmt.Errorf("GetConversation returned %+v", c)
+	}
+
+	title := "After"
+	if _, err := d.UpdateConversation(ctx, newUser(), cid, services.ConversationUpdate{Title: &title}); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("UpdateConversation by another user: got %v, want ErrConversationNotFound", err)
+	}
+	v := 0
+	c, err = d.UpdateConversation(ctx, user, cid, services.ConversationUpdate{Title: &title, IfVersion: &v})
+	if err != nil {
+		return fmt.Errorf("UpdateConversation: %w", err)
+	}
+	if c.Title != "After" || c.Version != 1 {
+		return fmt.Errorf("after rename got title %q version %d, want \"After\" 1", c.Title, c.Version)
+	}
+	c, err = d.UpdateConversation(ctx, user, cid, services.ConversationUpdate{Settings: &services.ConversationSettings{Language: "fr"}})
+	if err != nil {
+		return fmt.Errorf("UpdateConversation settings: %w", err)
+	}
+	if c.Title != "After" || c.Settings.Language != "fr" || c.Version != 2 {
+		return fmt.Errorf("after settings update got %+v, want title kept, language fr, version 2", c)
+	}
+	if _, err := d.UpdateConversation(ctx, user, cid, services.ConversationUpdate{Title: &title, IfVersion: &v}); !errors.Is(err, services.ErrVersionConflict) {
+		return fmt.Errorf("UpdateConversation with stale version: got %v, want ErrVersionConflict", err)
+	}
+	if c, err = d.GetConversation(ctx, user, cid); err != nil || c.Version != 2 || c.Settings.Language != "fr" {
+		return fmt.Errorf("GetConversation after updates returned %+v (err %v)", c, err)
+	}
+
+	if err := d.TrashConversation(ctx, user, cid, baseTime()); err != nil {
+		return fmt.Errorf("TrashConversation: %w", err)
+	}
+	if _, err := d.UpdateConversation(ctx, user, cid, services.ConversationUpdate{Title: &title}); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("UpdateConversation of trashed conversation: got %v, want ErrConversationNotFound", err)
+	}
+	if c, err = d.GetConversation(ctx, user, cid); err != nil || c.DeletedAt == nil {
+		return fmt.Errorf("GetConversation of trashed conversation returned %+v (err %v)", c, err)
+	}
+	return nil
+}
+
+// listExpiredTrash pages through all expired trash, which other runs may
+// share, and only checks this case's conversations.
+func listExpiredTrash(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	at := baseTime()
+	var expired []string
+	for i := 0; i < 3; i++ {
+		cid, err := d.CreateConversation(ctx, user, fmt.Sprintf("Expired %d", i))
+		if err != nil {
+			return fmt.Errorf("CreateConversation: %w", err)
+		}
+		if err := d.TrashConversation(ctx, user, cid, at.Add(time.Duration(i)*time.Millisecond)); err != nil {
+			return fmt.Errorf("TrashConversation: %w", err)
+		}
+		expired = append(expired, cid)
+	}
+	recent, err := d.CreateConversation(ctx, user, "Recent")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	if err := d.TrashConversation(ctx, user, recent, at.Add(time.Minute)); err != nil {
+		return fmt.Errorf("TrashConversation: %w", err)
+	}
+	if _, err := d.CreateConversation(ctx, user, "Active"); err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+
+	all, err := collect(25, func(token string) (services.ListPage[services.Conversation], error) {
+		return d.ListExpiredTrash(ctx, at.Add(time.Second), 25, token)
+	})
+	if err != nil {
+		return fmt.Errorf("ListExpiredTrash: %w", err)
+	}
+	var got []string
+	for _, c := range all {
+		if c.UserID == user {
+			got = append(got, c.ID)
+		}
+	}
+	// Oldest deletion first.
+	return sameOrder("ListExpiredTrash", got, expired)
+}
+
+func putExchange(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Exchange")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	at := baseTime()
+	question := message(cid, user, at, "What is osmosis?")
+	answer := message(cid, user, at.Add(time.Millisecond), "Osmosis is the movement of water across a membrane.")
+	answer.Role = "chatbot"
+	if err := d.PutExchange(ctx, question, answer); err != nil {
+		return fmt.Errorf("PutExchange: %w", err)
+	}
+	page, err := d.ListMessages(ctx, cid, 10, "", false)
+	if err != nil {
+		return fmt.Errorf("ListMessages: %w", err)
+	}
+	if err := sameOrder("ListMessages after PutExchange", ids(page.Items), []string{question.ID, answer.ID}); err != nil {
+		return err
+	}
+	convs, err := d.ListConversations(ctx, user, 10, "")
+	if err != nil {
+		return fmt.Errorf("ListConversations: %w", err)
+	}
+	if len(convs.Items) != 1 || convs.Items[0].LastMessageAt == nil || !convs.Items[0].LastMessageAt.Equal(answer.CreatedAt) {
+		return fmt.Errorf("after PutExchange, ListConversations returned %+v, want lastMessageAt %v", convs.Items, answer.CreatedAt)
+	}
+
+	// Nothing is stored for a conversation the user does not own, or one in
+	// the trash.
+	intruder := newUser()
+	q, a := message(cid, intruder, at.Add(time.Second), "mine?"), message(cid, intruder, at.Add(2*time.Second), "no")
+	if err := d.PutExchange(ctx, q, a); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("PutExchange by another user: got %v, want ErrConversationNotFound", err)
+	}
+	if err := d.TrashConversation(ctx, user, cid, at); err != nil {
+		return fmt.Errorf("TrashConversation: %w", err)
+	}
+	q, a = message(cid, user, at.Add(time.Second), "still there?"), message(cid, user, at.Add(2*time.Second), "no")
+	if err := d.PutExchange(ctx, q, a); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("PutExchange into the trash: got %v, want ErrConversationNotFound", err)
+	}
+	if err := d.PutMessage(ctx, q); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("PutMessage into the trash: got %v, want ErrConversationNotFound", err)
+	}
+	if err := d.PutMessage(ctx, answer); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("PutMessage replacing a message in the trash: got %v, want ErrConversationNotFound", err)
+	}
+	page, err = d.ListMessages(ctx, cid, 10, "", false)
+	if err != nil {
+		return fmt.Errorf("ListMessages: %w", err)
+	}
+	return sameOrder("ListMessages after rejected exchanges", ids(page.Items), []string{question.ID, answer.ID})
+}
+
+// messageCRUD gets, edits and deletes single messages and checks the
+// conversation's activity follows.
+func messageCRUD(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "CRUD")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	msgs, err := putMessages(ctx, d, cid, user, baseTime(), 3)
+	if err != nil {
+		return err
+	}
+	got, err := d.GetMessage(ctx, cid, msgs[1].ID)
+	if err != nil {
+		return fmt.Errorf("GetMessage: %w", err)
+	}
+	if got.ID != msgs[1].ID || got.Content != msgs[1].Content || !got.CreatedAt.Equal(msgs[1].CreatedAt) {
+		return fmt.Errorf("GetMessage returned %+v, want %+v", got, msgs[1])
+	}
+	if _, err := d.GetMessage(ctx, cid, "no-such-message"); !errors.Is(err, services.ErrMessageNotFound) {
+		return fmt.Errorf("GetMessage of unknown ID: got %v, want ErrMessageNotFound", err)
+	}
+	page, err := d.ListMessages(ctx, cid, 10, "", false)
+	if err != nil {
+		return fmt.Errorf("ListMessages: %w", err)
+	}
+	if err := sameOrder("ListMessages IDs", ids(page.Items), ids(msgs)); err != nil {
+		return err
+	}
+
+	at := baseTime().Add(time.Hour)
+	if _, err := d.UpdateMessage(ctx, newUser(), cid, msgs[2].ID, "stolen", at); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("UpdateMessage by another user: got %v, want ErrConversationNotFound", err)
+	}
+	if _, err := d.UpdateMessage(ctx, user, cid, "no-such-message", "x", at); !errors.Is(err, services.ErrMessageNotFound) {
+		return fmt.Errorf("UpdateMessage of unknown ID: got %v, want ErrMessageNotFound", err)
+	}
+	if _, err := d.UpdateMessage(ctx, user, cid, msgs[2].ID, "first edit", at); err != nil {
+		return fmt.Errorf("UpdateMessage: %w", err)
+	}
+	edited, err := d.UpdateMessage(ctx, user, cid, msgs[2].ID, "second edit", at.Add(time.Minute))
+	if err != nil {
+		return fmt.Errorf("UpdateMessage: %w", err)
+	}
+	got, err = d.GetMessage(ctx, cid, msgs[2].ID)
+	if err != nil {
+		return fmt.Errorf("GetMessage after edits: %w", err)
+	}
+	for _, m := range []services.ChatMessage{edited, got} {
+		if m.Content != "second edit" || m.EditedAt == nil || !m.EditedAt.Equal(at.Add(time.Minute)) ||
+			len(m.Edits) != 2 || m.Edits[0].Content != msgs[2].Content || !m.Edits[0].EditedAt.Equal(at) || m.Edits[1].Content != "first edit" {
+			return fmt.Errorf("edited message is %+v, want content \"second edit\" with two edits", m)
+		}
+	}
+	c, err := d.GetConversation(ctx, user, cid)
+	if err != nil {
+		return fmt.Errorf("GetConversation: %w", err)
+	}
+	if c.LastMessagePreview != "second edit" {
+		return fmt.Errorf("preview after editing the newest message is %q, want \"second edit\"", c.LastMessagePreview)
+	}
+
+	if err := d.DeleteMessage(ctx, newUser(), cid, msgs[2].ID); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("DeleteMessage by another user: got %v, want ErrConversationNotFound", err)
+	}
+	if err := d.DeleteMessage(ctx, user, cid, msgs[2].ID); err != nil {
+		return fmt.Errorf("DeleteMessage: %w", err)
+	}
+	if err := d.DeleteMessage(ctx, user, cid, msgs[2].ID); !errors.Is(err, services.ErrMessageNotFound) {
+		return fmt.Errorf("second DeleteMessage: got %v, want ErrMessageNotFound", err)
+	}
+	if _, err := d.GetMessage(ctx, cid, msgs[2].ID); !errors.Is(err, services.ErrMessageNotFound) {
+		return fmt.Errorf("GetMessage after delete: got %v, want ErrMessageNotFound", err)
+	}
+	c, err = d.GetConversation(ctx, user, cid)
+	if err != nil {
+		return fmt.Errorf("GetConversation: %w", err)
+	}
+	if c.MessageCount != 2 || c.LastMessageAt == nil || !c.LastMessageAt.Equal(msgs[1].CreatedAt) || c.LastMessagePreview != msgs[1].Content {
+		return fmt.Errorf("after deleting the newest message got count %d, last %v %q; want 2, %v %q",
+			c.MessageCount, c.LastMessageAt, c.LastMessagePreview, msgs[1].CreatedAt, msgs[1].Content)
+	}
+
+	if err := d.TrashConversation(ctx, user, cid, baseTime()); err != nil {
+		return fmt.Errorf("TrashConversation: %w", err)
+	}
+	if _, err := d.UpdateMessage(ctx, user, cid, msgs[0].ID, "x", at); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("UpdateMessage in the trash: got %v, want ErrConversationNotFound", err)
+	}
+	if err := d.DeleteMessage(ctx, user, cid, msgs[0].ID); !errors.Is(err, services.ErrConversationNotFound) {
+		return fmt.Errorf("DeleteMessage in the trash: got %v, want ErrConversationNotFound", err)
+	}
+	return nil
+}
+
+func putMessagesBatch(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Batch")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	// More than two DynamoDB batches.
+	start := baseTime()
+	var msgs []services.ChatMessage
+	for i := 0; i < 60; i++ {
+		msgs = append(msgs, message(cid, user, start.Add(time.Duration(i)*time.Second), fmt.Sprintf("imported %d", i)))
+	}
+	if err := d.PutMessages(ctx, msgs); err != nil {
+		return fmt.Errorf("PutMessages: %w", err)
+	}
+	got, err := collect(100, func(token string) (services.ListPage[services.ChatMessage], error) {
+		return d.ListMessages(ctx, cid, 100, token, false)
+	})
+	if err != nil {
+		return fmt.Errorf("ListMessages: %w", err)
+	}
+	return sameOrder("ListMessages after PutMessages", ids(got), ids(msgs))
+}
+
+func importConversation(ctx context.Context, d services.DAL) error {
+	user := newUser()
+	created := baseTime().Add(-30 * 24 * time.Hour)
+	c := services.Conversation{ID: services.GenerateULID(), UserID: user, Title: "Imported", CreatedAt: created}
+	ok, err := d.ImportConversation(ctx, c)
+	if err != nil || !ok {
+		return fmt.Errorf("first ImportConversation = %v, %v; want true, nil", ok, err)
+	}
+	if _, err := putMessages(ctx, d, c.ID, user, created, 2); err != nil {
+		return err
+	}
+	again := c
+	again.Title = "Renamed"
+	if ok, err := d.ImportConversation(ctx, again); err != nil || ok {
+		return fmt.Errorf("repeated ImportConversation = %v, %v; want false, nil", ok, err)
+	}
+	got, err := d.GetConversation(ctx, user, c.ID)
+	if err != nil {
+		return fmt.Errorf("GetConversation: %w", err)
+	}
+	if got.Title != "Imported" || !got.CreatedAt.Equal(created) || got.MessageCount != 2 {
+		return fmt.Errorf("after re-import got title %q, createdAt %v, %d messages; want %q, %v, 2",
+			got.Title, got.CreatedAt, got.MessageCount, "Imported", created)
+	}
+	return nil
+}
+
+func concurrentWrites(ctx context.Context, d services.DAL) error {
+	const writers, perWriter = 8, 10
+	user := newUser()
+	cid, err := d.CreateConversation(ctx, user, "Concurrent")
+	if err != nil {
+		return fmt.Errorf("CreateConversation: %w", err)
+	}
+	start := baseTime()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter)
+	convIDs := make(chan string, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			id, err := d.CreateConversation(ctx, user, fmt.Sprintf("Writer %d", w))
+			if err != nil {
+				errs <- fmt.Errorf("CreateConversation: %w", err)
+				return
+			}
+			convIDs <- id
+			for i := 0; i < perWriter; i++ {
+				at := start.Add(time.Duration(w*perWriter+i) * time.Second)
+				if err := d.PutMessage(ctx, message(cid, user, at, fmt.Sprintf("writer %d message %d", w, i))); err != nil {
+					errs <- fmt.Errorf("PutMessage: %w", err)
+				}
+				// Readers run alongside the writers.
+				if _, err := d.ListMessages(ctx, cid, 5, "", true); err != nil {
+					errs <- fmt.Errorf("ListMessages: %w", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	close(convIDs)
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	seen := map[string]bool{cid: true}
+	for id := range convIDs {
+		if seen[id] {
+			return fmt.Errorf("CreateConversation returned %s twice", id)
+		}
+		seen[id] = true
+	}
+	msgs, err := collect(25, func(token string) (services.ListPage[services.ChatMessage], error) {
+		return d.ListMessages(ctx, cid, 25, token, false)
+	})
+	if err != nil {
+		return fmt.Errorf("ListMessages: %w", err)
+	}
+	if len(msgs) != writers*perWriter {
+		return fmt.Errorf("stored %d messages concurrently, listed %d", writers*perWriter, len(msgs))
+	}
+	for i := 1; i < len(msgs); i++ {
+		if !msgs[i-1].CreatedAt.Before(msgs[i].CreatedAt) {
+			return fmt.Errorf("messages %d and %d are out of order", i-1, i)
+		}
+	}
+	c, err := findConversation(ctx, d, user, cid)
+	if err != nil {
+		return err
+	}
+	if c.MessageCount != writers*perWriter {
+		return fmt.Errorf("messageCount is %d after %d concurrent writes", c.MessageCount, writers*perWriter)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package dalconformance_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services"
+	"github.com/MrKOcode/AiChatBot3.0backenddeploy/backend/components/AIChat/services/dalconformance"
+)
+
+// runSuite configures backend as the service would (see services.InitDAL)
+// and runs every case as a subtest.
+func runSuite(t *testing.T, backend string) {
+	t.Helper()
+	t.Setenv("DAL_BACKEND", backend)
+	old := services.Store
+	t.Cleanup(func() { services.Store = old })
+	if err := services.InitDAL(); err != nil {
+		t.Fatalf("DAL init failed: %v", err)
+	}
+	if c, ok := services.Store.(interface{ Close() error }); ok {
+		t.Cleanup(func() { c.Close() })
+	}
+	ctx := context.Background()
+	for _, c := range dalconformance.Cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := c.Run(ctx, services.Store); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestMemory(t *testing.T) {
+	runSuite(t, "memory")
+}
+
+func TestSQLite(t *testing.T) {
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "conformance.db"))
+	runSuite(t, "sqlite")
+}
+
+// TestPostgres runs against DATABASE_URL, e.g. the local instance described
+// at services.NewPostgresDAL.
+func TestPostgres(t *testing.T) {
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	runSuite(t, "postgres")
+}
+
+// TestDynamo runs against DynamoDB Local (see cmd/dalconformance), creating
+// TABLE_NAME (default "conformance") if needed.
+func TestDynamo(t *testing.T) {
+	if os.Getenv("AWS_ENDPOINT_URL_DYNAMODB") == "" {
+		t.Skip("AWS_ENDPOINT_URL_DYNAMODB not set")
+	}
+	if os.Getenv("TABLE_NAME") == "" {
+		t.Setenv("TABLE_NAME", "conformance")
+	}
+	if err := services.EnsureTable(context.Background(), os.Getenv("TABLE_NAME")); err != nil {
+		t.Fatalf("creating table: %v", err)
+	}
+	runSuite(t, "dynamo")
+}
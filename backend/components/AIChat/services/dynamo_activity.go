@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Conversation activity lives on the header: messageCount and the token
+// totals are ADDed for every new message; lastMessageAt, lastMessagePreview
+// and the GSI2SK activity key are only SET by a message at least as new as
+// lastMessageAt. That also moves the header's expiresAt, for users under
+// a retention policy.
+
+func conversationKey(userID, conversationID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pkUser(userID)},
+		"SK": &types.AttributeValueMemberS{Value: skConv(conversationID)},
+	}
+}
+
+// activityUpdate adds msgs, all in one conversation, to its header. With
+// newest it also moves lastMessageAt to the newest of msgs, on condition
+// that this is not older than the stored one. With activeOnly the
+// conversation must not be in the trash. keep, the owner's retention,
+// moves expiresAt along with lastMessageAt.
+func (d *dynamoDAL) activityUpdate(msgs []ChatMessage, activeOnly, newest bool, keep time.Duration) *types.Update {
+	last := msgs[0]
+	prompt, completion := 0, 0
+	for _, m := range msgs {
+		prompt += m.PromptTokens
+		completion += m.CompletionTokens
+		if !m.CreatedAt.Before(last.CreatedAt) {
+			last = m
+		}
+	}
+	update := "ADD messageCount :n, promptTokens :p, completionTokens :c"
+	cond := "attribute_exists(PK)"
+	if activeOnly {
+		cond += " AND attribute_not_exists(deletedAt)"
+	}
+	values := map[string]types.AttributeValue{
+		":n": &types.AttributeValueMemberN{Value: strconv.Itoa(len(msgs))},
+		":p": &types.AttributeValueMemberN{Value: strconv.Itoa(prompt)},
+		":c": &types.AttributeValueMemberN{Value: strconv.Itoa(completion)},
+	}
+	if newest {
+		set := "SET lastMessageAt = :at, lastMessagePreview = :preview, GSI2SK = :act"
+		cond += " AND (attribute_not_exists(lastMessageAt) OR lastMessageAt <= :at)"
+		values[":at"] = &types.AttributeValueMemberS{Value: last.CreatedAt.UTC().Format(sortableTime)}
+		values[":preview"] = &types.AttributeValueMemberS{Value: messagePreview(last.Content)}
+		values[":act"] = &types.AttributeValueMemberS{Value: gsi2skActivity(last.CreatedAt, last.ConversationID)}
+		if keep > 0 {
+			set += ", expiresAt = :exp"
+			values[":exp"] = expiresAtAttr(last.CreatedAt, keep)
+		}
+		update = set + " " + update
+	}
+	return &types.Update{
+		TableName:                 aws.String(d.table),
+		Key:                       conversationKey(last.UserID, last.ConversationID),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeValues: values,
+	}
+}
+
+// putWithActivity writes msgs, all new and in one conversation, together
+// with the header update in one transaction:
+//   - if the header's condition fails, the messages may just be older than
+//     lastMessageAt, so it retries counting only; failing again means the
+//     conversation is missing (or trashed, with activeOnly).
+//   - if a single message already exists it is replaced in place without
+//     touching the totals.
+//   - transaction conflicts with concurrent writers are retried with the
+//     batch write backoff.
+func (d *dynamoDAL) putWithActivity(ctx context.Context, activeOnly bool, msgs ...ChatMessage) error {
+	newest := true
+	keep := d.retention(ctx, msgs[0].UserID)
+	delay := batchWriteBaseDelay
+	for attempt := 1; ; attempt++ {
+		items := []types.TransactWriteItem{{Update: d.activityUpdate(msgs, activeOnly, newest, keep)}}
+		for _, m := range msgs {
+			items = append(items, types.TransactWriteItem{Put: &types.Put{
+				TableName:           aws.String(d.table),
+				Item:                withExpiry(messageItem(m), m.CreatedAt, keep),
+				ConditionExpression: aws.String("attribute_not_exists(SK)"),
+			}})
+		}
+		_, err := d.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: items})
+		reasons := cancellationReasons(err)
+		switch {
+		case err == nil:
+			return nil
+		case reasons == nil:
+			return err
+		case reasons[0] == "ConditionalCheckFailed" && newest:
+			newest = false
+			continue
+		case reasons[0] == "ConditionalCheckFailed":
+			return ErrConversationNotFound
+		case len(msgs) == 1 && reasons[1] == "ConditionalCheckFailed":
+			return d.replaceMessage(ctx, msgs[0])
+		case !slices.Contains(reasons, "TransactionConflict") || attempt == batchWriteAttempts:
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if delay *= 2; delay > batchWriteMaxBackoff {
+			delay = batchWriteMaxBackoff
+		}
+	}
+}
+
+// replaceMessage overwrites a stored message, checking that m.UserID owns
+// the conversation and it is not in the trash.
+func (d *dynamoDAL) replaceMessage(ctx context.Context, m ChatMessage) error {
+	_, err := d.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{ConditionCheck: &types.ConditionCheck{
+			TableName:           aws.String(d.table),
+			Key:                 conversationKey(m.UserID, m.ConversationID),
+			ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(deletedAt)"),
+		}},
+		{Put: &types.Put{TableName: aws.String(d.table), Item: withExpiry(messageItem(m), m.CreatedAt, d.retention(ctx, m.UserID))}},
+	}})
+	if isConditionFailure(err) {
+		return ErrConversationNotFound
+	}
+	return err
+}
+
+// addActivity applies msgs, already written and all in one conversation,
+// to its header outside a transaction (PutMessages).
+func (d *dynamoDAL) addActivity(ctx context.Context, msgs []ChatMessage) error {
+	keep := d.retention(ctx, msgs[0].UserID)
+	for _, newest := range []bool{true, false} {
+		u := d.activityUpdate(msgs, false, newest, keep)
+		_, err := d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+			TableName:                 u.TableName,
+			Key:                       u.Key,
+			UpdateExpression:          u.UpdateExpression,
+			ConditionExpression:       u.ConditionExpression,
+			ExpressionAttributeValues: u.ExpressionAttributeValues,
+		})
+		if !isConditionFailure(err) {
+			return err
+		}
+	}
+	return ErrConversationNotFound
+}
+
+// cancellationReasons returns the per-item codes of a cancelled
+// transaction ("None" for items that were fine), or nil for other errors.
+func cancellationReasons(err error) []string {
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		return nil
+	}
+	codes := make([]string, len(tce.CancellationReasons))
+	for i, r := range tce.CancellationReasons {
+		codes[i] = aws.ToString(r.Code)
+	}
+	return codes
+}
+
+// BackfillConversationActivity computes the activity fields and GSI2 keys
+// for DynamoDB conversation headers written before they existed, from
+// their messages. It is idempotent and returns how many headers it
+// updated. The SQL backends backfill in their schema migrations.
+func BackfillConversationActivity(ctx context.Context) (int, error) {
+	d, ok := Store.(*dynamoDAL)
+	if !ok {
+		return 0, errors.New("activity backfill only applies to the DynamoDB backend")
+	}
+	updated := 0
+	var lek map[string]types.AttributeValue
+	for {
+		page, err := d.client.Scan(ctx, &ddb.ScanInput{
+			TableName:        aws.String(d.table),
+			FilterExpression: aws.String("entityType = :conv AND attribute_not_exists(GSI2SK)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":conv": &types.AttributeValueMemberS{Value: entityConversation},
+			},
+			ExclusiveStartKey: lek,
+		})
+		if err != nil {
+			return updated, err
+		}
+		for _, it := range page.Items {
+			if err := d.backfillActivity(ctx, conversationFromItem(it)); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+		if page.LastEvaluatedKey == nil {
+			return updated, nil
+		}
+		lek = page.LastEvaluatedKey
+	}
+}
+
+func (d *dynamoDAL) backfillActivity(ctx context.Context, c Conversation) error {
+	var count, prompt, completion int
+	var last *ChatMessage
+	token := ""
+	for {
+		page, err := d.queryMessages(ctx, c.ID, MessageRange{}, 500, token, false)
+		if err != nil {
+			return err
+		}
+		for i := range page.Items {
+			m := page.Items[i]
+			count++
+			prompt += m.PromptTokens
+			completion += m.CompletionTokens
+			last = &m
+		}
+		if page.NextToken == "" {
+			break
+		}
+		token = page.NextToken
+	}
+	set := "SET messageCount = :n, promptTokens = :p, completionTokens = :c, GSI2SK = :act"
+	values := map[string]types.AttributeValue{
+		":n": &types.AttributeValueMemberN{Value: strconv.Itoa(count)},
+		":p": &types.AttributeValueMemberN{Value: strconv.Itoa(prompt)},
+		":c": &types.AttributeValueMemberN{Value: strconv.Itoa(completion)},
+	}
+	if last != nil {
+		c.LastMessageAt = &last.CreatedAt
+		set += ", lastMessageAt = :at, lastMessagePreview = :preview"
+		values[":at"] = &types.AttributeValueMemberS{Value: last.CreatedAt.UTC().Format(sortableTime)}
+		values[":preview"] = &types.AttributeValueMemberS{Value: messagePreview(last.Content)}
+	}
+	values[":act"] = &types.AttributeValueMemberS{Value: gsi2skActivity(c.ActivityAt(), c.ID)}
+	if c.DeletedAt == nil {
+		set += ", GSI2PK = :pk"
+		values[":pk"] = &types.AttributeValueMemberS{Value: gsi2pkUser(c.UserID)}
+	}
+	_, err := d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       conversationKey(c.UserID, c.ID),
+		UpdateExpression:          aws.String(set),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
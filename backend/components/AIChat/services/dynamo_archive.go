@@ -0,0 +1,415 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// An archived conversation has archivedAt on its header and a marker item
+// in its partition (CONV#id/ARCHIVE) with the user and the object key, so
+// that message listings, which only know the conversation ID, can find the
+// archive too. Header and marker are written and removed together.
+const skArchive = "ARCHIVE"
+
+func archiveMarkerKey(conversationID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pkConv(conversationID)},
+		"SK": &types.AttributeValueMemberS{Value: skArchive},
+	}
+}
+
+var errArchiveNotConfigured = errors.New("conversation is archived but ARCHIVE_BUCKET is not set")
+
+// archiveInactive scans for active headers last active before cutoff. A
+// scan reads the whole table, which the monthly job can afford; GSI2SK
+// holds the activity time in sortable form and is missing on trashed
+// headers.
+func (d *dynamoDAL) archiveInactive(ctx context.Context, cutoff time.Time) (ArchiveResult, error) {
+	var res ArchiveResult
+	failed := 0
+	var lek map[string]types.AttributeValue
+	for {
+		page, err := d.client.Scan(ctx, &ddb.ScanInput{
+			TableName:        aws.String(d.table),
+			FilterExpression: aws.String("entityType = :conv AND GSI2SK < :before AND messageCount > :zero AND attribute_not_exists(archivedAt)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":conv":   &types.AttributeValueMemberS{Value: entityConversation},
+				":before": &types.AttributeValueMemberS{Value: "ACT#" + cutoff.UTC().Format(sortableTime)},
+				":zero":   &types.AttributeValueMemberN{Value: "0"},
+			},
+			ExclusiveStartKey: lek,
+		})
+		if err != nil {
+			return res, err
+		}
+		for _, it := range page.Items {
+			c := conversationFromItem(it)
+			n, err := d.archiveConversation(ctx, c, it)
+			if err != nil {
+				failed++
+				log.Printf("❌ Archiving conversation %s: %v", c.ID, err)
+				continue
+			}
+			if n > 0 {
+				res.Conversations++
+				res.Messages += n
+			}
+		}
+		if page.LastEvaluatedKey == nil {
+			break
+		}
+		lek = page.LastEvaluatedKey
+	}
+	if failed > 0 {
+		return res, fmt.Errorf("archiving failed for %d conversation(s)", failed)
+	}
+	return res, nil
+}
+
+// archiveConversation uploads c's messages, marks the header archived on
+// condition that its activity has not changed since, and then deletes the
+// messages from the table. It returns how many messages it archived, 0 if
+// the conversation changed meanwhile.
+func (d *dynamoDAL) archiveConversation(ctx context.Context, c Conversation, header map[string]types.AttributeValue) (int, error) {
+	var msgs []ChatMessage
+	token := ""
+	for {
+		page, err := d.queryMessages(ctx, c.ID, MessageRange{}, 500, token, false)
+		if err != nil {
+			return 0, err
+		}
+		msgs = append(msgs, page.Items...)
+		if page.NextToken == "" {
+			break
+		}
+		token = page.NextToken
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	at := time.Now().UTC()
+	key := archiveKey(c, at)
+	body, err := encodeArchive(c, msgs, at)
+	if err != nil {
+		return 0, err
+	}
+	if err := Archive.PutObject(ctx, key, body); err != nil {
+		return 0, err
+	}
+
+	marker := archiveMarkerKey(c.ID)
+	marker["userId"] = &types.AttributeValueMemberS{Value: c.UserID}
+	marker["archiveKey"] = &types.AttributeValueMemberS{Value: key}
+	marker["archivedAt"] = &types.AttributeValueMemberS{Value: at.Format(sortableTime)}
+	marker["firstMessageAt"] = &types.AttributeValueMemberS{Value: msgs[0].CreatedAt.UTC().Format(sortableTime)}
+	if exp, ok := header["expiresAt"]; ok {
+		marker["expiresAt"] = exp // goes with the header
+	}
+	_, err = d.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName:           aws.String(d.table),
+			Key:                 conversationKey(c.UserID, c.ID),
+			UpdateExpression:    aws.String("SET archivedAt = :at"),
+			ConditionExpression: aws.String("GSI2SK = :act AND messageCount = :n AND attribute_not_exists(archivedAt)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":at":  marker["archivedAt"],
+				":act": header["GSI2SK"],
+				":n":   &types.AttributeValueMemberN{Value: strconv.Itoa(c.MessageCount)},
+			},
+		}},
+		{Put: &types.Put{TableName: aws.String(d.table), Item: marker}},
+	}})
+	if isConditionFailure(err) {
+		// A message arrived, or the conversation was trashed, meanwhile.
+		if err := Archive.DeleteObject(ctx, key); err != nil {
+			log.Printf("⚠️ Removing unused archive %s: %v", key, err)
+		}
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Messages left behind by a failure here are harmless: rehydrating
+	// writes the same items again.
+	writes := make([]types.WriteRequest, 0, len(msgs))
+	for _, m := range msgs {
+		writes = append(writes, types.WriteRequest{
+			DeleteRequest: &types.DeleteRequest{Key: messageKey(c.ID, skMsg(m.CreatedAt.UTC(), m.ID))},
+		})
+	}
+	if _, err := batchWrite(ctx, d.client, d.table, writes); err != nil {
+		return 0, err
+	}
+	log.Printf("📦 Archived conversation %s (%d messages) to %s", c.ID, len(msgs), key)
+	return len(msgs), nil
+}
+
+// rehydrate puts an archived conversation's messages back in the table and
+// clears the stub, reporting whether the conversation was archived. The
+// messages are written as they were, without touching the header's
+// activity, so repeated or concurrent rehydrations are harmless.
+func (d *dynamoDAL) rehydrate(ctx context.Context, conversationID string) (bool, error) {
+	marker, err := d.archiveMarker(ctx, conversationID)
+	if err != nil || marker == nil {
+		return false, err
+	}
+	if Archive == nil {
+		return false, errArchiveNotConfigured
+	}
+	userID, key := attrS(marker, "userId"), attrS(marker, "archiveKey")
+	b, err := Archive.GetObject(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		// Another rehydration finished first, or the archive is gone.
+		again, merr := d.archiveMarker(ctx, conversationID)
+		if merr != nil {
+			return false, merr
+		}
+		if again == nil || attrS(again, "archiveKey") != key {
+			return true, nil
+		}
+		return true, d.loseArchive(ctx, userID, conversationID, key)
+	}
+	if err != nil {
+		return false, err
+	}
+	_, msgs, err := decodeArchive(b)
+	if err != nil {
+		return false, err
+	}
+
+	keep := d.retention(ctx, userID)
+	writes := make([]types.WriteRequest, 0, len(msgs))
+	for _, m := range msgs {
+		item := withExpiry(messageItem(m), m.CreatedAt, keep)
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	if _, err := batchWrite(ctx, d.client, d.table, writes); err != nil {
+		return false, err
+	}
+	_, err = d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 conversationKey(userID, conversationID),
+		UpdateExpression:    aws.String("REMOVE archivedAt"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	})
+	if err != nil && !isConditionFailure(err) {
+		return false, err
+	}
+	if _, err := d.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       archiveMarkerKey(conversationID),
+	}); err != nil {
+		return false, err
+	}
+	if err := Archive.DeleteObject(ctx, key); err != nil {
+		log.Printf("⚠️ Removing rehydrated archive %s: %v", key, err)
+	}
+	log.Printf("📦 Rehydrated conversation %s (%d messages)", conversationID, len(msgs))
+	return true, nil
+}
+
+// loseArchive clears the stub of a conversation whose archive object is
+// missing, so that it opens again (without the archived messages) instead
+// of failing on every read, and records the loss on the header. It returns
+// ErrArchiveLost.
+func (d *dynamoDAL) loseArchive(ctx context.Context, userID, conversationID, key string) error {
+	log.Printf("❌ Archive %s of conversation %s is missing; its messages are lost", key, conversationID)
+	_, err := d.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName:           aws.String(d.table),
+			Key:                 conversationKey(userID, conversationID),
+			UpdateExpression:    aws.String("SET archiveLostAt = :now REMOVE archivedAt"),
+			ConditionExpression: aws.String("attribute_exists(PK)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(sortableTime)},
+			},
+		}},
+		{Delete: &types.Delete{
+			TableName:           aws.String(d.table),
+			Key:                 archiveMarkerKey(conversationID),
+			ConditionExpression: aws.String("archiveKey = :key"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":key": &types.AttributeValueMemberS{Value: key},
+			},
+		}},
+	}})
+	if err != nil && !isConditionFailure(err) {
+		return err
+	}
+	return ErrArchiveLost
+}
+
+// purgeArchivedMessages applies retention inside an archived conversation:
+// messages from before cutoff are dropped by writing the rest to a new
+// object, and the header's totals lose them. Its activity stays, since the
+// latest message is newer than cutoff (or purgeUser would have deleted the
+// conversation). It returns how many messages it dropped.
+func (d *dynamoDAL) purgeArchivedMessages(ctx context.Context, c Conversation, cutoff time.Time) (int, error) {
+	marker, err := d.archiveMarker(ctx, c.ID)
+	if err != nil || marker == nil {
+		return 0, err
+	}
+	if first := attrS(marker, "firstMessageAt"); first != "" && !parseTime(first).Before(cutoff) {
+		return 0, nil
+	}
+	if Archive == nil {
+		return 0, errArchiveNotConfigured
+	}
+	oldKey := attrS(marker, "archiveKey")
+	b, err := Archive.GetObject(ctx, oldKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return 0, nil // rehydrated meanwhile, or lost, which opening it reports
+	}
+	if err != nil {
+		return 0, err
+	}
+	h, msgs, err := decodeArchive(b)
+	if err != nil {
+		return 0, err
+	}
+	var keep, drop []ChatMessage
+	for _, m := range msgs {
+		if m.CreatedAt.Before(cutoff) {
+			drop = append(drop, m)
+		} else {
+			keep = append(keep, m)
+		}
+	}
+	if len(drop) == 0 || len(keep) == 0 {
+		return 0, nil
+	}
+
+	key := archiveKey(c, time.Now().UTC())
+	body, err := encodeArchive(h.Conversation, keep, h.ArchivedAt)
+	if err != nil {
+		return 0, err
+	}
+	if err := Archive.PutObject(ctx, key, body); err != nil {
+		return 0, err
+	}
+	prompt, completion := 0, 0
+	for _, m := range drop {
+		prompt += m.PromptTokens
+		completion += m.CompletionTokens
+	}
+	_, err = d.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName:           aws.String(d.table),
+			Key:                 archiveMarkerKey(c.ID),
+			UpdateExpression:    aws.String("SET archiveKey = :new, firstMessageAt = :first"),
+			ConditionExpression: aws.String("archiveKey = :old"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":new":   &types.AttributeValueMemberS{Value: key},
+				":first": &types.AttributeValueMemberS{Value: keep[0].CreatedAt.UTC().Format(sortableTime)},
+				":old":   &types.AttributeValueMemberS{Value: oldKey},
+			},
+		}},
+		{Update: &types.Update{
+			TableName:           aws.String(d.table),
+			Key:                 conversationKey(c.UserID, c.ID),
+			UpdateExpression:    aws.String("ADD messageCount :n, promptTokens :p, completionTokens :c"),
+			ConditionExpression: aws.String("attribute_exists(archivedAt)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":n": &types.AttributeValueMemberN{Value: strconv.Itoa(-len(drop))},
+				":p": &types.AttributeValueMemberN{Value: strconv.Itoa(-prompt)},
+				":c": &types.AttributeValueMemberN{Value: strconv.Itoa(-completion)},
+			},
+		}},
+	}})
+	if isConditionFailure(err) {
+		// Rehydrated meanwhile; purgeMessages handles it on the next run.
+		if err := Archive.DeleteObject(ctx, key); err != nil {
+			log.Printf("⚠️ Removing unused archive %s: %v", key, err)
+		}
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range drop {
+		_, err := d.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+			TableName: aws.String(d.table),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: pkConv(c.ID)},
+				"SK": &types.AttributeValueMemberS{Value: skFeedback(m.ID)},
+			},
+		})
+		if err != nil {
+			return len(drop), err
+		}
+	}
+	if err := Archive.DeleteObject(ctx, oldKey); err != nil {
+		log.Printf("⚠️ Removing replaced archive %s: %v", oldKey, err) // PurgeArchives retries
+	}
+	return len(drop), nil
+}
+
+// purgeArchives deletes the objects last modified before before that are
+// not the archive their conversation's marker points at.
+func (d *dynamoDAL) purgeArchives(ctx context.Context, before time.Time) (int, error) {
+	deleted := 0
+	token := ""
+	for {
+		page, err := Archive.ListObjects(ctx, "conversations/", token)
+		if err != nil {
+			return deleted, err
+		}
+		for _, o := range page.Items {
+			conversationID, ok := archiveKeyConversation(o.Key)
+			if !ok || !o.LastModified.Before(before) {
+				continue
+			}
+			marker, err := d.archiveMarker(ctx, conversationID)
+			if err != nil {
+				return deleted, err
+			}
+			if marker != nil && attrS(marker, "archiveKey") == o.Key {
+				continue
+			}
+			if err := Archive.DeleteObject(ctx, o.Key); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if page.NextToken == "" {
+			return deleted, nil
+		}
+		token = page.NextToken
+	}
+}
+
+// archiveMarker returns the conversation's archive marker, or nil.
+func (d *dynamoDAL) archiveMarker(ctx context.Context, conversationID string) (map[string]types.AttributeValue, error) {
+	out, err := d.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            archiveMarkerKey(conversationID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Item, nil
+}
+
+// dropArchive deletes the archive of a conversation that is being deleted
+// for good; the marker goes with the rest of the partition.
+func (d *dynamoDAL) dropArchive(ctx context.Context, conversationID string) error {
+	marker, err := d.archiveMarker(ctx, conversationID)
+	if err != nil || marker == nil {
+		return err
+	}
+	if Archive == nil {
+		return errArchiveNotConfigured
+	}
+	return Archive.DeleteObject(ctx, attrS(marker, "archiveKey"))
+}
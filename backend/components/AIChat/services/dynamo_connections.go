@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const entityConnection = "Connection"
+
+// WebSocket connections are keyed by connection ID (what API Gateway gives
+// us on every route) and indexed per user on GSI1 for broadcasts.
+// expiresAt lets a table TTL clean up connections whose $disconnect never
+// arrived; API Gateway closes connections after two hours anyway.
+func pkConnection(id string) string          { return "WSCONN#" + id }
+func gsi1pkConnections(userID string) string { return "WSUSER#" + userID }
+
+const (
+	skConnection       = "CONN"
+	connectionLifetime = 2 * time.Hour
+)
+
+type dynamoConnectionStore struct {
+	client *ddb.Client
+	table  string
+}
+
+func (s *dynamoConnectionStore) PutConnection(ctx context.Context, c Connection) error {
+	_, err := s.client.PutItem(ctx, &ddb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]types.AttributeValue{
+			"PK":           &types.AttributeValueMemberS{Value: pkConnection(c.ID)},
+			"SK":           &types.AttributeValueMemberS{Value: skConnection},
+			"GSI1PK":       &types.AttributeValueMemberS{Value: gsi1pkConnections(c.UserID)},
+			"GSI1SK":       &types.AttributeValueMemberS{Value: "CONN#" + c.ID},
+			"entityType":   &types.AttributeValueMemberS{Value: entityConnection},
+			"connectionId": &types.AttributeValueMemberS{Value: c.ID},
+			"userId":       &types.AttributeValueMemberS{Value: c.UserID},
+			"connectedAt":  &types.AttributeValueMemberS{Value: c.ConnectedAt.UTC().Format(time.RFC3339Nano)},
+			"expiresAt":    &types.AttributeValueMemberN{Value: strconv.FormatInt(c.ConnectedAt.Add(connectionLifetime).Unix(), 10)},
+		},
+	})
+	return err
+}
+
+func (s *dynamoConnectionStore) GetConnection(ctx context.Context, id string) (Connection, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkConnection(id)},
+			"SK": &types.AttributeValueMemberS{Value: skConnection},
+		},
+	})
+	if err != nil {
+		return Connection{}, err
+	}
+	if out.Item == nil {
+		return Connection{}, ErrConnectionNotFound
+	}
+	return connectionFromItem(out.Item), nil
+}
+
+func (s *dynamoConnectionStore) DeleteConnection(ctx context.Context, id string) error {
+	_, err := s.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkConnection(id)},
+			"SK": &types.AttributeValueMemberS{Value: skConnection},
+		},
+	})
+	return err
+}
+
+func (s *dynamoConnectionStore) ListUserConnections(ctx context.Context, userID string) ([]Connection, error) {
+	var conns []Connection
+	var lek map[string]types.AttributeValue
+	for {
+		out, err := s.client.Query(ctx, &ddb.QueryInput{
+			TableName:              aws.String(s.table),
+			IndexName:              aws.String("GSI1"),
+			KeyConditionExpression: aws.String("GSI1PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: gsi1pkConnections(userID)},
+			},
+			ExclusiveStartKey: lek,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range out.Items {
+			conns = append(conns, connectionFromItem(it))
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return conns, nil
+		}
+		lek = out.LastEvaluatedKey
+	}
+}
+
+func connectionFromItem(it map[string]types.AttributeValue) Connection {
+	return Connection{
+		ID:          attrS(it, "connectionId"),
+		UserID:      attrS(it, "userId"),
+		ConnectedAt: parseTime(attrS(it, "connectedAt")),
+	}
+}
@@ -2,11 +2,13 @@ package services
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
-	"sort"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -22,27 +24,43 @@ const (
 )
 
 // Key helpers
-func pkUser(userID string) string             { return "USER#" + userID }
-func skConv(conversationID string) string     { return "CONV#" + conversationID }
-func pkConv(conversationID string) string     { return "CONV#" + conversationID }
+func pkUser(userID string) string         { return "USER#" + userID }
+func skConv(conversationID string) string { return "CONV#" + conversationID }
+func pkConv(conversationID string) string { return "CONV#" + conversationID }
 func skMsg(ts time.Time, messageID string) string {
-	return "MSG#" + ts.UTC().Format(time.RFC3339Nano) + "#" + messageID
+	return "MSG#" + ts.UTC().Format(sortableTime) + "#" + messageID
 }
 func gsi1pkUser(userID string) string { return "USER#" + userID }
 func gsi1sk(ts time.Time, conversationID, messageID string) string {
-	return "TS#" + ts.UTC().Format(time.RFC3339Nano) + "#CONV#" + conversationID + "#MSG#" + messageID
+	return "TS#" + ts.UTC().Format(sortableTime) + "#CONV#" + conversationID + "#MSG#" + messageID
 }
 
+// gsi1pkTrash indexes trashed conversation headers by deletion time.
+const gsi1pkTrash = "TRASH"
+
+func gsi1skTrash(deletedAt time.Time, conversationID string) string {
+	return "TS#" + deletedAt.UTC().Format(sortableTime) + "#CONV#" + conversationID
+}
+
+// GSI2 lists a user's active conversations by recent activity. Trashed
+// headers drop GSI2PK, which takes them out of the (sparse) index.
+func gsi2pkUser(userID string) string { return "USER#" + userID }
+func gsi2skActivity(at time.Time, conversationID string) string {
+	return "ACT#" + at.UTC().Format(sortableTime) + "#CONV#" + conversationID
+}
+
+// sortableTime is RFC 3339 with fixed-width nanoseconds, so that string
+// order is time order (RFC3339Nano drops trailing zeros). Used for sort
+// keys and for the header timestamps that conditions compare.
+const sortableTime = "2006-01-02T15:04:05.000000000Z07:00"
+
 type dynamoDAL struct {
 	client *ddb.Client
 	table  string
 }
 
-// Global, used by your handlers/services.
-var Store DAL
-
-// Call once during cold start (e.g., in main.init or first handler call)
-func InitDAL() error {
+// initDynamoDAL points every store at the TABLE_NAME table.
+func initDynamoDAL() error {
 	table := os.Getenv("TABLE_NAME")
 	if table == "" {
 		return errors.New("TABLE_NAME env var is required")
@@ -51,37 +69,75 @@ func InitDAL() error {
 	if err != nil {
 		return err
 	}
+	client := ddb.NewFromConfig(cfg)
 	Store = &dynamoDAL{
-		client: ddb.NewFromConfig(cfg),
+		client: client,
 		table:  table,
 	}
+	Templates = &dynamoTemplateStore{client: client, table: table}
+	Experiments = &dynamoExperimentStore{client: client, table: table}
+	FeedbackItems = &dynamoFeedbackStore{client: client, table: table}
+	RedactionEvents = &dynamoRedactionLog{client: client, table: table}
+	UserPreferences = &dynamoPreferenceStore{client: client, table: table}
+	Jobs = &dynamoJobStore{client: client, table: table}
+	Connections = &dynamoConnectionStore{client: client, table: table}
+	Retention = &dynamoRetentionStore{client: client, table: table}
+	Idempotency = &dynamoIdempotencyStore{client: client, table: table}
+	if bucket := os.Getenv("ARCHIVE_BUCKET"); bucket != "" {
+		Archive = newS3ObjectStore(cfg, bucket)
+	}
 	return nil
 }
 
 // ---------- Key encoding for NextToken ----------
 
-func encodeLEK(lek map[string]types.AttributeValue) (string, error) {
+// Keys are flattened to {"PK": "S:USER#..."}: AttributeValue is an
+// interface, so the SDK types do not round-trip through encoding/json. The
+// result is sealed as a cursor for scope (see cursor.go).
+func encodeLEK(scope cursorScope, lek map[string]types.AttributeValue) (string, error) {
 	if lek == nil {
 		return "", nil
 	}
-	b, err := json.Marshal(lek)
+	flat := make(map[string]string, len(lek))
+	for k, v := range lek {
+		switch v := v.(type) {
+		case *types.AttributeValueMemberS:
+			flat[k] = "S:" + v.Value
+		case *types.AttributeValueMemberN:
+			flat[k] = "N:" + v.Value
+		default:
+			return "", fmt.Errorf("unsupported key attribute type for %s", k)
+		}
+	}
+	b, err := json.Marshal(flat)
 	if err != nil {
 		return "", err
 	}
-	return base64.StdEncoding.EncodeToString(b), nil
+	return sealCursor(scope, b), nil
 }
 
-func decodeLEK(token string) (map[string]types.AttributeValue, error) {
+func decodeLEK(scope cursorScope, token string) (map[string]types.AttributeValue, error) {
 	if token == "" {
 		return nil, nil
 	}
-	b, err := base64.StdEncoding.DecodeString(token)
+	b, err := openCursor(scope, token)
 	if err != nil {
 		return nil, err
 	}
-	var m map[string]types.AttributeValue
-	if err := json.Unmarshal(b, &m); err != nil {
-		return nil, err
+	var flat map[string]string
+	if err := json.Unmarshal(b, &flat); err != nil {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	m := make(map[string]types.AttributeValue, len(flat))
+	for k, v := range flat {
+		switch {
+		case strings.HasPrefix(v, "S:"):
+			m[k] = &types.AttributeValueMemberS{Value: v[2:]}
+		case strings.HasPrefix(v, "N:"):
+			m[k] = &types.AttributeValueMemberN{Value: v[2:]}
+		default:
+			return nil, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+		}
 	}
 	return m, nil
 }
@@ -90,178 +146,519 @@ func decodeLEK(token string) (map[string]types.AttributeValue, error) {
 
 func (d *dynamoDAL) CreateConversation(ctx context.Context, userID, title string) (string, error) {
 	id := ulid.Make().String()
-	now := time.Now().UTC()
+	_, err := d.client.PutItem(ctx, &ddb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                d.conversationItem(ctx, userID, id, title, time.Now().UTC()),
+		ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+	})
+	return id, err
+}
+
+// ImportConversation cannot see another user's header with the same ID;
+// imported IDs are derived from the user's ID, so they do not collide.
+func (d *dynamoDAL) ImportConversation(ctx context.Context, c Conversation) (bool, error) {
+	_, err := d.client.PutItem(ctx, &ddb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                d.conversationItem(ctx, c.UserID, c.ID, c.Title, c.CreatedAt.UTC()),
+		ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+	})
+	if isConditionFailure(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
 
+// conversationItem is a new conversation header created at.
+func (d *dynamoDAL) conversationItem(ctx context.Context, userID, id, title string, at time.Time) map[string]types.AttributeValue {
 	item := map[string]types.AttributeValue{
-		"PK":          &types.AttributeValueMemberS{Value: pkUser(userID)},
-		"SK":          &types.AttributeValueMemberS{Value: skConv(id)},
-		"entityType":  &types.AttributeValueMemberS{Value: entityConversation},
+		"PK":             &types.AttributeValueMemberS{Value: pkUser(userID)},
+		"SK":             &types.AttributeValueMemberS{Value: skConv(id)},
+		"entityType":     &types.AttributeValueMemberS{Value: entityConversation},
 		"conversationId": &types.AttributeValueMemberS{Value: id},
-		"userId":      &types.AttributeValueMemberS{Value: userID},
-		"title":       &types.AttributeValueMemberS{Value: title},
-		"createdAt":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+		"userId":         &types.AttributeValueMemberS{Value: userID},
+		"title":          &types.AttributeValueMemberS{Value: title},
+		"createdAt":      &types.AttributeValueMemberS{Value: at.Format(time.RFC3339Nano)},
+		"messageCount":   &types.AttributeValueMemberN{Value: "0"},
+		"GSI2PK":         &types.AttributeValueMemberS{Value: gsi2pkUser(userID)},
+		"GSI2SK":         &types.AttributeValueMemberS{Value: gsi2skActivity(at, id)},
 	}
+	return withExpiry(item, at, d.retention(ctx, userID))
+}
 
-	_, err := d.client.PutItem(ctx, &ddb.PutItemInput{
+func (d *dynamoDAL) GetConversation(ctx context.Context, userID, conversationID string) (Conversation, error) {
+	out, err := d.client.GetItem(ctx, &ddb.GetItemInput{
 		TableName: aws.String(d.table),
-		Item:      item,
-		ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+		Key:       conversationKey(userID, conversationID),
 	})
-	return id, err
+	if err != nil {
+		return Conversation{}, err
+	}
+	if out.Item == nil {
+		return Conversation{}, ErrConversationNotFound
+	}
+	c := conversationFromItem(out.Item)
+	if c.ArchivedAt != nil {
+		_, err := d.rehydrate(ctx, conversationID)
+		if errors.Is(err, ErrArchiveLost) {
+			now := time.Now().UTC()
+			c.ArchiveLostAt, err = &now, nil
+		}
+		if err != nil {
+			return Conversation{}, err
+		}
+		c.ArchivedAt = nil
+	}
+	return c, nil
+}
+
+// UpdateConversation is one conditional UpdateItem; on a failed condition
+// the old item tells a stale version from a missing or trashed
+// conversation.
+func (d *dynamoDAL) UpdateConversation(ctx context.Context, userID, conversationID string, u ConversationUpdate) (Conversation, error) {
+	set := []string{}
+	values := map[string]types.AttributeValue{
+		":one": &types.AttributeValueMemberN{Value: "1"},
+	}
+	if u.Title != nil {
+		set = append(set, "title = :title")
+		values[":title"] = &types.AttributeValueMemberS{Value: *u.Title}
+	}
+	if u.Settings != nil {
+		set = append(set, "settingsLanguage = :lang")
+		values[":lang"] = &types.AttributeValueMemberS{Value: u.Settings.Language}
+	}
+	update := "ADD version :one"
+	if len(set) > 0 {
+		update = "SET " + strings.Join(set, ", ") + " " + update
+	}
+	cond := "attribute_exists(PK) AND attribute_not_exists(deletedAt)"
+	switch {
+	case u.IfVersion == nil:
+	case *u.IfVersion == 0:
+		// Never-updated headers have no version attribute.
+		cond += " AND (attribute_not_exists(version) OR version = :v)"
+		values[":v"] = &types.AttributeValueMemberN{Value: "0"}
+	default:
+		cond += " AND version = :v"
+		values[":v"] = &types.AttributeValueMemberN{Value: strconv.Itoa(*u.IfVersion)}
+	}
+	out, err := d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                           aws.String(d.table),
+		Key:                                 conversationKey(userID, conversationID),
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String(cond),
+		ExpressionAttributeValues:           values,
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		if ccf.Item == nil || attrS(ccf.Item, "deletedAt") != "" {
+			return Conversation{}, ErrConversationNotFound
+		}
+		return Conversation{}, ErrVersionConflict
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+	return conversationFromItem(out.Attributes), nil
 }
 
 func (d *dynamoDAL) ListConversations(ctx context.Context, userID string, limit int32, nextToken string) (ListPage[Conversation], error) {
-	lek, err := decodeLEK(nextToken)
-	if err != nil { return ListPage[Conversation]{}, err }
+	scope := conversationsCursor(userID)
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
+	out, err := d.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(d.table),
+		IndexName:              aws.String("GSI2"),
+		KeyConditionExpression: aws.String("GSI2PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: gsi2pkUser(userID)},
+		},
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: lek,
+		ScanIndexForward:  aws.Bool(false), // most recent activity first
+	})
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
+	var items []Conversation
+	for _, it := range out.Items {
+		items = append(items, conversationFromItem(it))
+	}
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
+	return ListPage[Conversation]{Items: items, NextToken: token}, nil
+}
+
+// queryConversations pages through userID's conversation headers, newest
+// first, keeping those matching filter. Filters apply after Limit, so a
+// page can be short (even empty) while NextToken is set.
+func (d *dynamoDAL) queryConversations(ctx context.Context, scope cursorScope, userID string, limit int32, nextToken, filter string) (ListPage[Conversation], error) {
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
 
 	out, err := d.client.Query(ctx, &ddb.QueryInput{
 		TableName:              aws.String(d.table),
 		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :conv)"),
+		FilterExpression:       aws.String(filter),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":pk":   &types.AttributeValueMemberS{Value: pkUser(userID)},
 			":conv": &types.AttributeValueMemberS{Value: "CONV#"},
 		},
-		Limit:            aws.Int32(limit),
+		Limit:             aws.Int32(limit),
 		ExclusiveStartKey: lek,
-		ScanIndexForward: aws.Bool(false), // newest first if you maintain lastMessageAt later
+		ScanIndexForward:  aws.Bool(false), // newest first: conversation IDs are ULIDs
 	})
-	if err != nil { return ListPage[Conversation]{}, err }
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
 
 	var items []Conversation
 	for _, it := range out.Items {
-		items = append(items, Conversation{
-			ID:        attrS(it, "conversationId"),
-			UserID:    attrS(it, "userId"),
-			Title:     attrS(it, "title"),
-			CreatedAt: parseTime(attrS(it, "createdAt")),
-		})
+		items = append(items, conversationFromItem(it))
 	}
-	token, _ := encodeLEK(out.LastEvaluatedKey)
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
 	return ListPage[Conversation]{Items: items, NextToken: token}, nil
 }
 
+// PutMessage writes m and its conversation's activity in one transaction;
+// see putWithActivity.
 func (d *dynamoDAL) PutMessage(ctx context.Context, m ChatMessage) error {
+	return d.putWithActivity(ctx, true, m)
+}
+
+func (d *dynamoDAL) PutExchange(ctx context.Context, userMsg, botMsg ChatMessage) error {
+	if err := checkExchange(userMsg, botMsg); err != nil {
+		return err
+	}
+	return d.putWithActivity(ctx, true, userMsg, botMsg)
+}
+
+// PutMessages checks that every conversation exists, batch-writes msgs (see
+// batchWrite for retries) and then adds what was written to each
+// conversation's activity. BatchWriteItem cannot tell new items from
+// replaced ones, so rewriting stored messages this way counts them again.
+func (d *dynamoDAL) PutMessages(ctx context.Context, msgs []ChatMessage) error {
+	type conv struct{ userID, conversationID string }
+	var convs []conv
+	byConv := map[conv][]ChatMessage{}
+	for _, m := range msgs {
+		k := conv{m.UserID, m.ConversationID}
+		if _, ok := byConv[k]; !ok {
+			convs = append(convs, k)
+		}
+		byConv[k] = append(byConv[k], m)
+	}
+	for _, k := range convs {
+		out, err := d.client.GetItem(ctx, &ddb.GetItemInput{
+			TableName:            aws.String(d.table),
+			Key:                  conversationKey(k.userID, k.conversationID),
+			ProjectionExpression: aws.String("PK"),
+			ConsistentRead:       aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		if out.Item == nil {
+			return ErrConversationNotFound
+		}
+	}
+
+	writes := make([]types.WriteRequest, 0, len(msgs))
+	for _, m := range msgs {
+		item := withExpiry(messageItem(m), m.CreatedAt, d.retention(ctx, m.UserID))
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	failed, werr := batchWrite(ctx, d.client, d.table, writes)
+	var perr *PartialWriteError
+	notWritten := map[string]bool{}
+	if werr != nil {
+		perr = &PartialWriteError{Written: len(msgs) - len(failed), Err: werr}
+		for _, w := range failed {
+			id := attrS(w.PutRequest.Item, "messageId")
+			perr.FailedIDs = append(perr.FailedIDs, id)
+			notWritten[id] = true
+		}
+	}
+
+	for _, k := range convs {
+		var written []ChatMessage
+		for _, m := range byConv[k] {
+			if !notWritten[m.ID] {
+				written = append(written, m)
+			}
+		}
+		if len(written) == 0 {
+			continue
+		}
+		if err := d.addActivity(ctx, written); err != nil {
+			return err
+		}
+	}
+	if perr != nil {
+		return perr
+	}
+	return nil
+}
+
+// messageItem is the table item for m.
+func messageItem(m ChatMessage) map[string]types.AttributeValue {
 	ts := m.CreatedAt.UTC()
 	item := map[string]types.AttributeValue{
-		"PK":            &types.AttributeValueMemberS{Value: pkConv(m.ConversationID)},
-		"SK":            &types.AttributeValueMemberS{Value: skMsg(ts, m.ID)},
-		"entityType":    &types.AttributeValueMemberS{Value: entityMessage},
+		"PK":             &types.AttributeValueMemberS{Value: pkConv(m.ConversationID)},
+		"SK":             &types.AttributeValueMemberS{Value: skMsg(ts, m.ID)},
+		"entityType":     &types.AttributeValueMemberS{Value: entityMessage},
+		"messageId":      &types.AttributeValueMemberS{Value: m.ID},
 		"conversationId": &types.AttributeValueMemberS{Value: m.ConversationID},
-		"userId":        &types.AttributeValueMemberS{Value: m.UserID},
-		"role":          &types.AttributeValueMemberS{Value: m.Role},
-		"content":       &types.AttributeValueMemberS{Value: m.Content},
-		"createdAt":     &types.AttributeValueMemberS{Value: ts.Format(time.RFC3339Nano)},
-		"epochMs":       &types.AttributeValueMemberN{Value: toEpochMs(ts)},
+		"userId":         &types.AttributeValueMemberS{Value: m.UserID},
+		"role":           &types.AttributeValueMemberS{Value: m.Role},
+		"content":        &types.AttributeValueMemberS{Value: m.Content},
+		"createdAt":      &types.AttributeValueMemberS{Value: ts.Format(time.RFC3339Nano)},
+		"epochMs":        &types.AttributeValueMemberN{Value: toEpochMs(ts)},
 		// GSI1 for user-time queries:
 		"GSI1PK": &types.AttributeValueMemberS{Value: gsi1pkUser(m.UserID)},
 		"GSI1SK": &types.AttributeValueMemberS{Value: gsi1sk(ts, m.ConversationID, m.ID)},
 	}
-
-	_, err := d.client.PutItem(ctx, &ddb.PutItemInput{
-		TableName: aws.String(d.table),
-		Item:      item,
-	})
-	return err
+	if m.TemplateID != "" {
+		item["templateId"] = &types.AttributeValueMemberS{Value: m.TemplateID}
+		item["templateVersion"] = &types.AttributeValueMemberN{Value: strconv.Itoa(m.TemplateVersion)}
+	}
+	if m.ExperimentID != "" {
+		item["experimentId"] = &types.AttributeValueMemberS{Value: m.ExperimentID}
+		item["variant"] = &types.AttributeValueMemberS{Value: m.Variant}
+	}
+	if m.Model != "" {
+		item["model"] = &types.AttributeValueMemberS{Value: m.Model}
+		item["promptTokens"] = &types.AttributeValueMemberN{Value: strconv.Itoa(m.PromptTokens)}
+		item["completionTokens"] = &types.AttributeValueMemberN{Value: strconv.Itoa(m.CompletionTokens)}
+	}
+	if m.Language != "" {
+		item["language"] = &types.AttributeValueMemberS{Value: m.Language}
+	}
+	if m.Translation != "" {
+		item["translation"] = &types.AttributeValueMemberS{Value: m.Translation}
+		item["translationLanguage"] = &types.AttributeValueMemberS{Value: m.TranslationLanguage}
+	}
+	if m.EditedAt != nil {
+		item["editedAt"] = &types.AttributeValueMemberS{Value: m.EditedAt.UTC().Format(time.RFC3339Nano)}
+		item["edits"] = editsAttr(m.Edits)
+	}
+	return item
 }
 
 func (d *dynamoDAL) ListMessages(ctx context.Context, conversationID string, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error) {
-	lek, err := decodeLEK(nextToken)
-	if err != nil { return ListPage[ChatMessage]{}, err }
+	return d.ListMessagesRange(ctx, conversationID, MessageRange{}, limit, nextToken, newestFirst)
+}
+
+// ListMessagesRange rehydrates an archived conversation first. Its
+// messages are not in the table, so only a listing that ends on its first
+// page needs to look for the archive marker.
+func (d *dynamoDAL) ListMessagesRange(ctx context.Context, conversationID string, r MessageRange, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error) {
+	page, err := d.queryMessages(ctx, conversationID, r, limit, nextToken, newestFirst)
+	if err != nil || Archive == nil || nextToken != "" || page.NextToken != "" {
+		return page, err
+	}
+	restored, err := d.rehydrate(ctx, conversationID)
+	if errors.Is(err, ErrArchiveLost) {
+		return page, nil
+	}
+	if err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
+	if restored {
+		return d.queryMessages(ctx, conversationID, r, limit, nextToken, newestFirst)
+	}
+	return page, nil
+}
+
+// queryMessages lists what is in the table, for ListMessagesRange and the
+// jobs that must not rehydrate. It queries SK BETWEEN the bounds' sort
+// keys, which includes the bounds themselves. The bound the page starts
+// from is skipped by starting the query after it; the far one ends the
+// listing when reached.
+func (d *dynamoDAL) queryMessages(ctx context.Context, conversationID string, r MessageRange, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error) {
+	scope := messagesCursor(conversationID, r, newestFirst)
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
+	// '$' sorts right after '#', so MSG$ is above every MSG# key.
+	lo, hi := "MSG#", "MSG$"
+	if r.After != "" {
+		if lo, err = d.messageSK(ctx, conversationID, r.After); err != nil {
+			return ListPage[ChatMessage]{}, err
+		}
+	}
+	if r.Before != "" {
+		if hi, err = d.messageSK(ctx, conversationID, r.Before); err != nil {
+			return ListPage[ChatMessage]{}, err
+		}
+	}
+	start, end := r.After, r.Before
+	startSK := lo
+	if newestFirst {
+		start, end = r.Before, r.After
+		startSK = hi
+	}
+	if lek == nil && start != "" {
+		lek = map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkConv(conversationID)},
+			"SK": &types.AttributeValueMemberS{Value: startSK},
+		}
+	}
 
 	out, err := d.client.Query(ctx, &ddb.QueryInput{
 		TableName:              aws.String(d.table),
-		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :msg)"),
+		KeyConditionExpression: aws.String("PK = :pk AND SK BETWEEN :lo AND :hi"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk":  &types.AttributeValueMemberS{Value: pkConv(conversationID)},
-			":msg": &types.AttributeValueMemberS{Value: "MSG#"},
+			":pk": &types.AttributeValueMemberS{Value: pkConv(conversationID)},
+			":lo": &types.AttributeValueMemberS{Value: lo},
+			":hi": &types.AttributeValueMemberS{Value: hi},
 		},
-		Limit:            aws.Int32(limit),
+		Limit:             aws.Int32(limit),
 		ExclusiveStartKey: lek,
-		ScanIndexForward: aws.Bool(!newestFirst), // Dynamo ascending when true
+		ScanIndexForward:  aws.Bool(!newestFirst), // Dynamo ascending when true
 	})
-	if err != nil { return ListPage[ChatMessage]{}, err }
+	if err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
 
 	var items []ChatMessage
+	reachedEnd := false
 	for _, it := range out.Items {
-		items = append(items, ChatMessage{
-			ID:             parseMessageID(attrS(it, "SK")),
-			ConversationID: conversationID,
-			UserID:         attrS(it, "userId"),
-			Role:           attrS(it, "role"),
-			Content:        attrS(it, "content"),
-			CreatedAt:      parseTime(attrS(it, "createdAt")),
-		})
+		m := messageFromItem(it)
+		m.ConversationID = conversationID
+		if end != "" && m.ID == end {
+			reachedEnd = true
+			break
+		}
+		items = append(items, m)
 	}
-	// If newestFirst==true and Dynamo returned ascending (because ScanIndexForward=false already gives descending),
-	// we’re good. If you ever switch to ascending, reverse here:
-	if newestFirst {
-		// already descending from query; keep as-is
-	} else {
-		// ensure ascending
-		sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	// SK orders by timestamp, then ID. The stable sort only matters for
+	// keys written before they were fixed-width (see BackfillMessageKeys).
+	slices.SortStableFunc(items, func(a, b ChatMessage) int {
+		if newestFirst {
+			return b.CreatedAt.Compare(a.CreatedAt)
+		}
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	if reachedEnd {
+		return ListPage[ChatMessage]{Items: items}, nil
 	}
 
-	token, _ := encodeLEK(out.LastEvaluatedKey)
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
 	return ListPage[ChatMessage]{Items: items, NextToken: token}, nil
 }
 
-func (d *dynamoDAL) DeleteConversationCascade(ctx context.Context, conversationID string) error {
-	// Query all PK=CONV#id and batch delete
-	var lek map[string]types.AttributeValue
-	for {
-		out, err := d.client.Query(ctx, &ddb.QueryInput{
-			TableName:              aws.String(d.table),
-			KeyConditionExpression: aws.String("PK = :pk"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":pk": &types.AttributeValueMemberS{Value: pkConv(conversationID)},
-			},
-			ExclusiveStartKey: lek,
-		})
-		if err != nil { return err }
-
-		if len(out.Items) > 0 {
-			writes := make([]types.WriteRequest, 0, len(out.Items))
-			for _, it := range out.Items {
-				writes = append(writes, types.WriteRequest{
-					DeleteRequest: &types.DeleteRequest{
-						Key: map[string]types.AttributeValue{
-							"PK": it["PK"],
-							"SK": it["SK"],
-						},
-					},
-				})
-			}
-			// BatchWriteItem in chunks of 25
-			for i := 0; i < len(writes); i += 25 {
-				end := i + 25
-				if end > len(writes) { end = len(writes) }
-				_, err := d.client.BatchWriteItem(ctx, &ddb.BatchWriteItemInput{
-					RequestItems: map[string][]types.WriteRequest{d.table: writes[i:end]},
-				})
-				if err != nil { return err }
-			}
-		}
-
-		if out.LastEvaluatedKey == nil {
-			break
-		}
-		lek = out.LastEvaluatedKey
+// DeleteConversationCascade permanently removes the conversation header,
+// its archive if it has one, and everything in its partition (messages,
+// feedback, redaction events). The header goes last, so a failed delete can
+// simply be retried.
+func (d *dynamoDAL) DeleteConversationCascade(ctx context.Context, userID, conversationID string) error {
+	header := conversationKey(userID, conversationID)
+	out, err := d.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName:            aws.String(d.table),
+		Key:                  header,
+		ProjectionExpression: aws.String("PK"),
+	})
+	if err != nil {
+		return err
 	}
-	// Also delete the conversation header item (USER#sub, SK=CONV#id)
-	_, err := d.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+	if out.Item == nil {
+		return ErrConversationNotFound
+	}
+	if err := d.dropArchive(ctx, conversationID); err != nil {
+		return err
+	}
+	if err := deletePartition(ctx, d.client, d.table, pkConv(conversationID)); err != nil {
+		return err
+	}
+	_, err = d.client.DeleteItem(ctx, &ddb.DeleteItemInput{
 		TableName: aws.String(d.table),
-		Key: map[string]types.AttributeValue{
-			"PK": &types.AttributeValueMemberS{Value: pkUser("")}, // not known here
-			"SK": &types.AttributeValueMemberS{Value: skConv(conversationID)},
+		Key:       header,
+	})
+	return err
+}
+
+// TrashConversation stamps deletedAt on the header and indexes it on GSI1
+// under TRASH, by deletion time, for the purge, instead of on GSI2.
+func (d *dynamoDAL) TrashConversation(ctx context.Context, userID, conversationID string, at time.Time) error {
+	_, err := d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 conversationKey(userID, conversationID),
+		UpdateExpression:    aws.String("SET deletedAt = :at, GSI1PK = :trash, GSI1SK = :gsk REMOVE GSI2PK"),
+		ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(deletedAt)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at":    &types.AttributeValueMemberS{Value: at.UTC().Format(sortableTime)},
+			":trash": &types.AttributeValueMemberS{Value: gsi1pkTrash},
+			":gsk":   &types.AttributeValueMemberS{Value: gsi1skTrash(at, conversationID)},
 		},
 	})
-	if err != nil {// Note: To delete the header, you need userID; do this delete in handler where you have the userID.
-	return nil
+	if isConditionFailure(err) {
+		return ErrConversationNotFound
+	}
+	return err
+}
+
+func (d *dynamoDAL) RestoreConversation(ctx context.Context, userID, conversationID string, deletedAfter time.Time) error {
+	_, err := d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 conversationKey(userID, conversationID),
+		UpdateExpression:    aws.String("SET GSI2PK = :pk REMOVE deletedAt, GSI1PK, GSI1SK"),
+		ConditionExpression: aws.String("attribute_exists(deletedAt) AND deletedAt >= :after"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":    &types.AttributeValueMemberS{Value: gsi2pkUser(userID)},
+			":after": &types.AttributeValueMemberS{Value: deletedAfter.UTC().Format(sortableTime)},
+		},
+	})
+	if isConditionFailure(err) {
+		return ErrConversationNotFound
+	}
+	return err
+}
+
+func (d *dynamoDAL) ListTrashedConversations(ctx context.Context, userID string, limit int32, nextToken string) (ListPage[Conversation], error) {
+	return d.queryConversations(ctx, trashCursor(userID), userID, limit, nextToken, "attribute_exists(deletedAt)")
+}
+
+func (d *dynamoDAL) ListExpiredTrash(ctx context.Context, deletedBefore time.Time, limit int32, nextToken string) (ListPage[Conversation], error) {
+	scope := expiredTrashCursor(deletedBefore)
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
+	out, err := d.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(d.table),
+		IndexName:              aws.String("GSI1"),
+		KeyConditionExpression: aws.String("GSI1PK = :pk AND GSI1SK < :before"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":     &types.AttributeValueMemberS{Value: gsi1pkTrash},
+			":before": &types.AttributeValueMemberS{Value: "TS#" + deletedBefore.UTC().Format(sortableTime)},
+		},
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: lek,
+	})
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
+	var items []Conversation
+	for _, it := range out.Items {
+		items = append(items, conversationFromItem(it))
+	}
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
+	return ListPage[Conversation]{Items: items, NextToken: token}, nil
 }
 
 func (d *dynamoDAL) ListUserMessagesSince(ctx context.Context, userID string, since time.Time, limit int32, nextToken string) (ListPage[ChatMessage], error) {
-	lek, err := decodeLEK(nextToken)
-	if err != nil { return ListPage[ChatMessage]{}, err }
+	scope := userMessagesCursor(userID, since)
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
 
 	out, err := d.client.Query(ctx, &ddb.QueryInput{
 		TableName:              aws.String(d.table),
@@ -269,47 +666,236 @@ func (d *dynamoDAL) ListUserMessagesSince(ctx context.Context, userID string, si
 		KeyConditionExpression: aws.String("GSI1PK = :pk AND GSI1SK >= :from"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":pk":   &types.AttributeValueMemberS{Value: gsi1pkUser(userID)},
-			":from": &types.AttributeValueMemberS{Value: "TS#" + since.UTC().Format(time.RFC3339Nano)},
+			":from": &types.AttributeValueMemberS{Value: "TS#" + since.UTC().Format(sortableTime)},
 		},
 		Limit:             aws.Int32(limit),
 		ExclusiveStartKey: lek,
 		ScanIndexForward:  aws.Bool(true),
 	})
-	if err != nil { return ListPage[ChatMessage]{}, err }
+	if err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
 
 	var items []ChatMessage
 	for _, it := range out.Items {
-		items = append(items, ChatMessage{
-			ID:             parseMessageID(attrS(it, "GSI1SK")),
-			ConversationID: attrS(it, "conversationId"),
-			UserID:         attrS(it, "userId"),
-			Role:           attrS(it, "role"),
-			Content:        attrS(it, "content"),
-			CreatedAt:      parseTime(attrS(it, "createdAt")),
-		})
+		m := messageFromItem(it)
+		items = append(items, m)
 	}
-	token, _ := encodeLEK(out.LastEvaluatedKey)
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
 	return ListPage[ChatMessage]{Items: items, NextToken: token}, nil
 }
 
 // ---------- helpers ----------
 
+func conversationFromItem(it map[string]types.AttributeValue) Conversation {
+	c := Conversation{
+		ID:        attrS(it, "conversationId"),
+		UserID:    attrS(it, "userId"),
+		Title:     attrS(it, "title"),
+		CreatedAt: parseTime(attrS(it, "createdAt")),
+	}
+	if at := attrS(it, "lastMessageAt"); at != "" {
+		t := parseTime(at)
+		c.LastMessageAt = &t
+		c.LastMessagePreview = attrS(it, "lastMessagePreview")
+	}
+	c.Settings.Language = attrS(it, "settingsLanguage")
+	c.Version = attrInt(it, "version")
+	c.MessageCount = attrInt(it, "messageCount")
+	c.PromptTokens = attrInt(it, "promptTokens")
+	c.CompletionTokens = attrInt(it, "completionTokens")
+	if at := attrS(it, "deletedAt"); at != "" {
+		t := parseTime(at)
+		c.DeletedAt = &t
+	}
+	if at := attrS(it, "archivedAt"); at != "" {
+		t := parseTime(at)
+		c.ArchivedAt = &t
+	}
+	if at := attrS(it, "archiveLostAt"); at != "" {
+		t := parseTime(at)
+		c.ArchiveLostAt = &t
+	}
+	return c
+}
+
+// messageFromItem reads a message item, from the table or GSI1. Messages
+// written before messageId was stored have their ID only in the keys.
+func messageFromItem(it map[string]types.AttributeValue) ChatMessage {
+	id := attrS(it, "messageId")
+	if id == "" {
+		id = parseMessageID(attrS(it, "SK"))
+	}
+	m := ChatMessage{
+		ID:                  id,
+		ConversationID:      attrS(it, "conversationId"),
+		UserID:              attrS(it, "userId"),
+		Role:                attrS(it, "role"),
+		Content:             attrS(it, "content"),
+		CreatedAt:           parseTime(attrS(it, "createdAt")),
+		TemplateID:          attrS(it, "templateId"),
+		TemplateVersion:     attrInt(it, "templateVersion"),
+		ExperimentID:        attrS(it, "experimentId"),
+		Variant:             attrS(it, "variant"),
+		Model:               attrS(it, "model"),
+		PromptTokens:        attrInt(it, "promptTokens"),
+		CompletionTokens:    attrInt(it, "completionTokens"),
+		Language:            attrS(it, "language"),
+		Translation:         attrS(it, "translation"),
+		TranslationLanguage: attrS(it, "translationLanguage"),
+	}
+	if at := attrS(it, "editedAt"); at != "" {
+		t := parseTime(at)
+		m.EditedAt = &t
+		m.Edits = editsFromAttr(it["edits"])
+	}
+	return m
+}
+
 func attrS(m map[string]types.AttributeValue, k string) string {
 	if v, ok := m[k].(*types.AttributeValueMemberS); ok {
 		return v.Value
 	}
 	return ""
 }
-func toEpochMs(t time.Time) string { return aws.ToString(aws.String((time.Duration(t.UnixNano()/1e6)).String())) }
+func attrInt(m map[string]types.AttributeValue, k string) int {
+	if v, ok := m[k].(*types.AttributeValueMemberN); ok {
+		n, _ := strconv.Atoi(v.Value)
+		return n
+	}
+	return 0
+}
+func attrInt64(m map[string]types.AttributeValue, k string) int64 {
+	if v, ok := m[k].(*types.AttributeValueMemberN); ok {
+		n, _ := strconv.ParseInt(v.Value, 10, 64)
+		return n
+	}
+	return 0
+}
+func toEpochMs(t time.Time) string { return strconv.FormatInt(t.UnixMilli(), 10) }
 func parseTime(s string) time.Time {
-	if s == "" { return time.Time{} }
-	t, _ := time.Parse(time.RFC3339Nano, s); return t
+	if s == "" {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
 }
 func parseMessageID(skOrGsi1sk string) string {
 	// sk:  MSG#<ts>#<id>
 	// gsi: TS#<ts>#CONV#<cid>#MSG#<id>
-	parts := []rune(skOrGsi1sk)
-	_ = parts
-	// simplest: not needed by callers right now; return ""
-	return ""
+	// RFC3339 timestamps and ULIDs never contain '#', so the ID is the last segment.
+	i := strings.LastIndex(skOrGsi1sk, "#")
+	if i < 0 {
+		return ""
+	}
+	return skOrGsi1sk[i+1:]
+}
+
+// GenerateULID returns a new time-ordered ID for messages and conversations.
+func GenerateULID() string { return ulid.Make().String() }
+
+// isConditionFailure reports whether err is a failed ConditionExpression,
+// either on a single write or inside a cancelled transaction.
+func isConditionFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return true
+	}
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		for _, r := range tce.CancellationReasons {
+			if aws.ToString(r.Code) == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deletePartition removes every item under pk.
+func deletePartition(ctx context.Context, client *ddb.Client, table, pk string) error {
+	var lek map[string]types.AttributeValue
+	for {
+		page, err := client.Query(ctx, &ddb.QueryInput{
+			TableName:              aws.String(table),
+			KeyConditionExpression: aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: pk},
+			},
+			ProjectionExpression: aws.String("PK, SK"),
+			ExclusiveStartKey:    lek,
+		})
+		if err != nil {
+			return err
+		}
+		writes := make([]types.WriteRequest, 0, len(page.Items))
+		for _, it := range page.Items {
+			writes = append(writes, types.WriteRequest{
+				DeleteRequest: &types.DeleteRequest{Key: map[string]types.AttributeValue{"PK": it["PK"], "SK": it["SK"]}},
+			})
+		}
+		if _, err := batchWrite(ctx, client, table, writes); err != nil {
+			return err
+		}
+		if page.LastEvaluatedKey == nil {
+			return nil
+		}
+		lek = page.LastEvaluatedKey
+	}
+}
+
+// BatchWriteItem takes at most batchWriteSize writes. UnprocessedItems,
+// which DynamoDB returns when a batch is throttled, are resent after 50ms
+// doubling up to 5s, giving up after batchWriteAttempts.
+const (
+	batchWriteSize       = 25
+	batchWriteAttempts   = 10
+	batchWriteBaseDelay  = 50 * time.Millisecond
+	batchWriteMaxBackoff = 5 * time.Second
+)
+
+// batchWrite applies writes in batches, retrying unprocessed items. On
+// error it also returns the writes that were not applied: what was left of
+// the failing batch and all later batches.
+func batchWrite(ctx context.Context, client *ddb.Client, table string, writes []types.WriteRequest) ([]types.WriteRequest, error) {
+	for i := 0; i < len(writes); i += batchWriteSize {
+		end := min(i+batchWriteSize, len(writes))
+		if left, err := batchWriteChunk(ctx, client, table, writes[i:end]); err != nil {
+			return slices.Concat(left, writes[end:]), err
+		}
+	}
+	return nil, nil
+}
+
+// batchWriteChunk sends one batch until nothing is unprocessed, returning
+// what is still pending on error.
+func batchWriteChunk(ctx context.Context, client *ddb.Client, table string, writes []types.WriteRequest) ([]types.WriteRequest, error) {
+	pending := writes
+	delay := batchWriteBaseDelay
+	for attempt := 1; ; attempt++ {
+		out, err := client.BatchWriteItem(ctx, &ddb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{table: pending},
+		})
+		if err != nil {
+			return pending, err
+		}
+		if len(out.UnprocessedItems[table]) == 0 {
+			return nil, nil
+		}
+		pending = out.UnprocessedItems[table]
+		if attempt == batchWriteAttempts {
+			return pending, fmt.Errorf("batch write: %d items still unprocessed after %d attempts", len(pending), attempt)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return pending, ctx.Err()
+		}
+		if delay *= 2; delay > batchWriteMaxBackoff {
+			delay = batchWriteMaxBackoff
+		}
+	}
 }
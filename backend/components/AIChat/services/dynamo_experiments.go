@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	entityExperiment      = "Experiment"
+	entityExperimentStats = "ExperimentStats"
+)
+
+// Experiment definitions share one partition (like templates); each
+// variant's counters live under the experiment's own PK.
+const pkExperiments = "EXPERIMENTS"
+
+func skExperiment(id string) string { return "EXP#" + id }
+
+// skRunningExperiment is a lock item in the definitions partition naming
+// the running experiment. Writes that start or stop an experiment change it
+// in the same transaction, under a condition, so two admins cannot start
+// different experiments at once.
+const skRunningExperiment = "RUNNING"
+
+func pkExperiment(id string) string { return "EXP#" + id }
+func skVariant(name string) string  { return "VARIANT#" + name }
+
+type dynamoExperimentStore struct {
+	client *ddb.Client
+	table  string
+}
+
+func experimentItem(e Experiment) (map[string]types.AttributeValue, error) {
+	variants, err := json.Marshal(e.Variants)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]types.AttributeValue{
+		"PK":           &types.AttributeValueMemberS{Value: pkExperiments},
+		"SK":           &types.AttributeValueMemberS{Value: skExperiment(e.ID)},
+		"entityType":   &types.AttributeValueMemberS{Value: entityExperiment},
+		"experimentId": &types.AttributeValueMemberS{Value: e.ID},
+		"description":  &types.AttributeValueMemberS{Value: e.Description},
+		"status":       &types.AttributeValueMemberS{Value: e.Status},
+		"unit":         &types.AttributeValueMemberS{Value: e.Unit},
+		"variants":     &types.AttributeValueMemberS{Value: string(variants)},
+		"createdAt":    &types.AttributeValueMemberS{Value: e.CreatedAt.UTC().Format(time.RFC3339Nano)},
+		"updatedAt":    &types.AttributeValueMemberS{Value: e.UpdatedAt.UTC().Format(time.RFC3339Nano)},
+	}, nil
+}
+
+func experimentFromItem(it map[string]types.AttributeValue) Experiment {
+	e := Experiment{
+		ID:          attrS(it, "experimentId"),
+		Description: attrS(it, "description"),
+		Status:      attrS(it, "status"),
+		Unit:        attrS(it, "unit"),
+		CreatedAt:   parseTime(attrS(it, "createdAt")),
+		UpdatedAt:   parseTime(attrS(it, "updatedAt")),
+	}
+	_ = json.Unmarshal([]byte(attrS(it, "variants")), &e.Variants)
+	return e
+}
+
+func (s *dynamoExperimentStore) CreateExperiment(ctx context.Context, e Experiment) (Experiment, error) {
+	defer forgetActiveExperiment()
+	e.CreatedAt = time.Now().UTC()
+	e.UpdatedAt = e.CreatedAt
+	err := s.putExperiment(ctx, e, "attribute_not_exists(PK)", false)
+	if isConditionFailure(err) {
+		return Experiment{}, ErrExperimentExists
+	}
+	if err != nil {
+		return Experiment{}, err
+	}
+	return e, nil
+}
+
+func (s *dynamoExperimentStore) UpdateExperiment(ctx context.Context, e Experiment) (Experiment, error) {
+	defer forgetActiveExperiment()
+	current, err := s.GetExperiment(ctx, e.ID)
+	if err != nil {
+		return Experiment{}, err
+	}
+	e.CreatedAt = current.CreatedAt
+	e.UpdatedAt = time.Now().UTC()
+	err = s.putExperiment(ctx, e, "attribute_exists(PK)", current.Status == ExperimentRunning)
+	if isConditionFailure(err) {
+		return Experiment{}, ErrExperimentNotFound
+	}
+	if err != nil {
+		return Experiment{}, err
+	}
+	return e, nil
+}
+
+// putExperiment writes e under cond and, in the same transaction, takes the
+// running lock if e is running or releases it if e was running and no
+// longer is. It returns ErrExperimentRunning if another experiment holds
+// the lock, and the condition failure if cond does not hold.
+func (s *dynamoExperimentStore) putExperiment(ctx context.Context, e Experiment, cond string, wasRunning bool) error {
+	item, err := experimentItem(e)
+	if err != nil {
+		return err
+	}
+	items := []types.TransactWriteItem{{Put: &types.Put{
+		TableName:           aws.String(s.table),
+		Item:                item,
+		ConditionExpression: aws.String(cond),
+	}}}
+	if lock := s.runningLock(e.ID, e.Status == ExperimentRunning, wasRunning); lock != nil {
+		items = append(items, *lock)
+	}
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: items})
+	if reasons := cancellationReasons(err); len(reasons) > 1 && reasons[1] == "ConditionalCheckFailed" {
+		return ErrExperimentRunning
+	}
+	return err
+}
+
+// runningLock returns the transaction item that takes the running lock for
+// id, or releases it, or nil when neither applies. Either succeeds only if
+// the lock is free or already id's.
+func (s *dynamoExperimentStore) runningLock(id string, running, wasRunning bool) *types.TransactWriteItem {
+	key := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pkExperiments},
+		"SK": &types.AttributeValueMemberS{Value: skRunningExperiment},
+	}
+	cond := aws.String("attribute_not_exists(PK) OR experimentId = :id")
+	values := map[string]types.AttributeValue{":id": &types.AttributeValueMemberS{Value: id}}
+	switch {
+	case running:
+		key["experimentId"] = &types.AttributeValueMemberS{Value: id}
+		return &types.TransactWriteItem{Put: &types.Put{
+			TableName:                 aws.String(s.table),
+			Item:                      key,
+			ConditionExpression:       cond,
+			ExpressionAttributeValues: values,
+		}}
+	case wasRunning:
+		return &types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(s.table),
+			Key:                       key,
+			ConditionExpression:       cond,
+			ExpressionAttributeValues: values,
+		}}
+	}
+	return nil
+}
+
+func (s *dynamoExperimentStore) GetExperiment(ctx context.Context, id string) (Experiment, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkExperiments},
+			"SK": &types.AttributeValueMemberS{Value: skExperiment(id)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Experiment{}, err
+	}
+	if out.Item == nil {
+		return Experiment{}, ErrExperimentNotFound
+	}
+	return experimentFromItem(out.Item), nil
+}
+
+func (s *dynamoExperimentStore) ListExperiments(ctx context.Context, limit int32, nextToken string) (ListPage[Experiment], error) {
+	scope := newCursorScope("experiments")
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[Experiment]{}, err
+	}
+	out, err := s.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: pkExperiments},
+			":sk": &types.AttributeValueMemberS{Value: "EXP#"},
+		},
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: lek,
+	})
+	if err != nil {
+		return ListPage[Experiment]{}, err
+	}
+	items := make([]Experiment, 0, len(out.Items))
+	for _, it := range out.Items {
+		items = append(items, experimentFromItem(it))
+	}
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
+	return ListPage[Experiment]{Items: items, NextToken: token}, nil
+}
+
+func (s *dynamoExperimentStore) DeleteExperiment(ctx context.Context, id string) error {
+	defer forgetActiveExperiment()
+	current, err := s.GetExperiment(ctx, id)
+	if err != nil {
+		return err
+	}
+	items := []types.TransactWriteItem{{Delete: &types.Delete{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkExperiments},
+			"SK": &types.AttributeValueMemberS{Value: skExperiment(id)},
+		},
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	}}}
+	if lock := s.runningLock(id, false, current.Status == ExperimentRunning); lock != nil {
+		items = append(items, *lock)
+	}
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: items})
+	if isConditionFailure(err) {
+		return ErrExperimentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return deletePartition(ctx, s.client, s.table, pkExperiment(id))
+}
+
+// outcomeCounters maps Outcome fields to their attribute names on the stats item.
+func outcomeCounters(o Outcome) map[string]int64 {
+	return map[string]int64{
+		"exchanges":        o.Exchanges,
+		"thumbsUp":         o.ThumbsUp,
+		"thumbsDown":       o.ThumbsDown,
+		"followUps":        o.FollowUps,
+		"promptTokens":     o.PromptTokens,
+		"completionTokens": o.CompletionTokens,
+		"costMicroUsd":     o.CostMicroUSD,
+	}
+}
+
+func (s *dynamoExperimentStore) RecordOutcome(ctx context.Context, experimentID, variant string, o Outcome) error {
+	var adds []string
+	values := map[string]types.AttributeValue{
+		":entity":  &types.AttributeValueMemberS{Value: entityExperimentStats},
+		":variant": &types.AttributeValueMemberS{Value: variant},
+	}
+	for name, delta := range outcomeCounters(o) {
+		if delta == 0 {
+			continue
+		}
+		adds = append(adds, name+" :"+name)
+		values[":"+name] = &types.AttributeValueMemberN{Value: strconv.FormatInt(delta, 10)}
+	}
+	if len(adds) == 0 {
+		return nil
+	}
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkExperiment(experimentID)},
+			"SK": &types.AttributeValueMemberS{Value: skVariant(variant)},
+		},
+		UpdateExpression:          aws.String("SET entityType = :entity, variant = :variant ADD " + strings.Join(adds, ", ")),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+func (s *dynamoExperimentStore) Outcomes(ctx context.Context, experimentID string) (map[string]Outcome, error) {
+	out, err := s.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: pkExperiment(experimentID)},
+			":sk": &types.AttributeValueMemberS{Value: "VARIANT#"},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	outcomes := make(map[string]Outcome, len(out.Items))
+	for _, it := range out.Items {
+		outcomes[attrS(it, "variant")] = Outcome{
+			Exchanges:        attrInt64(it, "exchanges"),
+			ThumbsUp:         attrInt64(it, "thumbsUp"),
+			ThumbsDown:       attrInt64(it, "thumbsDown"),
+			FollowUps:        attrInt64(it, "followUps"),
+			PromptTokens:     attrInt64(it, "promptTokens"),
+			CompletionTokens: attrInt64(it, "completionTokens"),
+			CostMicroUSD:     attrInt64(it, "costMicroUsd"),
+		}
+	}
+	return outcomes, nil
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const entityFeedback = "Feedback"
+
+// Feedback lives in the conversation's partition next to the message it
+// rates, so DeleteConversationCascade removes it too. GSI1 indexes it by
+// time for the admin listing, one partition per month of creation so the
+// writes do not all land on a single hot key.
+func skFeedback(messageID string) string { return "FEEDBACK#" + messageID }
+
+func gsi1pkFeedback(month time.Time) string { return "FEEDBACK#" + month.UTC().Format("2006-01") }
+
+func gsi1skFeedback(ts time.Time, conversationID, messageID string) string {
+	return "TS#" + ts.UTC().Format(sortableTime) + "#CONV#" + conversationID + "#MSG#" + messageID
+}
+
+// The FEEDBACK/MONTHS item records the earliest month feedback was written
+// in, so a listing walks back no further than the data goes.
+const (
+	pkFeedbackMonths = "FEEDBACK"
+	skFeedbackMonths = "MONTHS"
+)
+
+// knownFeedbackMonth is the earliest month this instance has seen
+// recorded; later months need no write.
+var knownFeedbackMonth struct {
+	sync.Mutex
+	month string
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+type dynamoFeedbackStore struct {
+	client *ddb.Client
+	table  string
+}
+
+func feedbackFromItem(it map[string]types.AttributeValue) Feedback {
+	return Feedback{
+		ConversationID: attrS(it, "conversationId"),
+		MessageID:      attrS(it, "messageId"),
+		UserID:         attrS(it, "userId"),
+		Rating:         attrS(it, "rating"),
+		Reason:         attrS(it, "reason"),
+		Comment:        attrS(it, "comment"),
+		ExperimentID:   attrS(it, "experimentId"),
+		Variant:        attrS(it, "variant"),
+		CreatedAt:      parseTime(attrS(it, "createdAt")),
+		UpdatedAt:      parseTime(attrS(it, "updatedAt")),
+	}
+}
+
+func (s *dynamoFeedbackStore) PutFeedback(ctx context.Context, f Feedback) (Feedback, *Feedback, error) {
+	now := time.Now().UTC()
+	set := map[string]string{
+		"entityType":     entityFeedback,
+		"conversationId": f.ConversationID,
+		"messageId":      f.MessageID,
+		"userId":         f.UserID,
+		"rating":         f.Rating,
+		"reason":         f.Reason,
+		"comment":        f.Comment,
+		"experimentId":   f.ExperimentID,
+		"variant":        f.Variant,
+		"updatedAt":      now.Format(time.RFC3339Nano),
+	}
+	// The index keys keep the creation time, so an edit does not move the
+	// item to another month.
+	names := map[string]string{"#createdAt": "createdAt", "#gsi1pk": "GSI1PK", "#gsi1sk": "GSI1SK", "#owner": "userId"}
+	values := map[string]types.AttributeValue{
+		":createdAt": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+		":gsi1pk":    &types.AttributeValueMemberS{Value: gsi1pkFeedback(now)},
+		":gsi1sk":    &types.AttributeValueMemberS{Value: gsi1skFeedback(now, f.ConversationID, f.MessageID)},
+		":owner":     &types.AttributeValueMemberS{Value: f.UserID},
+	}
+	clauses := []string{
+		"#createdAt = if_not_exists(#createdAt, :createdAt)",
+		"#gsi1pk = if_not_exists(#gsi1pk, :gsi1pk)",
+		"#gsi1sk = if_not_exists(#gsi1sk, :gsi1sk)",
+	}
+	// Attribute names go through placeholders: several ("comment") are reserved words.
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		n, v := fmt.Sprintf("#f%d", i), fmt.Sprintf(":f%d", i)
+		names[n] = k
+		values[v] = &types.AttributeValueMemberS{Value: set[k]}
+		clauses = append(clauses, n+" = "+v)
+	}
+
+	out, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkConv(f.ConversationID)},
+			"SK": &types.AttributeValueMemberS{Value: skFeedback(f.MessageID)},
+		},
+		UpdateExpression:          aws.String("SET " + strings.Join(clauses, ", ")),
+		ConditionExpression:       aws.String("attribute_not_exists(PK) OR #owner = :owner"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllOld,
+	})
+	if isConditionFailure(err) {
+		return Feedback{}, nil, ErrNotMessageAuthor
+	}
+	if err != nil {
+		return Feedback{}, nil, err
+	}
+
+	f.CreatedAt, f.UpdatedAt = now, now
+	var prev *Feedback
+	if len(out.Attributes) > 0 {
+		p := feedbackFromItem(out.Attributes)
+		prev = &p
+		f.CreatedAt = p.CreatedAt
+	} else if err := s.recordFeedbackMonth(ctx, now); err != nil {
+		return Feedback{}, nil, err
+	}
+	return f, prev, nil
+}
+
+// recordFeedbackMonth lowers the recorded earliest month to at's, if it is
+// earlier.
+func (s *dynamoFeedbackStore) recordFeedbackMonth(ctx context.Context, at time.Time) error {
+	month := at.UTC().Format("2006-01")
+	knownFeedbackMonth.Lock()
+	known := knownFeedbackMonth.month
+	knownFeedbackMonth.Unlock()
+	if known != "" && known <= month {
+		return nil
+	}
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkFeedbackMonths},
+			"SK": &types.AttributeValueMemberS{Value: skFeedbackMonths},
+		},
+		UpdateExpression:    aws.String("SET earliest = :m"),
+		ConditionExpression: aws.String("attribute_not_exists(earliest) OR earliest > :m"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":m": &types.AttributeValueMemberS{Value: month},
+		},
+	})
+	if err != nil && !isConditionFailure(err) {
+		return err
+	}
+	knownFeedbackMonth.Lock()
+	knownFeedbackMonth.month = month
+	knownFeedbackMonth.Unlock()
+	return nil
+}
+
+// earliestFeedbackMonth returns the first month with feedback, or false if
+// none was ever written.
+func (s *dynamoFeedbackStore) earliestFeedbackMonth(ctx context.Context) (time.Time, bool, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkFeedbackMonths},
+			"SK": &types.AttributeValueMemberS{Value: skFeedbackMonths},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return time.Time{}, false, err
+	}
+	month, err := time.Parse("2006-01", attrS(out.Item, "earliest"))
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return month, true, nil
+}
+
+func (s *dynamoFeedbackStore) GetFeedback(ctx context.Context, conversationID, messageID string) (Feedback, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkConv(conversationID)},
+			"SK": &types.AttributeValueMemberS{Value: skFeedback(messageID)},
+		},
+	})
+	if err != nil {
+		return Feedback{}, err
+	}
+	if out.Item == nil {
+		return Feedback{}, ErrFeedbackNotFound
+	}
+	return feedbackFromItem(out.Item), nil
+}
+
+// ListFeedback walks the monthly partitions from until (or now) back to
+// since or the earliest month with feedback, whichever is later. A
+// nextToken either resumes inside a month or, holding only GSI1PK, starts
+// the next older month from its top.
+func (s *dynamoFeedbackStore) ListFeedback(ctx context.Context, filter FeedbackFilter, limit int32, nextToken string) (ListPage[Feedback], error) {
+	scope := newCursorScope("feedback", fmt.Sprintf("%+v", filter))
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[Feedback]{}, err
+	}
+
+	from := "TS#"
+	to := "TS#~" // '~' sorts after every timestamp character
+	oldest, found, err := s.earliestFeedbackMonth(ctx)
+	if err != nil {
+		return ListPage[Feedback]{}, err
+	}
+	if !found {
+		return ListPage[Feedback]{Items: []Feedback{}}, nil
+	}
+	month := monthStart(time.Now())
+	if !filter.Since.IsZero() {
+		from = "TS#" + filter.Since.UTC().Format(sortableTime)
+		if since := monthStart(filter.Since); since.After(oldest) {
+			oldest = since
+		}
+	}
+	if !filter.Until.IsZero() {
+		to = "TS#" + filter.Until.UTC().Format(sortableTime) + "~"
+		month = monthStart(filter.Until)
+	}
+	if lek != nil {
+		pk, err := time.Parse("2006-01", strings.TrimPrefix(attrS(lek, "GSI1PK"), "FEEDBACK#"))
+		if err != nil {
+			return ListPage[Feedback]{}, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+		}
+		month = pk
+		if len(lek) == 1 {
+			lek = nil
+		}
+	}
+
+	var filters []string
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{
+		":from": &types.AttributeValueMemberS{Value: from},
+		":to":   &types.AttributeValueMemberS{Value: to},
+	}
+	for attr, want := range map[string]string{
+		"rating":       filter.Rating,
+		"reason":       filter.Reason,
+		"userId":       filter.UserID,
+		"experimentId": filter.ExperimentID,
+	} {
+		if want == "" {
+			continue
+		}
+		names["#"+attr] = attr
+		values[":"+attr] = &types.AttributeValueMemberS{Value: want}
+		filters = append(filters, "#"+attr+" = :"+attr)
+	}
+	sort.Strings(filters)
+
+	items := make([]Feedback, 0, limit)
+	for !month.Before(oldest) {
+		values[":pk"] = &types.AttributeValueMemberS{Value: gsi1pkFeedback(month)}
+		in := &ddb.QueryInput{
+			TableName:                 aws.String(s.table),
+			IndexName:                 aws.String("GSI1"),
+			KeyConditionExpression:    aws.String("GSI1PK = :pk AND GSI1SK BETWEEN :from AND :to"),
+			ExpressionAttributeValues: values,
+			Limit:                     aws.Int32(limit - int32(len(items))),
+			ExclusiveStartKey:         lek,
+			ScanIndexForward:          aws.Bool(false),
+		}
+		if len(filters) > 0 {
+			in.FilterExpression = aws.String(strings.Join(filters, " AND "))
+			in.ExpressionAttributeNames = names
+		}
+		out, err := s.client.Query(ctx, in)
+		if err != nil {
+			return ListPage[Feedback]{}, err
+		}
+		for _, it := range out.Items {
+			items = append(items, feedbackFromItem(it))
+		}
+		if out.LastEvaluatedKey != nil {
+			token, _ := encodeLEK(scope, out.LastEvaluatedKey)
+			return ListPage[Feedback]{Items: items, NextToken: token}, nil
+		}
+		lek = nil
+		month = month.AddDate(0, -1, 0)
+		if int32(len(items)) >= limit && !month.Before(oldest) {
+			token, _ := encodeLEK(scope, map[string]types.AttributeValue{
+				"GSI1PK": &types.AttributeValueMemberS{Value: gsi1pkFeedback(month)},
+			})
+			return ListPage[Feedback]{Items: items, NextToken: token}, nil
+		}
+	}
+	return ListPage[Feedback]{Items: items}, nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// backdateFeedback moves f to the month index partition of at, as if it had
+// been written then.
+func backdateFeedback(t *testing.T, s *dynamoFeedbackStore, f Feedback, at time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkConv(f.ConversationID)},
+			"SK": &types.AttributeValueMemberS{Value: skFeedback(f.MessageID)},
+		},
+		UpdateExpression: aws.String("SET GSI1PK = :pk, GSI1SK = :sk, createdAt = :at"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: gsi1pkFeedback(at)},
+			":sk": &types.AttributeValueMemberS{Value: gsi1skFeedback(at, f.ConversationID, f.MessageID)},
+			":at": &types.AttributeValueMemberS{Value: at.Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.recordFeedbackMonth(ctx, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listAllFeedback(t *testing.T, s *dynamoFeedbackStore, filter FeedbackFilter) []string {
+	t.Helper()
+	var ids []string
+	token := ""
+	for {
+		page, err := s.ListFeedback(context.Background(), filter, 1, token)
+		if err != nil {
+			t.Fatalf("ListFeedback(%+v): %v", filter, err)
+		}
+		for _, f := range page.Items {
+			ids = append(ids, f.MessageID)
+		}
+		if page.NextToken == "" {
+			return ids
+		}
+		token = page.NextToken
+	}
+}
+
+func TestDynamoFeedback(t *testing.T) {
+	client, table := dynamoLocal(t)
+	s := &dynamoFeedbackStore{client: client, table: table}
+	ctx := context.Background()
+	user := "feedback-" + GenerateULID()
+	thisMonth := monthStart(time.Now())
+
+	// Feedback this month, last month and three months ago; the month in
+	// between has none.
+	var all []Feedback
+	for _, back := range []int{0, 1, 3} {
+		f := Feedback{ConversationID: GenerateULID(), MessageID: GenerateULID(), UserID: user, Rating: RatingUp}
+		if _, prev, err := s.PutFeedback(ctx, f); err != nil || prev != nil {
+			t.Fatalf("PutFeedback: %v, %v", prev, err)
+		}
+		if back > 0 {
+			backdateFeedback(t, s, f, thisMonth.AddDate(0, -back, 0).Add(time.Hour))
+		}
+		all = append(all, f)
+	}
+	ids := func(fs []Feedback) []string {
+		var out []string
+		for _, f := range fs {
+			out = append(out, f.MessageID)
+		}
+		return out
+	}
+
+	t.Run("ListsNewestFirstAcrossMonths", func(t *testing.T) {
+		if got := listAllFeedback(t, s, FeedbackFilter{UserID: user}); !slices.Equal(got, ids(all)) {
+			t.Errorf("got %v, want %v", got, ids(all))
+		}
+		since := FeedbackFilter{UserID: user, Since: thisMonth.AddDate(0, -1, 0)}
+		if got := listAllFeedback(t, s, since); !slices.Equal(got, ids(all[:2])) {
+			t.Errorf("since last month: got %v, want %v", got, ids(all[:2]))
+		}
+		until := FeedbackFilter{UserID: user, Until: thisMonth.AddDate(0, -1, 0)}
+		if got := listAllFeedback(t, s, until); !slices.Equal(got, ids(all[2:])) {
+			t.Errorf("until last month: got %v, want %v", got, ids(all[2:]))
+		}
+	})
+
+	t.Run("ChangeRating", func(t *testing.T) {
+		f := all[1]
+		f.Rating, f.Reason = RatingDown, ReasonIncorrect
+		stored, prev, err := s.PutFeedback(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev == nil || prev.Rating != RatingUp {
+			t.Errorf("previous = %+v, want the up rating", prev)
+		}
+		if !stored.CreatedAt.Equal(prev.CreatedAt) {
+			t.Errorf("createdAt moved from %v to %v", prev.CreatedAt, stored.CreatedAt)
+		}
+		// The edit stays in last month's partition.
+		got := listAllFeedback(t, s, FeedbackFilter{UserID: user, Rating: RatingDown})
+		if !slices.Equal(got, []string{f.MessageID}) {
+			t.Errorf("down ratings = %v, want %v", got, f.MessageID)
+		}
+	})
+
+	t.Run("OnlyTheAuthorCanChangeIt", func(t *testing.T) {
+		f := all[0]
+		f.UserID = "someone-else"
+		if _, _, err := s.PutFeedback(ctx, f); !errors.Is(err, ErrNotMessageAuthor) {
+			t.Errorf("PutFeedback as another user: %v, want ErrNotMessageAuthor", err)
+		}
+	})
+}
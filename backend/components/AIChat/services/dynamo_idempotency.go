@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const entityIdempotencyKey = "IdempotencyKey"
+
+// Keys live in the user's partition under IDEMPOTENCY#<key> and expire
+// through the table's expiresAt TTL. TTL deletion can lag, so expiresAt is
+// also checked when claiming.
+func skIdempotency(key string) string { return "IDEMPOTENCY#" + key }
+
+const (
+	idempotencyPending = "pending"
+	idempotencyDone    = "done"
+)
+
+type dynamoIdempotencyStore struct {
+	client *ddb.Client
+	table  string
+}
+
+func idempotencyKey(userID, key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pkUser(userID)},
+		"SK": &types.AttributeValueMemberS{Value: skIdempotency(key)},
+	}
+}
+
+// Begin is one conditional PutItem: it succeeds for a new or expired key,
+// or a stale claim of the same request. Concurrent duplicates race on that
+// condition and all but one see the item that won.
+func (s *dynamoIdempotencyStore) Begin(ctx context.Context, userID, key, fingerprint string) (*StoredResponse, error) {
+	now := time.Now().UTC()
+	item := idempotencyKey(userID, key)
+	item["entityType"] = &types.AttributeValueMemberS{Value: entityIdempotencyKey}
+	item["fingerprint"] = &types.AttributeValueMemberS{Value: fingerprint}
+	item["status"] = &types.AttributeValueMemberS{Value: idempotencyPending}
+	item["startedAt"] = &types.AttributeValueMemberS{Value: now.Format(sortableTime)}
+	item["expiresAt"] = expiresAtAttr(now, IdempotencyTTL)
+	_, err := s.client.PutItem(ctx, &ddb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+		ConditionExpression: aws.String("attribute_not_exists(PK) OR expiresAt < :now" +
+			" OR (#status = :pending AND startedAt < :stale AND fingerprint = :fp)"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":pending": &types.AttributeValueMemberS{Value: idempotencyPending},
+			":stale":   &types.AttributeValueMemberS{Value: now.Add(-idempotencyLockTimeout).Format(sortableTime)},
+			":fp":      &types.AttributeValueMemberS{Value: fingerprint},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if !errors.As(err, &ccf) {
+		return nil, err
+	}
+	switch {
+	case attrS(ccf.Item, "fingerprint") != fingerprint:
+		return nil, ErrIdempotencyMismatch
+	case attrS(ccf.Item, "status") != idempotencyDone:
+		return nil, ErrIdempotencyInProgress
+	}
+	var resp StoredResponse
+	if err := json.Unmarshal([]byte(attrS(ccf.Item, "response")), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (s *dynamoIdempotencyStore) Complete(ctx context.Context, userID, key string, resp StoredResponse) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      idempotencyKey(userID, key),
+		UpdateExpression:         aws.String("SET #status = :done, #response = :resp, expiresAt = :exp"),
+		ConditionExpression:      aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{"#status": "status", "#response": "response"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":done":    &types.AttributeValueMemberS{Value: idempotencyDone},
+			":pending": &types.AttributeValueMemberS{Value: idempotencyPending},
+			":resp":    &types.AttributeValueMemberS{Value: string(b)},
+			":exp":     expiresAtAttr(time.Now(), IdempotencyTTL),
+		},
+	})
+	return err
+}
+
+func (s *dynamoIdempotencyStore) Release(ctx context.Context, userID, key string) error {
+	_, err := s.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      idempotencyKey(userID, key),
+		ConditionExpression:      aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: idempotencyPending},
+		},
+	})
+	if isConditionFailure(err) {
+		return nil
+	}
+	return err
+}
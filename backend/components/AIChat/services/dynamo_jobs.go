@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const entityJob = "Job"
+
+// Each job is its own item; clients poll it by ID.
+func pkJob(id string) string { return "JOB#" + id }
+
+const skJob = "JOB"
+
+type dynamoJobStore struct {
+	client *ddb.Client
+	table  string
+}
+
+func (s *dynamoJobStore) CreateJob(ctx context.Context, j Job) error {
+	item := map[string]types.AttributeValue{
+		"PK":             &types.AttributeValueMemberS{Value: pkJob(j.ID)},
+		"SK":             &types.AttributeValueMemberS{Value: skJob},
+		"entityType":     &types.AttributeValueMemberS{Value: entityJob},
+		"jobId":          &types.AttributeValueMemberS{Value: j.ID},
+		"userId":         &types.AttributeValueMemberS{Value: j.UserID},
+		"conversationId": &types.AttributeValueMemberS{Value: j.ConversationID},
+		"userMessageId":  &types.AttributeValueMemberS{Value: j.UserMessageID},
+		"status":         &types.AttributeValueMemberS{Value: j.Status},
+		"createdAt":      &types.AttributeValueMemberS{Value: j.CreatedAt.Format(time.RFC3339Nano)},
+		"updatedAt":      &types.AttributeValueMemberS{Value: j.UpdatedAt.Format(time.RFC3339Nano)},
+	}
+	if j.Institution != "" {
+		item["institution"] = &types.AttributeValueMemberS{Value: j.Institution}
+	}
+	_, err := s.client.PutItem(ctx, &ddb.PutItemInput{
+		TableName:           aws.String(s.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	return err
+}
+
+func (s *dynamoJobStore) GetJob(ctx context.Context, id string) (Job, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName:      aws.String(s.table),
+		ConsistentRead: aws.Bool(true),
+		Key:            jobKey(id),
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	if out.Item == nil {
+		return Job{}, ErrJobNotFound
+	}
+	it := out.Item
+	return Job{
+		ID:             attrS(it, "jobId"),
+		UserID:         attrS(it, "userId"),
+		ConversationID: attrS(it, "conversationId"),
+		UserMessageID:  attrS(it, "userMessageId"),
+		Institution:    attrS(it, "institution"),
+		Status:         attrS(it, "status"),
+		MessageID:      attrS(it, "messageId"),
+		Error:          attrS(it, "error"),
+		CreatedAt:      parseTime(attrS(it, "createdAt")),
+		UpdatedAt:      parseTime(attrS(it, "updatedAt")),
+	}, nil
+}
+
+// ClaimJob is a conditional status transition: only one delivery of a job
+// can move it to running.
+func (s *dynamoJobStore) ClaimJob(ctx context.Context, id string, staleBefore time.Time) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:           aws.String(s.table),
+		Key:                 jobKey(id),
+		UpdateExpression:    aws.String("SET #s = :running, claimedAt = :now, updatedAt = :now"),
+		ConditionExpression: aws.String("#s = :pending OR (#s = :running AND claimedAt < :stale)"),
+		// "status" is a reserved word.
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":running": &types.AttributeValueMemberS{Value: JobRunning},
+			":pending": &types.AttributeValueMemberS{Value: JobPending},
+			":now":     &types.AttributeValueMemberS{Value: now},
+			":stale":   &types.AttributeValueMemberS{Value: staleBefore.UTC().Format(time.RFC3339Nano)},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		switch attrS(ccf.Item, "status") {
+		case "":
+			return ErrJobNotFound
+		case JobRunning:
+			return ErrJobClaimed
+		}
+		return ErrJobFinished
+	}
+	return err
+}
+
+func (s *dynamoJobStore) ReleaseJob(ctx context.Context, id string) error {
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      jobKey(id),
+		UpdateExpression:         aws.String("SET #s = :pending, updatedAt = :now REMOVE claimedAt"),
+		ConditionExpression:      aws.String("#s = :running"),
+		ExpressionAttributeNames: map[string]string{"#s": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: JobPending},
+			":running": &types.AttributeValueMemberS{Value: JobRunning},
+			":now":     &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	if isConditionFailure(err) {
+		return ErrJobFinished
+	}
+	return err
+}
+
+func (s *dynamoJobStore) FinishJob(ctx context.Context, id, status, messageID, errMsg string) error {
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:           aws.String(s.table),
+		Key:                 jobKey(id),
+		UpdateExpression:    aws.String("SET #s = :s, messageId = :m, #e = :e, updatedAt = :u REMOVE claimedAt"),
+		ConditionExpression: aws.String("#s IN (:pending, :running)"),
+		// "status" is a reserved word.
+		ExpressionAttributeNames: map[string]string{"#s": "status", "#e": "error"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s":       &types.AttributeValueMemberS{Value: status},
+			":m":       &types.AttributeValueMemberS{Value: messageID},
+			":e":       &types.AttributeValueMemberS{Value: errMsg},
+			":u":       &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+			":pending": &types.AttributeValueMemberS{Value: JobPending},
+			":running": &types.AttributeValueMemberS{Value: JobRunning},
+		},
+	})
+	if isConditionFailure(err) {
+		return ErrJobFinished
+	}
+	return err
+}
+
+func jobKey(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pkJob(id)},
+		"SK": &types.AttributeValueMemberS{Value: skJob},
+	}
+}
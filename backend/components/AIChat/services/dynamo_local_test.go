@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// dynamoLocal returns a client for DynamoDB Local and the TABLE_NAME table
+// (default "conformance", created if needed), or skips the test unless
+// AWS_ENDPOINT_URL_DYNAMODB is set, as the conformance runner does.
+func dynamoLocal(t *testing.T) (*ddb.Client, string) {
+	t.Helper()
+	if os.Getenv("AWS_ENDPOINT_URL_DYNAMODB") == "" {
+		t.Skip("AWS_ENDPOINT_URL_DYNAMODB not set")
+	}
+	table := os.Getenv("TABLE_NAME")
+	if table == "" {
+		table = "conformance"
+	}
+	ctx := context.Background()
+	if err := EnsureTable(ctx, table); err != nil {
+		t.Fatalf("creating table: %v", err)
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ddb.NewFromConfig(cfg), table
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Messages are keyed by time (SK MSG#<ts>#<id>), so finding one by ID goes
+// through GSI3 (PK, messageId; keys only). The index is eventually
+// consistent and lacks messages stored before messageId was, so a miss
+// falls back to reading the conversation's keys.
+
+func editsAttr(edits []MessageEdit) types.AttributeValue {
+	l := make([]types.AttributeValue, len(edits))
+	for i, e := range edits {
+		l[i] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"content":  &types.AttributeValueMemberS{Value: e.Content},
+			"editedAt": &types.AttributeValueMemberS{Value: e.EditedAt.UTC().Format(time.RFC3339Nano)},
+		}}
+	}
+	return &types.AttributeValueMemberL{Value: l}
+}
+
+func editsFromAttr(v types.AttributeValue) []MessageEdit {
+	l, _ := v.(*types.AttributeValueMemberL)
+	if l == nil {
+		return nil
+	}
+	var edits []MessageEdit
+	for _, e := range l.Value {
+		if m, ok := e.(*types.AttributeValueMemberM); ok {
+			edits = append(edits, MessageEdit{Content: attrS(m.Value, "content"), EditedAt: parseTime(attrS(m.Value, "editedAt"))})
+		}
+	}
+	return edits
+}
+
+// messageSK finds the sort key of a message from its ID.
+func (d *dynamoDAL) messageSK(ctx context.Context, conversationID, messageID string) (string, error) {
+	out, err := d.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(d.table),
+		IndexName:              aws.String("GSI3"),
+		KeyConditionExpression: aws.String("PK = :pk AND messageId = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: pkConv(conversationID)},
+			":id": &types.AttributeValueMemberS{Value: messageID},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(out.Items) > 0 {
+		return attrS(out.Items[0], "SK"), nil
+	}
+
+	var lek map[string]types.AttributeValue
+	for {
+		out, err := d.client.Query(ctx, &ddb.QueryInput{
+			TableName:              aws.String(d.table),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :msg)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":  &types.AttributeValueMemberS{Value: pkConv(conversationID)},
+				":msg": &types.AttributeValueMemberS{Value: "MSG#"},
+			},
+			ProjectionExpression: aws.String("SK"),
+			ExclusiveStartKey:    lek,
+		})
+		if err != nil {
+			return "", err
+		}
+		for _, it := range out.Items {
+			if sk := attrS(it, "SK"); parseMessageID(sk) == messageID {
+				return sk, nil
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return "", ErrMessageNotFound
+		}
+		lek = out.LastEvaluatedKey
+	}
+}
+
+func messageKey(conversationID, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pkConv(conversationID)},
+		"SK": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+func (d *dynamoDAL) GetMessage(ctx context.Context, conversationID, messageID string) (ChatMessage, error) {
+	sk, err := d.messageSK(ctx, conversationID, messageID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	out, err := d.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            messageKey(conversationID, sk),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	if out.Item == nil {
+		return ChatMessage{}, ErrMessageNotFound
+	}
+	return messageFromItem(out.Item), nil
+}
+
+// activeConversationCheck is a transaction item requiring userID's
+// conversation to exist outside the trash.
+func (d *dynamoDAL) activeConversationCheck(userID, conversationID string) types.TransactWriteItem {
+	return types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+		TableName:           aws.String(d.table),
+		Key:                 conversationKey(userID, conversationID),
+		ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(deletedAt)"),
+	}}
+}
+
+// UpdateMessage rewrites the message on condition that its content is
+// still what was read, retrying a concurrent edit.
+func (d *dynamoDAL) UpdateMessage(ctx context.Context, userID, conversationID, messageID, content string, at time.Time) (ChatMessage, error) {
+	for attempt := 1; ; attempt++ {
+		m, err := d.GetMessage(ctx, conversationID, messageID)
+		if err != nil {
+			return ChatMessage{}, err
+		}
+		old := m.Content
+		m = editMessage(m, content, at)
+		_, err = d.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+			d.activeConversationCheck(userID, conversationID),
+			{Update: &types.Update{
+				TableName:           aws.String(d.table),
+				Key:                 messageKey(conversationID, skMsg(m.CreatedAt, m.ID)),
+				UpdateExpression:    aws.String("SET content = :content, editedAt = :at, edits = :edits"),
+				ConditionExpression: aws.String("attribute_exists(SK) AND content = :old"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":content": &types.AttributeValueMemberS{Value: m.Content},
+					":at":      &types.AttributeValueMemberS{Value: m.EditedAt.Format(time.RFC3339Nano)},
+					":edits":   editsAttr(m.Edits),
+					":old":     &types.AttributeValueMemberS{Value: old},
+				},
+			}},
+		}})
+		reasons := cancellationReasons(err)
+		switch {
+		case err == nil:
+			return m, d.refreshPreview(ctx, userID, m)
+		case reasons == nil:
+			return ChatMessage{}, err
+		case reasons[0] == "ConditionalCheckFailed":
+			return ChatMessage{}, ErrConversationNotFound
+		case attempt == batchWriteAttempts:
+			return ChatMessage{}, err
+		}
+	}
+}
+
+// refreshPreview sets the conversation preview to m's content if m is the
+// newest message.
+func (d *dynamoDAL) refreshPreview(ctx context.Context, userID string, m ChatMessage) error {
+	_, err := d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 conversationKey(userID, m.ConversationID),
+		UpdateExpression:    aws.String("SET lastMessagePreview = :preview"),
+		ConditionExpression: aws.String("lastMessageAt = :at"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":preview": &types.AttributeValueMemberS{Value: messagePreview(m.Content)},
+			":at":      &types.AttributeValueMemberS{Value: m.CreatedAt.UTC().Format(sortableTime)},
+		},
+	})
+	if isConditionFailure(err) {
+		return nil
+	}
+	return err
+}
+
+// DeleteMessage deletes the message and subtracts it from the header's
+// totals in one transaction. If it was the newest message, lastMessageAt,
+// the preview and the activity key then move to the one before it.
+func (d *dynamoDAL) DeleteMessage(ctx context.Context, userID, conversationID, messageID string) error {
+	m, err := d.GetMessage(ctx, conversationID, messageID)
+	if err != nil {
+		return err
+	}
+	_, err = d.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName:           aws.String(d.table),
+			Key:                 conversationKey(userID, conversationID),
+			UpdateExpression:    aws.String("ADD messageCount :n, promptTokens :p, completionTokens :c"),
+			ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(deletedAt)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":n": &types.AttributeValueMemberN{Value: "-1"},
+				":p": &types.AttributeValueMemberN{Value: strconv.Itoa(-m.PromptTokens)},
+				":c": &types.AttributeValueMemberN{Value: strconv.Itoa(-m.CompletionTokens)},
+			},
+		}},
+		{Delete: &types.Delete{
+			TableName:           aws.String(d.table),
+			Key:                 messageKey(conversationID, skMsg(m.CreatedAt, m.ID)),
+			ConditionExpression: aws.String("attribute_exists(SK)"),
+		}},
+	}})
+	if reasons := cancellationReasons(err); reasons != nil {
+		if reasons[0] == "ConditionalCheckFailed" {
+			return ErrConversationNotFound
+		}
+		if reasons[1] == "ConditionalCheckFailed" {
+			return ErrMessageNotFound
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return d.rewindLastMessage(ctx, userID, conversationID, m.CreatedAt)
+}
+
+// rewindLastMessage points the header's lastMessageAt at the newest
+// remaining message, if it still names the deleted one at deletedAt.
+func (d *dynamoDAL) rewindLastMessage(ctx context.Context, userID, conversationID string, deletedAt time.Time) error {
+	c, err := d.GetConversation(ctx, userID, conversationID)
+	if err != nil {
+		return err
+	}
+	if c.LastMessageAt == nil || !c.LastMessageAt.Equal(deletedAt) {
+		return nil
+	}
+	page, err := d.ListMessages(ctx, conversationID, 1, "", true)
+	if err != nil {
+		return err
+	}
+	update := "REMOVE lastMessageAt, lastMessagePreview SET GSI2SK = :act"
+	values := map[string]types.AttributeValue{
+		":old": &types.AttributeValueMemberS{Value: deletedAt.UTC().Format(sortableTime)},
+		":act": &types.AttributeValueMemberS{Value: gsi2skActivity(c.CreatedAt, c.ID)},
+	}
+	if len(page.Items) > 0 {
+		last := page.Items[0]
+		update = "SET lastMessageAt = :at, lastMessagePreview = :preview, GSI2SK = :act"
+		values[":at"] = &types.AttributeValueMemberS{Value: last.CreatedAt.UTC().Format(sortableTime)}
+		values[":preview"] = &types.AttributeValueMemberS{Value: messagePreview(last.Content)}
+		values[":act"] = &types.AttributeValueMemberS{Value: gsi2skActivity(last.CreatedAt, c.ID)}
+	}
+	_, err = d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       conversationKey(userID, conversationID),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("lastMessageAt = :old"),
+		ExpressionAttributeValues: values,
+	})
+	if isConditionFailure(err) {
+		return nil // a newer message arrived meanwhile
+	}
+	return err
+}
+
+// BackfillMessageIDs stores messageId on DynamoDB messages written before
+// it existed, so GSI3 finds them. It is idempotent and returns how many
+// messages it updated.
+func BackfillMessageIDs(ctx context.Context) (int, error) {
+	d, ok := Store.(*dynamoDAL)
+	if !ok {
+		return 0, errors.New("message ID backfill only applies to the DynamoDB backend")
+	}
+	updated := 0
+	var lek map[string]types.AttributeValue
+	for {
+		page, err := d.client.Scan(ctx, &ddb.ScanInput{
+			TableName:            aws.String(d.table),
+			FilterExpression:     aws.String("entityType = :msg AND attribute_not_exists(messageId)"),
+			ProjectionExpression: aws.String("PK, SK"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":msg": &types.AttributeValueMemberS{Value: entityMessage},
+			},
+			ExclusiveStartKey: lek,
+		})
+		if err != nil {
+			return updated, err
+		}
+		for _, it := range page.Items {
+			_, err := d.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+				TableName:           aws.String(d.table),
+				Key:                 map[string]types.AttributeValue{"PK": it["PK"], "SK": it["SK"]},
+				UpdateExpression:    aws.String("SET messageId = :id"),
+				ConditionExpression: aws.String("attribute_exists(SK)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":id": &types.AttributeValueMemberS{Value: parseMessageID(attrS(it, "SK"))},
+				},
+			})
+			if err != nil && !isConditionFailure(err) {
+				return updated, err
+			}
+			updated++
+		}
+		if page.LastEvaluatedKey == nil {
+			return updated, nil
+		}
+		lek = page.LastEvaluatedKey
+	}
+}
+
+// BackfillMessageKeys rewrites the SK and GSI1SK of DynamoDB messages keyed
+// with RFC3339Nano timestamps, which do not sort in time order, to the
+// fixed-width sortableTime form. SK is part of the primary key, so each
+// message is put under its new key and the old item deleted in one
+// transaction. It is idempotent and returns how many messages it moved.
+func BackfillMessageKeys(ctx context.Context) (int, error) {
+	d, ok := Store.(*dynamoDAL)
+	if !ok {
+		return 0, errors.New("message key backfill only applies to the DynamoDB backend")
+	}
+	moved := 0
+	var lek map[string]types.AttributeValue
+	for {
+		page, err := d.client.Scan(ctx, &ddb.ScanInput{
+			TableName:        aws.String(d.table),
+			FilterExpression: aws.String("entityType = :msg"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":msg": &types.AttributeValueMemberS{Value: entityMessage},
+			},
+			ExclusiveStartKey: lek,
+		})
+		if err != nil {
+			return moved, err
+		}
+		for _, it := range page.Items {
+			oldSK := attrS(it, "SK")
+			id := attrS(it, "messageId")
+			if id == "" {
+				id = parseMessageID(oldSK)
+			}
+			at := parseTime(attrS(it, "createdAt"))
+			sk := skMsg(at, id)
+			if sk == oldSK {
+				continue
+			}
+			item := make(map[string]types.AttributeValue, len(it))
+			for k, v := range it {
+				item[k] = v
+			}
+			item["SK"] = &types.AttributeValueMemberS{Value: sk}
+			item["messageId"] = &types.AttributeValueMemberS{Value: id}
+			if _, ok := it["GSI1SK"]; ok {
+				cid := strings.TrimPrefix(attrS(it, "PK"), "CONV#")
+				item["GSI1SK"] = &types.AttributeValueMemberS{Value: gsi1sk(at, cid, id)}
+			}
+			_, err := d.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+				{Put: &types.Put{
+					TableName:           aws.String(d.table),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(SK)"),
+				}},
+				{Delete: &types.Delete{
+					TableName:           aws.String(d.table),
+					Key:                 map[string]types.AttributeValue{"PK": it["PK"], "SK": it["SK"]},
+					ConditionExpression: aws.String("attribute_exists(SK)"),
+				}},
+			}})
+			if isConditionFailure(err) {
+				continue // moved or deleted since the scan read it
+			}
+			if err != nil {
+				return moved, err
+			}
+			moved++
+		}
+		if page.LastEvaluatedKey == nil {
+			return moved, nil
+		}
+		lek = page.LastEvaluatedKey
+	}
+}
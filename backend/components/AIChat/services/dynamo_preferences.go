@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const entityPreferences = "Preferences"
+
+// Preferences live in the user's partition. ListConversations only reads
+// SK begins_with CONV#, so the extra item does not show up there.
+const skPreferences = "PREFERENCES"
+
+type dynamoPreferenceStore struct {
+	client *ddb.Client
+	table  string
+}
+
+func (s *dynamoPreferenceStore) GetPreferences(ctx context.Context, userID string) (Preferences, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkUser(userID)},
+			"SK": &types.AttributeValueMemberS{Value: skPreferences},
+		},
+	})
+	if err != nil {
+		return Preferences{}, err
+	}
+	p := Preferences{UserID: userID}
+	if out.Item == nil {
+		return p, nil
+	}
+	p.PreferredLanguage = attrS(out.Item, "preferredLanguage")
+	p.NativeLanguage = attrS(out.Item, "nativeLanguage")
+	if v, ok := out.Item["translationMode"].(*types.AttributeValueMemberBOOL); ok {
+		p.TranslationMode = v.Value
+	}
+	p.UpdatedAt = parseTime(attrS(out.Item, "updatedAt"))
+	return p, nil
+}
+
+func (s *dynamoPreferenceStore) PutPreferences(ctx context.Context, p Preferences) (Preferences, error) {
+	p.UpdatedAt = time.Now().UTC()
+	_, err := s.client.PutItem(ctx, &ddb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]types.AttributeValue{
+			"PK":                &types.AttributeValueMemberS{Value: pkUser(p.UserID)},
+			"SK":                &types.AttributeValueMemberS{Value: skPreferences},
+			"entityType":        &types.AttributeValueMemberS{Value: entityPreferences},
+			"userId":            &types.AttributeValueMemberS{Value: p.UserID},
+			"preferredLanguage": &types.AttributeValueMemberS{Value: p.PreferredLanguage},
+			"nativeLanguage":    &types.AttributeValueMemberS{Value: p.NativeLanguage},
+			"translationMode":   &types.AttributeValueMemberBOOL{Value: p.TranslationMode},
+			"updatedAt":         &types.AttributeValueMemberS{Value: p.UpdatedAt.Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		return Preferences{}, err
+	}
+	return p, nil
+}
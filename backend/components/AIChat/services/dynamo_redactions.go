@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const entityRedaction = "Redaction"
+
+// Redaction events sit in the conversation's partition so they are deleted
+// with it; events without a conversation (tools, evals) share one partition.
+// GSI1 indexes them all by time for the admin listing.
+const (
+	pkRedactionsNoConv = "REDACTIONS"
+	gsi1pkRedactions   = "REDACTIONS"
+)
+
+func skRedaction(ts time.Time, id string) string {
+	return "REDACTION#" + ts.UTC().Format(time.RFC3339Nano) + "#" + id
+}
+
+type dynamoRedactionLog struct {
+	client *ddb.Client
+	table  string
+}
+
+func (s *dynamoRedactionLog) RecordRedaction(ctx context.Context, e RedactionEvent) error {
+	pk := pkRedactionsNoConv
+	if e.ConversationID != "" {
+		pk = pkConv(e.ConversationID)
+	}
+	counts := make(map[string]types.AttributeValue, len(e.Counts))
+	for name, n := range e.Counts {
+		counts[name] = &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+	}
+	item := map[string]types.AttributeValue{
+		"PK":         &types.AttributeValueMemberS{Value: pk},
+		"SK":         &types.AttributeValueMemberS{Value: skRedaction(e.CreatedAt, e.ID)},
+		"GSI1PK":     &types.AttributeValueMemberS{Value: gsi1pkRedactions},
+		"GSI1SK":     &types.AttributeValueMemberS{Value: "TS#" + e.CreatedAt.UTC().Format(time.RFC3339Nano) + "#" + e.ID},
+		"entityType": &types.AttributeValueMemberS{Value: entityRedaction},
+		"id":         &types.AttributeValueMemberS{Value: e.ID},
+		"counts":     &types.AttributeValueMemberM{Value: counts},
+		"createdAt":  &types.AttributeValueMemberS{Value: e.CreatedAt.UTC().Format(time.RFC3339Nano)},
+	}
+	for attr, v := range map[string]string{"userId": e.UserID, "conversationId": e.ConversationID, "institution": e.Institution} {
+		if v != "" {
+			item[attr] = &types.AttributeValueMemberS{Value: v}
+		}
+	}
+	_, err := s.client.PutItem(ctx, &ddb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *dynamoRedactionLog) ListRedactions(ctx context.Context, since, until time.Time, limit int32, nextToken string) (ListPage[RedactionEvent], error) {
+	scope := newCursorScope("redactions", since.UTC().Format(time.RFC3339Nano), until.UTC().Format(time.RFC3339Nano))
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[RedactionEvent]{}, err
+	}
+	from, to := "TS#", "TS#~"
+	if !since.IsZero() {
+		from = "TS#" + since.UTC().Format(time.RFC3339Nano)
+	}
+	if !until.IsZero() {
+		to = "TS#" + until.UTC().Format(time.RFC3339Nano) + "~"
+	}
+	out, err := s.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(s.table),
+		IndexName:              aws.String("GSI1"),
+		KeyConditionExpression: aws.String("GSI1PK = :pk AND GSI1SK BETWEEN :from AND :to"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":   &types.AttributeValueMemberS{Value: gsi1pkRedactions},
+			":from": &types.AttributeValueMemberS{Value: from},
+			":to":   &types.AttributeValueMemberS{Value: to},
+		},
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: lek,
+		ScanIndexForward:  aws.Bool(false),
+	})
+	if err != nil {
+		return ListPage[RedactionEvent]{}, err
+	}
+	items := make([]RedactionEvent, 0, len(out.Items))
+	for _, it := range out.Items {
+		e := RedactionEvent{
+			ID:             attrS(it, "id"),
+			UserID:         attrS(it, "userId"),
+			ConversationID: attrS(it, "conversationId"),
+			Institution:    attrS(it, "institution"),
+			Counts:         map[string]int{},
+			CreatedAt:      parseTime(attrS(it, "createdAt")),
+		}
+		if m, ok := it["counts"].(*types.AttributeValueMemberM); ok {
+			for name := range m.Value {
+				e.Counts[name] = attrInt(m.Value, name)
+			}
+		}
+		items = append(items, e)
+	}
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
+	return ListPage[RedactionEvent]{Items: items, NextToken: token}, nil
+}
@@ -0,0 +1,477 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Retention policies share one partition with the pending TTL backfills.
+// Each member also gets an item in their own partition, so a write finds
+// the user's policies with one query; ListConversations only reads
+// SK begins_with CONV#, so they do not show up there.
+const (
+	entityRetentionPolicy   = "RetentionPolicy"
+	entityRetentionMember   = "RetentionMember"
+	entityRetentionBackfill = "RetentionBackfill"
+	pkRetention             = "RETENTION"
+)
+
+func skRetentionPolicy(scope, id string) string { return "POLICY#" + scope + "#" + id }
+func skRetentionMember(scope, id string) string { return "RETENTION#" + scope + "#" + id }
+func skRetentionBackfill(userID string) string  { return "BACKFILL#" + userID }
+
+type dynamoRetentionStore struct {
+	client *ddb.Client
+	table  string
+}
+
+func policyFromItem(it map[string]types.AttributeValue) RetentionPolicy {
+	p := RetentionPolicy{
+		Scope:     attrS(it, "scope"),
+		ID:        attrS(it, "policyId"),
+		Days:      attrInt(it, "days"),
+		UpdatedAt: parseTime(attrS(it, "updatedAt")),
+	}
+	if l, ok := it["members"].(*types.AttributeValueMemberL); ok {
+		for _, v := range l.Value {
+			if s, ok := v.(*types.AttributeValueMemberS); ok {
+				p.Members = append(p.Members, s.Value)
+			}
+		}
+	}
+	return p
+}
+
+func (s *dynamoRetentionStore) GetPolicy(ctx context.Context, scope, id string) (RetentionPolicy, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkRetention},
+			"SK": &types.AttributeValueMemberS{Value: skRetentionPolicy(scope, id)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	if out.Item == nil {
+		return RetentionPolicy{}, ErrPolicyNotFound
+	}
+	return policyFromItem(out.Item), nil
+}
+
+func (s *dynamoRetentionStore) ListPolicies(ctx context.Context, limit int32, nextToken string) (ListPage[RetentionPolicy], error) {
+	scope := newCursorScope("retention-policies")
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[RetentionPolicy]{}, err
+	}
+	out, err := s.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: pkRetention},
+			":sk": &types.AttributeValueMemberS{Value: "POLICY#"},
+		},
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: lek,
+	})
+	if err != nil {
+		return ListPage[RetentionPolicy]{}, err
+	}
+	items := make([]RetentionPolicy, 0, len(out.Items))
+	for _, it := range out.Items {
+		items = append(items, policyFromItem(it))
+	}
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
+	return ListPage[RetentionPolicy]{Items: items, NextToken: token}, nil
+}
+
+// PutPolicy writes the policy, a member item for each member, deletes the
+// member items of users it no longer lists and queues a backfill for both.
+// The writes are not atomic; repeating a failed PutPolicy completes them.
+func (s *dynamoRetentionStore) PutPolicy(ctx context.Context, p RetentionPolicy) (RetentionPolicy, error) {
+	old, err := s.GetPolicy(ctx, p.Scope, p.ID)
+	if err != nil && !errors.Is(err, ErrPolicyNotFound) {
+		return RetentionPolicy{}, err
+	}
+	p.UpdatedAt = time.Now().UTC()
+
+	members := make([]types.AttributeValue, 0, len(p.Members))
+	for _, m := range p.Members {
+		members = append(members, &types.AttributeValueMemberS{Value: m})
+	}
+	writes := []types.WriteRequest{{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+		"PK":         &types.AttributeValueMemberS{Value: pkRetention},
+		"SK":         &types.AttributeValueMemberS{Value: skRetentionPolicy(p.Scope, p.ID)},
+		"entityType": &types.AttributeValueMemberS{Value: entityRetentionPolicy},
+		"scope":      &types.AttributeValueMemberS{Value: p.Scope},
+		"policyId":   &types.AttributeValueMemberS{Value: p.ID},
+		"days":       &types.AttributeValueMemberN{Value: strconv.Itoa(p.Days)},
+		"members":    &types.AttributeValueMemberL{Value: members},
+		"updatedAt":  &types.AttributeValueMemberS{Value: p.UpdatedAt.Format(time.RFC3339Nano)},
+	}}}}
+	for _, m := range p.Members {
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+			"PK":         &types.AttributeValueMemberS{Value: pkUser(m)},
+			"SK":         &types.AttributeValueMemberS{Value: skRetentionMember(p.Scope, p.ID)},
+			"entityType": &types.AttributeValueMemberS{Value: entityRetentionMember},
+			"scope":      &types.AttributeValueMemberS{Value: p.Scope},
+			"policyId":   &types.AttributeValueMemberS{Value: p.ID},
+			"days":       &types.AttributeValueMemberN{Value: strconv.Itoa(p.Days)},
+		}}})
+	}
+	for _, m := range old.Members {
+		if !slices.Contains(p.Members, m) {
+			writes = append(writes, s.memberDelete(m, p.Scope, p.ID))
+		}
+	}
+	affected := slices.Concat(old.Members, p.Members)
+	if err := s.changed(ctx, writes, affected, p.UpdatedAt); err != nil {
+		return RetentionPolicy{}, err
+	}
+	return p, nil
+}
+
+func (s *dynamoRetentionStore) DeletePolicy(ctx context.Context, scope, id string) error {
+	old, err := s.GetPolicy(ctx, scope, id)
+	if err != nil {
+		return err
+	}
+	var writes []types.WriteRequest
+	for _, m := range old.Members {
+		writes = append(writes, s.memberDelete(m, scope, id))
+	}
+	// The policy item goes last, so a failed delete can be retried.
+	if err := s.changed(ctx, writes, old.Members, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err = s.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkRetention},
+			"SK": &types.AttributeValueMemberS{Value: skRetentionPolicy(scope, id)},
+		},
+	})
+	return err
+}
+
+func (s *dynamoRetentionStore) memberDelete(userID, scope, id string) types.WriteRequest {
+	return types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pkUser(userID)},
+		"SK": &types.AttributeValueMemberS{Value: skRetentionMember(scope, id)},
+	}}}
+}
+
+// changed applies writes and queues a backfill for the affected users.
+func (s *dynamoRetentionStore) changed(ctx context.Context, writes []types.WriteRequest, affected []string, at time.Time) error {
+	slices.Sort(affected)
+	affected = slices.Compact(affected)
+	for _, u := range affected {
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+			"PK":          &types.AttributeValueMemberS{Value: pkRetention},
+			"SK":          &types.AttributeValueMemberS{Value: skRetentionBackfill(u)},
+			"entityType":  &types.AttributeValueMemberS{Value: entityRetentionBackfill},
+			"userId":      &types.AttributeValueMemberS{Value: u},
+			"requestedAt": &types.AttributeValueMemberS{Value: at.Format(time.RFC3339Nano)},
+		}}})
+	}
+	_, err := batchWrite(ctx, s.client, s.table, writes)
+	forgetRetention(affected)
+	return err
+}
+
+func (s *dynamoRetentionStore) UserPolicies(ctx context.Context, userID string) ([]RetentionPolicy, error) {
+	out, err := s.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: pkUser(userID)},
+			":sk": &types.AttributeValueMemberS{Value: "RETENTION#"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]RetentionPolicy, 0, len(out.Items))
+	for _, it := range out.Items {
+		policies = append(policies, policyFromItem(it))
+	}
+	return policies, nil
+}
+
+func (s *dynamoRetentionStore) PendingBackfills(ctx context.Context) ([]RetentionBackfill, error) {
+	var pending []RetentionBackfill
+	var lek map[string]types.AttributeValue
+	for {
+		page, err := s.client.Query(ctx, &ddb.QueryInput{
+			TableName:              aws.String(s.table),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :sk)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: pkRetention},
+				":sk": &types.AttributeValueMemberS{Value: "BACKFILL#"},
+			},
+			ConsistentRead:    aws.Bool(true),
+			ExclusiveStartKey: lek,
+		})
+		if err != nil {
+			return pending, err
+		}
+		for _, it := range page.Items {
+			pending = append(pending, RetentionBackfill{
+				UserID:      attrS(it, "userId"),
+				RequestedAt: parseTime(attrS(it, "requestedAt")),
+			})
+		}
+		if page.LastEvaluatedKey == nil {
+			return pending, nil
+		}
+		lek = page.LastEvaluatedKey
+	}
+}
+
+func (s *dynamoRetentionStore) BackfillDone(ctx context.Context, b RetentionBackfill) error {
+	_, err := s.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkRetention},
+			"SK": &types.AttributeValueMemberS{Value: skRetentionBackfill(b.UserID)},
+		},
+		ConditionExpression: aws.String("requestedAt = :at"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: b.RequestedAt.UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	if isConditionFailure(err) {
+		// Requested again meanwhile; the next run backfills once more.
+		return nil
+	}
+	return err
+}
+
+// ---------- TTL on the DAL's items ----------
+
+// expiresAtAttr is the table's TTL attribute: epoch seconds.
+func expiresAtAttr(at time.Time, keep time.Duration) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(at.Add(keep).Unix(), 10)}
+}
+
+// withExpiry stamps expiresAt on item when userID is under a policy.
+func withExpiry(item map[string]types.AttributeValue, at time.Time, keep time.Duration) map[string]types.AttributeValue {
+	if keep > 0 {
+		item["expiresAt"] = expiresAtAttr(at, keep)
+	}
+	return item
+}
+
+// retention is userID's retention for a write. A failed lookup only costs
+// the TTL attribute: PurgeExpired still applies the policy.
+func (d *dynamoDAL) retention(ctx context.Context, userID string) time.Duration {
+	keep, err := userRetention(ctx, userID)
+	if err != nil {
+		log.Printf("⚠️ Retention lookup for user %s failed, writing without TTL: %v", userID, err)
+	}
+	return keep
+}
+
+// setExpiry re-stamps (or, for keep 0, removes) expiresAt on an existing
+// item.
+func (d *dynamoDAL) setExpiry(ctx context.Context, key map[string]types.AttributeValue, at time.Time, keep time.Duration) error {
+	in := &ddb.UpdateItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 key,
+		UpdateExpression:    aws.String("REMOVE expiresAt"),
+		ConditionExpression: aws.String("attribute_exists(PK)"),
+	}
+	if keep > 0 {
+		in.UpdateExpression = aws.String("SET expiresAt = :exp")
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{":exp": expiresAtAttr(at, keep)}
+	}
+	_, err := d.client.UpdateItem(ctx, in)
+	if isConditionFailure(err) {
+		return nil // deleted meanwhile
+	}
+	return err
+}
+
+// allConversations calls fn for each of userID's conversations, trashed
+// ones included.
+func (d *dynamoDAL) allConversations(ctx context.Context, userID string, fn func(Conversation) error) error {
+	var lek map[string]types.AttributeValue
+	for {
+		page, err := d.client.Query(ctx, &ddb.QueryInput{
+			TableName:              aws.String(d.table),
+			KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :conv)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":   &types.AttributeValueMemberS{Value: pkUser(userID)},
+				":conv": &types.AttributeValueMemberS{Value: "CONV#"},
+			},
+			ExclusiveStartKey: lek,
+		})
+		if err != nil {
+			return err
+		}
+		for _, it := range page.Items {
+			if err := fn(conversationFromItem(it)); err != nil {
+				return err
+			}
+		}
+		if page.LastEvaluatedKey == nil {
+			return nil
+		}
+		lek = page.LastEvaluatedKey
+	}
+}
+
+// stampRetention sets expiresAt on all of userID's conversations and
+// messages for retention keep, or removes it for 0.
+func (d *dynamoDAL) stampRetention(ctx context.Context, userID string, keep time.Duration) error {
+	return d.allConversations(ctx, userID, func(c Conversation) error {
+		if err := d.setExpiry(ctx, conversationKey(userID, c.ID), c.ActivityAt(), keep); err != nil {
+			return err
+		}
+		token := ""
+		for {
+			page, err := d.queryMessages(ctx, c.ID, MessageRange{}, 500, token, false)
+			if err != nil {
+				return err
+			}
+			for _, m := range page.Items {
+				key := messageKey(c.ID, skMsg(m.CreatedAt.UTC(), m.ID))
+				if err := d.setExpiry(ctx, key, m.CreatedAt, keep); err != nil {
+					return err
+				}
+			}
+			if page.NextToken == "" {
+				return nil
+			}
+			token = page.NextToken
+		}
+	})
+}
+
+// purgeUser deletes userID's conversations last active before cutoff and
+// the older messages of the others, returning how many of each it
+// deleted.
+func (d *dynamoDAL) purgeUser(ctx context.Context, userID string, cutoff time.Time) (int, int, error) {
+	convs, msgs := 0, 0
+	err := d.allConversations(ctx, userID, func(c Conversation) error {
+		if c.ActivityAt().Before(cutoff) {
+			err := d.DeleteConversationCascade(ctx, userID, c.ID)
+			if errors.Is(err, ErrConversationNotFound) {
+				return nil
+			}
+			if err == nil {
+				convs++
+			}
+			return err
+		}
+		purge := d.purgeMessages
+		if c.ArchivedAt != nil {
+			purge = d.purgeArchivedMessages
+		}
+		n, err := purge(ctx, c, cutoff)
+		msgs += n
+		if err != nil {
+			return err
+		}
+		return d.purgeRedactions(ctx, c.ID, cutoff)
+	})
+	return convs, msgs, err
+}
+
+// purgeMessages deletes c's messages from before cutoff, oldest first,
+// with their feedback. Active conversations go through DeleteMessage so
+// the header's activity stays right; the header of a trashed one is not
+// shown, and the trash purge removes it anyway.
+func (d *dynamoDAL) purgeMessages(ctx context.Context, c Conversation, cutoff time.Time) (int, error) {
+	deleted := 0
+	token := ""
+	for {
+		page, err := d.queryMessages(ctx, c.ID, MessageRange{}, 100, token, false)
+		if err != nil {
+			return deleted, err
+		}
+		for _, m := range page.Items {
+			if !m.CreatedAt.Before(cutoff) {
+				return deleted, nil
+			}
+			if c.DeletedAt == nil {
+				err = d.DeleteMessage(ctx, c.UserID, c.ID, m.ID)
+			} else {
+				_, err = d.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+					TableName: aws.String(d.table),
+					Key:       messageKey(c.ID, skMsg(m.CreatedAt.UTC(), m.ID)),
+				})
+			}
+			switch {
+			case errors.Is(err, ErrMessageNotFound):
+			case errors.Is(err, ErrConversationNotFound):
+				return deleted, nil // trashed or deleted meanwhile
+			case err != nil:
+				return deleted, err
+			}
+			_, err = d.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+				TableName: aws.String(d.table),
+				Key: map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: pkConv(c.ID)},
+					"SK": &types.AttributeValueMemberS{Value: skFeedback(m.ID)},
+				},
+			})
+			if err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if page.NextToken == "" {
+			return deleted, nil
+		}
+		token = page.NextToken
+	}
+}
+
+// purgeRedactions deletes the conversation's redaction events from before
+// cutoff. Their sort keys are not fixed-width, so the key range is only a
+// bound and createdAt decides.
+func (d *dynamoDAL) purgeRedactions(ctx context.Context, conversationID string, cutoff time.Time) error {
+	var lek map[string]types.AttributeValue
+	for {
+		page, err := d.client.Query(ctx, &ddb.QueryInput{
+			TableName:              aws.String(d.table),
+			KeyConditionExpression: aws.String("PK = :pk AND SK BETWEEN :lo AND :hi"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: pkConv(conversationID)},
+				":lo": &types.AttributeValueMemberS{Value: "REDACTION#"},
+				":hi": &types.AttributeValueMemberS{Value: skRedaction(cutoff.Truncate(time.Second).Add(time.Second), "")},
+			},
+			ProjectionExpression: aws.String("PK, SK, createdAt"),
+			ExclusiveStartKey:    lek,
+		})
+		if err != nil {
+			return err
+		}
+		var writes []types.WriteRequest
+		for _, it := range page.Items {
+			if parseTime(attrS(it, "createdAt")).Before(cutoff) {
+				writes = append(writes, types.WriteRequest{
+					DeleteRequest: &types.DeleteRequest{Key: map[string]types.AttributeValue{"PK": it["PK"], "SK": it["SK"]}},
+				})
+			}
+		}
+		if _, err := batchWrite(ctx, d.client, d.table, writes); err != nil {
+			return err
+		}
+		if page.LastEvaluatedKey == nil {
+			return nil
+		}
+		lek = page.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// EnsureTable creates the single-table layout the Dynamo DAL expects:
+// PK/SK plus the GSI1 (GSI1PK/GSI1SK), GSI2 (GSI2PK/GSI2SK) and GSI3
+// (PK/messageId) indexes, unless table already exists. It uses the default
+// AWS configuration, so AWS_ENDPOINT_URL_DYNAMODB can point at DynamoDB Local.
+func EnsureTable(ctx context.Context, table string) error {
+	if table == "" {
+		return errors.New("TABLE_NAME env var is required")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	client := ddb.NewFromConfig(cfg)
+	if _, err := client.DescribeTable(ctx, &ddb.DescribeTableInput{TableName: aws.String(table)}); err == nil {
+		return nil
+	}
+	str := func(name string) types.AttributeDefinition {
+		return types.AttributeDefinition{AttributeName: aws.String(name), AttributeType: types.ScalarAttributeTypeS}
+	}
+	key := func(hash, rng string) []types.KeySchemaElement {
+		return []types.KeySchemaElement{
+			{AttributeName: aws.String(hash), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(rng), KeyType: types.KeyTypeRange},
+		}
+	}
+	_, err = client.CreateTable(ctx, &ddb.CreateTableInput{
+		TableName:            aws.String(table),
+		BillingMode:          types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{str("PK"), str("SK"), str("GSI1PK"), str("GSI1SK"), str("GSI2PK"), str("GSI2SK"), str("messageId")},
+		KeySchema:            key("PK", "SK"),
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName:  aws.String("GSI1"),
+				KeySchema:  key("GSI1PK", "GSI1SK"),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			},
+			{
+				IndexName:  aws.String("GSI2"),
+				KeySchema:  key("GSI2PK", "GSI2SK"),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			},
+			{
+				IndexName:  aws.String("GSI3"),
+				KeySchema:  key("PK", "messageId"),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("🗄️ Created table %s", table)
+	return ddb.NewTableExistsWaiter(client).Wait(ctx, &ddb.DescribeTableInput{TableName: aws.String(table)}, 2*time.Minute)
+}
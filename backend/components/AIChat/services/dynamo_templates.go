@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	entityTemplate        = "PromptTemplate"
+	entityTemplateVersion = "PromptTemplateVersion"
+)
+
+// Template headers share one partition so the admin list is a single Query;
+// each header mirrors its latest version. Versions live under their own PK.
+const pkTemplates = "TEMPLATES"
+
+func skTemplate(id string) string    { return "TEMPLATE#" + id }
+func pkTemplate(id string) string    { return "TEMPLATE#" + id }
+func skTemplateVersion(v int) string { return fmt.Sprintf("V#%06d", v) }
+
+type dynamoTemplateStore struct {
+	client *ddb.Client
+	table  string
+}
+
+func templateItem(pk, sk, entity string, t PromptTemplate) (map[string]types.AttributeValue, error) {
+	vars, err := json.Marshal(t.Variables)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]types.AttributeValue{
+		"PK":          &types.AttributeValueMemberS{Value: pk},
+		"SK":          &types.AttributeValueMemberS{Value: sk},
+		"entityType":  &types.AttributeValueMemberS{Value: entity},
+		"templateId":  &types.AttributeValueMemberS{Value: t.ID},
+		"version":     &types.AttributeValueMemberN{Value: strconv.Itoa(t.Version)},
+		"description": &types.AttributeValueMemberS{Value: t.Description},
+		"body":        &types.AttributeValueMemberS{Value: t.Body},
+		"variables":   &types.AttributeValueMemberS{Value: string(vars)},
+		"createdAt":   &types.AttributeValueMemberS{Value: t.CreatedAt.UTC().Format(time.RFC3339Nano)},
+		"createdBy":   &types.AttributeValueMemberS{Value: t.CreatedBy},
+	}, nil
+}
+
+func templateFromItem(it map[string]types.AttributeValue) PromptTemplate {
+	t := PromptTemplate{
+		ID:          attrS(it, "templateId"),
+		Version:     attrInt(it, "version"),
+		Description: attrS(it, "description"),
+		Body:        attrS(it, "body"),
+		CreatedAt:   parseTime(attrS(it, "createdAt")),
+		CreatedBy:   attrS(it, "createdBy"),
+	}
+	_ = json.Unmarshal([]byte(attrS(it, "variables")), &t.Variables)
+	return t
+}
+
+// putTemplateVersion writes the header and version items in one transaction.
+// The header condition guards against two admins publishing the same version.
+func (s *dynamoTemplateStore) putTemplateVersion(ctx context.Context, t PromptTemplate, headerCond string, condValues map[string]types.AttributeValue) error {
+	var condNames map[string]string
+	if condValues != nil {
+		condNames = map[string]string{"#v": "version"}
+	}
+	header, err := templateItem(pkTemplates, skTemplate(t.ID), entityTemplate, t)
+	if err != nil {
+		return err
+	}
+	version, err := templateItem(pkTemplate(t.ID), skTemplateVersion(t.Version), entityTemplateVersion, t)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:                 aws.String(s.table),
+				Item:                      header,
+				ConditionExpression:       aws.String(headerCond),
+				ExpressionAttributeNames:  condNames,
+				ExpressionAttributeValues: condValues,
+			}},
+			{Put: &types.Put{
+				TableName:           aws.String(s.table),
+				Item:                version,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			}},
+		},
+	})
+	return err
+}
+
+// CreateTemplate stores version 1 of a new template. Re-creating a deleted
+// template continues its version numbering so old references stay unique.
+func (s *dynamoTemplateStore) CreateTemplate(ctx context.Context, t PromptTemplate) (PromptTemplate, error) {
+	defer forgetTemplate(t.ID)
+	header, err := s.header(ctx, t.ID)
+	if err != nil {
+		return PromptTemplate{}, err
+	}
+	cond, values := "attribute_not_exists(PK)", map[string]types.AttributeValue(nil)
+	t.Version = 1
+	if header != nil {
+		if attrS(header, "deletedAt") == "" {
+			return PromptTemplate{}, ErrTemplateExists
+		}
+		current := attrInt(header, "version")
+		t.Version = current + 1
+		cond = "attribute_exists(deletedAt) AND #v = :cur"
+		values = map[string]types.AttributeValue{
+			":cur": &types.AttributeValueMemberN{Value: strconv.Itoa(current)},
+		}
+	}
+	t.CreatedAt = time.Now().UTC()
+	err = s.putTemplateVersion(ctx, t, cond, values)
+	if isConditionFailure(err) {
+		return PromptTemplate{}, ErrTemplateExists
+	}
+	return t, err
+}
+
+func (s *dynamoTemplateStore) AddTemplateVersion(ctx context.Context, t PromptTemplate) (PromptTemplate, error) {
+	defer forgetTemplate(t.ID)
+	current, err := s.GetTemplate(ctx, t.ID, 0)
+	if err != nil {
+		return PromptTemplate{}, err
+	}
+	t.Version = current.Version + 1
+	t.CreatedAt = time.Now().UTC()
+	err = s.putTemplateVersion(ctx, t, "#v = :cur AND attribute_not_exists(deletedAt)", map[string]types.AttributeValue{
+		":cur": &types.AttributeValueMemberN{Value: strconv.Itoa(current.Version)},
+	})
+	if isConditionFailure(err) {
+		return PromptTemplate{}, ErrTemplateConflict
+	}
+	return t, err
+}
+
+// header returns the raw header item for id, deleted or not, or nil.
+func (s *dynamoTemplateStore) header(ctx context.Context, id string) (map[string]types.AttributeValue, error) {
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkTemplates},
+			"SK": &types.AttributeValueMemberS{Value: skTemplate(id)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Item, nil
+}
+
+func (s *dynamoTemplateStore) GetTemplate(ctx context.Context, id string, version int) (PromptTemplate, error) {
+	key := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pkTemplates},
+		"SK": &types.AttributeValueMemberS{Value: skTemplate(id)},
+	}
+	if version > 0 {
+		key = map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkTemplate(id)},
+			"SK": &types.AttributeValueMemberS{Value: skTemplateVersion(version)},
+		}
+	}
+	out, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return PromptTemplate{}, err
+	}
+	// A deleted header hides the template; its versions stay readable so
+	// messages that reference them can still be audited.
+	if out.Item == nil || (version == 0 && attrS(out.Item, "deletedAt") != "") {
+		return PromptTemplate{}, ErrTemplateNotFound
+	}
+	return templateFromItem(out.Item), nil
+}
+
+func (s *dynamoTemplateStore) ListTemplates(ctx context.Context, limit int32, nextToken string) (ListPage[PromptTemplate], error) {
+	return s.queryTemplates(ctx, pkTemplates, "TEMPLATE#", "attribute_not_exists(deletedAt)", limit, nextToken, true)
+}
+
+func (s *dynamoTemplateStore) ListTemplateVersions(ctx context.Context, id string, limit int32, nextToken string) (ListPage[PromptTemplate], error) {
+	return s.queryTemplates(ctx, pkTemplate(id), "V#", "", limit, nextToken, false)
+}
+
+// queryTemplates pages items under pk, keeping those matching filter when it
+// is set. Filters apply after Limit, so a page can be short.
+func (s *dynamoTemplateStore) queryTemplates(ctx context.Context, pk, skPrefix, filter string, limit int32, nextToken string, forward bool) (ListPage[PromptTemplate], error) {
+	scope := newCursorScope("templates", pk, skPrefix)
+	lek, err := decodeLEK(scope, nextToken)
+	if err != nil {
+		return ListPage[PromptTemplate]{}, err
+	}
+	var filterExpr *string
+	if filter != "" {
+		filterExpr = aws.String(filter)
+	}
+	out, err := s.client.Query(ctx, &ddb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		FilterExpression:       filterExpr,
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: pk},
+			":sk": &types.AttributeValueMemberS{Value: skPrefix},
+		},
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: lek,
+		ScanIndexForward:  aws.Bool(forward), // versions newest first
+	})
+	if err != nil {
+		return ListPage[PromptTemplate]{}, err
+	}
+	items := make([]PromptTemplate, 0, len(out.Items))
+	for _, it := range out.Items {
+		items = append(items, templateFromItem(it))
+	}
+	token, _ := encodeLEK(scope, out.LastEvaluatedKey)
+	return ListPage[PromptTemplate]{Items: items, NextToken: token}, nil
+}
+
+// DeleteTemplate marks the header deleted so renders fall back to the
+// built-in default. The version history is kept, and the header keeps the
+// latest version number so a re-created template never reuses one.
+func (s *dynamoTemplateStore) DeleteTemplate(ctx context.Context, id string) error {
+	defer forgetTemplate(id)
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: pkTemplates},
+			"SK": &types.AttributeValueMemberS{Value: skTemplate(id)},
+		},
+		UpdateExpression:    aws.String("SET deletedAt = :at"),
+		ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(deletedAt)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	if isConditionFailure(err) {
+		return ErrTemplateNotFound
+	}
+	return err
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EvalCase is one line of an evaluation dataset (JSONL):
+//
+//	{"id": "photosynthesis-1", "question": "...", "reference": "...",
+//	 "keywords": ["chlorophyll", "light"], "rubric": "optional per-case rubric"}
+type EvalCase struct {
+	ID        string   `json:"id"`
+	Question  string   `json:"question"`
+	Reference string   `json:"reference"`
+	Keywords  []string `json:"keywords,omitempty"`
+	Rubric    string   `json:"rubric,omitempty"`
+}
+
+// EvalConfig says how to answer and how to judge. Judge may be the same
+// provider as Answerer; nil skips the LLM-as-judge score.
+type EvalConfig struct {
+	Answer     AnswerConfig
+	Answerer   Provider
+	Judge      Provider
+	JudgeModel string
+	Rubric     string // default rubric for cases without their own
+}
+
+type EvalResult struct {
+	CaseID           string   `json:"caseId"`
+	Question         string   `json:"question"`
+	Reference        string   `json:"reference"`
+	Answer           string   `json:"answer"`
+	ExactMatch       bool     `json:"exactMatch"`
+	KeywordCoverage  *float64 `json:"keywordCoverage,omitempty"` // nil when the case has no keywords
+	MissingKeywords  []string `json:"missingKeywords,omitempty"`
+	JudgeScore       *float64 `json:"judgeScore,omitempty"` // 1-5 rubric score scaled to 0-1
+	JudgeRationale   string   `json:"judgeRationale,omitempty"`
+	JudgeError       string   `json:"judgeError,omitempty"`
+	Error            string   `json:"error,omitempty"`
+	PromptTokens     int      `json:"promptTokens"`
+	CompletionTokens int      `json:"completionTokens"`
+}
+
+// EvalSummary averages each metric over the cases where it was computed.
+type EvalSummary struct {
+	Cases           int     `json:"cases"`
+	Errors          int     `json:"errors"`
+	ExactMatchRate  float64 `json:"exactMatchRate"`
+	KeywordCoverage float64 `json:"keywordCoverage"`
+	KeywordCases    int     `json:"keywordCases"`
+	JudgeScore      float64 `json:"judgeScore"`
+	JudgedCases     int     `json:"judgedCases"`
+}
+
+type EvalRun struct {
+	Name        string       `json:"name"`
+	StartedAt   time.Time    `json:"startedAt"`
+	Template    TemplateRef  `json:"template"`
+	Model       string       `json:"model"`
+	Temperature *float64     `json:"temperature,omitempty"`
+	Results     []EvalResult `json:"results"`
+	Summary     EvalSummary  `json:"summary"`
+}
+
+const defaultEvalRubric = "Correctness against the reference answer matters most, then clarity and completeness for a student."
+
+// LoadEvalDataset reads JSONL cases, skipping blank lines. Cases without an
+// id are numbered by line.
+func LoadEvalDataset(r io.Reader) ([]EvalCase, error) {
+	var cases []EvalCase
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		var c EvalCase
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if c.Question == "" {
+			return nil, fmt.Errorf("line %d: question is required", line)
+		}
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("line-%d", line)
+		}
+		if seen[c.ID] {
+			return nil, fmt.Errorf("line %d: duplicate id %q", line, c.ID)
+		}
+		seen[c.ID] = true
+		cases = append(cases, c)
+	}
+	return cases, sc.Err()
+}
+
+// RunEval answers every case with cfg and scores the answers. A failing case
+// is recorded in its result rather than aborting the run.
+func RunEval(ctx context.Context, name string, cfg EvalConfig, cases []EvalCase) (EvalRun, error) {
+	if cfg.Answerer == nil {
+		return EvalRun{}, errors.New("eval: no answer provider")
+	}
+	run := EvalRun{
+		Name:        name,
+		StartedAt:   time.Now().UTC(),
+		Model:       cfg.Answer.Model,
+		Temperature: cfg.Answer.Temperature,
+		Results:     make([]EvalResult, 0, len(cases)),
+	}
+	for _, c := range cases {
+		if err := ctx.Err(); err != nil {
+			return run, err
+		}
+		res := EvalResult{CaseID: c.ID, Question: c.Question, Reference: c.Reference}
+		reply, err := Answer(ctx, cfg.Answerer, cfg.Answer, c.Question)
+		if err != nil {
+			res.Error = err.Error()
+			run.Results = append(run.Results, res)
+			continue
+		}
+		run.Template = reply.Template
+		if reply.Model != "" {
+			run.Model = reply.Model
+		}
+		res.Answer = reply.Content
+		res.PromptTokens, res.CompletionTokens = reply.PromptTokens, reply.CompletionTokens
+		res.ExactMatch = c.Reference != "" && ExactMatch(reply.Content, c.Reference)
+		if len(c.Keywords) > 0 {
+			cov, missing := KeywordCoverage(reply.Content, c.Keywords)
+			res.KeywordCoverage, res.MissingKeywords = &cov, missing
+		}
+		if cfg.Judge != nil {
+			rubric := c.Rubric
+			if rubric == "" {
+				rubric = cfg.Rubric
+			}
+			score, rationale, err := JudgeAnswer(ctx, cfg.Judge, cfg.JudgeModel, rubric, c, reply.Content)
+			if err != nil {
+				res.JudgeError = err.Error()
+			} else {
+				scaled := float64(score-1) / 4
+				res.JudgeScore, res.JudgeRationale = &scaled, rationale
+			}
+		}
+		run.Results = append(run.Results, res)
+	}
+	run.Summary = summarizeEval(run.Results)
+	return run, nil
+}
+
+func summarizeEval(results []EvalResult) EvalSummary {
+	s := EvalSummary{Cases: len(results)}
+	var exact, answered int
+	var cov, judge float64
+	for _, r := range results {
+		if r.Error != "" {
+			s.Errors++
+			continue
+		}
+		answered++
+		if r.ExactMatch {
+			exact++
+		}
+		if r.KeywordCoverage != nil {
+			cov += *r.KeywordCoverage
+			s.KeywordCases++
+		}
+		if r.JudgeScore != nil {
+			judge += *r.JudgeScore
+			s.JudgedCases++
+		}
+	}
+	if answered > 0 {
+		s.ExactMatchRate = float64(exact) / float64(answered)
+	}
+	if s.KeywordCases > 0 {
+		s.KeywordCoverage = cov / float64(s.KeywordCases)
+	}
+	if s.JudgedCases > 0 {
+		s.JudgeScore = judge / float64(s.JudgedCases)
+	}
+	return s
+}
+
+// normalizeAnswer lowercases, turns punctuation and symbols into spaces and
+// collapses whitespace.
+func normalizeAnswer(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// ExactMatch compares answer and reference ignoring case, punctuation and spacing.
+func ExactMatch(answer, reference string) bool {
+	return normalizeAnswer(answer) == normalizeAnswer(reference)
+}
+
+// KeywordCoverage is the fraction of keywords that appear in answer, plus
+// the ones that did not.
+func KeywordCoverage(answer string, keywords []string) (float64, []string) {
+	if len(keywords) == 0 {
+		return 1, nil
+	}
+	haystack := " " + normalizeAnswer(answer) + " "
+	var missing []string
+	for _, k := range keywords {
+		if !strings.Contains(haystack, " "+normalizeAnswer(k)+" ") {
+			missing = append(missing, k)
+		}
+	}
+	return float64(len(keywords)-len(missing)) / float64(len(keywords)), missing
+}
+
+// JudgeAnswer asks p to grade answer against the case with the eval-judge
+// template and returns the 1-5 score and the judge's rationale.
+func JudgeAnswer(ctx context.Context, p Provider, model, rubric string, c EvalCase, answer string) (int, string, error) {
+	if rubric == "" {
+		rubric = defaultEvalRubric
+	}
+	prompt, _, err := RenderTemplate(ctx, TemplateEvalJudge, map[string]any{
+		"rubric":    rubric,
+		"question":  c.Question,
+		"reference": c.Reference,
+		"answer":    answer,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	zero := 0.0
+	res, err := p.Complete(ctx, CompletionRequest{
+		Model:       model,
+		Temperature: &zero,
+		Messages:    []Message{{Role: "user", Content: prompt}},
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return parseJudgeVerdict(res.Content)
+}
+
+// parseJudgeVerdict extracts {"score": n, "rationale": "..."} from the judge's
+// reply, tolerating prose or code fences around the JSON object.
+func parseJudgeVerdict(content string) (int, string, error) {
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return 0, "", fmt.Errorf("judge reply has no JSON verdict: %q", content)
+	}
+	var verdict struct {
+		Score     float64 `json:"score"`
+		Rationale string  `json:"rationale"`
+	}
+	if err := json.Unmarshal([]byte(content[start:end+1]), &verdict); err != nil {
+		return 0, "", fmt.Errorf("judge verdict: %w", err)
+	}
+	score := int(verdict.Score + 0.5)
+	if score < 1 || score > 5 {
+		return 0, "", fmt.Errorf("judge score %v outside 1-5", verdict.Score)
+	}
+	return score, verdict.Rationale, nil
+}
+
+// EvalCaseDiff is a case whose scores moved noticeably between two runs.
+type EvalCaseDiff struct {
+	CaseID         string   `json:"caseId"`
+	BaseExact      bool     `json:"baseExact"`
+	CandidateExact bool     `json:"candidateExact"`
+	KeywordDelta   *float64 `json:"keywordDelta,omitempty"`
+	JudgeDelta     *float64 `json:"judgeDelta,omitempty"`
+	Note           string   `json:"note,omitempty"`
+}
+
+type EvalComparison struct {
+	Base         EvalRun        `json:"-"`
+	Candidate    EvalRun        `json:"-"`
+	BaseName     string         `json:"base"`
+	CandName     string         `json:"candidate"`
+	ExactDelta   float64        `json:"exactMatchRateDelta"`
+	KeywordDelta float64        `json:"keywordCoverageDelta"`
+	JudgeDelta   float64        `json:"judgeScoreDelta"`
+	Regressions  []EvalCaseDiff `json:"regressions"`
+	Improvements []EvalCaseDiff `json:"improvements"`
+	OnlyInBase   []string       `json:"onlyInBase,omitempty"`
+	OnlyInCand   []string       `json:"onlyInCandidate,omitempty"`
+}
+
+// evalDiffThreshold is the per-case score change (on the 0-1 scale) that
+// counts as a regression or improvement.
+const evalDiffThreshold = 0.25
+
+// CompareEvalRuns diffs candidate against base, case by case.
+func CompareEvalRuns(base, candidate EvalRun) EvalComparison {
+	cmp := EvalComparison{
+		Base:         base,
+		Candidate:    candidate,
+		BaseName:     base.Name,
+		CandName:     candidate.Name,
+		ExactDelta:   candidate.Summary.ExactMatchRate - base.Summary.ExactMatchRate,
+		KeywordDelta: candidate.Summary.KeywordCoverage - base.Summary.KeywordCoverage,
+		JudgeDelta:   candidate.Summary.JudgeScore - base.Summary.JudgeScore,
+	}
+	byID := make(map[string]EvalResult, len(candidate.Results))
+	for _, r := range candidate.Results {
+		byID[r.CaseID] = r
+	}
+	for _, b := range base.Results {
+		c, ok := byID[b.CaseID]
+		if !ok {
+			cmp.OnlyInBase = append(cmp.OnlyInBase, b.CaseID)
+			continue
+		}
+		delete(byID, b.CaseID)
+
+		d := EvalCaseDiff{CaseID: b.CaseID, BaseExact: b.ExactMatch, CandidateExact: c.ExactMatch}
+		score := 0.0
+		if b.ExactMatch != c.ExactMatch {
+			score += boolDelta(c.ExactMatch) - boolDelta(b.ExactMatch)
+		}
+		if b.KeywordCoverage != nil && c.KeywordCoverage != nil {
+			delta := *c.KeywordCoverage - *b.KeywordCoverage
+			d.KeywordDelta = &delta
+			if abs(delta) >= evalDiffThreshold {
+				score += delta
+			}
+		}
+		if b.JudgeScore != nil && c.JudgeScore != nil {
+			delta := *c.JudgeScore - *b.JudgeScore
+			d.JudgeDelta = &delta
+			if abs(delta) >= evalDiffThreshold {
+				score += delta
+			}
+		}
+		switch {
+		case b.Error == "" && c.Error != "":
+			d.Note = "candidate failed: " + c.Error
+			cmp.Regressions = append(cmp.Regressions, d)
+		case b.Error != "" && c.Error == "":
+			d.Note = "base failed: " + b.Error
+			cmp.Improvements = append(cmp.Improvements, d)
+		case score < 0:
+			cmp.Regressions = append(cmp.Regressions, d)
+		case score > 0:
+			cmp.Improvements = append(cmp.Improvements, d)
+		}
+	}
+	for _, r := range candidate.Results {
+		if _, ok := byID[r.CaseID]; ok {
+			cmp.OnlyInCand = append(cmp.OnlyInCand, r.CaseID)
+		}
+	}
+	return cmp
+}
+
+func boolDelta(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// Markdown renders the comparison as a report for a PR or a chat channel.
+func (c EvalComparison) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Eval comparison: %s → %s\n\n", c.BaseName, c.CandName)
+	fmt.Fprintf(&b, "| | %s | %s |\n|---|---|---|\n", c.BaseName, c.CandName)
+	fmt.Fprintf(&b, "| Template | %s v%d | %s v%d |\n", c.Base.Template.ID, c.Base.Template.Version, c.Candidate.Template.ID, c.Candidate.Template.Version)
+	fmt.Fprintf(&b, "| Model | %s | %s |\n", c.Base.Model, c.Candidate.Model)
+	fmt.Fprintf(&b, "| Cases (errors) | %d (%d) | %d (%d) |\n", c.Base.Summary.Cases, c.Base.Summary.Errors, c.Candidate.Summary.Cases, c.Candidate.Summary.Errors)
+	fmt.Fprintf(&b, "| Exact match | %.3f | %.3f (%+.3f) |\n", c.Base.Summary.ExactMatchRate, c.Candidate.Summary.ExactMatchRate, c.ExactDelta)
+	fmt.Fprintf(&b, "| Keyword coverage | %.3f | %.3f (%+.3f) |\n", c.Base.Summary.KeywordCoverage, c.Candidate.Summary.KeywordCoverage, c.KeywordDelta)
+	fmt.Fprintf(&b, "| Judge score | %.3f | %.3f (%+.3f) |\n", c.Base.Summary.JudgeScore, c.Candidate.Summary.JudgeScore, c.JudgeDelta)
+
+	writeDiffs := func(title string, diffs []EvalCaseDiff) {
+		fmt.Fprintf(&b, "\n## %s (%d)\n\n", title, len(diffs))
+		if len(diffs) == 0 {
+			b.WriteString("None.\n")
+			return
+		}
+		b.WriteString("| Case | Exact | Keyword Δ | Judge Δ | Note |\n|---|---|---|---|---|\n")
+		for _, d := range diffs {
+			fmt.Fprintf(&b, "| %s | %v → %v | %s | %s | %s |\n", d.CaseID, d.BaseExact, d.CandidateExact, fmtDelta(d.KeywordDelta), fmtDelta(d.JudgeDelta), d.Note)
+		}
+	}
+	writeDiffs("Regressions", c.Regressions)
+	writeDiffs("Improvements", c.Improvements)
+	if len(c.OnlyInBase) > 0 || len(c.OnlyInCand) > 0 {
+		fmt.Fprintf(&b, "\nOnly in %s: %s\n\nOnly in %s: %s\n", c.BaseName, strings.Join(c.OnlyInBase, ", "), c.CandName, strings.Join(c.OnlyInCand, ", "))
+	}
+	return b.String()
+}
+
+func fmtDelta(d *float64) string {
+	if d == nil {
+		return "–"
+	}
+	return fmt.Sprintf("%+.2f", *d)
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const evalDataset = `{"id": "photosynthesis", "question": "What do plants need for photosynthesis?", "reference": "Light, water and carbon dioxide.", "keywords": ["light", "water", "carbon dioxide"]}
+
+{"question": "What is 2 + 2?", "reference": "4"}
+`
+
+func TestLoadEvalDataset(t *testing.T) {
+	cases, err := LoadEvalDataset(strings.NewReader(evalDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 2 || cases[0].ID != "photosynthesis" || cases[1].ID != "line-3" {
+		t.Fatalf("cases = %+v", cases)
+	}
+
+	for name, data := range map[string]string{
+		"no question":  `{"id": "a"}`,
+		"duplicate id": `{"id": "a", "question": "q"}` + "\n" + `{"id": "a", "question": "q"}`,
+		"bad json":     `{"id": `,
+	} {
+		if _, err := LoadEvalDataset(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRunEvalWithFakeProvider(t *testing.T) {
+	cases, err := LoadEvalDataset(strings.NewReader(evalDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	answerer := &FakeProvider{Reply: func(req CompletionRequest) string {
+		if !strings.Contains(req.Messages[0].Content, "AiChatBot") {
+			t.Errorf("system prompt is not the built-in persona: %q", req.Messages[0].Content)
+		}
+		if strings.Contains(req.Messages[len(req.Messages)-1].Content, "2 + 2") {
+			return "4."
+		}
+		return "Plants need light and water."
+	}}
+	judge := &FakeProvider{Reply: func(req CompletionRequest) string {
+		return "Verdict:\n```json\n{\"score\": 5, \"rationale\": \"correct\"}\n```"
+	}}
+
+	run, err := RunEval(context.Background(), "offline", EvalConfig{Answerer: answerer, Judge: judge}, cases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Template.ID != TemplateChatSystem {
+		t.Errorf("template = %+v, want %s", run.Template, TemplateChatSystem)
+	}
+	photo, sum := run.Results[0], run.Results[1]
+	if photo.ExactMatch || !sum.ExactMatch {
+		t.Errorf("exact match: photosynthesis=%v sum=%v", photo.ExactMatch, sum.ExactMatch)
+	}
+	if photo.KeywordCoverage == nil || len(photo.MissingKeywords) != 1 || photo.MissingKeywords[0] != "carbon dioxide" {
+		t.Errorf("keywords: coverage=%v missing=%v", photo.KeywordCoverage, photo.MissingKeywords)
+	}
+	if photo.JudgeScore == nil || *photo.JudgeScore != 1 || photo.JudgeRationale != "correct" {
+		t.Errorf("judge: score=%v rationale=%q error=%q", photo.JudgeScore, photo.JudgeRationale, photo.JudgeError)
+	}
+	if run.Summary.Cases != 2 || run.Summary.ExactMatchRate != 0.5 || run.Summary.KeywordCases != 1 || run.Summary.JudgedCases != 2 {
+		t.Errorf("summary = %+v", run.Summary)
+	}
+}
+
+func TestParseJudgeVerdict(t *testing.T) {
+	for content, want := range map[string]int{
+		`{"score": 4, "rationale": "ok"}`:     4,
+		`Sure! {"score": 2.6}`:                3,
+		`{"score": 0}`:                        0,
+		`{"score": 6}`:                        0,
+		`no verdict here`:                     0,
+		`{"score": "five", "rationale": "x"}`: 0,
+	} {
+		got, _, err := parseJudgeVerdict(content)
+		if want == 0 {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", content, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%q: got %d, %v; want %d", content, got, err, want)
+		}
+	}
+}
+
+func TestCompareEvalRuns(t *testing.T) {
+	score := func(f float64) *float64 { return &f }
+	base := EvalRun{Name: "base", Results: []EvalResult{
+		{CaseID: "a", ExactMatch: true, JudgeScore: score(1)},
+		{CaseID: "b", JudgeScore: score(0.25)},
+		{CaseID: "c"},
+		{CaseID: "gone"},
+	}}
+	candidate := EvalRun{Name: "candidate", Results: []EvalResult{
+		{CaseID: "a", JudgeScore: score(0.5)},
+		{CaseID: "b", JudgeScore: score(1)},
+		{CaseID: "c", Error: "timeout"},
+		{CaseID: "new"},
+	}}
+	cmp := CompareEvalRuns(base, candidate)
+
+	ids := func(diffs []EvalCaseDiff) string {
+		var out []string
+		for _, d := range diffs {
+			out = append(out, d.CaseID)
+		}
+		return strings.Join(out, ",")
+	}
+	if got := ids(cmp.Regressions); got != "a,c" {
+		t.Errorf("regressions = %s, want a,c", got)
+	}
+	if got := ids(cmp.Improvements); got != "b" {
+		t.Errorf("improvements = %s, want b", got)
+	}
+	if len(cmp.OnlyInBase) != 1 || cmp.OnlyInBase[0] != "gone" || len(cmp.OnlyInCand) != 1 || cmp.OnlyInCand[0] != "new" {
+		t.Errorf("only in base %v, only in candidate %v", cmp.OnlyInBase, cmp.OnlyInCand)
+	}
+	if md := cmp.Markdown(); !strings.Contains(md, "base") || !strings.Contains(md, "candidate") {
+		t.Errorf("markdown report lacks the run names:\n%s", md)
+	}
+}
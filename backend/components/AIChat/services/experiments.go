@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ExperimentDraft   = "draft"
+	ExperimentRunning = "running"
+	ExperimentStopped = "stopped"
+
+	// Assignment units: a user keeps one variant across all conversations,
+	// or each conversation is bucketed independently.
+	UnitUser         = "user"
+	UnitConversation = "conversation"
+)
+
+// Variant is one arm of an experiment. Empty fields fall back to the
+// normal defaults (chat-system template, provider default model/temperature).
+type Variant struct {
+	Name            string   `json:"name"`
+	Weight          int      `json:"weight"`
+	TemplateID      string   `json:"templateId,omitempty"`
+	TemplateVersion int      `json:"templateVersion,omitempty"` // 0 = latest
+	Model           string   `json:"model,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+}
+
+type Experiment struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status"`
+	Unit        string    `json:"unit"`
+	Variants    []Variant `json:"variants"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Outcome is a delta added to a variant's running totals.
+type Outcome struct {
+	Exchanges        int64
+	ThumbsUp         int64
+	ThumbsDown       int64
+	FollowUps        int64
+	PromptTokens     int64
+	CompletionTokens int64
+	CostMicroUSD     int64
+}
+
+type VariantReport struct {
+	Variant          string  `json:"variant"`
+	Exchanges        int64   `json:"exchanges"`
+	ThumbsUp         int64   `json:"thumbsUp"`
+	ThumbsDown       int64   `json:"thumbsDown"`
+	ThumbsUpRate     float64 `json:"thumbsUpRate"` // up / (up + down)
+	FollowUps        int64   `json:"followUps"`
+	FollowUpsPerExch float64 `json:"followUpsPerExchange"`
+	PromptTokens     int64   `json:"promptTokens"`
+	CompletionTokens int64   `json:"completionTokens"`
+	CostUSD          float64 `json:"costUsd"`
+	CostPerExchange  float64 `json:"costUsdPerExchange"`
+}
+
+// ExperimentStore keeps definitions and outcomes. At most one experiment
+// runs at a time: creating or updating one as running while another runs
+// fails with ErrExperimentRunning, atomically with the write.
+type ExperimentStore interface {
+	CreateExperiment(ctx context.Context, e Experiment) (Experiment, error)
+	// UpdateExperiment replaces the definition of an existing experiment.
+	UpdateExperiment(ctx context.Context, e Experiment) (Experiment, error)
+	GetExperiment(ctx context.Context, id string) (Experiment, error)
+	ListExperiments(ctx context.Context, limit int32, nextToken string) (ListPage[Experiment], error)
+	DeleteExperiment(ctx context.Context, id string) error
+	// RecordOutcome atomically adds o to the variant's counters.
+	RecordOutcome(ctx context.Context, experimentID, variant string, o Outcome) error
+	// Outcomes returns the accumulated counters keyed by variant name.
+	Outcomes(ctx context.Context, experimentID string) (map[string]Outcome, error)
+}
+
+// Global, initialised alongside Store in InitDAL.
+var Experiments ExperimentStore
+
+var (
+	ErrExperimentNotFound = errors.New("experiment not found")
+	ErrExperimentExists   = errors.New("experiment already exists")
+	ErrExperimentRunning  = errors.New("another experiment is already running")
+)
+
+// Validate checks the experiment definition before it is stored.
+func (e Experiment) Validate() error {
+	if !templateIDPattern.MatchString(e.ID) {
+		return fmt.Errorf("invalid experiment id %q: use lowercase letters, digits, '.', '_' or '-'", e.ID)
+	}
+	switch e.Status {
+	case ExperimentDraft, ExperimentRunning, ExperimentStopped:
+	default:
+		return fmt.Errorf("unknown status %q", e.Status)
+	}
+	switch e.Unit {
+	case UnitUser, UnitConversation:
+	default:
+		return fmt.Errorf("unknown unit %q", e.Unit)
+	}
+	if len(e.Variants) < 2 {
+		return errors.New("an experiment needs at least two variants")
+	}
+	seen := map[string]bool{}
+	for _, v := range e.Variants {
+		if v.Name == "" || seen[v.Name] {
+			return fmt.Errorf("variant names must be unique and non-empty (got %q)", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Weight < 1 {
+			return fmt.Errorf("variant %q: weight must be at least 1", v.Name)
+		}
+		if v.Temperature != nil && (*v.Temperature < 0 || *v.Temperature > 2) {
+			return fmt.Errorf("variant %q: temperature must be between 0 and 2", v.Name)
+		}
+		if v.TemplateVersion != 0 && v.TemplateID == "" {
+			return fmt.Errorf("variant %q: templateVersion needs a templateId", v.Name)
+		}
+	}
+	return nil
+}
+
+// Assign deterministically picks the variant for a user or conversation:
+// the same experiment and unit ID always land in the same weighted bucket.
+func (e Experiment) Assign(userID, conversationID string) Variant {
+	unitID := userID
+	if e.Unit == UnitConversation {
+		unitID = conversationID
+	}
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	sum := sha256.Sum256([]byte(e.ID + ":" + unitID))
+	bucket := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for _, v := range e.Variants {
+		if bucket < v.Weight {
+			return v
+		}
+		bucket -= v.Weight
+	}
+	return e.Variants[len(e.Variants)-1]
+}
+
+// ActiveExperiment returns the running experiment, if any. reply.go asks
+// on every message, so the answer is cached per instance for
+// activeExperimentTTL.
+func ActiveExperiment(ctx context.Context) (*Experiment, error) {
+	if Experiments == nil {
+		return nil, nil
+	}
+	activeExperimentCache.Lock()
+	e, expires := activeExperimentCache.experiment, activeExperimentCache.expires
+	activeExperimentCache.Unlock()
+	if time.Now().Before(expires) {
+		return e, nil
+	}
+	e, err := activeExperiment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	activeExperimentCache.Lock()
+	activeExperimentCache.experiment = e
+	activeExperimentCache.expires = time.Now().Add(activeExperimentTTL)
+	activeExperimentCache.Unlock()
+	return e, nil
+}
+
+func activeExperiment(ctx context.Context) (*Experiment, error) {
+	token := ""
+	for {
+		page, err := Experiments.ListExperiments(ctx, 50, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range page.Items {
+			if e.Status == ExperimentRunning {
+				return &e, nil
+			}
+		}
+		if page.NextToken == "" {
+			return nil, nil
+		}
+		token = page.NextToken
+	}
+}
+
+// activeExperimentTTL bounds how long another instance's start or stop
+// takes to reach replies on this one.
+const activeExperimentTTL = time.Minute
+
+var activeExperimentCache struct {
+	sync.Mutex
+	experiment *Experiment // nil: none running
+	expires    time.Time
+}
+
+// forgetActiveExperiment drops the cached answer after an experiment is
+// created, changed or deleted.
+func forgetActiveExperiment() {
+	activeExperimentCache.Lock()
+	defer activeExperimentCache.Unlock()
+	activeExperimentCache.experiment = nil
+	activeExperimentCache.expires = time.Time{}
+}
+
+// Report turns raw counters into per-variant rates, in definition order.
+func (e Experiment) Report(outcomes map[string]Outcome) []VariantReport {
+	reports := make([]VariantReport, 0, len(e.Variants))
+	for _, v := range e.Variants {
+		o := outcomes[v.Name]
+		r := VariantReport{
+			Variant:          v.Name,
+			Exchanges:        o.Exchanges,
+			ThumbsUp:         o.ThumbsUp,
+			ThumbsDown:       o.ThumbsDown,
+			FollowUps:        o.FollowUps,
+			PromptTokens:     o.PromptTokens,
+			CompletionTokens: o.CompletionTokens,
+			CostUSD:          float64(o.CostMicroUSD) / 1e6,
+		}
+		if rated := o.ThumbsUp + o.ThumbsDown; rated > 0 {
+			r.ThumbsUpRate = float64(o.ThumbsUp) / float64(rated)
+		}
+		if o.Exchanges > 0 {
+			r.FollowUpsPerExch = float64(o.FollowUps) / float64(o.Exchanges)
+			r.CostPerExchange = r.CostUSD / float64(o.Exchanges)
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// USD per million tokens (prompt, completion). Unknown models cost 0 so
+// experiments still report token counts.
+var modelPricing = map[string][2]float64{
+	"gpt-4":         {30, 60},
+	"gpt-4-turbo":   {10, 30},
+	"gpt-4o":        {2.5, 10},
+	"gpt-4o-mini":   {0.15, 0.6},
+	"gpt-3.5-turbo": {0.5, 1.5},
+}
+
+// CostMicroUSD estimates the cost of one completion in millionths of a dollar.
+// Dated model names ("gpt-4o-mini-2024-07-18") use the longest matching prefix.
+func CostMicroUSD(model string, promptTokens, completionTokens int) int64 {
+	price, best := [2]float64{}, ""
+	for name, p := range modelPricing {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			price, best = p, name
+		}
+	}
+	return int64(float64(promptTokens)*price[0] + float64(completionTokens)*price[1])
+}
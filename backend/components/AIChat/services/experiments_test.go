@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func weighted(unit string, weights ...int) Experiment {
+	e := Experiment{ID: "tone", Status: ExperimentRunning, Unit: unit}
+	for i, w := range weights {
+		e.Variants = append(e.Variants, Variant{Name: fmt.Sprintf("v%d", i), Weight: w})
+	}
+	return e
+}
+
+func TestAssignIsDeterministic(t *testing.T) {
+	byUser := weighted(UnitUser, 1, 1)
+	byConv := weighted(UnitConversation, 1, 1)
+	for i := 0; i < 100; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		first := byUser.Assign(user, "conv-a")
+		if again := byUser.Assign(user, "conv-b"); again.Name != first.Name {
+			t.Fatalf("%s got %s then %s: user units must ignore the conversation", user, first.Name, again.Name)
+		}
+		conv := fmt.Sprintf("conv-%d", i)
+		if a, b := byConv.Assign("user-a", conv), byConv.Assign("user-b", conv); a.Name != b.Name {
+			t.Fatalf("%s got %s and %s: conversation units must ignore the user", conv, a.Name, b.Name)
+		}
+	}
+
+	// The experiment ID salts the hash, so experiments bucket independently.
+	other := byUser
+	other.ID = "length"
+	differ := 0
+	for i := 0; i < 200; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		if byUser.Assign(user, "").Name != other.Assign(user, "").Name {
+			differ++
+		}
+	}
+	if differ < 50 || differ > 150 {
+		t.Errorf("%d of 200 users changed variant between experiments, want about half", differ)
+	}
+}
+
+func TestAssignFollowsWeights(t *testing.T) {
+	e := weighted(UnitUser, 1, 3, 6)
+	const n = 20000
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		counts[e.Assign(fmt.Sprintf("user-%d", i), "").Name]++
+	}
+	for _, v := range e.Variants {
+		want := float64(v.Weight) / 10
+		got := float64(counts[v.Name]) / n
+		if math.Abs(got-want) > 0.02 {
+			t.Errorf("variant %s: share %.3f, want %.2f", v.Name, got, want)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	e := weighted(UnitUser, 1, 1)
+	reports := e.Report(map[string]Outcome{
+		"v0":   {Exchanges: 4, ThumbsUp: 3, ThumbsDown: 1, FollowUps: 2, PromptTokens: 400, CompletionTokens: 100, CostMicroUSD: 2_000_000},
+		"gone": {Exchanges: 9},
+	})
+	if len(reports) != 2 || reports[0].Variant != "v0" || reports[1].Variant != "v1" {
+		t.Fatalf("reports = %+v, want v0 and v1 in definition order", reports)
+	}
+	r := reports[0]
+	if r.ThumbsUpRate != 0.75 || r.FollowUpsPerExch != 0.5 || r.CostUSD != 2 || r.CostPerExchange != 0.5 {
+		t.Errorf("v0 report = %+v", r)
+	}
+	if r.PromptTokens != 400 || r.CompletionTokens != 100 {
+		t.Errorf("v0 tokens = %d/%d", r.PromptTokens, r.CompletionTokens)
+	}
+	// A variant with no outcomes reports zeros rather than NaN.
+	if (reports[1] != VariantReport{Variant: "v1"}) {
+		t.Errorf("v1 report = %+v, want zeros", reports[1])
+	}
+}
+
+// listingExperiments is an ExperimentStore that only lists, counting calls.
+type listingExperiments struct {
+	ExperimentStore
+	experiments []Experiment
+	lists       int
+}
+
+func (s *listingExperiments) ListExperiments(ctx context.Context, limit int32, nextToken string) (ListPage[Experiment], error) {
+	s.lists++
+	return ListPage[Experiment]{Items: s.experiments}, nil
+}
+
+func TestActiveExperimentIsCached(t *testing.T) {
+	store := &listingExperiments{experiments: []Experiment{{ID: "old", Status: ExperimentStopped}, weighted(UnitUser, 1, 1)}}
+	old := Experiments
+	Experiments = store
+	forgetActiveExperiment()
+	t.Cleanup(func() {
+		Experiments = old
+		forgetActiveExperiment()
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		e, err := ActiveExperiment(ctx)
+		if err != nil || e == nil || e.ID != "tone" {
+			t.Fatalf("ActiveExperiment = %+v, %v", e, err)
+		}
+	}
+	if store.lists != 1 {
+		t.Errorf("listed experiments %d times, want once", store.lists)
+	}
+
+	// Stopping it is seen at once on this instance.
+	store.experiments[1].Status = ExperimentStopped
+	forgetActiveExperiment()
+	if e, err := ActiveExperiment(ctx); err != nil || e != nil {
+		t.Errorf("after stop: %+v, %v", e, err)
+	}
+	if e, _ := ActiveExperiment(ctx); e != nil || store.lists != 2 {
+		t.Errorf("no running experiment was not cached: %d lists", store.lists)
+	}
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Conversation export. ExportConversation walks the transcript page by page
+// and hands each message to a renderer that writes it straight to the
+// output, so memory use does not grow with the conversation. It only uses
+// ListMessages, so every backend exports the same way.
+const (
+	ExportMarkdown = "markdown"
+	ExportHTML     = "html"
+	ExportJSON     = "json"
+	ExportPDF      = "pdf"
+)
+
+// ExportSchema identifies the JSON export format, documented on
+// ExportedConversation.
+const ExportSchema = "aichat.conversation-export/v1"
+
+// exportPageSize is how many messages each ListMessages call reads.
+const exportPageSize = 200
+
+var ErrUnknownExportFormat = errors.New("format must be markdown, html, json or pdf")
+
+// ExportedConversation is the JSON export (format "json"):
+//
+//	{
+//	  "schema": "aichat.conversation-export/v1",
+//	  "exportedAt": "2025-03-01T10:00:00Z",
+//	  "conversation": {
+//	    "id": "01J...", "title": "...", "createdAt": "...",
+//	    "lastMessageAt": "..." (omitted without messages),
+//	    "messageCount": 12
+//	  },
+//	  "messages": [
+//	    {
+//	      "id": "01J...", "role": "user" | "chatbot", "content": "...",
+//	      "createdAt": "...", "editedAt": "..." (only if edited),
+//	      "language": "en" (ISO 639-1, if detected),
+//	      "model": "..." (generated messages only)
+//	    }
+//	  ]
+//	}
+//
+// Times are RFC 3339 in UTC; messages are oldest first. Fields may be added
+// within v1; removing or changing one bumps the schema version.
+type ExportedConversation struct {
+	Schema       string            `json:"schema"`
+	ExportedAt   time.Time         `json:"exportedAt"`
+	Conversation ExportedHeader    `json:"conversation"`
+	Messages     []ExportedMessage `json:"messages"`
+}
+
+type ExportedHeader struct {
+	ID            string     `json:"id"`
+	Title         string     `json:"title"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	LastMessageAt *time.Time `json:"lastMessageAt,omitempty"`
+	MessageCount  int        `json:"messageCount"`
+}
+
+type ExportedMessage struct {
+	ID        string     `json:"id"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"createdAt"`
+	EditedAt  *time.Time `json:"editedAt,omitempty"`
+	Language  string     `json:"language,omitempty"`
+	Model     string     `json:"model,omitempty"`
+}
+
+// exportRenderer writes one format: begin once, message for each message
+// oldest first, then end.
+type exportRenderer interface {
+	begin(c Conversation, exportedAt time.Time) error
+	message(m ChatMessage) error
+	end() error
+}
+
+// ExportContentType returns the MIME type and file extension of format, or
+// ErrUnknownExportFormat.
+func ExportContentType(format string) (string, string, error) {
+	switch format {
+	case ExportMarkdown:
+		return "text/markdown; charset=utf-8", "md", nil
+	case ExportHTML:
+		return "text/html; charset=utf-8", "html", nil
+	case ExportJSON:
+		return "application/json", "json", nil
+	case ExportPDF:
+		return "application/pdf", "pdf", nil
+	}
+	return "", "", ErrUnknownExportFormat
+}
+
+// ExportConversation writes c's transcript to w in format. The caller
+// checks that the user owns c.
+func ExportConversation(ctx context.Context, w io.Writer, c Conversation, format string) error {
+	var r exportRenderer
+	switch format {
+	case ExportMarkdown:
+		r = &markdownExport{w: w}
+	case ExportHTML:
+		r = &htmlExport{w: w}
+	case ExportJSON:
+		r = &jsonExport{w: w}
+	case ExportPDF:
+		r = newPDFExport(w)
+	default:
+		return ErrUnknownExportFormat
+	}
+	if err := r.begin(c, time.Now().UTC()); err != nil {
+		return err
+	}
+	token := ""
+	for {
+		page, err := Store.ListMessages(ctx, c.ID, exportPageSize, token, false)
+		if err != nil {
+			return err
+		}
+		for _, m := range page.Items {
+			if err := r.message(m); err != nil {
+				return err
+			}
+		}
+		if page.NextToken == "" {
+			break
+		}
+		token = page.NextToken
+	}
+	return r.end()
+}
+
+// roleLabel is how transcripts name a message's author.
+func roleLabel(role string) string {
+	switch role {
+	case "user":
+		return "Student"
+	case "chatbot":
+		return "Chatbot"
+	case "":
+		return "Unknown"
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+func exportTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+func messageCount(c Conversation) string {
+	if c.MessageCount == 1 {
+		return "1 message"
+	}
+	return fmt.Sprintf("%d messages", c.MessageCount)
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func exportTitle(c Conversation) string {
+	if t := strings.TrimSpace(c.Title); t != "" {
+		return t
+	}
+	return "Conversation " + c.ID
+}
+
+// contentBlock is a run of message text or a fenced code block.
+type contentBlock struct {
+	code bool
+	lang string
+	text string
+}
+
+// splitFences splits Markdown content into text and ``` or ~~~ fenced code
+// blocks. An unclosed fence runs to the end.
+func splitFences(content string) []contentBlock {
+	var blocks []contentBlock
+	var cur []string
+	var fence string
+	code, lang := false, ""
+	flush := func() {
+		if len(cur) > 0 {
+			blocks = append(blocks, contentBlock{code: code, lang: lang, text: strings.Join(cur, "\n")})
+		}
+		cur = nil
+	}
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !code && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			flush()
+			fence = trimmed[:3]
+			code, lang = true, strings.TrimSpace(trimmed[3:])
+		case code && trimmed == fence:
+			if len(cur) == 0 {
+				cur = []string{""}
+			}
+			flush()
+			code, lang = false, ""
+		default:
+			cur = append(cur, line)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// ---------- Markdown ----------
+
+type markdownExport struct {
+	w io.Writer
+}
+
+func (e *markdownExport) begin(c Conversation, exportedAt time.Time) error {
+	_, err := fmt.Fprintf(e.w, "# %s\n\n_Exported %s · %s_\n",
+		exportTitle(c), exportTime(exportedAt), messageCount(c))
+	return err
+}
+
+func (e *markdownExport) message(m ChatMessage) error {
+	edited := ""
+	if m.EditedAt != nil {
+		edited = " (edited)"
+	}
+	_, err := fmt.Fprintf(e.w, "\n---\n\n**%s** · %s%s\n\n%s\n",
+		roleLabel(m.Role), exportTime(m.CreatedAt), edited, strings.TrimRight(m.Content, "\n"))
+	return err
+}
+
+func (e *markdownExport) end() error { return nil }
+
+// ---------- JSON ----------
+
+// jsonExport writes an ExportedConversation one message at a time.
+type jsonExport struct {
+	w     io.Writer
+	first bool
+}
+
+func (e *jsonExport) begin(c Conversation, exportedAt time.Time) error {
+	header, err := json.Marshal(ExportedHeader{
+		ID:            c.ID,
+		Title:         c.Title,
+		CreatedAt:     c.CreatedAt.UTC(),
+		LastMessageAt: utcPtr(c.LastMessageAt),
+		MessageCount:  c.MessageCount,
+	})
+	if err != nil {
+		return err
+	}
+	e.first = true
+	_, err = fmt.Fprintf(e.w, `{"schema":%q,"exportedAt":%q,"conversation":%s,"messages":[`,
+		ExportSchema, exportedAt.Format(time.RFC3339), header)
+	return err
+}
+
+func (e *jsonExport) message(m ChatMessage) error {
+	b, err := json.Marshal(ExportedMessage{
+		ID:        m.ID,
+		Role:      m.Role,
+		Content:   m.Content,
+		CreatedAt: m.CreatedAt.UTC(),
+		EditedAt:  utcPtr(m.EditedAt),
+		Language:  m.Language,
+		Model:     m.Model,
+	})
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if e.first {
+		sep, e.first = "", false
+	}
+	_, err = fmt.Fprintf(e.w, "%s\n%s", sep, b)
+	return err
+}
+
+func (e *jsonExport) end() error {
+	_, err := io.WriteString(e.w, "\n]}\n")
+	return err
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// The HTML export is one self-contained file: the stylesheet, including the
+// code highlighting classes, is inlined and nothing is loaded from elsewhere.
+// Message text keeps its line breaks; fenced code blocks are highlighted for
+// their language, or a guessed one.
+var (
+	codeStyle     = styles.Get("github")
+	codeFormatter = chromahtml.New(chromahtml.WithClasses(true), chromahtml.TabWidth(4))
+	inlineCode    = regexp.MustCompile("`([^`\n]+)`")
+)
+
+const exportCSS = `body{margin:0;background:#f6f7f9;color:#1f2328;font:15px/1.5 -apple-system,"Segoe UI",Helvetica,Arial,sans-serif}
+header,main{max-width:820px;margin:0 auto;padding:0 16px}
+header{padding-top:24px}h1{font-size:24px;margin:0 0 4px}.exported{color:#656d76;margin:0 0 16px}
+article{background:#fff;border:1px solid #d0d7de;border-radius:8px;padding:12px 16px;margin:0 0 12px}
+article.role-user{background:#eef6ff;border-color:#b6d7ff}
+.meta{color:#656d76;font-size:13px;margin-bottom:6px}.meta strong{color:#1f2328}
+.text{white-space:pre-wrap;overflow-wrap:anywhere}
+code{font:13px/1.45 ui-monospace,SFMono-Regular,Menlo,Consolas,monospace;background:#eff1f3;border-radius:4px;padding:1px 4px}
+pre{overflow-x:auto;border-radius:6px;padding:10px 12px}pre code{background:none;padding:0}
+@media print{body{background:#fff}article{break-inside:avoid}}
+`
+
+type htmlExport struct {
+	w io.Writer
+}
+
+func (e *htmlExport) begin(c Conversation, exportedAt time.Time) error {
+	title := html.EscapeString(exportTitle(c))
+	if _, err := fmt.Fprintf(e.w, "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n"+
+		"<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n<title>%s</title>\n<style>\n%s", title, exportCSS); err != nil {
+		return err
+	}
+	if err := codeFormatter.WriteCSS(e.w, codeStyle); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(e.w, "</style>\n</head>\n<body>\n<header>\n<h1>%s</h1>\n<p class=\"exported\">Exported %s · %s</p>\n</header>\n<main>\n",
+		title, exportTime(exportedAt), messageCount(c))
+	return err
+}
+
+func (e *htmlExport) message(m ChatMessage) error {
+	edited := ""
+	if m.EditedAt != nil {
+		edited = " · edited"
+	}
+	if _, err := fmt.Fprintf(e.w, "<article class=\"role-%s\">\n<div class=\"meta\"><strong>%s</strong> · <time datetime=\"%s\">%s</time>%s</div>\n",
+		html.EscapeString(m.Role), html.EscapeString(roleLabel(m.Role)),
+		m.CreatedAt.UTC().Format(time.RFC3339), exportTime(m.CreatedAt), edited); err != nil {
+		return err
+	}
+	for _, b := range splitFences(strings.Trim(m.Content, "\n")) {
+		if b.code {
+			if err := highlightCode(e.w, b.lang, b.text); err != nil {
+				return err
+			}
+			continue
+		}
+		text := inlineCode.ReplaceAllString(html.EscapeString(strings.Trim(b.text, "\n")), "<code>$1</code>")
+		if text == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(e.w, "<div class=\"text\">%s</div>\n", text); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(e.w, "</article>\n")
+	return err
+}
+
+func (e *htmlExport) end() error {
+	_, err := io.WriteString(e.w, "</main>\n</body>\n</html>\n")
+	return err
+}
+
+// highlightCode writes code as a highlighted <pre> block.
+func highlightCode(w io.Writer, lang, code string) error {
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code+"\n")
+	if err != nil {
+		return err
+	}
+	return codeFormatter.Format(w, codeStyle, tokens)
+}
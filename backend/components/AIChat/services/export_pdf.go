@@ -0,0 +1,411 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+// The PDF export is written by hand so that it streams: each page is
+// written as soon as it is full and only the page list, the cross-reference
+// table and the glyphs used so far are kept until the end, when the font
+// subsets are embedded. Characters the embedded font lacks (such as emoji)
+// show as an empty box.
+const (
+	pdfPageWidth  = 595.28 // A4, in points
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.0
+	pdfTextWidth  = pdfPageWidth - 2*pdfMargin
+	pdfCodeIndent = 12.0
+)
+
+// Object numbers fixed up front; pages, their contents and the info
+// dictionary come after.
+const (
+	pdfCatalogObj = 1
+	pdfPagesObj   = 2
+	pdfFontObj    = 3 // F1 text, F2 code; pdfFontObjs objects each
+	pdfFontObjs   = 5 // font, descendant font, descriptor, font file, ToUnicode
+	pdfTextFont   = 0
+	pdfCodeFont   = 1
+)
+
+// pdfStyle is how a line is set: font (pdfTextFont or pdfCodeFont),
+// weight, size, leading, indent and gray level.
+type pdfStyle struct {
+	font    int
+	bold    bool
+	size    float64
+	leading float64
+	indent  float64
+	gray    float64
+}
+
+var (
+	pdfTitle  = pdfStyle{font: pdfTextFont, bold: true, size: 16, leading: 22}
+	pdfMeta   = pdfStyle{font: pdfTextFont, size: 9, leading: 13, gray: 0.4}
+	pdfRole   = pdfStyle{font: pdfTextFont, bold: true, size: 10, leading: 15}
+	pdfBody   = pdfStyle{font: pdfTextFont, size: 10, leading: 14}
+	pdfCode   = pdfStyle{font: pdfCodeFont, size: 9, leading: 12, indent: pdfCodeIndent, gray: 0.2}
+	pdfFooter = pdfStyle{font: pdfTextFont, size: 8, gray: 0.5}
+)
+
+type pdfExport struct {
+	w       *countingWriter
+	offsets map[int]int64
+	next    int   // next free object number
+	pages   []int // page object numbers
+	page    bytes.Buffer
+	y       float64
+	title   string
+	fonts   []*ttFont
+	used    []map[uint16]rune // per font, the glyphs set and a character each shows
+}
+
+// pdfGlyph is a character set as a glyph of the style's font.
+type pdfGlyph struct {
+	r     rune
+	id    uint16
+	width float64 // in points
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newPDFExport(w io.Writer) *pdfExport {
+	return &pdfExport{
+		w:       &countingWriter{w: w},
+		offsets: map[int]int64{},
+		next:    pdfFontObj + 2*pdfFontObjs,
+		y:       pdfPageHeight - pdfMargin,
+	}
+}
+
+func (e *pdfExport) object(n int, body string) error {
+	e.offsets[n] = e.w.n
+	_, err := fmt.Fprintf(e.w, "%d 0 obj\n%s\nendobj\n", n, body)
+	return err
+}
+
+func (e *pdfExport) begin(c Conversation, exportedAt time.Time) error {
+	if _, err := io.WriteString(e.w, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"); err != nil {
+		return err
+	}
+	fonts, err := loadPDFFonts()
+	if err != nil {
+		return err
+	}
+	e.fonts = fonts
+	for range fonts {
+		e.used = append(e.used, map[uint16]rune{})
+	}
+	e.title = exportTitle(c)
+	if err := e.paragraph(pdfTitle, e.title); err != nil {
+		return err
+	}
+	return e.paragraph(pdfMeta, fmt.Sprintf("Exported %s · %s", exportTime(exportedAt), messageCount(c)))
+}
+
+func (e *pdfExport) message(m ChatMessage) error {
+	e.y -= 10
+	role := roleLabel(m.Role) + " · " + exportTime(m.CreatedAt)
+	if m.EditedAt != nil {
+		role += " · edited"
+	}
+	if err := e.paragraph(pdfRole, role); err != nil {
+		return err
+	}
+	for _, b := range splitFences(strings.Trim(m.Content, "\n")) {
+		style := pdfBody
+		if b.code {
+			style = pdfCode
+			e.y -= 3
+		}
+		if err := e.paragraph(style, b.text); err != nil {
+			return err
+		}
+		if b.code {
+			e.y -= 3
+		}
+	}
+	return nil
+}
+
+func (e *pdfExport) end() error {
+	if err := e.flushPage(); err != nil {
+		return err
+	}
+	for i := range e.fonts {
+		if err := e.font(i); err != nil {
+			return err
+		}
+	}
+	kids := make([]string, len(e.pages))
+	for i, p := range e.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", p)
+	}
+	if err := e.object(pdfPagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(e.pages))); err != nil {
+		return err
+	}
+	if err := e.object(pdfCatalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pdfPagesObj)); err != nil {
+		return err
+	}
+	info := e.next
+	e.next++
+	if err := e.object(info, "<< /Title "+pdfTextString(e.title)+" /Producer (AIChat export) >>"); err != nil {
+		return err
+	}
+
+	xref := e.w.n
+	var b strings.Builder
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", e.next)
+	for n := 1; n < e.next; n++ {
+		fmt.Fprintf(&b, "%010d 00000 n \n", e.offsets[n])
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", e.next, pdfCatalogObj, info, xref)
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+// paragraph sets text, wrapped to the text width, keeping its line breaks.
+func (e *pdfExport) paragraph(s pdfStyle, text string) error {
+	text = strings.ReplaceAll(text, "\t", "    ")
+	for _, line := range strings.Split(text, "\n") {
+		for _, l := range wrapPDFLine(e.glyphs(s, line), s) {
+			if err := e.line(s, l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// glyphs looks up text's characters in s's font, leaving out control
+// characters, and records them for the font subset.
+func (e *pdfExport) glyphs(s pdfStyle, text string) []pdfGlyph {
+	f := e.fonts[s.font]
+	var out []pdfGlyph
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			continue
+		}
+		id := f.glyph(r)
+		if _, ok := e.used[s.font][id]; !ok {
+			e.used[s.font][id] = r
+		}
+		out = append(out, pdfGlyph{r: r, id: id, width: float64(f.width(id)) * s.size / 1000})
+	}
+	return out
+}
+
+// line sets one line, starting a new page when this one is full.
+func (e *pdfExport) line(s pdfStyle, text []pdfGlyph) error {
+	if e.y-s.leading < pdfMargin {
+		if err := e.flushPage(); err != nil {
+			return err
+		}
+	}
+	e.y -= s.leading
+	if len(text) == 0 {
+		return nil
+	}
+	e.show(s, pdfMargin+s.indent, e.y, text)
+	return nil
+}
+
+// show adds text at x, y to the page. Bold is drawn by also stroking the
+// outlines, as the font has no bold face.
+func (e *pdfExport) show(s pdfStyle, x, y float64, text []pdfGlyph) {
+	mode := 0
+	if s.bold {
+		mode = 2
+		fmt.Fprintf(&e.page, "%.2f G %.2f w ", s.gray, s.size/30)
+	}
+	fmt.Fprintf(&e.page, "%.2f g BT /F%d %.1f Tf %d Tr 1 0 0 1 %.2f %.2f Tm <", s.gray, s.font+1, s.size, mode, x, y)
+	for _, g := range text {
+		fmt.Fprintf(&e.page, "%04X", g.id)
+	}
+	e.page.WriteString("> Tj ET\n")
+}
+
+// flushPage writes the current page with its page number and starts the
+// next one.
+func (e *pdfExport) flushPage() error {
+	number := len(e.pages) + 1
+	e.show(pdfFooter, pdfPageWidth/2, pdfMargin/2, e.glyphs(pdfFooter, fmt.Sprint(number)))
+
+	contents, page := e.next, e.next+1
+	e.next += 2
+	if err := e.stream(contents, "", e.page.Bytes()); err != nil {
+		return err
+	}
+	fonts := make([]string, len(e.fonts))
+	for i := range e.fonts {
+		fonts[i] = fmt.Sprintf("/F%d %d 0 R", i+1, pdfFontObj+i*pdfFontObjs)
+	}
+	if err := e.object(page, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+		pdfPagesObj, pdfPageWidth, pdfPageHeight, strings.Join(fonts, " "), contents)); err != nil {
+		return err
+	}
+	e.pages = append(e.pages, page)
+	e.page.Reset()
+	e.y = pdfPageHeight - pdfMargin
+	return nil
+}
+
+// stream writes object n as a compressed stream of data, with the extra
+// dictionary entries.
+func (e *pdfExport) stream(n int, extra string, data []byte) error {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return e.object(n, fmt.Sprintf("<< /Length %d /Filter /FlateDecode%s >>\nstream\n%s\nendstream", z.Len(), extra, z.Bytes()))
+}
+
+// font writes font i as a Type 0 font whose character codes are its glyph
+// IDs, with the subset of glyphs the export used and a ToUnicode map so
+// that the text can be copied and searched.
+func (e *pdfExport) font(i int) error {
+	f, used := e.fonts[i], e.used[i]
+	ids := slices.Sorted(maps.Keys(used))
+	name := subsetTag(ids) + "+" + f.name
+	obj := pdfFontObj + i*pdfFontObjs
+
+	if err := e.object(obj, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		name, obj+1, obj+4)); err != nil {
+		return err
+	}
+	var widths strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&widths, "%d [%d] ", id, f.width(id))
+	}
+	if err := e.object(obj+1, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /W [%s] /CIDToGIDMap /Identity >>",
+		name, obj+2, widths.String())); err != nil {
+		return err
+	}
+	b := f.bbox()
+	if err := e.object(obj+2, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		name, f.flags, b[0], b[1], b[2], b[3], f.ascent(), f.descent(), f.ascent(), obj+3)); err != nil {
+		return err
+	}
+	file := f.subset(ids)
+	if err := e.stream(obj+3, fmt.Sprintf(" /Length1 %d", len(file)), file); err != nil {
+		return err
+	}
+	return e.stream(obj+4, "", toUnicodeCMap(used))
+}
+
+// subsetTag is the six capital letters that name a font subset, derived
+// from its glyphs.
+func subsetTag(ids []uint16) string {
+	h := fnv.New32a()
+	for _, id := range ids {
+		h.Write([]byte{byte(id >> 8), byte(id)})
+	}
+	sum := h.Sum32()
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + byte(sum%26)
+		sum /= 26
+	}
+	return string(tag)
+}
+
+// toUnicodeCMap maps each glyph to the character it was set for.
+func toUnicodeCMap(used map[uint16]rune) []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	ids := slices.Sorted(maps.Keys(used))
+	ids = slices.DeleteFunc(ids, func(id uint16) bool { return id == 0 })
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), 100)]
+		ids = ids[len(chunk):]
+		fmt.Fprintf(&b, "%d beginbfchar\n", len(chunk))
+		for _, id := range chunk {
+			fmt.Fprintf(&b, "<%04X> <", id)
+			for _, u := range utf16.Encode([]rune{used[id]}) {
+				fmt.Fprintf(&b, "%04X", u)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
+
+// pdfTextString is s as a PDF text string: UTF-16 with a byte order mark.
+func pdfTextString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// breaksAfter reports whether a line may break after r without a space,
+// as in Chinese, Japanese and Korean text.
+func breaksAfter(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		r >= 0x3000 && r <= 0x303F || r >= 0xFF00 && r <= 0xFFEF
+}
+
+// wrapPDFLine breaks text at spaces (or after CJK characters) to fit the
+// text width, and inside words that are too long on their own.
+func wrapPDFLine(text []pdfGlyph, s pdfStyle) [][]pdfGlyph {
+	limit := pdfTextWidth - s.indent
+	var lines [][]pdfGlyph
+	start, lastBreak, width := 0, -1, 0.0
+	for i := 0; i < len(text); i++ {
+		if text[i].r == ' ' {
+			lastBreak = i
+		}
+		width += text[i].width
+		if width <= limit {
+			if breaksAfter(text[i].r) {
+				lastBreak = i + 1
+			}
+			continue
+		}
+		end := i
+		if lastBreak > start {
+			end = lastBreak
+		}
+		if end == start {
+			end = start + 1
+		}
+		lines = append(lines, text[start:end])
+		start = end
+		if start < len(text) && text[start].r == ' ' {
+			start++
+		}
+		i, lastBreak, width = start-1, -1, 0
+	}
+	return append(lines, text[start:])
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// The PDF export embeds WenQuanYi Micro Hei (fonts/LICENSE), a TrueType
+// collection covering Latin, Greek, Cyrillic, CJK and Hangul, with a
+// proportional and a monospaced face. Each export embeds a subset holding
+// only the glyphs it uses.
+//
+//go:embed fonts/wqy-microhei.ttc
+var wqyMicroHei []byte
+
+// ttFont is one face of a TrueType collection: the tables a PDF needs,
+// its character map and its glyph advances.
+type ttFont struct {
+	name       string // PostScript name
+	flags      int    // PDF font descriptor flags
+	tables     map[string][]byte
+	unitsPerEm int
+	glyphs     map[rune]uint16
+	advances   []uint16 // per glyph, in font units
+	numHMetric int
+}
+
+// loadPDFFonts parses the embedded faces once: F1 for text, F2 for code.
+var loadPDFFonts = sync.OnceValues(func() ([]*ttFont, error) {
+	sans, err := parseTTC(wqyMicroHei, 0, "WenQuanYiMicroHei", 4)
+	if err != nil {
+		return nil, err
+	}
+	mono, err := parseTTC(wqyMicroHei, 1, "WenQuanYiMicroHeiMono", 5)
+	if err != nil {
+		return nil, err
+	}
+	return []*ttFont{sans, mono}, nil
+})
+
+var errBadFont = errors.New("malformed TrueType font")
+
+func u16(b []byte, off int) uint16 { return binary.BigEndian.Uint16(b[off:]) }
+func u32(b []byte, off int) uint32 { return binary.BigEndian.Uint32(b[off:]) }
+
+// parseTTC reads face index of the TrueType collection data.
+func parseTTC(data []byte, index int, name string, flags int) (*ttFont, error) {
+	if len(data) < 12 || string(data[:4]) != "ttcf" || index >= int(u32(data, 8)) {
+		return nil, fmt.Errorf("%w: no face %d in the collection", errBadFont, index)
+	}
+	off := int(u32(data, 12+4*index))
+	if off+12 > len(data) {
+		return nil, errBadFont
+	}
+	f := &ttFont{name: name, flags: flags, tables: map[string][]byte{}}
+	for i := 0; i < int(u16(data, off+4)); i++ {
+		rec := off + 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, errBadFont
+		}
+		start, length := int(u32(data, rec+8)), int(u32(data, rec+12))
+		if start+length > len(data) {
+			return nil, errBadFont
+		}
+		f.tables[string(data[rec:rec+4])] = data[start : start+length]
+	}
+	for _, t := range []string{"head", "hhea", "hmtx", "maxp", "cmap", "loca", "glyf"} {
+		if f.tables[t] == nil {
+			return nil, fmt.Errorf("%w: %s has no %s table", errBadFont, name, t)
+		}
+	}
+	head := f.tables["head"]
+	if int16(u16(head, 50)) != 1 {
+		return nil, fmt.Errorf("%w: %s does not use long glyph offsets", errBadFont, name)
+	}
+	f.unitsPerEm = int(u16(head, 18))
+
+	numGlyphs := int(u16(f.tables["maxp"], 4))
+	f.numHMetric = int(u16(f.tables["hhea"], 34))
+	if f.numHMetric < 1 || len(f.tables["hmtx"]) < 4*f.numHMetric || len(f.tables["loca"]) < 4*(numGlyphs+1) {
+		return nil, errBadFont
+	}
+	f.advances = make([]uint16, numGlyphs)
+	for g := range f.advances {
+		f.advances[g] = u16(f.tables["hmtx"], 4*min(g, f.numHMetric-1))
+	}
+
+	var err error
+	f.glyphs, err = parseCmap(f.tables["cmap"], numGlyphs)
+	return f, err
+}
+
+// parseCmap reads the Windows full-Unicode (format 12) character map.
+func parseCmap(t []byte, numGlyphs int) (map[rune]uint16, error) {
+	for i := 0; i < int(u16(t, 2)); i++ {
+		rec := 4 + 8*i
+		platform, encoding, off := u16(t, rec), u16(t, rec+2), int(u32(t, rec+4))
+		if platform != 3 || encoding != 10 || u16(t, off) != 12 {
+			continue
+		}
+		m := map[rune]uint16{}
+		for g := 0; g < int(u32(t, off+12)); g++ {
+			p := off + 16 + 12*g
+			start, end, gid := u32(t, p), u32(t, p+4), u32(t, p+8)
+			for c := start; c <= end; c++ {
+				if id := gid + c - start; id < uint32(numGlyphs) {
+					m[rune(c)] = uint16(id)
+				}
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%w: no Unicode character map", errBadFont)
+}
+
+// glyph returns r's glyph, or 0 (.notdef, an empty box) if the font has
+// none.
+func (f *ttFont) glyph(r rune) uint16 {
+	return f.glyphs[r]
+}
+
+// width is g's advance in 1/1000 of the font size.
+func (f *ttFont) width(g uint16) int {
+	return int(f.advances[g]) * 1000 / f.unitsPerEm
+}
+
+// outline is g's entry in the glyf table.
+func (f *ttFont) outline(g uint16) []byte {
+	loca := f.tables["loca"]
+	return f.tables["glyf"][u32(loca, 4*int(g)):u32(loca, 4*int(g)+4)]
+}
+
+// bbox and ascent/descent are the font's, in 1/1000 of the font size.
+func (f *ttFont) bbox() [4]int {
+	head := f.tables["head"]
+	var b [4]int
+	for i := range b {
+		b[i] = int(int16(u16(head, 36+2*i))) * 1000 / f.unitsPerEm
+	}
+	return b
+}
+
+func (f *ttFont) ascent() int {
+	return int(int16(u16(f.tables["hhea"], 4))) * 1000 / f.unitsPerEm
+}
+
+func (f *ttFont) descent() int {
+	return int(int16(u16(f.tables["hhea"], 6))) * 1000 / f.unitsPerEm
+}
+
+// components are the glyphs a composite glyph is built from.
+func components(outline []byte) []uint16 {
+	if len(outline) < 10 || int16(u16(outline, 0)) >= 0 {
+		return nil
+	}
+	var out []uint16
+	for p := 10; p+4 <= len(outline); {
+		flags := u16(outline, p)
+		out = append(out, u16(outline, p+2))
+		p += 4
+		if flags&0x0001 != 0 { // ARG_1_AND_2_ARE_WORDS
+			p += 4
+		} else {
+			p += 2
+		}
+		switch {
+		case flags&0x0008 != 0: // WE_HAVE_A_SCALE
+			p += 2
+		case flags&0x0040 != 0: // WE_HAVE_AN_X_AND_Y_SCALE
+			p += 4
+		case flags&0x0080 != 0: // WE_HAVE_A_TWO_BY_TWO
+			p += 8
+		}
+		if flags&0x0020 == 0 { // MORE_COMPONENTS
+			break
+		}
+	}
+	return out
+}
+
+// subset returns a TrueType font with the outlines of used (and of the
+// glyphs their composites are built from) and empty glyphs otherwise.
+// Glyph IDs are kept, so the PDF maps character codes straight to them
+// (/CIDToGIDMap /Identity), and the font ends at the highest one used.
+func (f *ttFont) subset(used []uint16) []byte {
+	keep := map[uint16]bool{0: true}
+	todo := slices.Clone(used)
+	for len(todo) > 0 {
+		g := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if keep[g] {
+			continue
+		}
+		keep[g] = true
+		todo = append(todo, components(f.outline(g))...)
+	}
+	n := 0
+	for g := range keep {
+		n = max(n, int(g)+1)
+	}
+
+	var glyf []byte
+	loca := make([]byte, 4*(n+1))
+	hmtx := make([]byte, 4*n)
+	for g := 0; g < n; g++ {
+		binary.BigEndian.PutUint32(loca[4*g:], uint32(len(glyf)))
+		if !keep[uint16(g)] {
+			continue
+		}
+		glyf = append(glyf, f.outline(uint16(g))...)
+		for len(glyf)%4 != 0 {
+			glyf = append(glyf, 0)
+		}
+		binary.BigEndian.PutUint16(hmtx[4*g:], f.advances[g])
+		lsb := 4*f.numHMetric + 2*(g-f.numHMetric)
+		if g < f.numHMetric {
+			lsb = 4*g + 2
+		}
+		copy(hmtx[4*g+2:4*g+4], f.tables["hmtx"][lsb:])
+	}
+	binary.BigEndian.PutUint32(loca[4*n:], uint32(len(glyf)))
+
+	head := slices.Clone(f.tables["head"])
+	binary.BigEndian.PutUint32(head[8:], 0) // checkSumAdjustment, set below
+	hhea := slices.Clone(f.tables["hhea"])
+	binary.BigEndian.PutUint16(hhea[34:], uint16(n))
+	maxp := slices.Clone(f.tables["maxp"])
+	binary.BigEndian.PutUint16(maxp[4:], uint16(n))
+
+	tables := map[string][]byte{"head": head, "hhea": hhea, "maxp": maxp, "loca": loca, "glyf": glyf, "hmtx": hmtx, "cmap": emptyCmap}
+	for _, t := range []string{"cvt ", "fpgm", "prep"} {
+		if b := f.tables[t]; b != nil {
+			tables[t] = b
+		}
+	}
+	font := writeSFNT(tables)
+	headOff := int(u32(font, 12+16*slices.Index(sortedTags(tables), "head")+8))
+	binary.BigEndian.PutUint32(font[headOff+8:], 0xB1B0AFBA-sfntChecksum(font))
+	return font
+}
+
+// emptyCmap maps no characters. The PDF addresses glyphs by ID, but some
+// readers reject a font without a cmap table.
+var emptyCmap = []byte{
+	0, 0, 0, 1, // version, one subtable
+	0, 3, 0, 1, 0, 0, 0, 12, // Windows BMP, at offset 12
+	0, 4, 0, 24, 0, 0, // format 4, length, language
+	0, 2, 0, 2, 0, 0, 0, 0, // one segment
+	0xFF, 0xFF, 0, 0, 0xFF, 0xFF, // endCode, reservedPad, startCode
+	0, 1, 0, 0, // idDelta, idRangeOffset
+}
+
+func sortedTags(tables map[string][]byte) []string {
+	tags := make([]string, 0, len(tables))
+	for t := range tables {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// writeSFNT lays tables out as a TrueType font file.
+func writeSFNT(tables map[string][]byte) []byte {
+	tags := sortedTags(tables)
+	selector := 0
+	for 1<<(selector+1) <= len(tags) {
+		selector++
+	}
+	searchRange := 16 << selector
+
+	out := binary.BigEndian.AppendUint32(nil, 0x00010000)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(tags)))
+	out = binary.BigEndian.AppendUint16(out, uint16(searchRange))
+	out = binary.BigEndian.AppendUint16(out, uint16(selector))
+	out = binary.BigEndian.AppendUint16(out, uint16(16*len(tags)-searchRange))
+	off := len(out) + 16*len(tags)
+	for _, t := range tags {
+		b := tables[t]
+		out = append(out, t...)
+		out = binary.BigEndian.AppendUint32(out, sfntChecksum(b))
+		out = binary.BigEndian.AppendUint32(out, uint32(off))
+		out = binary.BigEndian.AppendUint32(out, uint32(len(b)))
+		off += (len(b) + 3) &^ 3
+	}
+	for _, t := range tags {
+		out = append(out, tables[t]...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+// sfntChecksum sums b as big-endian 32-bit words, zero padded.
+func sfntChecksum(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(b); i += 4 {
+		var w [4]byte
+		copy(w[:], b[i:])
+		sum += binary.BigEndian.Uint32(w[:])
+	}
+	return sum
+}
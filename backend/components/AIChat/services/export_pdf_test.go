@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pdfStreams returns the decompressed streams of pdf.
+func pdfStreams(t *testing.T, pdf []byte) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for _, m := range regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`).FindAllSubmatch(pdf, -1) {
+		r, err := zlib.NewReader(bytes.NewReader(m[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func TestPDFExportEmbedsTheGlyphsItUses(t *testing.T) {
+	setupImport(t)
+	ctx := context.Background()
+	id, err := Store.CreateConversation(ctx, "student-1", "光合作用")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range []string{"光合作用是什么？ Привет, Ünïcödé", "```\nprint('叶绿素')\n```"} {
+		m := ChatMessage{ID: GenerateULID(), ConversationID: id, UserID: "student-1", Role: "user", Content: content, CreatedAt: time.Now().Add(time.Duration(i) * time.Second)}
+		if err := Store.PutMessage(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := Store.GetConversation(ctx, "student-1", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ExportConversation(ctx, &buf, c, ExportPDF); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 100<<10 {
+		t.Errorf("export is %d bytes, want the fonts subset", buf.Len())
+	}
+
+	var cmaps string
+	fonts := 0
+	for _, s := range pdfStreams(t, buf.Bytes()) {
+		switch {
+		case bytes.HasPrefix(s, []byte{0, 1, 0, 0}):
+			fonts++
+			if sfntChecksum(s) != 0xB1B0AFBA {
+				t.Error("font subset checksum does not add up")
+			}
+		case bytes.Contains(s, []byte("beginbfchar")):
+			cmaps += string(s)
+		}
+	}
+	if fonts != 2 {
+		t.Errorf("embedded %d fonts, want the text and code faces", fonts)
+	}
+	// 光 and Ü in the text face, 叶 in the code face, so they can be copied.
+	for _, want := range []string{"<5149>", "<00DC>", "<53F6>"} {
+		if !strings.Contains(cmaps, want) {
+			t.Errorf("no ToUnicode entry for %s", want)
+		}
+	}
+}
+
+func TestPDFGlyphs(t *testing.T) {
+	e := newPDFExport(io.Discard)
+	if err := e.begin(Conversation{Title: "t"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []pdfStyle{pdfBody, pdfCode} {
+		for _, g := range e.glyphs(s, "光合作用 Привет Ünïcödé ひらがな 한국어\x07") {
+			if g.id == 0 {
+				t.Errorf("font %d has no glyph for %q", s.font, g.r)
+			}
+		}
+	}
+	if g := e.glyphs(pdfBody, "😀"); len(g) != 1 || g[0].id != 0 {
+		t.Errorf("emoji = %+v, want the missing glyph box", g)
+	}
+
+	// Chinese wraps between characters, with no spaces to break at.
+	text := e.glyphs(pdfBody, strings.Repeat("光合作用是植物利用光能的过程。", 10))
+	lines := wrapPDFLine(text, pdfBody)
+	if len(lines) < 2 {
+		t.Fatalf("%d lines, want the text wrapped", len(lines))
+	}
+	n := 0
+	for _, l := range lines {
+		width := 0.0
+		for _, g := range l {
+			width += g.width
+		}
+		if width > pdfTextWidth {
+			t.Errorf("line of %.1fpt, wider than %.1fpt", width, pdfTextWidth)
+		}
+		n += len(l)
+	}
+	if n != len(text) {
+		t.Errorf("wrapped %d of %d characters", n, len(text))
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	RatingUp   = "up"
+	RatingDown = "down"
+
+	ReasonIncorrect = "incorrect"
+	ReasonUnhelpful = "unhelpful"
+	ReasonUnsafe    = "unsafe"
+	ReasonOffTopic  = "off-topic"
+
+	maxFeedbackComment = 2000
+)
+
+// Feedback is a student's rating of one chatbot message. There is at most
+// one per message, owned by the conversation's user.
+type Feedback struct {
+	ConversationID string    `json:"conversationId"`
+	MessageID      string    `json:"messageId"`
+	UserID         string    `json:"userId"`
+	Rating         string    `json:"rating"`
+	Reason         string    `json:"reason,omitempty"`
+	Comment        string    `json:"comment,omitempty"`
+	ExperimentID   string    `json:"experimentId,omitempty"` // copied from the rated message
+	Variant        string    `json:"variant,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// FeedbackFilter narrows the admin listing; zero values match everything.
+type FeedbackFilter struct {
+	Rating       string
+	Reason       string
+	UserID       string
+	ExperimentID string
+	Since        time.Time
+	Until        time.Time
+}
+
+type FeedbackStore interface {
+	// PutFeedback creates or replaces the feedback on f.MessageID. It returns
+	// the previous feedback (nil if none) so callers can adjust aggregates.
+	PutFeedback(ctx context.Context, f Feedback) (stored Feedback, previous *Feedback, err error)
+	GetFeedback(ctx context.Context, conversationID, messageID string) (Feedback, error)
+	// ListFeedback returns feedback newest first.
+	ListFeedback(ctx context.Context, filter FeedbackFilter, limit int32, nextToken string) (ListPage[Feedback], error)
+}
+
+// Global, initialised alongside Store in InitDAL.
+var FeedbackItems FeedbackStore
+
+var (
+	ErrFeedbackNotFound = errors.New("feedback not found")
+	ErrNotMessageAuthor = errors.New("only the conversation's user can rate its messages")
+	ErrNotBotMessage    = errors.New("only chatbot messages can be rated")
+	ErrInvalidFeedback  = errors.New("invalid feedback")
+)
+
+func validRating(r string) bool { return r == RatingUp || r == RatingDown }
+
+func validReason(r string) bool {
+	switch r {
+	case ReasonIncorrect, ReasonUnhelpful, ReasonUnsafe, ReasonOffTopic:
+		return true
+	}
+	return false
+}
+
+// Validate checks the user-supplied fields of f.
+func (f Feedback) Validate() error {
+	if !validRating(f.Rating) {
+		return fmt.Errorf("%w: rating must be %q or %q", ErrInvalidFeedback, RatingUp, RatingDown)
+	}
+	if f.Reason != "" && !validReason(f.Reason) {
+		return fmt.Errorf("%w: reason must be one of %s, %s, %s, %s", ErrInvalidFeedback, ReasonIncorrect, ReasonUnhelpful, ReasonUnsafe, ReasonOffTopic)
+	}
+	if utf8.RuneCountInString(f.Comment) > maxFeedbackComment {
+		return fmt.Errorf("%w: comment is limited to %d characters", ErrInvalidFeedback, maxFeedbackComment)
+	}
+	return nil
+}
+
+// Validate checks the filter values that have a closed set of options.
+func (f FeedbackFilter) Validate() error {
+	if f.Rating != "" && !validRating(f.Rating) {
+		return fmt.Errorf("%w: rating must be %q or %q", ErrInvalidFeedback, RatingUp, RatingDown)
+	}
+	if f.Reason != "" && !validReason(f.Reason) {
+		return fmt.Errorf("%w: unknown reason %q", ErrInvalidFeedback, f.Reason)
+	}
+	return nil
+}
+
+// SubmitFeedback validates and stores userID's feedback on a chatbot message
+// and keeps the message's experiment thumbs counters in step with edits.
+func SubmitFeedback(ctx context.Context, userID string, f Feedback) (Feedback, error) {
+	if err := f.Validate(); err != nil {
+		return Feedback{}, err
+	}
+	msg, err := Store.GetMessage(ctx, f.ConversationID, f.MessageID)
+	if err != nil {
+		return Feedback{}, err
+	}
+	if msg.UserID != userID {
+		return Feedback{}, ErrNotMessageAuthor
+	}
+	if msg.Role != "chatbot" {
+		return Feedback{}, ErrNotBotMessage
+	}
+	f.UserID = userID
+	f.ExperimentID = msg.ExperimentID
+	f.Variant = msg.Variant
+
+	stored, prev, err := FeedbackItems.PutFeedback(ctx, f)
+	if err != nil {
+		return Feedback{}, err
+	}
+	if stored.ExperimentID != "" && Experiments != nil {
+		var delta Outcome
+		thumb(&delta, stored.Rating, 1)
+		if prev != nil {
+			thumb(&delta, prev.Rating, -1)
+		}
+		if delta != (Outcome{}) {
+			if err := Experiments.RecordOutcome(ctx, stored.ExperimentID, stored.Variant, delta); err != nil {
+				log.Printf("⚠️ Failed to record feedback outcome: %v", err)
+			}
+		}
+	}
+	return stored, nil
+}
+
+func thumb(o *Outcome, rating string, n int64) {
+	switch rating {
+	case RatingUp:
+		o.ThumbsUp += n
+	case RatingDown:
+		o.ThumbsDown += n
+	}
+}
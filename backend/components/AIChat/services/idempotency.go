@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Idempotency keys let clients retry a request without repeating it: the
+// first request with a key claims it, and once it has finished its response
+// is stored under the key for IdempotencyTTL, to be returned to every retry
+// instead of running the request again. A claim whose request never
+// finished (the Lambda timed out) can be taken over after
+// idempotencyLockTimeout. Keys are per user.
+type IdempotencyStore interface {
+	// Begin claims key for a request with fingerprint, a hash of what was
+	// sent. It returns the stored response if the request already finished,
+	// ErrIdempotencyInProgress while another claim is being processed, and
+	// ErrIdempotencyMismatch if the key was used for a different request.
+	Begin(ctx context.Context, userID, key, fingerprint string) (*StoredResponse, error)
+	// Complete stores the response of the request that claimed key.
+	Complete(ctx context.Context, userID, key string, resp StoredResponse) error
+	// Release drops the claim, so that a retry runs the request again.
+	Release(ctx context.Context, userID, key string) error
+}
+
+// StoredResponse is a response kept for replays.
+type StoredResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body"`
+}
+
+// Idempotency is set by InitDAL for every backend. While it is nil (before
+// InitDAL), keys are ignored.
+var Idempotency IdempotencyStore
+
+var (
+	ErrIdempotencyInProgress = errors.New("a request with this Idempotency-Key is still in progress")
+	ErrIdempotencyMismatch   = errors.New("this Idempotency-Key was used for a different request")
+)
+
+const (
+	// IdempotencyTTL is how long a key and its response are kept.
+	IdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is well past the API Lambda's timeout.
+	idempotencyLockTimeout = 2 * time.Minute
+)
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// backdater moves a key's claim and expiry d into the past.
+type backdater func(t *testing.T, userID, key string, d time.Duration)
+
+// testIdempotencyStore checks the claim rules every IdempotencyStore
+// shares with the DynamoDB one.
+func testIdempotencyStore(t *testing.T, s IdempotencyStore, backdate backdater) {
+	ctx := context.Background()
+	user := "idem-" + GenerateULID()
+	begin := func(key, fingerprint string) (*StoredResponse, error) {
+		t.Helper()
+		return s.Begin(ctx, user, key, fingerprint)
+	}
+	claims := func(key, fingerprint string) {
+		t.Helper()
+		if resp, err := begin(key, fingerprint); err != nil || resp != nil {
+			t.Fatalf("Begin(%s, %s) = %+v, %v; want a new claim", key, fingerprint, resp, err)
+		}
+	}
+	fails := func(key, fingerprint string, want error) {
+		t.Helper()
+		if resp, err := begin(key, fingerprint); !errors.Is(err, want) {
+			t.Fatalf("Begin(%s, %s) = %+v, %v; want %v", key, fingerprint, resp, err, want)
+		}
+	}
+
+	t.Run("ReplaysTheFinishedResponse", func(t *testing.T) {
+		claims("k1", "fp1")
+		fails("k1", "fp1", ErrIdempotencyInProgress)
+		fails("k1", "fp2", ErrIdempotencyMismatch)
+		want := StoredResponse{StatusCode: 200, Headers: map[string]string{"Content-Type": "application/json"}, Body: `{"ok":true}`}
+		if err := s.Complete(ctx, user, "k1", want); err != nil {
+			t.Fatal(err)
+		}
+		// Releasing a finished key keeps it.
+		if err := s.Release(ctx, user, "k1"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := begin("k1", "fp1")
+		if err != nil || got == nil || !reflect.DeepEqual(*got, want) {
+			t.Errorf("replay = %+v, %v; want %+v", got, err, want)
+		}
+		fails("k1", "fp2", ErrIdempotencyMismatch)
+	})
+
+	t.Run("ReleaseLetsTheRetryRun", func(t *testing.T) {
+		claims("k2", "fp1")
+		if err := s.Release(ctx, user, "k2"); err != nil {
+			t.Fatal(err)
+		}
+		claims("k2", "fp2")
+	})
+
+	t.Run("StaleClaimIsTakenOver", func(t *testing.T) {
+		claims("k3", "fp1")
+		backdate(t, user, "k3", idempotencyLockTimeout+time.Minute)
+		fails("k3", "fp2", ErrIdempotencyMismatch)
+		claims("k3", "fp1")
+		fails("k3", "fp1", ErrIdempotencyInProgress)
+	})
+
+	t.Run("ExpiredKeyIsReused", func(t *testing.T) {
+		claims("k4", "fp1")
+		if err := s.Complete(ctx, user, "k4", StoredResponse{StatusCode: 201, Body: "{}"}); err != nil {
+			t.Fatal(err)
+		}
+		backdate(t, user, "k4", IdempotencyTTL+time.Hour)
+		claims("k4", "fp2")
+	})
+
+	t.Run("KeysBelongToOneUser", func(t *testing.T) {
+		if resp, err := s.Begin(ctx, user+"-other", "k1", "fp2"); err != nil || resp != nil {
+			t.Errorf("another user's k1 = %+v, %v; want a new claim", resp, err)
+		}
+	})
+}
+
+func TestMemoryIdempotency(t *testing.T) {
+	m := NewMemoryIdempotency()
+	testIdempotencyStore(t, m, func(t *testing.T, userID, key string, d time.Duration) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		r := m.keys[[2]string{userID, key}]
+		r.startedAt, r.expiresAt = r.startedAt.Add(-d), r.expiresAt.Add(-d)
+		m.keys[[2]string{userID, key}] = r
+	})
+}
+
+func sqlBackdater(d *SQLDAL) backdater {
+	return func(t *testing.T, userID, key string, by time.Duration) {
+		_, err := d.db.Exec(d.q(`UPDATE idempotency_keys SET started_at = started_at - ?, expires_at = expires_at - ?
+			WHERE user_id = ? AND idem_key = ?`), int64(by), int64(by), userID, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSQLiteIdempotency(t *testing.T) {
+	d, err := NewSQLiteDAL(context.Background(), filepath.Join(t.TempDir(), "idempotency.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	testIdempotencyStore(t, &sqlIdempotencyStore{d: d}, sqlBackdater(d))
+}
+
+func TestPostgresIdempotency(t *testing.T) {
+	d := postgresDAL(t)
+	testIdempotencyStore(t, &sqlIdempotencyStore{d: d}, sqlBackdater(d))
+}
+
+func TestDynamoIdempotency(t *testing.T) {
+	client, table := dynamoLocal(t)
+	testIdempotencyStore(t, &dynamoIdempotencyStore{client: client, table: table}, func(t *testing.T, userID, key string, d time.Duration) {
+		at := time.Now().UTC().Add(-d)
+		_, err := client.UpdateItem(context.Background(), &ddb.UpdateItemInput{
+			TableName:        aws.String(table),
+			Key:              idempotencyKey(userID, key),
+			UpdateExpression: aws.String("SET startedAt = :at, expiresAt = :exp"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":at":  &types.AttributeValueMemberS{Value: at.Format(sortableTime)},
+				":exp": expiresAtAttr(at, IdempotencyTTL),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
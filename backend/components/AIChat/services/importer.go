@@ -0,0 +1,415 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// Importing history from other chat tools. Conversation and message IDs are
+// ULIDs derived from the user, the format and the IDs in the file (their
+// time part is the original timestamp), so importing the same file again
+// finds what is already stored and only adds what is missing.
+const (
+	// ImportChatGPT is the conversations.json of a ChatGPT data export. Only
+	// the branch that was current is imported, and only user and assistant
+	// text.
+	ImportChatGPT = "chatgpt"
+	// ImportJSONL has one message per line:
+	//
+	//	{"conversationId": "abc", "title": "Essay help", "id": "m1",
+	//	 "role": "user" | "assistant", "content": "...",
+	//	 "createdAt": "2024-05-01T09:30:00Z", "model": "..."}
+	//
+	// conversationId, role, content and createdAt are required; id, title
+	// (taken from a conversation's first line that has one) and model are
+	// optional. A conversation's lines must be consecutive.
+	ImportJSONL = "jsonl"
+)
+
+// importBatchSize is how many messages each PutMessages call stores.
+const importBatchSize = 500
+
+var (
+	ErrUnknownImportFormat = errors.New("format must be chatgpt or jsonl")
+	ErrInvalidImport       = errors.New("invalid import file")
+)
+
+// ImportProgress counts what an import has done so far.
+type ImportProgress struct {
+	Conversations int  `json:"conversations"` // read from the file
+	Created       int  `json:"created"`       // stored as new conversations
+	Skipped       int  `json:"skipped"`       // without messages, or in the trash
+	Messages      int  `json:"messages"`      // new messages stored
+	Duplicates    int  `json:"duplicates"`    // messages stored by an earlier import
+	Done          bool `json:"done"`
+}
+
+type sourceConversation struct {
+	id        string
+	title     string
+	createdAt time.Time
+	messages  []sourceMessage
+}
+
+type sourceMessage struct {
+	id        string
+	role      string // user or chatbot
+	content   string
+	model     string
+	createdAt time.Time
+}
+
+// Import reads a file in format into userID's conversations. progress, if
+// set, is called after each conversation and once more at the end. A
+// failed import can simply be run again.
+func Import(ctx context.Context, userID string, r io.Reader, format string, progress func(ImportProgress)) (ImportProgress, error) {
+	var read func(io.Reader, func(sourceConversation) error) error
+	switch format {
+	case ImportChatGPT:
+		read = readChatGPT
+	case ImportJSONL:
+		read = readJSONL
+	default:
+		return ImportProgress{}, ErrUnknownImportFormat
+	}
+	var p ImportProgress
+	err := read(r, func(src sourceConversation) error {
+		p.Conversations++
+		if err := importConversation(ctx, userID, format, src, &p); err != nil {
+			return fmt.Errorf("conversation %q: %w", src.title, err)
+		}
+		if progress != nil {
+			progress(p)
+		}
+		return nil
+	})
+	p.Done = err == nil
+	if progress != nil {
+		progress(p)
+	}
+	return p, err
+}
+
+func importConversation(ctx context.Context, userID, format string, src sourceConversation, p *ImportProgress) error {
+	if len(src.messages) == 0 {
+		p.Skipped++
+		return nil
+	}
+	// Keep the file's order even where timestamps repeat or are missing.
+	for i := 1; i < len(src.messages); i++ {
+		if prev := src.messages[i-1].createdAt; !src.messages[i].createdAt.After(prev) {
+			src.messages[i].createdAt = prev.Add(time.Millisecond)
+		}
+	}
+	if src.createdAt.IsZero() || src.createdAt.After(src.messages[0].createdAt) {
+		src.createdAt = src.messages[0].createdAt
+	}
+
+	c := Conversation{
+		ID:        importID(src.createdAt, "conversation", userID, format, src.id),
+		UserID:    userID,
+		Title:     importTitle(src.title),
+		CreatedAt: src.createdAt,
+	}
+	created, err := Store.ImportConversation(ctx, c)
+	if err != nil {
+		return err
+	}
+	stored := map[string]bool{}
+	if created {
+		p.Created++
+	} else {
+		existing, err := Store.GetConversation(ctx, userID, c.ID)
+		if err != nil {
+			return err
+		}
+		if existing.DeletedAt != nil {
+			p.Skipped++
+			return nil
+		}
+		token := ""
+		for {
+			page, err := Store.ListMessages(ctx, c.ID, importBatchSize, token, false)
+			if err != nil {
+				return err
+			}
+			for _, m := range page.Items {
+				stored[m.ID] = true
+			}
+			if page.NextToken == "" {
+				break
+			}
+			token = page.NextToken
+		}
+	}
+
+	var batch []ChatMessage
+	for _, sm := range src.messages {
+		m := ChatMessage{
+			ID:             importID(sm.createdAt, "message", userID, format, src.id, sm.id),
+			ConversationID: c.ID,
+			UserID:         userID,
+			Role:           sm.role,
+			Content:        sm.content,
+			CreatedAt:      sm.createdAt,
+			Model:          sm.model,
+		}
+		if stored[m.ID] {
+			p.Duplicates++
+			continue
+		}
+		if m.Role == "user" {
+			m.Language = DetectLanguage(m.Content)
+		}
+		batch = append(batch, m)
+	}
+	for chunk := range slices.Chunk(batch, importBatchSize) {
+		if err := Store.PutMessages(ctx, chunk); err != nil {
+			return err
+		}
+		p.Messages += len(chunk)
+	}
+	return nil
+}
+
+// importID is a ULID for at whose random part is a hash of parts.
+func importID(at time.Time, parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	id, err := ulid.New(uint64(max(at.UnixMilli(), 0)), bytes.NewReader(sum[:]))
+	if err != nil {
+		// Beyond the year 10889.
+		id = ulid.MustNew(ulid.MaxTime(), bytes.NewReader(sum[:]))
+	}
+	return id.String()
+}
+
+func importTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "Imported conversation"
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength])
+	}
+	return title
+}
+
+func importRole(role string) (string, bool) {
+	switch role {
+	case "user":
+		return "user", true
+	case "assistant", "chatbot":
+		return "chatbot", true
+	}
+	return "", false
+}
+
+// ---------- ChatGPT conversations.json ----------
+
+type chatGPTConversation struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"`
+	Title          string                 `json:"title"`
+	CreateTime     float64                `json:"create_time"`
+	CurrentNode    string                 `json:"current_node"`
+	Mapping        map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	Parent  string `json:"parent"`
+	Message *struct {
+		ID     string `json:"id"`
+		Author struct {
+			Role string `json:"role"`
+		} `json:"author"`
+		CreateTime *float64 `json:"create_time"`
+		Content    struct {
+			ContentType string            `json:"content_type"`
+			Parts       []json.RawMessage `json:"parts"`
+			Text        string            `json:"text"`
+		} `json:"content"`
+		Metadata struct {
+			ModelSlug string `json:"model_slug"`
+		} `json:"metadata"`
+	} `json:"message"`
+}
+
+// readChatGPT decodes the export's top-level array one conversation at a
+// time, so large exports are not held in memory.
+func readChatGPT(r io.Reader, fn func(sourceConversation) error) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return fmt.Errorf("%w: expected a JSON array of conversations", ErrInvalidImport)
+	}
+	for dec.More() {
+		var c chatGPTConversation
+		if err := dec.Decode(&c); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		if err := fn(c.source()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c chatGPTConversation) source() sourceConversation {
+	src := sourceConversation{id: c.ID, title: c.Title, createdAt: unixSeconds(c.CreateTime)}
+	if src.id == "" {
+		src.id = c.ConversationID
+	}
+	if src.id == "" {
+		src.id = fmt.Sprintf("%s@%v", c.Title, c.CreateTime)
+	}
+
+	// The conversation is a tree of edits and regenerations; the current
+	// node's ancestors are the branch the user last saw.
+	var path []chatGPTNode
+	seen := map[string]bool{}
+	for id := c.CurrentNode; id != "" && !seen[id]; {
+		seen[id] = true
+		n, ok := c.Mapping[id]
+		if !ok {
+			break
+		}
+		path = append(path, n)
+		id = n.Parent
+	}
+	slices.Reverse(path)
+
+	for _, n := range path {
+		m := n.Message
+		if m == nil {
+			continue
+		}
+		role, ok := importRole(m.Author.Role)
+		if !ok {
+			continue
+		}
+		var content string
+		switch m.Content.ContentType {
+		case "text", "multimodal_text":
+			var parts []string
+			for _, raw := range m.Content.Parts {
+				var s string
+				if json.Unmarshal(raw, &s) == nil && strings.TrimSpace(s) != "" {
+					parts = append(parts, s)
+				}
+			}
+			content = strings.Join(parts, "\n\n")
+		case "code":
+			content = "```\n" + m.Content.Text + "\n```"
+		}
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		at := src.createdAt
+		if m.CreateTime != nil {
+			at = unixSeconds(*m.CreateTime)
+		}
+		sm := sourceMessage{id: m.ID, role: role, content: content, createdAt: at}
+		if role == "chatbot" {
+			sm.model = m.Metadata.ModelSlug
+		}
+		src.messages = append(src.messages, sm)
+	}
+	return src
+}
+
+func unixSeconds(s float64) time.Time {
+	if s <= 0 {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(s)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC().Truncate(time.Microsecond)
+}
+
+// ---------- Generic JSONL ----------
+
+type jsonlMessage struct {
+	ConversationID string    `json:"conversationId"`
+	Title          string    `json:"title"`
+	ID             string    `json:"id"`
+	Role           string    `json:"role"`
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Model          string    `json:"model"`
+}
+
+// maxJSONLLine bounds one line of a JSONL import.
+const maxJSONLLine = 4 << 20
+
+func readJSONL(r io.Reader, fn func(sourceConversation) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxJSONLLine)
+	var cur *sourceConversation
+	done := map[string]bool{}
+	flush := func() error {
+		if cur == nil {
+			return nil
+		}
+		done[cur.id] = true
+		// Lines need not be in time order; the file's order breaks ties.
+		slices.SortStableFunc(cur.messages, func(a, b sourceMessage) int { return a.createdAt.Compare(b.createdAt) })
+		err := fn(*cur)
+		cur = nil
+		return err
+	}
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var m jsonlMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrInvalidImport, line, err)
+		}
+		role, ok := importRole(m.Role)
+		switch {
+		case m.ConversationID == "":
+			return fmt.Errorf("%w: line %d: conversationId is required", ErrInvalidImport, line)
+		case !ok:
+			return fmt.Errorf("%w: line %d: role must be user or assistant", ErrInvalidImport, line)
+		case m.CreatedAt.IsZero():
+			return fmt.Errorf("%w: line %d: createdAt is required", ErrInvalidImport, line)
+		}
+		if cur == nil || cur.id != m.ConversationID {
+			if err := flush(); err != nil {
+				return err
+			}
+			if done[m.ConversationID] {
+				return fmt.Errorf("%w: line %d: the lines of conversation %q are not consecutive", ErrInvalidImport, line, m.ConversationID)
+			}
+			cur = &sourceConversation{id: m.ConversationID}
+		}
+		if cur.title == "" {
+			cur.title = m.Title
+		}
+		id := m.ID
+		if id == "" {
+			sum := sha256.Sum256([]byte(m.CreatedAt.UTC().Format(time.RFC3339Nano) + "\x00" + role + "\x00" + m.Content))
+			id = fmt.Sprintf("%x", sum[:12])
+		}
+		if strings.TrimSpace(m.Content) == "" {
+			continue
+		}
+		cur.messages = append(cur.messages, sourceMessage{id: id, role: role, content: m.Content, model: m.Model, createdAt: m.CreatedAt.UTC()})
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	return flush()
+}
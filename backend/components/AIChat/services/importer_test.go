@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupImport runs imports against an empty in-memory DAL.
+func setupImport(t *testing.T) {
+	t.Helper()
+	old := Store
+	t.Cleanup(func() { Store = old })
+	Store = NewMemoryDAL()
+}
+
+func importFile(t *testing.T, userID, path, format string) ImportProgress {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := Import(context.Background(), userID, f, format, nil)
+	if err != nil {
+		t.Fatalf("Import(%s): %v", path, err)
+	}
+	return p
+}
+
+// importedConversations returns userID's active conversations by title and
+// their messages oldest first.
+func importedConversations(t *testing.T, userID string) (map[string]Conversation, map[string][]ChatMessage) {
+	t.Helper()
+	ctx := context.Background()
+	page, err := Store.ListConversations(ctx, userID, 50, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	convs, msgs := map[string]Conversation{}, map[string][]ChatMessage{}
+	for _, c := range page.Items {
+		convs[c.Title] = c
+		mp, err := Store.ListMessages(ctx, c.ID, 50, "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs[c.Title] = mp.Items
+	}
+	return convs, msgs
+}
+
+func TestImportChatGPT(t *testing.T) {
+	setupImport(t)
+	p := importFile(t, "student-1", "testdata/import/chatgpt.json", ImportChatGPT)
+	want := ImportProgress{Conversations: 2, Created: 1, Skipped: 1, Messages: 4, Done: true}
+	if p != want {
+		t.Errorf("progress = %+v, want %+v (the system-only conversation is skipped)", p, want)
+	}
+
+	convs, msgs := importedConversations(t, "student-1")
+	c, ok := convs["Photosynthesis"]
+	if len(convs) != 1 || !ok {
+		t.Fatalf("conversations = %+v", convs)
+	}
+	if !c.CreatedAt.Equal(time.Unix(1714550400, 0)) {
+		t.Errorf("createdAt = %v", c.CreatedAt)
+	}
+	// The current branch only: the regenerated answer, the system prompt,
+	// the tool message and the browsing display are left out.
+	wantMsgs := []struct{ role, content, model string }{
+		{"user", "What is photosynthesis?", ""},
+		{"chatbot", "Plants turn light into sugar.", "gpt-4o"},
+		{"user", "Look at this leaf\n\nWhy is it green?", ""},
+		{"chatbot", "```\nprint('chlorophyll')\n```", ""},
+	}
+	got := msgs["Photosynthesis"]
+	if len(got) != len(wantMsgs) {
+		t.Fatalf("messages = %+v", got)
+	}
+	for i, w := range wantMsgs {
+		if got[i].Role != w.role || got[i].Content != w.content || got[i].Model != w.model {
+			t.Errorf("message %d = %s %q (%s), want %s %q (%s)", i, got[i].Role, got[i].Content, got[i].Model, w.role, w.content, w.model)
+		}
+	}
+	if !got[0].CreatedAt.Equal(time.Unix(1714550410, 5e8)) {
+		t.Errorf("first message at %v, want its create_time", got[0].CreatedAt)
+	}
+}
+
+func TestImportJSONL(t *testing.T) {
+	setupImport(t)
+	p := importFile(t, "student-1", "testdata/import/history.jsonl", ImportJSONL)
+	want := ImportProgress{Conversations: 2, Created: 2, Messages: 4, Done: true}
+	if p != want {
+		t.Errorf("progress = %+v, want %+v", p, want)
+	}
+
+	convs, msgs := importedConversations(t, "student-1")
+	if _, ok := convs["Essay help"]; !ok || len(convs) != 2 {
+		t.Fatalf("conversations = %+v, want the essay titled from its second line", convs)
+	}
+	essay := msgs["Essay help"]
+	if len(essay) != 2 || essay[0].Content != "How do I start my essay?" || essay[1].Model != "gpt-4o-mini" {
+		t.Errorf("essay messages = %+v, want them in time order without the blank line", essay)
+	}
+	// Equal timestamps keep the file's order.
+	maths := msgs["Imported conversation"]
+	if len(maths) != 2 || maths[0].Role != "user" || maths[1].Role != "chatbot" || !maths[1].CreatedAt.After(maths[0].CreatedAt) {
+		t.Errorf("maths messages = %+v", maths)
+	}
+}
+
+func TestImportIsIdempotent(t *testing.T) {
+	setupImport(t)
+	ctx := context.Background()
+	// The first export ends before the maths answer.
+	partial := strings.Join(strings.Split(readFixture(t, "testdata/import/history.jsonl"), "\n")[:5], "\n")
+	if _, err := Import(ctx, "student-1", strings.NewReader(partial), ImportJSONL, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p := importFile(t, "student-1", "testdata/import/history.jsonl", ImportJSONL)
+	want := ImportProgress{Conversations: 2, Messages: 1, Duplicates: 3, Done: true}
+	if p != want {
+		t.Errorf("second import = %+v, want %+v", p, want)
+	}
+	convs, msgs := importedConversations(t, "student-1")
+	if len(convs) != 2 || len(msgs["Essay help"]) != 2 || len(msgs["Imported conversation"]) != 2 {
+		t.Errorf("after the second import: %d conversations, messages %+v", len(convs), msgs)
+	}
+
+	// Another user importing the same file gets their own copies.
+	if p := importFile(t, "student-2", "testdata/import/history.jsonl", ImportJSONL); p.Created != 2 || p.Messages != 4 {
+		t.Errorf("another user's import = %+v", p)
+	}
+
+	// A conversation the user trashed is not brought back.
+	if err := Store.TrashConversation(ctx, "student-1", convs["Essay help"].ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	p = importFile(t, "student-1", "testdata/import/history.jsonl", ImportJSONL)
+	want = ImportProgress{Conversations: 2, Skipped: 1, Duplicates: 2, Done: true}
+	if p != want {
+		t.Errorf("import after trashing = %+v, want %+v", p, want)
+	}
+	if convs, _ := importedConversations(t, "student-1"); len(convs) != 1 {
+		t.Errorf("active conversations = %+v, want the trashed one to stay in the trash", convs)
+	}
+}
+
+func readFixture(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestImportRejectsInvalidFiles(t *testing.T) {
+	setupImport(t)
+	cases := []struct {
+		name, format, file, want string
+	}{
+		{"NonConsecutive", ImportJSONL, `{"conversationId": "a", "role": "user", "content": "1", "createdAt": "2024-05-01T09:30:00Z"}
+{"conversationId": "b", "role": "user", "content": "2", "createdAt": "2024-05-01T09:31:00Z"}
+{"conversationId": "a", "role": "user", "content": "3", "createdAt": "2024-05-01T09:32:00Z"}`, `line 3: the lines of conversation "a" are not consecutive`},
+		{"UnknownRole", ImportJSONL, `{"conversationId": "a", "role": "system", "content": "1", "createdAt": "2024-05-01T09:30:00Z"}`, "line 1: role must be user or assistant"},
+		{"MissingCreatedAt", ImportJSONL, `{"conversationId": "a", "role": "user", "content": "1"}`, "line 1: createdAt is required"},
+		{"NotJSON", ImportJSONL, `{"conversationId": `, "line 1"},
+		{"NotAnArray", ImportChatGPT, `{"title": "one conversation"}`, "expected a JSON array"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := Import(context.Background(), "student-1", strings.NewReader(c.file), c.format, nil)
+			if !errors.Is(err, ErrInvalidImport) || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("err = %v, want ErrInvalidImport mentioning %q", err, c.want)
+			}
+			if p.Done {
+				t.Error("a failed import reports Done")
+			}
+		})
+	}
+	if _, err := Import(context.Background(), "student-1", strings.NewReader(""), "csv", nil); !errors.Is(err, ErrUnknownImportFormat) {
+		t.Errorf("unknown format: %v", err)
+	}
+}
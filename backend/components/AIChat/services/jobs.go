@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Async generation: the send endpoint stores the student's message, creates
+// a pending Job and enqueues its ID. A worker claims it (running), answers
+// it and marks the job done (with the chatbot message ID) or failed.
+// Clients poll the job.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// jobClaimTimeout is how long a running job stays claimed. It covers the
+// worker Lambda's timeout, so an older claim belongs to a worker that died.
+const jobClaimTimeout = 2 * time.Minute
+
+type Job struct {
+	ID             string    `json:"jobId"`
+	UserID         string    `json:"userId"`
+	ConversationID string    `json:"conversationId"`
+	UserMessageID  string    `json:"userMessageId"`
+	Institution    string    `json:"-"` // selects PII recognizers in the worker
+	Status         string    `json:"status"`
+	MessageID      string    `json:"messageId,omitempty"` // chatbot answer, once done
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+type JobStore interface {
+	CreateJob(ctx context.Context, j Job) error
+	GetJob(ctx context.Context, id string) (Job, error)
+	// ClaimJob moves a pending job, or one whose claim is older than
+	// staleBefore, to running. It returns ErrJobFinished if the job is done
+	// or failed and ErrJobClaimed if another worker holds it.
+	ClaimJob(ctx context.Context, id string, staleBefore time.Time) error
+	// ReleaseJob moves a running job back to pending so a redelivery can
+	// claim it straight away.
+	ReleaseJob(ctx context.Context, id string) error
+	// FinishJob moves a pending or running job to done or failed. It returns
+	// ErrJobFinished if the job already finished (a redelivered message).
+	FinishJob(ctx context.Context, id, status, messageID, errMsg string) error
+}
+
+// JobQueue hands job IDs to the worker.
+type JobQueue interface {
+	Enqueue(ctx context.Context, jobID string) error
+}
+
+// Globals: Jobs is initialised alongside Store in InitDAL, Queue by InitQueue.
+var (
+	Jobs  JobStore
+	Queue JobQueue
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+	ErrJobClaimed  = errors.New("job is being processed by another worker")
+)
+
+// jobMessage is the queue payload.
+type jobMessage struct {
+	JobID string `json:"jobId"`
+}
+
+// EnqueueGeneration records a pending job for userMsg, which must already be
+// stored, and queues it. The institution on ctx's ChatScope travels with it.
+func EnqueueGeneration(ctx context.Context, userMsg ChatMessage) (Job, error) {
+	if Jobs == nil || Queue == nil {
+		return Job{}, errors.New("async generation is not configured")
+	}
+	now := time.Now().UTC()
+	j := Job{
+		ID:             GenerateULID(),
+		UserID:         userMsg.UserID,
+		ConversationID: userMsg.ConversationID,
+		UserMessageID:  userMsg.ID,
+		Institution:    chatScopeFrom(ctx).Institution,
+		Status:         JobPending,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := Jobs.CreateJob(ctx, j); err != nil {
+		return Job{}, err
+	}
+	if err := Queue.Enqueue(ctx, j.ID); err != nil {
+		_ = Jobs.FinishJob(ctx, j.ID, JobFailed, "", "could not queue job")
+		return Job{}, err
+	}
+	return j, nil
+}
+
+// ProcessJob claims and answers a pending job, so a redelivered queue
+// message never stores or bills a second answer. Generation failures mark
+// the job failed and return nil, since retrying will not help the student
+// who is polling; storage errors release the claim and are returned so the
+// queue redelivers. A job claimed by a live worker returns ErrJobClaimed,
+// which the queue also retries until that worker finishes.
+func ProcessJob(ctx context.Context, jobID string) error {
+	err := Jobs.ClaimJob(ctx, jobID, time.Now().Add(-jobClaimTimeout))
+	if errors.Is(err, ErrJobFinished) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := processClaimedJob(ctx, jobID); err != nil {
+		if rerr := Jobs.ReleaseJob(ctx, jobID); rerr != nil {
+			log.Printf("⚠️ Job %s: could not release claim: %v", jobID, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+func processClaimedJob(ctx context.Context, jobID string) error {
+	j, err := Jobs.GetJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	conv, err := Store.GetConversation(ctx, j.UserID, j.ConversationID)
+	if errors.Is(err, ErrConversationNotFound) || (err == nil && conv.DeletedAt != nil) {
+		return finishJob(ctx, jobID, JobFailed, "", "Conversation was deleted")
+	}
+	if err != nil {
+		return err
+	}
+	userMsg, err := Store.GetMessage(ctx, j.ConversationID, j.UserMessageID)
+	if err != nil {
+		return err
+	}
+	ctx = WithChatScope(ctx, ChatScope{Institution: j.Institution})
+
+	// The student's own message is already stored, so the latest earlier
+	// message decides the follow-up credit.
+	if prev, err := Store.ListMessages(ctx, j.ConversationID, 2, "", true); err == nil {
+		for _, m := range prev.Items {
+			if m.ID != userMsg.ID {
+				RecordFollowUp(ctx, m)
+				break
+			}
+		}
+	}
+
+	reply, err := GenerateReply(ctx, j.UserID, j.ConversationID, userMsg.Content)
+	if err != nil {
+		log.Printf("❌ Job %s failed: %v", jobID, err)
+		return finishJob(ctx, jobID, JobFailed, "", "Failed to generate reply: "+err.Error())
+	}
+	botMsg := ChatMessage{
+		ID:             GenerateULID(),
+		ConversationID: j.ConversationID,
+		UserID:         j.UserID,
+		Role:           "chatbot",
+		Content:        reply.Content,
+		CreatedAt:      time.Now().UTC(),
+	}
+	reply.Stamp(&botMsg)
+	err = Store.PutMessage(ctx, botMsg)
+	if errors.Is(err, ErrConversationNotFound) {
+		return finishJob(ctx, jobID, JobFailed, "", "Conversation was deleted")
+	}
+	if err != nil {
+		return err
+	}
+	if err := finishJob(ctx, jobID, JobDone, botMsg.ID, ""); err != nil {
+		return err
+	}
+	NotifyMessage(ctx, botMsg, "")
+	return nil
+}
+
+func finishJob(ctx context.Context, id, status, messageID, errMsg string) error {
+	err := Jobs.FinishJob(ctx, id, status, messageID, errMsg)
+	if errors.Is(err, ErrJobFinished) {
+		log.Printf("⚠️ Job %s was finished by another delivery", id)
+		return nil
+	}
+	return err
+}
+
+// sqsQueue sends job IDs to the SQS queue that triggers the worker Lambda.
+type sqsQueue struct {
+	client *sqs.Client
+	url    string
+}
+
+func (q *sqsQueue) Enqueue(ctx context.Context, jobID string) error {
+	body, _ := json.Marshal(jobMessage{JobID: jobID})
+	_, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(string(body)),
+	})
+	return err
+}
+
+// ParseJobMessage extracts the job ID from a queue message body.
+func ParseJobMessage(body string) (string, error) {
+	var m jobMessage
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		return "", err
+	}
+	if m.JobID == "" {
+		return "", errors.New("queue message has no jobId")
+	}
+	return m.JobID, nil
+}
+
+// LocalQueue runs jobs on goroutines in this process. It stands in for SQS
+// and the worker Lambda when running locally; jobs are lost on exit.
+type LocalQueue struct {
+	jobs chan string
+}
+
+// NewLocalQueue starts workers goroutines that call ProcessJob.
+func NewLocalQueue(workers int) *LocalQueue {
+	q := &LocalQueue{jobs: make(chan string, 100)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for id := range q.jobs {
+				if err := ProcessJob(context.Background(), id); err != nil {
+					log.Printf("❌ Local job %s: %v", id, err)
+				}
+			}
+		}()
+	}
+	return q
+}
+
+func (q *LocalQueue) Enqueue(ctx context.Context, jobID string) error {
+	select {
+	case q.jobs <- jobID:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// InitQueue uses SQS when JOB_QUEUE_URL is set and an in-process queue with
+// LOCAL_JOB_WORKERS goroutines (default 2) otherwise.
+func InitQueue() error {
+	if url := os.Getenv("JOB_QUEUE_URL"); url != "" {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return err
+		}
+		Queue = &sqsQueue{client: sqs.NewFromConfig(cfg), url: url}
+		return nil
+	}
+	workers := 2
+	if v := os.Getenv("LOCAL_JOB_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("LOCAL_JOB_WORKERS must be a positive integer, got %q", v)
+		}
+		workers = n
+	}
+	Queue = NewLocalQueue(workers)
+	return nil
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryJobs is a JobStore with the same transitions as the DynamoDB one.
+type memoryJobs struct {
+	mu      sync.Mutex
+	jobs    map[string]Job
+	claimed map[string]time.Time
+}
+
+func newMemoryJobs() *memoryJobs {
+	return &memoryJobs{jobs: map[string]Job{}, claimed: map[string]time.Time{}}
+}
+
+func (s *memoryJobs) CreateJob(ctx context.Context, j Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = j
+	return nil
+}
+
+func (s *memoryJobs) GetJob(ctx context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return j, nil
+}
+
+func (s *memoryJobs) ClaimJob(ctx context.Context, id string, staleBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	switch {
+	case !ok:
+		return ErrJobNotFound
+	case j.Status == JobRunning && !s.claimed[id].Before(staleBefore):
+		return ErrJobClaimed
+	case j.Status != JobPending && j.Status != JobRunning:
+		return ErrJobFinished
+	}
+	j.Status = JobRunning
+	s.jobs[id], s.claimed[id] = j, time.Now()
+	return nil
+}
+
+func (s *memoryJobs) ReleaseJob(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[id]
+	if j.Status != JobRunning {
+		return ErrJobFinished
+	}
+	j.Status = JobPending
+	s.jobs[id] = j
+	return nil
+}
+
+func (s *memoryJobs) FinishJob(ctx context.Context, id, status, messageID, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[id]
+	if j.Status != JobPending && j.Status != JobRunning {
+		return ErrJobFinished
+	}
+	j.Status, j.MessageID, j.Error = status, messageID, errMsg
+	s.jobs[id] = j
+	return nil
+}
+
+// setupJobs swaps in a memory DAL, job store and counting fake provider.
+func setupJobs(t *testing.T) (*memoryJobs, *int) {
+	t.Helper()
+	oldStore, oldJobs, oldLLM := Store, Jobs, LLM
+	t.Cleanup(func() { Store, Jobs, LLM = oldStore, oldJobs, oldLLM })
+	jobs, calls := newMemoryJobs(), new(int)
+	Store, Jobs = NewMemoryDAL(), jobs
+	LLM = &FakeProvider{Reply: func(req CompletionRequest) string {
+		*calls++
+		return "an answer"
+	}}
+	return jobs, calls
+}
+
+func pendingJob(t *testing.T, jobs *memoryJobs, userID string) (Job, string) {
+	t.Helper()
+	ctx := context.Background()
+	cid, err := Store.CreateConversation(ctx, userID, "Jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := ChatMessage{ID: GenerateULID(), ConversationID: cid, UserID: userID, Role: "user", Content: "What is a prime number?", CreatedAt: time.Now().UTC()}
+	if err := Store.PutMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	j := Job{ID: GenerateULID(), UserID: userID, ConversationID: cid, UserMessageID: msg.ID, Status: JobPending}
+	if err := jobs.CreateJob(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	return j, cid
+}
+
+func TestProcessJobAnswersOnceOnRedelivery(t *testing.T) {
+	jobs, calls := setupJobs(t)
+	ctx := context.Background()
+	j, cid := pendingJob(t, jobs, "student-1")
+
+	for delivery := 1; delivery <= 3; delivery++ {
+		if err := ProcessJob(ctx, j.ID); err != nil {
+			t.Fatalf("delivery %d: %v", delivery, err)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("provider called %d times, want 1", *calls)
+	}
+	got, _ := jobs.GetJob(ctx, j.ID)
+	if got.Status != JobDone || got.MessageID == "" {
+		t.Errorf("job = %+v", got)
+	}
+	page, err := Store.ListMessages(ctx, cid, 10, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 {
+		t.Errorf("stored %d messages, want the question and one answer", len(page.Items))
+	}
+}
+
+func TestProcessJobWaitsForALiveClaim(t *testing.T) {
+	jobs, calls := setupJobs(t)
+	ctx := context.Background()
+	j, _ := pendingJob(t, jobs, "student-1")
+
+	// Another worker holds the job: the queue should retry, not drop it.
+	if err := jobs.ClaimJob(ctx, j.ID, time.Now().Add(-jobClaimTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ProcessJob(ctx, j.ID); !errors.Is(err, ErrJobClaimed) {
+		t.Fatalf("ProcessJob on a claimed job: %v, want ErrJobClaimed", err)
+	}
+	if *calls != 0 {
+		t.Errorf("provider called %d times while another worker held the job", *calls)
+	}
+
+	// A claim older than the timeout belongs to a dead worker.
+	jobs.claimed[j.ID] = time.Now().Add(-2 * jobClaimTimeout)
+	if err := ProcessJob(ctx, j.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := jobs.GetJob(ctx, j.ID); got.Status != JobDone || *calls != 1 {
+		t.Errorf("after reclaiming: job %+v, %d provider calls", got, *calls)
+	}
+}
+
+func TestProcessJobSkipsTrashedConversation(t *testing.T) {
+	jobs, calls := setupJobs(t)
+	ctx := context.Background()
+	j, cid := pendingJob(t, jobs, "student-1")
+	if err := Store.TrashConversation(ctx, "student-1", cid, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ProcessJob(ctx, j.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := jobs.GetJob(ctx, j.ID); got.Status != JobFailed || *calls != 0 {
+		t.Errorf("job %+v after %d provider calls, want failed without generating", got, *calls)
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/abadojack/whatlanggo"
+)
+
+// Languages are ISO 639-1 codes ("en", "es", "zh"). Detection runs locally
+// with trigram profiles, so message text never leaves the system for it.
+
+// minDetectConfidence is low because short questions rarely score high; below
+// it (and for text with too few letters) the language is left unknown.
+const (
+	minDetectConfidence = 0.3
+	minDetectLetters    = 8
+)
+
+var languagesByCode = func() map[string]whatlanggo.Lang {
+	m := map[string]whatlanggo.Lang{}
+	for l := range whatlanggo.Langs {
+		if code := l.Iso6391(); code != "" {
+			m[code] = l
+		}
+	}
+	return m
+}()
+
+// defaultDetectLanguages limits detection to languages students actually
+// write in; trigram matching over every language confuses short English
+// questions with Estonian or Haitian Creole.
+const defaultDetectLanguages = "en,es,fr,de,it,pt,nl,zh,ja,ko,ar,hi,ru,vi,id,th,tr,pl,uk,fa,bn,ur"
+
+// detectOptions holds the whitelist from DETECT_LANGUAGES (comma-separated
+// ISO 639-1 codes). It is read on first use, after main has loaded .env.
+var detectOptions struct {
+	once sync.Once
+	opts whatlanggo.Options
+}
+
+func loadDetectOptions() {
+	codes := os.Getenv("DETECT_LANGUAGES")
+	if codes == "" {
+		codes = defaultDetectLanguages
+	}
+	opts := whatlanggo.Options{Whitelist: map[whatlanggo.Lang]bool{}}
+	for _, c := range strings.Split(codes, ",") {
+		if l, ok := languagesByCode[strings.TrimSpace(c)]; ok {
+			opts.Whitelist[l] = true
+		}
+	}
+	detectOptions.opts = opts
+}
+
+// notWords are stripped before detection: addresses and numbers say nothing
+// about the language and skew the trigrams.
+var notWords = regexp.MustCompile(`\S+@\S+|https?://\S+|\d+`)
+
+// DetectLanguage returns the ISO 639-1 code of text's language, or "" when
+// it cannot tell with reasonable confidence.
+func DetectLanguage(text string) string {
+	text = notWords.ReplaceAllString(text, " ")
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	// Scripts like Han pack a word into one or two letters.
+	if letters < minDetectLetters && whatlanggo.DetectScript(text) == unicode.Latin {
+		return ""
+	}
+	detectOptions.once.Do(loadDetectOptions)
+	info := whatlanggo.DetectWithOptions(text, detectOptions.opts)
+	if info.Confidence < minDetectConfidence {
+		return ""
+	}
+	return info.Lang.Iso6391()
+}
+
+// ValidLanguage reports whether code is a known ISO 639-1 code.
+func ValidLanguage(code string) bool {
+	_, ok := languagesByCode[code]
+	return ok
+}
+
+// LanguageName is the English name for code ("es" -> "Spanish"), or code
+// itself when unknown. Prompts name languages rather than using codes.
+func LanguageName(code string) string {
+	if l, ok := languagesByCode[code]; ok {
+		return l.String()
+	}
+	return code
+}
+
+// Preferences are a student's language settings. PreferredLanguage, when
+// set, overrides the detected language of each message. In translation mode
+// answers are also given in NativeLanguage.
+type Preferences struct {
+	UserID            string    `json:"userId"`
+	PreferredLanguage string    `json:"preferredLanguage,omitempty"`
+	NativeLanguage    string    `json:"nativeLanguage,omitempty"`
+	TranslationMode   bool      `json:"translationMode"`
+	UpdatedAt         time.Time `json:"updatedAt,omitempty"`
+}
+
+type PreferenceStore interface {
+	// GetPreferences returns zero Preferences (not an error) for users who
+	// never saved any.
+	GetPreferences(ctx context.Context, userID string) (Preferences, error)
+	PutPreferences(ctx context.Context, p Preferences) (Preferences, error)
+}
+
+// Global, initialised alongside Store in InitDAL.
+var UserPreferences PreferenceStore
+
+var ErrInvalidPreferences = errors.New("invalid preferences")
+
+func (p Preferences) Validate() error {
+	for name, code := range map[string]string{"preferredLanguage": p.PreferredLanguage, "nativeLanguage": p.NativeLanguage} {
+		if code != "" && !ValidLanguage(code) {
+			return fmt.Errorf("%w: %s %q is not a supported ISO 639-1 code", ErrInvalidPreferences, name, code)
+		}
+	}
+	if p.TranslationMode && p.NativeLanguage == "" {
+		return fmt.Errorf("%w: translation mode needs a nativeLanguage", ErrInvalidPreferences)
+	}
+	return nil
+}
+
+// loadPreferences never fails an answer: unreadable settings mean defaults.
+func loadPreferences(ctx context.Context, userID string) Preferences {
+	if UserPreferences == nil || userID == "" {
+		return Preferences{}
+	}
+	p, err := UserPreferences.GetPreferences(ctx, userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to load preferences for %s: %v", userID, err)
+		return Preferences{}
+	}
+	return p
+}
+
+// replyLanguages picks the answer language (preference, else detected) and,
+// in translation mode, the language to translate the answer into.
+func (p Preferences) replyLanguages(detected string) (reply, translation string) {
+	reply = detected
+	if p.PreferredLanguage != "" {
+		reply = p.PreferredLanguage
+	}
+	if p.TranslationMode && p.NativeLanguage != "" && p.NativeLanguage != reply {
+		translation = p.NativeLanguage
+	}
+	return reply, translation
+}
+
+// Translate asks p to translate text into language.
+func Translate(ctx context.Context, p Provider, model, text, language string) (Reply, error) {
+	system, tmpl, err := RenderTemplate(ctx, TemplateTranslate, map[string]any{"language": LanguageName(language)})
+	if err != nil {
+		return Reply{}, err
+	}
+	res, err := p.Complete(ctx, CompletionRequest{
+		Model: model,
+		Messages: []Message{
+			{Role: "system", Content: system},
+			{Role: "user", Content: text},
+		},
+	})
+	if err != nil {
+		return Reply{}, err
+	}
+	return Reply{
+		Content:          strings.TrimSpace(res.Content),
+		Template:         tmpl,
+		Model:            res.Model,
+		Language:         language,
+		PromptTokens:     res.PromptTokens,
+		CompletionTokens: res.CompletionTokens,
+	}, nil
+}
+
+// LocalizeGreeting translates a greeting into userID's preferred language.
+// It falls back to the original text if there is no preference or the
+// translation fails; a greeting is never worth an error.
+func LocalizeGreeting(ctx context.Context, userID, greeting string) (text, language string) {
+	prefs := loadPreferences(ctx, userID)
+	if prefs.PreferredLanguage == "" || prefs.PreferredLanguage == "en" || LLM == nil {
+		return greeting, ""
+	}
+	tr, err := Translate(ctx, LLM, "", greeting, prefs.PreferredLanguage)
+	if err != nil || tr.Content == "" {
+		log.Printf("⚠️ Failed to localize greeting: %v", err)
+		return greeting, ""
+	}
+	return tr.Content, prefs.PreferredLanguage
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDetectLanguageReadsEnvOnFirstUse(t *testing.T) {
+	// Set after package init, as godotenv.Load in main does. Other tests
+	// may have detected already, so start from an unread whitelist.
+	t.Setenv("DETECT_LANGUAGES", "en,es")
+	detectOptions.once = sync.Once{}
+	t.Cleanup(func() { detectOptions.once = sync.Once{} })
+	for text, want := range map[string]string{
+		"How does photosynthesis turn light into chemical energy?":   "en",
+		"¿Cómo convierte la fotosíntesis la luz en energía química?": "es",
+		"Comment la photosynthèse transforme-t-elle la lumière?":     "es", // French is not whitelisted
+		"hi 42": "",
+	} {
+		if got := DetectLanguage(text); got != want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
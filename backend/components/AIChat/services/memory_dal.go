@@ -0,0 +1,455 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDAL is a DAL kept in process memory, for tests and local runs
+// without AWS. It orders and pages items by the same key strings the Dynamo
+// table uses (skConv, skMsg, gsi1sk, gsi2skActivity), so results and limits
+// match what the Dynamo DAL returns. NextToken is the last returned key; it
+// is only set when more items follow. Data is lost on exit.
+type MemoryDAL struct {
+	mu            sync.RWMutex
+	conversations map[string]Conversation           // by conversation ID
+	messages      map[string]map[string]ChatMessage // conversation ID -> skMsg -> message
+}
+
+func NewMemoryDAL() *MemoryDAL {
+	return &MemoryDAL{
+		conversations: map[string]Conversation{},
+		messages:      map[string]map[string]ChatMessage{},
+	}
+}
+
+var errInvalidLimit = errors.New("limit must be at least 1")
+
+func (d *MemoryDAL) CreateConversation(ctx context.Context, userID, title string) (string, error) {
+	id := GenerateULID()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conversations[id] = Conversation{ID: id, UserID: userID, Title: title, CreatedAt: time.Now().UTC()}
+	return id, nil
+}
+
+func (d *MemoryDAL) ImportConversation(ctx context.Context, c Conversation) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if old, ok := d.conversations[c.ID]; ok {
+		if old.UserID != c.UserID {
+			return false, ErrConversationNotFound
+		}
+		return false, nil
+	}
+	d.conversations[c.ID] = Conversation{ID: c.ID, UserID: c.UserID, Title: c.Title, CreatedAt: c.CreatedAt.UTC()}
+	return true, nil
+}
+
+func (d *MemoryDAL) ListConversations(ctx context.Context, userID string, limit int32, nextToken string) (ListPage[Conversation], error) {
+	d.mu.RLock()
+	var items []keyed[Conversation]
+	for _, c := range d.conversations {
+		if c.UserID == userID && c.DeletedAt == nil {
+			items = append(items, keyed[Conversation]{key: gsi2skActivity(c.ActivityAt(), c.ID), item: c})
+		}
+	}
+	d.mu.RUnlock()
+	return memoryPage(conversationsCursor(userID), items, limit, nextToken, true)
+}
+
+func (d *MemoryDAL) GetConversation(ctx context.Context, userID, conversationID string) (Conversation, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if !d.ownsLocked(userID, conversationID, false) {
+		return Conversation{}, ErrConversationNotFound
+	}
+	return d.conversations[conversationID], nil
+}
+
+func (d *MemoryDAL) UpdateConversation(ctx context.Context, userID, conversationID string, u ConversationUpdate) (Conversation, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ownsLocked(userID, conversationID, true) {
+		return Conversation{}, ErrConversationNotFound
+	}
+	c := d.conversations[conversationID]
+	if u.IfVersion != nil && *u.IfVersion != c.Version {
+		return Conversation{}, ErrVersionConflict
+	}
+	if u.Title != nil {
+		c.Title = *u.Title
+	}
+	if u.Settings != nil {
+		c.Settings = *u.Settings
+	}
+	c.Version++
+	d.conversations[conversationID] = c
+	return c, nil
+}
+
+// PutMessage replaces any message with the same conversation, timestamp and
+// ID, like a Dynamo PutItem on the same key.
+func (d *MemoryDAL) PutMessage(ctx context.Context, m ChatMessage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ownsLocked(m.UserID, m.ConversationID, true) {
+		return ErrConversationNotFound
+	}
+	d.putLocked(m)
+	return nil
+}
+
+// ownsLocked reports whether userID owns the conversation and, if active
+// is set, it is not in the trash.
+func (d *MemoryDAL) ownsLocked(userID, conversationID string, active bool) bool {
+	c, ok := d.conversations[conversationID]
+	return ok && c.UserID == userID && (!active || c.DeletedAt == nil)
+}
+
+// putLocked stores m and, unless it replaces a stored message, adds it to
+// the conversation's activity. d.mu must be held for writing.
+func (d *MemoryDAL) putLocked(m ChatMessage) {
+	m.CreatedAt = m.CreatedAt.UTC()
+	msgs := d.messages[m.ConversationID]
+	if msgs == nil {
+		msgs = map[string]ChatMessage{}
+		d.messages[m.ConversationID] = msgs
+	}
+	sk := skMsg(m.CreatedAt, m.ID)
+	_, replaced := msgs[sk]
+	msgs[sk] = m
+	if replaced {
+		return
+	}
+	c := d.conversations[m.ConversationID]
+	c.MessageCount++
+	c.PromptTokens += m.PromptTokens
+	c.CompletionTokens += m.CompletionTokens
+	if c.LastMessageAt == nil || !m.CreatedAt.Before(*c.LastMessageAt) {
+		c.LastMessageAt = &m.CreatedAt
+		c.LastMessagePreview = messagePreview(m.Content)
+	}
+	d.conversations[m.ConversationID] = c
+}
+
+func (d *MemoryDAL) PutExchange(ctx context.Context, userMsg, botMsg ChatMessage) error {
+	if err := checkExchange(userMsg, botMsg); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ownsLocked(userMsg.UserID, userMsg.ConversationID, true) {
+		return ErrConversationNotFound
+	}
+	d.putLocked(userMsg)
+	d.putLocked(botMsg)
+	return nil
+}
+
+// PutMessages checks every conversation first, so it writes all or nothing.
+func (d *MemoryDAL) PutMessages(ctx context.Context, msgs []ChatMessage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, m := range msgs {
+		if !d.ownsLocked(m.UserID, m.ConversationID, false) {
+			return ErrConversationNotFound
+		}
+	}
+	for _, m := range msgs {
+		d.putLocked(m)
+	}
+	return nil
+}
+
+func (d *MemoryDAL) ListMessages(ctx context.Context, conversationID string, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error) {
+	return d.ListMessagesRange(ctx, conversationID, MessageRange{}, limit, nextToken, newestFirst)
+}
+
+func (d *MemoryDAL) ListMessagesRange(ctx context.Context, conversationID string, r MessageRange, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error) {
+	d.mu.RLock()
+	msgs := d.messages[conversationID]
+	bound := func(id string) (string, bool) {
+		for sk, m := range msgs {
+			if m.ID == id {
+				return sk, true
+			}
+		}
+		return "", false
+	}
+	var after, before string
+	var ok bool
+	if r.After != "" {
+		if after, ok = bound(r.After); !ok {
+			d.mu.RUnlock()
+			return ListPage[ChatMessage]{}, ErrMessageNotFound
+		}
+	}
+	if r.Before != "" {
+		if before, ok = bound(r.Before); !ok {
+			d.mu.RUnlock()
+			return ListPage[ChatMessage]{}, ErrMessageNotFound
+		}
+	}
+	var items []keyed[ChatMessage]
+	for sk, m := range msgs {
+		if (after != "" && sk <= after) || (before != "" && sk >= before) {
+			continue
+		}
+		items = append(items, keyed[ChatMessage]{key: sk, item: m})
+	}
+	d.mu.RUnlock()
+	return memoryPage(messagesCursor(conversationID, r, newestFirst), items, limit, nextToken, newestFirst)
+}
+
+// messageLocked finds a message by ID and returns it with its key.
+func (d *MemoryDAL) messageLocked(conversationID, messageID string) (string, ChatMessage, bool) {
+	for sk, m := range d.messages[conversationID] {
+		if m.ID == messageID {
+			return sk, m, true
+		}
+	}
+	return "", ChatMessage{}, false
+}
+
+func (d *MemoryDAL) GetMessage(ctx context.Context, conversationID, messageID string) (ChatMessage, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, m, ok := d.messageLocked(conversationID, messageID)
+	if !ok {
+		return ChatMessage{}, ErrMessageNotFound
+	}
+	return m, nil
+}
+
+func (d *MemoryDAL) UpdateMessage(ctx context.Context, userID, conversationID, messageID, content string, at time.Time) (ChatMessage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ownsLocked(userID, conversationID, true) {
+		return ChatMessage{}, ErrConversationNotFound
+	}
+	sk, m, ok := d.messageLocked(conversationID, messageID)
+	if !ok {
+		return ChatMessage{}, ErrMessageNotFound
+	}
+	m = editMessage(m, content, at)
+	d.messages[conversationID][sk] = m
+	if c := d.conversations[conversationID]; c.LastMessageAt != nil && c.LastMessageAt.Equal(m.CreatedAt) {
+		c.LastMessagePreview = messagePreview(m.Content)
+		d.conversations[conversationID] = c
+	}
+	return m, nil
+}
+
+func (d *MemoryDAL) DeleteMessage(ctx context.Context, userID, conversationID, messageID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ownsLocked(userID, conversationID, true) {
+		return ErrConversationNotFound
+	}
+	sk, m, ok := d.messageLocked(conversationID, messageID)
+	if !ok {
+		return ErrMessageNotFound
+	}
+	d.deleteMessageLocked(sk, m)
+	return nil
+}
+
+// deleteMessageLocked removes m, stored under sk, and takes it out of its
+// conversation's activity. d.mu must be held for writing.
+func (d *MemoryDAL) deleteMessageLocked(sk string, m ChatMessage) {
+	conversationID := m.ConversationID
+	delete(d.messages[conversationID], sk)
+	c := d.conversations[conversationID]
+	c.MessageCount--
+	c.PromptTokens -= m.PromptTokens
+	c.CompletionTokens -= m.CompletionTokens
+	c.LastMessageAt, c.LastMessagePreview = nil, ""
+	for _, rest := range d.messages[conversationID] {
+		if c.LastMessageAt == nil || !rest.CreatedAt.Before(*c.LastMessageAt) {
+			c.LastMessageAt = &rest.CreatedAt
+			c.LastMessagePreview = messagePreview(rest.Content)
+		}
+	}
+	d.conversations[conversationID] = c
+}
+
+func (d *MemoryDAL) DeleteConversationCascade(ctx context.Context, userID, conversationID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c, ok := d.conversations[conversationID]; !ok || c.UserID != userID {
+		return ErrConversationNotFound
+	}
+	delete(d.messages, conversationID)
+	delete(d.conversations, conversationID)
+	return nil
+}
+
+func (d *MemoryDAL) TrashConversation(ctx context.Context, userID, conversationID string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.conversations[conversationID]
+	if !ok || c.UserID != userID || c.DeletedAt != nil {
+		return ErrConversationNotFound
+	}
+	at = at.UTC()
+	c.DeletedAt = &at
+	d.conversations[conversationID] = c
+	return nil
+}
+
+func (d *MemoryDAL) RestoreConversation(ctx context.Context, userID, conversationID string, deletedAfter time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.conversations[conversationID]
+	if !ok || c.UserID != userID || c.DeletedAt == nil || c.DeletedAt.Before(deletedAfter) {
+		return ErrConversationNotFound
+	}
+	c.DeletedAt = nil
+	d.conversations[conversationID] = c
+	return nil
+}
+
+func (d *MemoryDAL) ListTrashedConversations(ctx context.Context, userID string, limit int32, nextToken string) (ListPage[Conversation], error) {
+	d.mu.RLock()
+	var items []keyed[Conversation]
+	for _, c := range d.conversations {
+		if c.UserID == userID && c.DeletedAt != nil {
+			items = append(items, keyed[Conversation]{key: skConv(c.ID), item: c})
+		}
+	}
+	d.mu.RUnlock()
+	return memoryPage(trashCursor(userID), items, limit, nextToken, true)
+}
+
+func (d *MemoryDAL) ListExpiredTrash(ctx context.Context, deletedBefore time.Time, limit int32, nextToken string) (ListPage[Conversation], error) {
+	before := "TS#" + deletedBefore.UTC().Format(sortableTime)
+	d.mu.RLock()
+	var items []keyed[Conversation]
+	for _, c := range d.conversations {
+		if c.DeletedAt == nil {
+			continue
+		}
+		if k := gsi1skTrash(*c.DeletedAt, c.ID); k < before {
+			items = append(items, keyed[Conversation]{key: k, item: c})
+		}
+	}
+	d.mu.RUnlock()
+	return memoryPage(expiredTrashCursor(deletedBefore), items, limit, nextToken, false)
+}
+
+// ListUserMessagesSince compares since against message timestamps as GSI1SK
+// strings, oldest first, exactly as the Dynamo key condition does.
+func (d *MemoryDAL) ListUserMessagesSince(ctx context.Context, userID string, since time.Time, limit int32, nextToken string) (ListPage[ChatMessage], error) {
+	from := "TS#" + since.UTC().Format(sortableTime)
+	d.mu.RLock()
+	var items []keyed[ChatMessage]
+	for _, msgs := range d.messages {
+		for _, m := range msgs {
+			if m.UserID != userID {
+				continue
+			}
+			if k := gsi1sk(m.CreatedAt, m.ConversationID, m.ID); k >= from {
+				items = append(items, keyed[ChatMessage]{key: k, item: m})
+			}
+		}
+	}
+	d.mu.RUnlock()
+	return memoryPage(userMessagesCursor(userID, since), items, limit, nextToken, false)
+}
+
+// keyed pairs an item with its sort key.
+type keyed[T any] struct {
+	key  string
+	item T
+}
+
+// memoryPage sorts items by key and returns up to limit of them after the
+// key in nextToken, a cursor for scope.
+func memoryPage[T any](scope cursorScope, items []keyed[T], limit int32, nextToken string, descending bool) (ListPage[T], error) {
+	if limit < 1 {
+		return ListPage[T]{}, errInvalidLimit
+	}
+	after, err := decodeMemoryToken(scope, nextToken)
+	if err != nil {
+		return ListPage[T]{}, err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if descending {
+			return items[i].key > items[j].key
+		}
+		return items[i].key < items[j].key
+	})
+	start := 0
+	if nextToken != "" {
+		start = sort.Search(len(items), func(i int) bool {
+			if descending {
+				return items[i].key < after
+			}
+			return items[i].key > after
+		})
+	}
+	end := start + int(limit)
+	if end > len(items) {
+		end = len(items)
+	}
+	var page ListPage[T]
+	for _, it := range items[start:end] {
+		page.Items = append(page.Items, it.item)
+	}
+	if end < len(items) {
+		page.NextToken = sealCursor(scope, []byte(items[end-1].key))
+	}
+	return page, nil
+}
+
+func decodeMemoryToken(scope cursorScope, token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	b, err := openCursor(scope, token)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(string(b), "#") {
+		return "", fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	return string(b), nil
+}
+
+// stampRetention has nothing to do: the in-memory backend keeps no TTL
+// attribute, so PurgeExpired alone applies retention.
+func (d *MemoryDAL) stampRetention(ctx context.Context, userID string, keep time.Duration) error {
+	return nil
+}
+
+// purgeUser deletes userID's conversations last active before cutoff and
+// the older messages of the others, as the DynamoDB backend does.
+func (d *MemoryDAL) purgeUser(ctx context.Context, userID string, cutoff time.Time) (int, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	convs, msgs := 0, 0
+	for id, c := range d.conversations {
+		if c.UserID != userID {
+			continue
+		}
+		if c.ActivityAt().Before(cutoff) {
+			delete(d.messages, id)
+			delete(d.conversations, id)
+			convs++
+			continue
+		}
+		for sk, m := range d.messages[id] {
+			if m.CreatedAt.Before(cutoff) {
+				d.deleteMessageLocked(sk, m)
+				msgs++
+			}
+		}
+	}
+	return convs, msgs, nil
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryIdempotency is the IdempotencyStore of the in-memory backend, with
+// the same claim rules as the DynamoDB store.
+type MemoryIdempotency struct {
+	mu   sync.Mutex
+	keys map[[2]string]idempotencyRecord // by user ID and key
+}
+
+type idempotencyRecord struct {
+	fingerprint string
+	done        bool
+	startedAt   time.Time
+	expiresAt   time.Time
+	response    StoredResponse
+}
+
+func NewMemoryIdempotency() *MemoryIdempotency {
+	return &MemoryIdempotency{keys: map[[2]string]idempotencyRecord{}}
+}
+
+func (m *MemoryIdempotency) Begin(ctx context.Context, userID, key, fingerprint string) (*StoredResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	id := [2]string{userID, key}
+	r, ok := m.keys[id]
+	switch {
+	case !ok, r.expiresAt.Before(now), !r.done && r.startedAt.Before(now.Add(-idempotencyLockTimeout)) && r.fingerprint == fingerprint:
+		m.keys[id] = idempotencyRecord{fingerprint: fingerprint, startedAt: now, expiresAt: now.Add(IdempotencyTTL)}
+		return nil, nil
+	case r.fingerprint != fingerprint:
+		return nil, ErrIdempotencyMismatch
+	case !r.done:
+		return nil, ErrIdempotencyInProgress
+	}
+	resp := r.response
+	return &resp, nil
+}
+
+func (m *MemoryIdempotency) Complete(ctx context.Context, userID, key string, resp StoredResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := [2]string{userID, key}
+	if r, ok := m.keys[id]; ok && !r.done {
+		r.done, r.response, r.expiresAt = true, resp, time.Now().Add(IdempotencyTTL)
+		m.keys[id] = r
+	}
+	return nil
+}
+
+func (m *MemoryIdempotency) Release(ctx context.Context, userID, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := [2]string{userID, key}
+	if r, ok := m.keys[id]; ok && !r.done {
+		delete(m.keys, id)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// postgresDAL connects to the database in DATABASE_URL, e.g. the local
+// instance described at NewPostgresDAL, or skips the test.
+func postgresDAL(t *testing.T) *SQLDAL {
+	t.Helper()
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL not set; start a local PostgreSQL to run this test")
+	}
+	d, err := NewPostgresDAL(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestPostgresMigrationsAreIdempotent(t *testing.T) {
+	postgresDAL(t)
+	d := postgresDAL(t) // a second cold start finds nothing to do
+	var version int
+	if err := d.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(postgresMigrations) {
+		t.Errorf("schema at version %d, want %d", version, len(postgresMigrations))
+	}
+}
+
+func TestPostgresCascadeAndContentSearch(t *testing.T) {
+	d := postgresDAL(t)
+	ctx := context.Background()
+	userID := "pg-test-" + GenerateULID()
+	cid, err := d.CreateConversation(ctx, userID, "Biology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := "chlorophyll" + GenerateULID() // unique across runs on a shared database
+	at := time.Now().UTC()
+	for i, content := range []string{"How does " + marker + " absorb light?", "It absorbs red and blue light."} {
+		m := ChatMessage{ID: GenerateULID(), ConversationID: cid, UserID: userID, Role: "user", Content: content, CreatedAt: at.Add(time.Duration(i) * time.Millisecond)}
+		if err := d.PutMessage(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var hits int
+	err = d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM messages
+		WHERE to_tsvector('simple', content) @@ plainto_tsquery('simple', $1)`, marker).Scan(&hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits != 1 {
+		t.Errorf("full-text search found %d messages, want 1", hits)
+	}
+
+	// Only the conversation row is deleted; the foreign key removes its messages.
+	if err := d.DeleteConversationCascade(ctx, userID, cid); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	if err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM messages WHERE conversation_id = $1`, cid).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d messages left after the cascade", left)
+	}
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Built-in template IDs. Handlers render these by ID; an admin can override
+// any of them by creating a stored template with the same ID.
+const (
+	TemplateConversationGreeting = "conversation-greeting"
+	TemplateChatSystem           = "chat-system"
+	TemplateEvalJudge            = "eval-judge"
+	TemplateReplyLanguage        = "reply-language"
+	TemplateTranslate            = "translate"
+)
+
+type VariableType string
+
+const (
+	VarString  VariableType = "string"
+	VarNumber  VariableType = "number"
+	VarBoolean VariableType = "boolean"
+)
+
+type TemplateVariable struct {
+	Name        string       `json:"name"`
+	Type        VariableType `json:"type"`
+	Required    bool         `json:"required"`
+	Default     string       `json:"default,omitempty"`
+	Description string       `json:"description,omitempty"`
+}
+
+// PromptTemplate is one immutable version of a named template.
+// Version 0 is reserved for the built-in defaults compiled into the binary.
+type PromptTemplate struct {
+	ID          string             `json:"id"`
+	Version     int                `json:"version"`
+	Description string             `json:"description,omitempty"`
+	Body        string             `json:"body"`
+	Variables   []TemplateVariable `json:"variables"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	CreatedBy   string             `json:"createdBy,omitempty"`
+}
+
+// TemplateRef identifies the exact template version that produced a message.
+type TemplateRef struct {
+	ID      string `json:"templateId"`
+	Version int    `json:"templateVersion"`
+}
+
+type TemplateStore interface {
+	// CreateTemplate stores version 1 of a new template, or latest+1 when
+	// re-creating a deleted one: version numbers are never reused.
+	CreateTemplate(ctx context.Context, t PromptTemplate) (PromptTemplate, error)
+	// AddTemplateVersion stores t as latest+1 of an existing template.
+	AddTemplateVersion(ctx context.Context, t PromptTemplate) (PromptTemplate, error)
+	// GetTemplate returns the given version, or the latest one when version is 0.
+	GetTemplate(ctx context.Context, id string, version int) (PromptTemplate, error)
+	ListTemplates(ctx context.Context, limit int32, nextToken string) (ListPage[PromptTemplate], error)
+	ListTemplateVersions(ctx context.Context, id string, limit int32, nextToken string) (ListPage[PromptTemplate], error)
+	// DeleteTemplate hides the template from GetTemplate(id, 0) and the list;
+	// its versions stay readable by number.
+	DeleteTemplate(ctx context.Context, id string) error
+}
+
+// Global, initialised alongside Store in InitDAL.
+var Templates TemplateStore
+
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrTemplateExists   = errors.New("template already exists")
+	ErrTemplateConflict = errors.New("template was modified concurrently, retry")
+	ErrInvalidVariable  = errors.New("invalid template variable")
+)
+
+// MissingVariablesError is returned by Render when required variables have no value.
+type MissingVariablesError struct {
+	TemplateID string
+	Names      []string
+}
+
+func (e *MissingVariablesError) Error() string {
+	return fmt.Sprintf("template %q: missing variables: %s", e.TemplateID, strings.Join(e.Names, ", "))
+}
+
+var (
+	templateIDPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholderPattern  = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+var builtinTemplates = map[string]PromptTemplate{
+	TemplateConversationGreeting: {
+		ID:          TemplateConversationGreeting,
+		Description: "First chatbot message of a new conversation",
+		Body:        "This is your personal AiChatBot, what can I help you study today?",
+		Variables:   []TemplateVariable{},
+	},
+	TemplateChatSystem: {
+		ID:          TemplateChatSystem,
+		Description: "System prompt sent with every student message",
+		Body: "You are AiChatBot, a patient academic tutor. Explain concepts step by step, " +
+			"check the student's understanding and keep answers focused on their studies.",
+		Variables: []TemplateVariable{},
+	},
+	TemplateEvalJudge: {
+		ID:          TemplateEvalJudge,
+		Description: "LLM-as-judge prompt used by the offline evaluation harness",
+		Body: "You are grading a tutoring chatbot's answer.\n\n" +
+			"Rubric:\n{{rubric}}\n\nQuestion:\n{{question}}\n\n" +
+			"Reference answer:\n{{reference}}\n\nChatbot answer:\n{{answer}}\n\n" +
+			"Score the chatbot answer from 1 (wrong or unhelpful) to 5 (fully correct and clear). " +
+			`Reply with JSON only: {"score": <1-5>, "rationale": "<one sentence>"}`,
+		Variables: []TemplateVariable{
+			{Name: "rubric", Type: VarString, Required: true},
+			{Name: "question", Type: VarString, Required: true},
+			{Name: "reference", Type: VarString},
+			{Name: "answer", Type: VarString, Required: true},
+		},
+	},
+	TemplateReplyLanguage: {
+		ID:          TemplateReplyLanguage,
+		Description: "Appended to the system prompt when the reply language is known",
+		Body: "Always reply in {{language}}, even though these instructions are in English. " +
+			"Keep technical terms, code and formulas as they are.",
+		Variables: []TemplateVariable{
+			{Name: "language", Type: VarString, Required: true},
+		},
+	},
+	TemplateTranslate: {
+		ID:          TemplateTranslate,
+		Description: "System prompt for translating an answer in translation mode",
+		Body: "Translate the user's message into {{language}}. Keep the formatting, code, formulas " +
+			"and any placeholders in square brackets unchanged. Reply with the translation only.",
+		Variables: []TemplateVariable{
+			{Name: "language", Type: VarString, Required: true},
+		},
+	},
+}
+
+// BuiltinTemplate returns the compiled-in default for id, if there is one.
+func BuiltinTemplate(id string) (PromptTemplate, bool) {
+	t, ok := builtinTemplates[id]
+	return t, ok
+}
+
+// Validate checks the template's ID, variable declarations and that every
+// placeholder in Body is declared. An override of a built-in template may
+// only require what the built-in requires, with the same types, since the
+// handlers render built-ins with exactly those variables.
+func (t PromptTemplate) Validate() error {
+	if !templateIDPattern.MatchString(t.ID) {
+		return fmt.Errorf("invalid template id %q: use lowercase letters, digits, '.', '_' or '-'", t.ID)
+	}
+	if strings.TrimSpace(t.Body) == "" {
+		return errors.New("template body is required")
+	}
+	declared := make(map[string]bool, len(t.Variables))
+	for _, v := range t.Variables {
+		if !variableNamePattern.MatchString(v.Name) {
+			return fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		if declared[v.Name] {
+			return fmt.Errorf("variable %q declared twice", v.Name)
+		}
+		declared[v.Name] = true
+		switch v.Type {
+		case VarString, VarNumber, VarBoolean:
+		default:
+			return fmt.Errorf("variable %q: unknown type %q", v.Name, v.Type)
+		}
+		if v.Default != "" {
+			if _, err := coerceVariable(v, v.Default); err != nil {
+				return fmt.Errorf("variable %q: bad default: %w", v.Name, err)
+			}
+		}
+	}
+	for _, name := range t.Placeholders() {
+		if !declared[name] {
+			return fmt.Errorf("placeholder {{%s}} is not declared as a variable", name)
+		}
+	}
+	if builtin, ok := builtinTemplates[t.ID]; ok {
+		return checkOverride(t, builtin)
+	}
+	return nil
+}
+
+// checkOverride rejects variables of an override that callers of the
+// built-in would not supply.
+func checkOverride(t, builtin PromptTemplate) error {
+	supplied := make(map[string]TemplateVariable, len(builtin.Variables))
+	for _, v := range builtin.Variables {
+		supplied[v.Name] = v
+	}
+	for _, v := range t.Variables {
+		b, ok := supplied[v.Name]
+		switch {
+		case ok && b.Type != v.Type:
+			return fmt.Errorf("variable %q of built-in template %q must be a %s", v.Name, t.ID, b.Type)
+		case v.Required && v.Default == "" && !(ok && b.Required):
+			return fmt.Errorf("variable %q cannot be required: built-in template %q is rendered without it", v.Name, t.ID)
+		}
+	}
+	return nil
+}
+
+// Placeholders lists the distinct {{name}} placeholders used in Body.
+func (t PromptTemplate) Placeholders() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range placeholderPattern.FindAllStringSubmatch(t.Body, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// Ref returns the ID/version pair to stamp on generated messages.
+func (t PromptTemplate) Ref() TemplateRef {
+	return TemplateRef{ID: t.ID, Version: t.Version}
+}
+
+// Render substitutes vars into Body. Declared defaults fill in absent values;
+// required variables without a value produce a *MissingVariablesError, and
+// values that do not match the declared type are rejected.
+func (t PromptTemplate) Render(vars map[string]any) (string, error) {
+	values := make(map[string]string, len(t.Variables))
+	var missing []string
+	for _, v := range t.Variables {
+		raw, ok := vars[v.Name]
+		if !ok || raw == nil {
+			if v.Default != "" {
+				raw = v.Default
+			} else if v.Required {
+				missing = append(missing, v.Name)
+				continue
+			} else {
+				raw = ""
+			}
+		}
+		s, err := coerceVariable(v, raw)
+		if err != nil {
+			return "", fmt.Errorf("%w: template %q: variable %q: %v", ErrInvalidVariable, t.ID, v.Name, err)
+		}
+		values[v.Name] = s
+	}
+	for _, name := range t.Placeholders() {
+		if _, ok := values[name]; !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", &MissingVariablesError{TemplateID: t.ID, Names: missing}
+	}
+	return placeholderPattern.ReplaceAllStringFunc(t.Body, func(m string) string {
+		return values[placeholderPattern.FindStringSubmatch(m)[1]]
+	}), nil
+}
+
+func coerceVariable(v TemplateVariable, raw any) (string, error) {
+	switch v.Type {
+	case VarNumber:
+		switch n := raw.(type) {
+		case int:
+			return strconv.Itoa(n), nil
+		case int32:
+			return strconv.FormatInt(int64(n), 10), nil
+		case int64:
+			return strconv.FormatInt(n, 10), nil
+		case float64:
+			return strconv.FormatFloat(n, 'f', -1, 64), nil
+		case string:
+			if n == "" && !v.Required {
+				return "", nil
+			}
+			if _, err := strconv.ParseFloat(n, 64); err != nil {
+				return "", fmt.Errorf("expected a number, got %q", n)
+			}
+			return n, nil
+		}
+		return "", fmt.Errorf("expected a number, got %T", raw)
+	case VarBoolean:
+		switch b := raw.(type) {
+		case bool:
+			return strconv.FormatBool(b), nil
+		case string:
+			if b == "" && !v.Required {
+				return "", nil
+			}
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return "", fmt.Errorf("expected a boolean, got %q", b)
+			}
+			return strconv.FormatBool(parsed), nil
+		}
+		return "", fmt.Errorf("expected a boolean, got %T", raw)
+	default:
+		if s, ok := raw.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(raw), nil
+	}
+}
+
+// RenderTemplate renders the latest stored version of id, falling back to the
+// built-in default when nothing is stored (or no store is configured).
+func RenderTemplate(ctx context.Context, id string, vars map[string]any) (string, TemplateRef, error) {
+	t, err := ResolveTemplate(ctx, id, 0)
+	if err != nil {
+		return "", TemplateRef{}, err
+	}
+	text, err := t.Render(vars)
+	if err != nil {
+		return "", TemplateRef{}, err
+	}
+	return text, t.Ref(), nil
+}
+
+// ResolveTemplate returns the given stored version of id. With version 0 it
+// returns the latest stored version, or the built-in default if none is stored.
+// Results are cached per instance for templateCacheTTL.
+func ResolveTemplate(ctx context.Context, id string, version int) (PromptTemplate, error) {
+	key := templateCacheKey{id: id, version: version}
+	templateCache.Lock()
+	c, ok := templateCache.templates[key]
+	templateCache.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.template, nil
+	}
+	t, err := resolveTemplate(ctx, id, version)
+	if err != nil {
+		return PromptTemplate{}, err
+	}
+	templateCache.Lock()
+	if templateCache.templates == nil {
+		templateCache.templates = map[templateCacheKey]cachedTemplate{}
+	}
+	templateCache.templates[key] = cachedTemplate{template: t, expires: time.Now().Add(templateCacheTTL)}
+	templateCache.Unlock()
+	return t, nil
+}
+
+func resolveTemplate(ctx context.Context, id string, version int) (PromptTemplate, error) {
+	if Templates != nil {
+		t, err := Templates.GetTemplate(ctx, id, version)
+		if err == nil {
+			return t, nil
+		}
+		if !errors.Is(err, ErrTemplateNotFound) {
+			return PromptTemplate{}, err
+		}
+	}
+	if t, ok := BuiltinTemplate(id); ok && version == 0 {
+		return t, nil
+	}
+	return PromptTemplate{}, fmt.Errorf("%w: %s", ErrTemplateNotFound, id)
+}
+
+// templateCacheTTL bounds how long another instance's publish or delete
+// takes to reach renders on this one.
+const templateCacheTTL = time.Minute
+
+type templateCacheKey struct {
+	id      string
+	version int
+}
+
+type cachedTemplate struct {
+	template PromptTemplate
+	expires  time.Time
+}
+
+var templateCache struct {
+	sync.Mutex
+	templates map[templateCacheKey]cachedTemplate
+}
+
+// forgetTemplate drops cached versions of id after a publish or delete.
+func forgetTemplate(id string) {
+	templateCache.Lock()
+	defer templateCache.Unlock()
+	for k := range templateCache.templates {
+		if k.id == id {
+			delete(templateCache.templates, k)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tmpl := PromptTemplate{
+		ID:   "quiz",
+		Body: "Ask {{count}} questions about {{ topic }} (hints: {{hints}}){{suffix}}.",
+		Variables: []TemplateVariable{
+			{Name: "topic", Type: VarString, Required: true},
+			{Name: "count", Type: VarNumber, Default: "3"},
+			{Name: "hints", Type: VarBoolean, Required: true},
+			{Name: "suffix", Type: VarString},
+		},
+	}
+
+	got, err := tmpl.Render(map[string]any{"topic": "cells", "hints": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Ask 3 questions about cells (hints: true)."; got != want {
+		t.Errorf("Render = %q, want %q", got, want)
+	}
+	got, err = tmpl.Render(map[string]any{"topic": "atoms", "count": 2.5, "hints": "0", "suffix": "!"})
+	if err != nil || got != "Ask 2.5 questions about atoms (hints: false)!." {
+		t.Errorf("Render = %q, %v", got, err)
+	}
+
+	_, err = tmpl.Render(map[string]any{"count": 1})
+	var missing *MissingVariablesError
+	if !errors.As(err, &missing) || strings.Join(missing.Names, ",") != "hints,topic" {
+		t.Errorf("missing variables: %v", err)
+	}
+	if _, err := tmpl.Render(map[string]any{"topic": "x", "hints": true, "count": "many"}); !errors.Is(err, ErrInvalidVariable) {
+		t.Errorf("bad number: %v, want ErrInvalidVariable", err)
+	}
+
+	undeclared := PromptTemplate{ID: "raw", Body: "Hello {{name}}"}
+	if _, err := undeclared.Render(nil); !errors.As(err, &missing) || missing.Names[0] != "name" {
+		t.Errorf("undeclared placeholder: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	str := func(name string, required bool) TemplateVariable {
+		return TemplateVariable{Name: name, Type: VarString, Required: required}
+	}
+	for name, tc := range map[string]struct {
+		tmpl PromptTemplate
+		ok   bool
+	}{
+		"valid":                {PromptTemplate{ID: "quiz.v2", Body: "On {{topic}}", Variables: []TemplateVariable{str("topic", true)}}, true},
+		"bad id":               {PromptTemplate{ID: "Quiz", Body: "x"}, false},
+		"empty body":           {PromptTemplate{ID: "quiz", Body: "  "}, false},
+		"bad variable name":    {PromptTemplate{ID: "quiz", Body: "x", Variables: []TemplateVariable{str("1topic", false)}}, false},
+		"declared twice":       {PromptTemplate{ID: "quiz", Body: "x", Variables: []TemplateVariable{str("a", false), str("a", false)}}, false},
+		"unknown type":         {PromptTemplate{ID: "quiz", Body: "x", Variables: []TemplateVariable{{Name: "a", Type: "date"}}}, false},
+		"bad default":          {PromptTemplate{ID: "quiz", Body: "x", Variables: []TemplateVariable{{Name: "n", Type: VarNumber, Default: "ten"}}}, false},
+		"undeclared":           {PromptTemplate{ID: "quiz", Body: "On {{topic}}"}, false},
+		"builtin override":     {PromptTemplate{ID: TemplateReplyLanguage, Body: "Answer in {{language}}.", Variables: []TemplateVariable{str("language", true)}}, true},
+		"builtin optional":     {PromptTemplate{ID: TemplateConversationGreeting, Body: "Hi{{name}}!", Variables: []TemplateVariable{str("name", false)}}, true},
+		"builtin new default":  {PromptTemplate{ID: TemplateConversationGreeting, Body: "Hi {{name}}!", Variables: []TemplateVariable{{Name: "name", Type: VarString, Required: true, Default: "there"}}}, true},
+		"builtin new required": {PromptTemplate{ID: TemplateConversationGreeting, Body: "Hi {{name}}!", Variables: []TemplateVariable{str("name", true)}}, false},
+		"builtin now required": {PromptTemplate{ID: TemplateEvalJudge, Body: "{{rubric}} {{question}} {{reference}} {{answer}}",
+			Variables: []TemplateVariable{str("rubric", true), str("question", true), str("reference", true), str("answer", true)}}, false},
+		"builtin retyped": {PromptTemplate{ID: TemplateTranslate, Body: "To {{language}}", Variables: []TemplateVariable{{Name: "language", Type: VarNumber, Required: true}}}, false},
+	} {
+		if err := tc.tmpl.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", name, err, tc.ok)
+		}
+	}
+}
+
+func TestCoerceVariable(t *testing.T) {
+	number := TemplateVariable{Name: "n", Type: VarNumber}
+	boolean := TemplateVariable{Name: "b", Type: VarBoolean}
+	required := func(v TemplateVariable) TemplateVariable { v.Required = true; return v }
+	for _, tc := range []struct {
+		v    TemplateVariable
+		raw  any
+		want string
+		ok   bool
+	}{
+		{number, 7, "7", true},
+		{number, int64(-2), "-2", true},
+		{number, 0.25, "0.25", true},
+		{number, "1e3", "1e3", true},
+		{number, "", "", true},
+		{required(number), "", "", false},
+		{number, "seven", "", false},
+		{number, true, "", false},
+		{boolean, false, "false", true},
+		{boolean, "TRUE", "true", true},
+		{boolean, "", "", true},
+		{required(boolean), "", "", false},
+		{boolean, "yes", "", false},
+		{boolean, 1, "", false},
+		{TemplateVariable{Name: "s", Type: VarString}, 42, "42", true},
+		{TemplateVariable{Name: "s", Type: VarString}, "text", "text", true},
+	} {
+		got, err := coerceVariable(tc.v, tc.raw)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("coerceVariable(%s %s, %#v) = %q, %v; want %q ok=%v", tc.v.Type, tc.v.Name, tc.raw, got, err, tc.want, tc.ok)
+		}
+	}
+}
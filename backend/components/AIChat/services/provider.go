@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CompletionRequest is a provider-neutral chat completion call.
+// Empty Model means the provider's default; nil Temperature means its default.
+type CompletionRequest struct {
+	Model       string
+	Temperature *float64
+	Messages    []Message
+}
+
+type CompletionResult struct {
+	Content          string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Provider generates chatbot answers. OpenAIProvider talks to the real API;
+// FakeProvider answers locally so handlers and tools run without a key.
+type Provider interface {
+	Complete(ctx context.Context, req CompletionRequest) (CompletionResult, error)
+}
+
+// StreamingProvider is implemented by providers that can deliver the answer
+// incrementally. onDelta receives each piece of content as it arrives; the
+// result still carries the full content and usage.
+type StreamingProvider interface {
+	Provider
+	CompleteStream(ctx context.Context, req CompletionRequest, onDelta func(delta string)) (CompletionResult, error)
+}
+
+type tokenStreamKey struct{}
+
+// WithTokenStream asks Answer to stream the answer to onDelta when the
+// provider supports it.
+func WithTokenStream(ctx context.Context, onDelta func(delta string)) context.Context {
+	return context.WithValue(ctx, tokenStreamKey{}, onDelta)
+}
+
+func tokenStreamFrom(ctx context.Context) func(string) {
+	f, _ := ctx.Value(tokenStreamKey{}).(func(string))
+	return f
+}
+
+// Global, used by your handlers/services.
+var LLM Provider
+
+// InitProvider selects the provider from AI_PROVIDER ("openai", "azure" or
+// "fake"). When unset, OpenAI is used and OPENAI_API_KEY is required: the
+// fake only answers when asked for by name, so a missing key in a deployed
+// stack fails at start-up instead of serving echoes. The result is wrapped
+// in PII redaction unless PII_REDACTION=off.
+func InitProvider() error {
+	var err error
+	switch name := os.Getenv("AI_PROVIDER"); name {
+	case "openai":
+		LLM, err = NewOpenAIProviderFromEnv()
+	case "azure":
+		LLM, err = NewAzureProviderFromEnv()
+	case "fake":
+		LLM = &FakeProvider{}
+	case "":
+		if os.Getenv("OPENAI_API_KEY") == "" {
+			return errors.New("OPENAI_API_KEY is not set; set AI_PROVIDER=fake to run without a model")
+		}
+		LLM, err = NewOpenAIProviderFromEnv()
+	default:
+		return fmt.Errorf("unknown AI_PROVIDER %q", name)
+	}
+	if err != nil {
+		LLM = nil
+		return err
+	}
+	p, err := withRedaction(LLM)
+	if err != nil {
+		return err
+	}
+	LLM = p
+	return nil
+}
+
+// IsFake reports whether p answers locally, looking through wrappers.
+func IsFake(p Provider) bool {
+	if r, ok := p.(*RedactingProvider); ok {
+		p = r.Next
+	}
+	_, fake := p.(*FakeProvider)
+	return fake
+}
+
+// FakeProvider echoes the last user message, or returns whatever Reply
+// produces. Token counts are whitespace-separated word counts.
+type FakeProvider struct {
+	Reply func(req CompletionRequest) string
+}
+
+func (f *FakeProvider) Complete(ctx context.Context, req CompletionRequest) (CompletionResult, error) {
+	if err := ctx.Err(); err != nil {
+		return CompletionResult{}, err
+	}
+	var content string
+	if f.Reply != nil {
+		content = f.Reply(req)
+	} else {
+		for i := len(req.Messages) - 1; i >= 0; i-- {
+			if req.Messages[i].Role == "user" {
+				content = "You said: " + req.Messages[i].Content
+				break
+			}
+		}
+	}
+	model := req.Model
+	if model == "" {
+		model = "fake"
+	}
+	prompt := 0
+	for _, m := range req.Messages {
+		prompt += len(strings.Fields(m.Content))
+	}
+	return CompletionResult{
+		Content:          content,
+		Model:            model,
+		PromptTokens:     prompt,
+		CompletionTokens: len(strings.Fields(content)),
+	}, nil
+}
+
+// CompleteStream delivers the fake answer word by word.
+func (f *FakeProvider) CompleteStream(ctx context.Context, req CompletionRequest, onDelta func(string)) (CompletionResult, error) {
+	res, err := f.Complete(ctx, req)
+	if err != nil {
+		return res, err
+	}
+	for _, w := range strings.SplitAfter(res.Content, " ") {
+		if err := ctx.Err(); err != nil {
+			return CompletionResult{}, err
+		}
+		if w != "" {
+			onDelta(w)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// Realtime chat over WebSockets. Connections are registered per user so a
+// student's other devices hear about changes to their conversations.
+//
+// Client -> server actions (JSON):
+//
+//	{"action": "sendMessage", "conversationId": "...", "content": "...", "requestId": "..."}
+//	{"action": "typing", "conversationId": "...", "typing": true}
+//
+// Server -> client events are SocketEvents.
+const (
+	EventTyping               = "typing"               // Who ("user" or "chatbot") started/stopped typing
+	EventToken                = "token"                // streamed piece of the chatbot answer
+	EventMessage              = "message"              // a message was stored
+	EventMessageUpdated       = "message.updated"      // a message was edited
+	EventMessageDeleted       = "message.deleted"      // Message carries only the IDs
+	EventConversationCreated  = "conversation.created" // another device created a conversation
+	EventConversationDeleted  = "conversation.deleted" // moved to the trash
+	EventConversationRestored = "conversation.restored"
+	EventImportProgress       = "import.progress" // Import carries the counts so far
+	EventError                = "error"
+)
+
+type SocketEvent struct {
+	Type           string          `json:"type"`
+	ConversationID string          `json:"conversationId,omitempty"`
+	RequestID      string          `json:"requestId,omitempty"` // echoes the client's sendMessage requestId
+	Who            string          `json:"who,omitempty"`
+	Typing         *bool           `json:"typing,omitempty"`
+	Delta          string          `json:"delta,omitempty"`
+	Message        *ChatMessage    `json:"message,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Import         *ImportProgress `json:"import,omitempty"`
+}
+
+type Connection struct {
+	ID          string    `json:"connectionId"`
+	UserID      string    `json:"userId"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+type ConnectionStore interface {
+	PutConnection(ctx context.Context, c Connection) error
+	GetConnection(ctx context.Context, id string) (Connection, error)
+	DeleteConnection(ctx context.Context, id string) error
+	ListUserConnections(ctx context.Context, userID string) ([]Connection, error)
+}
+
+// Pusher delivers a payload to one connection. It returns
+// ErrConnectionGone when the client has disconnected.
+type Pusher interface {
+	Push(ctx context.Context, connectionID string, payload []byte) error
+}
+
+// Globals: Connections is initialised alongside Store in InitDAL, Sockets
+// by InitRealtime. Either being nil turns notifications into no-ops.
+var (
+	Connections ConnectionStore
+	Sockets     Pusher
+)
+
+var (
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrConnectionGone     = errors.New("connection gone")
+)
+
+// SendEvent pushes ev to one connection, forgetting it if it is gone.
+func SendEvent(ctx context.Context, connectionID string, ev SocketEvent) error {
+	if Sockets == nil {
+		return nil
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	err = Sockets.Push(ctx, connectionID, payload)
+	if errors.Is(err, ErrConnectionGone) && Connections != nil {
+		_ = Connections.DeleteConnection(ctx, connectionID)
+	}
+	return err
+}
+
+// Broadcast pushes ev to every connection of userID except skip. Delivery
+// is best effort: failures are logged, never returned.
+func Broadcast(ctx context.Context, userID string, ev SocketEvent, skip string) {
+	if Sockets == nil || Connections == nil || userID == "" {
+		return
+	}
+	conns, err := Connections.ListUserConnections(ctx, userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to list connections for %s: %v", userID, err)
+		return
+	}
+	for _, c := range conns {
+		if c.ID == skip {
+			continue
+		}
+		if err := SendEvent(ctx, c.ID, ev); err != nil && !errors.Is(err, ErrConnectionGone) {
+			log.Printf("⚠️ Failed to push %s to %s: %v", ev.Type, c.ID, err)
+		}
+	}
+}
+
+// NotifyMessage tells userID's devices that m was stored.
+func NotifyMessage(ctx context.Context, m ChatMessage, skip string) {
+	Broadcast(ctx, m.UserID, SocketEvent{Type: EventMessage, ConversationID: m.ConversationID, Message: &m}, skip)
+}
+
+type socketAction struct {
+	Action         string `json:"action"`
+	ConversationID string `json:"conversationId"`
+	Content        string `json:"content"`
+	Typing         bool   `json:"typing"`
+	RequestID      string `json:"requestId"`
+}
+
+// HandleSocketMessage runs one client action received on conn. Problems are
+// reported to the client as error events.
+func HandleSocketMessage(ctx context.Context, conn Connection, body string) {
+	var a socketAction
+	if err := json.Unmarshal([]byte(body), &a); err != nil {
+		_ = SendEvent(ctx, conn.ID, SocketEvent{Type: EventError, Error: "Invalid JSON body"})
+		return
+	}
+	switch a.Action {
+	case "typing":
+		typing := a.Typing
+		Broadcast(ctx, conn.UserID, SocketEvent{Type: EventTyping, ConversationID: a.ConversationID, Who: "user", Typing: &typing}, conn.ID)
+	case "sendMessage":
+		if err := socketSendMessage(ctx, conn, a); err != nil {
+			log.Printf("❌ sendMessage on %s: %v", conn.ID, err)
+			_ = SendEvent(ctx, conn.ID, SocketEvent{Type: EventError, ConversationID: a.ConversationID, RequestID: a.RequestID, Error: err.Error()})
+		}
+	default:
+		_ = SendEvent(ctx, conn.ID, SocketEvent{Type: EventError, RequestID: a.RequestID, Error: fmt.Sprintf("unknown action %q", a.Action)})
+	}
+}
+
+// socketSendMessage announces the student's message to all the user's
+// devices, streams the answer to the sending connection and stores both
+// together once the answer is complete, so a question is never kept
+// without its answer.
+func socketSendMessage(ctx context.Context, conn Connection, a socketAction) error {
+	if a.ConversationID == "" || strings.TrimSpace(a.Content) == "" {
+		return errors.New("conversationId and content are required")
+	}
+	// Nothing is announced or generated for a conversation the user does
+	// not own or has trashed.
+	conv, err := Store.GetConversation(ctx, conn.UserID, a.ConversationID)
+	if err == nil && conv.DeletedAt != nil {
+		err = ErrConversationNotFound
+	}
+	if err != nil {
+		return err
+	}
+	ctx = WithChatScope(ctx, ChatScope{UserID: conn.UserID, ConversationID: a.ConversationID})
+	if prev, err := Store.ListMessages(ctx, a.ConversationID, 1, "", true); err == nil && len(prev.Items) > 0 {
+		RecordFollowUp(ctx, prev.Items[0])
+	}
+
+	userMsg := ChatMessage{
+		ID:             GenerateULID(),
+		ConversationID: a.ConversationID,
+		UserID:         conn.UserID,
+		Role:           "user",
+		Content:        a.Content,
+		CreatedAt:      time.Now().UTC(),
+		Language:       DetectLanguage(a.Content),
+	}
+	userEvent := SocketEvent{Type: EventMessage, ConversationID: a.ConversationID, RequestID: a.RequestID, Message: &userMsg}
+	_ = SendEvent(ctx, conn.ID, userEvent)
+	userEvent.RequestID = ""
+	Broadcast(ctx, conn.UserID, userEvent, conn.ID)
+
+	typing := true
+	Broadcast(ctx, conn.UserID, SocketEvent{Type: EventTyping, ConversationID: a.ConversationID, Who: "chatbot", Typing: &typing}, "")
+	defer func() {
+		stopped := false
+		Broadcast(ctx, conn.UserID, SocketEvent{Type: EventTyping, ConversationID: a.ConversationID, Who: "chatbot", Typing: &stopped}, "")
+	}()
+
+	tokens := newTokenBatcher(func(delta string) {
+		_ = SendEvent(ctx, conn.ID, SocketEvent{Type: EventToken, ConversationID: a.ConversationID, RequestID: a.RequestID, Delta: delta})
+	})
+	reply, err := GenerateReply(WithTokenStream(ctx, tokens.Add), conn.UserID, a.ConversationID, a.Content)
+	tokens.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to generate reply: %w", err)
+	}
+
+	botMsg := ChatMessage{
+		ID:             GenerateULID(),
+		ConversationID: a.ConversationID,
+		UserID:         conn.UserID,
+		Role:           "chatbot",
+		Content:        reply.Content,
+		CreatedAt:      time.Now().UTC(),
+	}
+	reply.Stamp(&botMsg)
+	if err := Store.PutExchange(ctx, userMsg, botMsg); err != nil {
+		return fmt.Errorf("failed to save messages: %w", err)
+	}
+	botEvent := SocketEvent{Type: EventMessage, ConversationID: a.ConversationID, RequestID: a.RequestID, Message: &botMsg}
+	_ = SendEvent(ctx, conn.ID, botEvent)
+	botEvent.RequestID = ""
+	Broadcast(ctx, conn.UserID, botEvent, conn.ID)
+	return nil
+}
+
+// tokenBatcher coalesces streamed deltas so each push (an HTTP call on API
+// Gateway) carries a useful amount of text.
+type tokenBatcher struct {
+	mu    sync.Mutex
+	buf   strings.Builder
+	last  time.Time
+	flush func(string)
+}
+
+const (
+	tokenBatchChars    = 48
+	tokenBatchInterval = 150 * time.Millisecond
+)
+
+func newTokenBatcher(flush func(string)) *tokenBatcher {
+	return &tokenBatcher{flush: flush, last: time.Now()}
+}
+
+func (b *tokenBatcher) Add(delta string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.WriteString(delta)
+	if b.buf.Len() >= tokenBatchChars || time.Since(b.last) >= tokenBatchInterval {
+		b.flushLocked()
+	}
+}
+
+func (b *tokenBatcher) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+}
+
+func (b *tokenBatcher) flushLocked() {
+	if b.buf.Len() == 0 {
+		return
+	}
+	b.flush(b.buf.String())
+	b.buf.Reset()
+	b.last = time.Now()
+}
+
+// apiGatewayPusher posts to the API Gateway WebSocket management endpoint,
+// https://{api-id}.execute-api.{region}.amazonaws.com/{stage}/@connections/{id},
+// signing requests with the Lambda's credentials.
+type apiGatewayPusher struct {
+	endpoint string
+	region   string
+	creds    aws.CredentialsProvider
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+// NewAPIGatewayPusher returns a Pusher for the WebSocket API at endpoint.
+func NewAPIGatewayPusher(ctx context.Context, endpoint string) (Pusher, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &apiGatewayPusher{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		region:   cfg.Region,
+		creds:    cfg.Credentials,
+		signer:   v4.NewSigner(),
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (p *apiGatewayPusher) Push(ctx context.Context, connectionID string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoint+"/@connections/"+url.PathEscape(connectionID), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	creds, err := p.creds.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(payload)
+	if err := p.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "execute-api", p.region, time.Now()); err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		return ErrConnectionGone
+	case resp.StatusCode >= 300:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("post to connection failed (status %d): %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// InitRealtime enables pushes through the WebSocket API at
+// WEBSOCKET_ENDPOINT (https://{api-id}.execute-api.{region}.amazonaws.com/{stage}).
+// Without it, notifications are skipped.
+func InitRealtime() error {
+	endpoint := os.Getenv("WEBSOCKET_ENDPOINT")
+	if endpoint == "" {
+		return nil
+	}
+	p, err := NewAPIGatewayPusher(context.Background(), endpoint)
+	if err != nil {
+		return err
+	}
+	Sockets = p
+	return nil
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPusher collects every event pushed to a connection.
+type recordingPusher struct {
+	mu     sync.Mutex
+	events []SocketEvent
+}
+
+func (p *recordingPusher) Push(ctx context.Context, connectionID string, payload []byte) error {
+	var ev SocketEvent
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, ev)
+	return nil
+}
+
+func TestSocketSendMessageChecksOwnershipFirst(t *testing.T) {
+	_, calls := setupJobs(t)
+	oldSockets := Sockets
+	t.Cleanup(func() { Sockets = oldSockets })
+	pusher := &recordingPusher{}
+	Sockets = pusher
+	ctx := context.Background()
+
+	cid, err := Store.CreateConversation(ctx, "owner", "Mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := Store.CreateConversation(ctx, "intruder", "Old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Store.TrashConversation(ctx, "intruder", trashed, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	intruder := Connection{ID: "conn-1", UserID: "intruder"}
+	for _, id := range []string{cid, trashed} {
+		err := socketSendMessage(ctx, intruder, socketAction{Action: "sendMessage", ConversationID: id, Content: "hi"})
+		if !errors.Is(err, ErrConversationNotFound) {
+			t.Errorf("%s: err = %v, want ErrConversationNotFound", id, err)
+		}
+	}
+	if *calls != 0 || len(pusher.events) != 0 {
+		t.Errorf("%d provider calls and %d events before the ownership check", *calls, len(pusher.events))
+	}
+
+	owner := Connection{ID: "conn-2", UserID: "owner"}
+	if err := socketSendMessage(ctx, owner, socketAction{Action: "sendMessage", ConversationID: cid, Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 1 {
+		t.Errorf("provider called %d times for the owner", *calls)
+	}
+}
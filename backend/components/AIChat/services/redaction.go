@@ -0,0 +1,450 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recognizer finds one kind of personal data. Valid, if set, rejects
+// pattern hits that are not really PII (e.g. a date that looks like a
+// phone number); before is the text preceding the match, for context words.
+type Recognizer struct {
+	Name    string
+	Label   string // placeholder prefix, e.g. EMAIL in [EMAIL_1]
+	Pattern *regexp.Regexp
+	Valid   func(match, before string) bool
+}
+
+// Built-in recognizers, applied in this order so the more specific formats
+// claim their matches before the phone pattern sees the digits.
+var builtinRecognizers = []Recognizer{
+	{
+		Name:    "email",
+		Label:   "EMAIL",
+		Pattern: regexp.MustCompile(`(?i)\b[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}\b`),
+	},
+	{
+		// US SSN and UK National Insurance number.
+		Name:    "national-id",
+		Label:   "NATIONAL_ID",
+		Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b|(?i:\b[A-CEGHJ-PR-TW-Z]{2} ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b)`),
+	},
+	{
+		Name:    "phone",
+		Label:   "PHONE",
+		Pattern: regexp.MustCompile(`\+?\(?\d[\d ().\-]{6,}\d`),
+		Valid:   validPhone,
+	},
+}
+
+var (
+	phoneGroupSep  = regexp.MustCompile(`[ ().\-]+`)
+	phoneContext   = regexp.MustCompile(`(?i)\b(?:phone|tel|mobile|cell|call|ring|text|sms|whatsapp|fax|contact)\w*\b\D{0,12}$`)
+	phoneNotNumber = []*regexp.Regexp{
+		regexp.MustCompile(`^\d{4}[./\-]\d{1,2}[./\-]\d{1,2}\b`),  // ISO date, maybe followed by a time
+		regexp.MustCompile(`^\d{1,2}[./\-]\d{1,2}[./\-]\d{2,4}$`), // day-month-year
+		regexp.MustCompile(`^\d{1,3}(?:\.\d{1,3}){3}$`),           // IPv4 address
+		regexp.MustCompile(`^\d+\.\d+$`),                          // decimal
+	}
+)
+
+// validPhone accepts 8-15 digits that are grouped like a phone number
+// (international prefix, area code in parentheses, three or more groups,
+// or a trunk 0 before a second group), or any such run of digits right
+// after a word like "phone" or "call". Dates, IP addresses and decimals
+// never count.
+func validPhone(m, before string) bool {
+	digits := 0
+	for _, r := range m {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	if digits < 8 || digits > 15 {
+		return false
+	}
+	for _, re := range phoneNotNumber {
+		if re.MatchString(m) {
+			return false
+		}
+	}
+	if phoneContext.MatchString(before) {
+		return true
+	}
+	if strings.HasPrefix(m, "+") || strings.HasPrefix(m, "(") {
+		return true
+	}
+	groups := phoneGroupSep.Split(strings.Trim(m, "()"), -1)
+	for _, g := range groups[1:] {
+		if len(g) < 2 {
+			return false
+		}
+	}
+	return len(groups) >= 3 || (len(groups) == 2 && strings.HasPrefix(m, "0"))
+}
+
+// CustomRecognizer is an institution-specific pattern from configuration.
+type CustomRecognizer struct {
+	Name    string `json:"name"`
+	Label   string `json:"label,omitempty"`
+	Pattern string `json:"pattern"`
+}
+
+// RedactionConfig controls which recognizers run. Custom recognizers are
+// keyed by institution; the "*" key applies to everyone.
+type RedactionConfig struct {
+	Enabled     bool
+	Recognizers []string
+	Custom      map[string][]CustomRecognizer
+}
+
+// Redactor replaces PII in prompts with placeholders that are restored in
+// the answer.
+type Redactor struct {
+	builtin []Recognizer
+	custom  map[string][]Recognizer
+}
+
+// NewRedactor compiles cfg. Unknown built-in names and bad patterns are errors.
+func NewRedactor(cfg RedactionConfig) (*Redactor, error) {
+	r := &Redactor{custom: map[string][]Recognizer{}}
+	for _, name := range cfg.Recognizers {
+		found := false
+		for _, b := range builtinRecognizers {
+			if b.Name == name {
+				r.builtin = append(r.builtin, b)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown PII recognizer %q", name)
+		}
+	}
+	for institution, list := range cfg.Custom {
+		for _, c := range list {
+			if c.Name == "" || c.Pattern == "" {
+				return nil, fmt.Errorf("PII recognizer for %q needs a name and a pattern", institution)
+			}
+			re, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("PII recognizer %q: %w", c.Name, err)
+			}
+			label := c.Label
+			if label == "" {
+				label = strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(c.Name))
+			}
+			r.custom[institution] = append(r.custom[institution], Recognizer{Name: c.Name, Label: label, Pattern: re})
+		}
+	}
+	return r, nil
+}
+
+// recognizers returns the custom patterns for institution, then the global
+// custom ones, then the built-ins.
+func (r *Redactor) recognizers(institution string) []Recognizer {
+	var out []Recognizer
+	if institution != "" && institution != "*" {
+		out = append(out, r.custom[institution]...)
+	}
+	out = append(out, r.custom["*"]...)
+	return append(out, r.builtin...)
+}
+
+// Redaction holds the placeholder mapping for one provider call.
+type Redaction struct {
+	recognizers []Recognizer
+	byValue     map[string]string
+	values      map[string]string
+	counts      map[string]int
+	next        map[string]int
+}
+
+// Start begins a redaction using the recognizers for institution.
+func (r *Redactor) Start(institution string) *Redaction {
+	return &Redaction{
+		recognizers: r.recognizers(institution),
+		byValue:     map[string]string{},
+		values:      map[string]string{},
+		counts:      map[string]int{},
+		next:        map[string]int{},
+	}
+}
+
+// Redact replaces every hit in text. The same value always gets the same
+// placeholder, so the model can still tell two addresses apart.
+func (x *Redaction) Redact(text string) string {
+	for _, rec := range x.recognizers {
+		var b strings.Builder
+		last := 0
+		for _, loc := range rec.Pattern.FindAllStringIndex(text, -1) {
+			m := text[loc[0]:loc[1]]
+			if rec.Valid != nil && !rec.Valid(m, text[:loc[0]]) {
+				continue
+			}
+			b.WriteString(text[last:loc[0]])
+			b.WriteString(x.placeholder(rec, m))
+			last = loc[1]
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text
+}
+
+func (x *Redaction) placeholder(rec Recognizer, m string) string {
+	x.counts[rec.Name]++
+	if ph, ok := x.byValue[m]; ok {
+		return ph
+	}
+	x.next[rec.Label]++
+	ph := fmt.Sprintf("[%s_%d]", rec.Label, x.next[rec.Label])
+	x.byValue[m] = ph
+	x.values[ph] = m
+	return ph
+}
+
+// Restore puts the original values back in place of their placeholders.
+func (x *Redaction) Restore(text string) string {
+	if len(x.values) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(x.values))
+	for ph, v := range x.values {
+		pairs = append(pairs, ph, v)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Counts is the number of hits per recognizer name.
+func (x *Redaction) Counts() map[string]int { return x.counts }
+
+// Redacted reports whether anything was replaced.
+func (x *Redaction) Redacted() bool { return len(x.values) > 0 }
+
+// RedactionEvent records that a provider call had PII removed. It holds
+// counts only, never the redacted values.
+type RedactionEvent struct {
+	ID             string         `json:"id"`
+	UserID         string         `json:"userId,omitempty"`
+	ConversationID string         `json:"conversationId,omitempty"`
+	Institution    string         `json:"institution,omitempty"`
+	Counts         map[string]int `json:"counts"`
+	CreatedAt      time.Time      `json:"createdAt"`
+}
+
+type RedactionLog interface {
+	RecordRedaction(ctx context.Context, e RedactionEvent) error
+	// ListRedactions returns events newest first; zero times are unbounded.
+	ListRedactions(ctx context.Context, since, until time.Time, limit int32, nextToken string) (ListPage[RedactionEvent], error)
+}
+
+// Global, initialised alongside Store in InitDAL.
+var RedactionEvents RedactionLog
+
+// ChatScope identifies who a provider call is for, so redaction can pick
+// institution recognizers and attribute its events.
+type ChatScope struct {
+	UserID         string
+	ConversationID string
+	Institution    string
+}
+
+type chatScopeKey struct{}
+
+// WithChatScope attaches s to ctx. Empty fields keep the values of any
+// scope already on ctx.
+func WithChatScope(ctx context.Context, s ChatScope) context.Context {
+	prev := chatScopeFrom(ctx)
+	if s.UserID == "" {
+		s.UserID = prev.UserID
+	}
+	if s.ConversationID == "" {
+		s.ConversationID = prev.ConversationID
+	}
+	if s.Institution == "" {
+		s.Institution = prev.Institution
+	}
+	return context.WithValue(ctx, chatScopeKey{}, s)
+}
+
+func chatScopeFrom(ctx context.Context) ChatScope {
+	s, _ := ctx.Value(chatScopeKey{}).(ChatScope)
+	return s
+}
+
+const redactionNotice = "Some personal details in this conversation were replaced with placeholders such as [EMAIL_1]. Refer to them by placeholder exactly as written and never guess their values."
+
+// RedactingProvider strips PII from every non-system message before the
+// wrapped provider sees it and restores the values in the answer.
+type RedactingProvider struct {
+	Next     Provider
+	Redactor *Redactor
+}
+
+func (p *RedactingProvider) Complete(ctx context.Context, req CompletionRequest) (CompletionResult, error) {
+	scope := chatScopeFrom(ctx)
+	x := p.Redactor.Start(scope.Institution)
+	msgs := make([]Message, len(req.Messages))
+	for i, m := range req.Messages {
+		if m.Role != "system" {
+			m.Content = x.Redact(m.Content)
+		}
+		msgs[i] = m
+	}
+	if !x.Redacted() {
+		return p.Next.Complete(ctx, req)
+	}
+	req.Messages = append([]Message{{Role: "system", Content: redactionNotice}}, msgs...)
+	recordRedaction(ctx, scope, x.Counts())
+
+	res, err := p.Next.Complete(ctx, req)
+	if err != nil {
+		return res, err
+	}
+	res.Content = x.Restore(res.Content)
+	return res, nil
+}
+
+// CompleteStream redacts like Complete and restores placeholders in the
+// stream. A delta ending inside a possible placeholder is held back until
+// the placeholder is complete.
+func (p *RedactingProvider) CompleteStream(ctx context.Context, req CompletionRequest, onDelta func(string)) (CompletionResult, error) {
+	next, ok := p.Next.(StreamingProvider)
+	if !ok {
+		res, err := p.Complete(ctx, req)
+		if err == nil && res.Content != "" {
+			onDelta(res.Content)
+		}
+		return res, err
+	}
+	scope := chatScopeFrom(ctx)
+	x := p.Redactor.Start(scope.Institution)
+	msgs := make([]Message, len(req.Messages))
+	for i, m := range req.Messages {
+		if m.Role != "system" {
+			m.Content = x.Redact(m.Content)
+		}
+		msgs[i] = m
+	}
+	if !x.Redacted() {
+		return next.CompleteStream(ctx, req, onDelta)
+	}
+	req.Messages = append([]Message{{Role: "system", Content: redactionNotice}}, msgs...)
+	recordRedaction(ctx, scope, x.Counts())
+
+	var pending string
+	res, err := next.CompleteStream(ctx, req, func(delta string) {
+		pending += delta
+		cut := len(pending)
+		if i := strings.LastIndexByte(pending, '['); i >= 0 && !strings.Contains(pending[i:], "]") && len(pending)-i <= maxPlaceholderLen {
+			cut = i
+		}
+		if cut > 0 {
+			onDelta(x.Restore(pending[:cut]))
+			pending = pending[cut:]
+		}
+	})
+	if err != nil {
+		return res, err
+	}
+	if pending != "" {
+		onDelta(x.Restore(pending))
+	}
+	res.Content = x.Restore(res.Content)
+	return res, nil
+}
+
+// maxPlaceholderLen bounds how much streamed text is held back waiting for
+// a placeholder's closing bracket.
+const maxPlaceholderLen = 48
+
+func recordRedaction(ctx context.Context, scope ChatScope, counts map[string]int) {
+	names := make([]string, 0, len(counts))
+	for n, c := range counts {
+		names = append(names, fmt.Sprintf("%s=%d", n, c))
+	}
+	sort.Strings(names)
+	log.Printf("🛡️ Redacted PII before provider call (conversation %q): %s", scope.ConversationID, strings.Join(names, " "))
+	if RedactionEvents == nil {
+		return
+	}
+	err := RedactionEvents.RecordRedaction(ctx, RedactionEvent{
+		ID:             GenerateULID(),
+		UserID:         scope.UserID,
+		ConversationID: scope.ConversationID,
+		Institution:    scope.Institution,
+		Counts:         counts,
+		CreatedAt:      time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to record redaction event: %v", err)
+	}
+}
+
+// LoadRedactionConfig reads
+//
+//	PII_REDACTION            "off" disables redaction (on by default)
+//	PII_RECOGNIZERS          comma-separated built-ins (default email,phone,national-id)
+//	PII_CUSTOM_RECOGNIZERS   JSON {"<institution>|*": [{"name","pattern","label"}]}
+//	PII_CUSTOM_RECOGNIZERS_FILE  path to the same JSON, for larger configs
+func LoadRedactionConfig() (RedactionConfig, error) {
+	cfg := RedactionConfig{Enabled: !strings.EqualFold(os.Getenv("PII_REDACTION"), "off")}
+	names := os.Getenv("PII_RECOGNIZERS")
+	if names == "" {
+		names = "email,national-id,phone"
+	}
+	for _, n := range strings.Split(names, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			cfg.Recognizers = append(cfg.Recognizers, n)
+		}
+	}
+	raw := os.Getenv("PII_CUSTOM_RECOGNIZERS")
+	if path := os.Getenv("PII_CUSTOM_RECOGNIZERS_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		raw = string(b)
+	}
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cfg.Custom); err != nil {
+			return cfg, fmt.Errorf("PII_CUSTOM_RECOGNIZERS: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+var (
+	defaultRedactorOnce sync.Once
+	defaultRedactor     *Redactor
+	defaultRedactorErr  error
+)
+
+// withRedaction wraps p according to the environment's redaction config.
+func withRedaction(p Provider) (Provider, error) {
+	defaultRedactorOnce.Do(func() {
+		cfg, err := LoadRedactionConfig()
+		if err != nil {
+			defaultRedactorErr = err
+			return
+		}
+		if cfg.Enabled {
+			defaultRedactor, defaultRedactorErr = NewRedactor(cfg)
+		}
+	})
+	if defaultRedactorErr != nil {
+		return nil, defaultRedactorErr
+	}
+	if defaultRedactor == nil {
+		return p, nil
+	}
+	return &RedactingProvider{Next: p, Redactor: defaultRedactor}, nil
+}
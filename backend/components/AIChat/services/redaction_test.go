@@ -0,0 +1,53 @@
+package services
+
+import "testing"
+
+func TestPhoneRecognizer(t *testing.T) {
+	r, err := NewRedactor(RedactionConfig{Enabled: true, Recognizers: []string{"email", "national-id", "phone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string]string{
+		// Phone-like grouping.
+		"ring +44 20 7946 0958 tonight":     "ring [PHONE_1] tonight",
+		"office (555) 123-4567":             "office [PHONE_1]",
+		"it is 555-123-4567":                "it is [PHONE_1]",
+		"reach me at 07700 900123":          "reach me at [PHONE_1]",
+		"my phone is 07700900123":           "my phone is [PHONE_1]",
+		"call me on 5551234567, thanks":     "call me on [PHONE_1], thanks",
+		"Tel: 0044.20.7946.0958":            "Tel: [PHONE_1]",
+		"a@b.io or 020 7946 0958 or a@b.io": "[EMAIL_1] or [PHONE_1] or [EMAIL_1]",
+
+		// Not phone numbers.
+		"the exam is on 2024-03-15 10:30":     "the exam is on 2024-03-15 10:30",
+		"due 15.03.2024 at noon":              "due 15.03.2024 at noon",
+		"pi is 3.14159265358":                 "pi is 3.14159265358",
+		"population was 8045311447 last year": "population was 8045311447 last year",
+		"1234 5678 is the answer":             "1234 5678 is the answer",
+		"server 192.168.100.200 is down":      "server 192.168.100.200 is down",
+		"call 2024-03-15 the deadline":        "call 2024-03-15 the deadline",
+		"only 555-1234":                       "only 555-1234",
+	} {
+		if got := r.Start("").Redact(text); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestRedactionRestore(t *testing.T) {
+	r, err := NewRedactor(RedactionConfig{Enabled: true, Recognizers: []string{"email", "phone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := r.Start("")
+	redacted := x.Redact("mail jo@uni.edu or call 020 7946 0958")
+	if redacted != "mail [EMAIL_1] or call [PHONE_1]" {
+		t.Fatalf("redacted = %q", redacted)
+	}
+	if got := x.Restore("I will email [EMAIL_1] and call [PHONE_1]."); got != "I will email jo@uni.edu and call 020 7946 0958." {
+		t.Errorf("restored = %q", got)
+	}
+	if c := x.Counts(); c["email"] != 1 || c["phone"] != 1 {
+		t.Errorf("counts = %v", c)
+	}
+}
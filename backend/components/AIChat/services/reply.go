@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// Reply is a generated chatbot answer plus everything needed to attribute it.
+type Reply struct {
+	Content          string
+	Template         TemplateRef
+	Model            string
+	ExperimentID     string
+	Variant          string
+	PromptTokens     int
+	CompletionTokens int
+	// Language the answer was requested in, the detected language of the
+	// student's message, and the translation-mode copy of the answer.
+	Language            string
+	MessageLanguage     string
+	Translation         string
+	TranslationLanguage string
+}
+
+// StampVariant marks m as part of the reply's experiment arm.
+func (r Reply) StampVariant(m *ChatMessage) {
+	m.ExperimentID = r.ExperimentID
+	m.Variant = r.Variant
+}
+
+// Stamp copies the reply's provenance (template, variant, model, usage) onto m.
+func (r Reply) Stamp(m *ChatMessage) {
+	r.StampVariant(m)
+	m.TemplateID = r.Template.ID
+	m.TemplateVersion = r.Template.Version
+	m.Model = r.Model
+	m.PromptTokens = r.PromptTokens
+	m.CompletionTokens = r.CompletionTokens
+	m.Language = r.Language
+	m.Translation = r.Translation
+	m.TranslationLanguage = r.TranslationLanguage
+}
+
+// AnswerConfig selects the persona (system prompt template) and model
+// settings for one answer. Zero values mean the defaults.
+type AnswerConfig struct {
+	TemplateID      string
+	TemplateVersion int
+	Model           string
+	Temperature     *float64
+	Language        string // ISO 639-1 reply language; empty leaves it to the model
+}
+
+// Answer renders the persona's system prompt and asks p to answer message,
+// streaming it to the context's token stream if there is one.
+func Answer(ctx context.Context, p Provider, cfg AnswerConfig, message string) (Reply, error) {
+	if cfg.TemplateID == "" {
+		cfg.TemplateID = TemplateChatSystem
+	}
+	tmpl, err := ResolveTemplate(ctx, cfg.TemplateID, cfg.TemplateVersion)
+	if err != nil {
+		return Reply{}, err
+	}
+	system, err := tmpl.Render(nil)
+	if err != nil {
+		return Reply{}, err
+	}
+	if cfg.Language != "" {
+		instruction, _, err := RenderTemplate(ctx, TemplateReplyLanguage, map[string]any{"language": LanguageName(cfg.Language)})
+		if err != nil {
+			return Reply{}, err
+		}
+		system += "\n\n" + instruction
+	}
+	req := CompletionRequest{
+		Model:       cfg.Model,
+		Temperature: cfg.Temperature,
+		Messages: []Message{
+			{Role: "system", Content: system},
+			{Role: "user", Content: message},
+		},
+	}
+	var res CompletionResult
+	if sp, ok := p.(StreamingProvider); ok && tokenStreamFrom(ctx) != nil {
+		res, err = sp.CompleteStream(ctx, req, tokenStreamFrom(ctx))
+	} else {
+		res, err = p.Complete(ctx, req)
+	}
+	if err != nil {
+		return Reply{}, err
+	}
+	return Reply{
+		Content:          res.Content,
+		Template:         tmpl.Ref(),
+		Model:            res.Model,
+		Language:         cfg.Language,
+		PromptTokens:     res.PromptTokens,
+		CompletionTokens: res.CompletionTokens,
+	}, nil
+}
+
+// GenerateReply answers a student message with the configured provider, in
+// the conversation's language setting, the student's preferred language or
+// else the message's detected language, adding a translation into their
+// native language in translation mode. If an experiment is running, the
+// student's variant picks the system prompt, model and temperature, and the
+// exchange's tokens and cost are added to that variant's outcomes.
+func GenerateReply(ctx context.Context, userID, conversationID, message string) (Reply, error) {
+	if LLM == nil {
+		return Reply{}, errors.New("no AI provider configured")
+	}
+	ctx = WithChatScope(ctx, ChatScope{UserID: userID, ConversationID: conversationID})
+
+	exp, err := ActiveExperiment(ctx)
+	if err != nil {
+		// Never fail an answer because experiment config is unreadable.
+		log.Printf("⚠️ Failed to load active experiment: %v", err)
+		exp = nil
+	}
+	var cfg AnswerConfig
+	var variant Variant
+	if exp != nil {
+		variant = exp.Assign(userID, conversationID)
+		cfg = AnswerConfig{
+			TemplateID:      variant.TemplateID,
+			TemplateVersion: variant.TemplateVersion,
+			Model:           variant.Model,
+			Temperature:     variant.Temperature,
+		}
+	}
+
+	detected := DetectLanguage(message)
+	replyLang, translateTo := loadPreferences(ctx, userID).replyLanguages(detected)
+	if lang := loadConversationSettings(ctx, userID, conversationID).Language; lang != "" {
+		replyLang = lang
+	}
+	cfg.Language = replyLang
+
+	reply, err := Answer(ctx, LLM, cfg, message)
+	if err != nil {
+		return Reply{}, err
+	}
+	reply.MessageLanguage = detected
+	if translateTo != "" {
+		// The answer stands on its own; a failed translation only loses the copy.
+		tr, err := Translate(ctx, LLM, cfg.Model, reply.Content, translateTo)
+		if err != nil {
+			log.Printf("⚠️ Failed to translate reply into %s: %v", translateTo, err)
+		} else {
+			reply.Translation, reply.TranslationLanguage = tr.Content, translateTo
+			reply.PromptTokens += tr.PromptTokens
+			reply.CompletionTokens += tr.CompletionTokens
+		}
+	}
+	if exp != nil {
+		reply.ExperimentID = exp.ID
+		reply.Variant = variant.Name
+		err := Experiments.RecordOutcome(ctx, exp.ID, variant.Name, Outcome{
+			Exchanges:        1,
+			PromptTokens:     int64(reply.PromptTokens),
+			CompletionTokens: int64(reply.CompletionTokens),
+			CostMicroUSD:     CostMicroUSD(reply.Model, reply.PromptTokens, reply.CompletionTokens),
+		})
+		if err != nil {
+			log.Printf("⚠️ Failed to record experiment outcome: %v", err)
+		}
+	}
+	return reply, nil
+}
+
+// RecordFollowUp credits a follow-up to the variant that produced prev,
+// the conversation's latest message before the student wrote again.
+func RecordFollowUp(ctx context.Context, prev ChatMessage) {
+	if Experiments == nil || prev.Role != "chatbot" || prev.ExperimentID == "" {
+		return
+	}
+	if err := Experiments.RecordOutcome(ctx, prev.ExperimentID, prev.Variant, Outcome{FollowUps: 1}); err != nil {
+		log.Printf("⚠️ Failed to record follow-up: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retention policies delete chats a fixed number of days after their last
+// activity, to meet schools' data-retention rules. A policy applies to one
+// user, or to the members of a group or course (the ID is the Cognito
+// group or course code; the admin who sets the policy lists the members).
+//
+// Messages and conversation headers carry an expiresAt TTL attribute
+// stamped when they are written, so DynamoDB removes them even if nothing
+// else does. PurgeExpired is the authoritative cleanup: it deletes expired
+// data together with what derives from it, and re-stamps expiresAt for
+// users whose policy changed.
+const (
+	RetentionUser   = "user"
+	RetentionGroup  = "group"
+	RetentionCourse = "course"
+)
+
+type RetentionPolicy struct {
+	Scope string `json:"scope"`
+	ID    string `json:"id"`
+	// Days after a message (or a conversation's last activity) it expires.
+	Days int `json:"days"`
+	// Members of a group or course policy; a user policy applies to ID.
+	Members   []string  `json:"members,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type RetentionStore interface {
+	GetPolicy(ctx context.Context, scope, id string) (RetentionPolicy, error)
+	ListPolicies(ctx context.Context, limit int32, nextToken string) (ListPage[RetentionPolicy], error)
+	// PutPolicy creates or replaces a policy, and queues a TTL backfill
+	// for everyone it applied or now applies to.
+	PutPolicy(ctx context.Context, p RetentionPolicy) (RetentionPolicy, error)
+	DeletePolicy(ctx context.Context, scope, id string) error
+	// UserPolicies returns the policies that apply to userID (without
+	// their member lists).
+	UserPolicies(ctx context.Context, userID string) ([]RetentionPolicy, error)
+	// PendingBackfills returns the users whose TTLs must be re-stamped.
+	// BackfillDone removes one, unless the user's policies changed again
+	// since it was requested.
+	PendingBackfills(ctx context.Context) ([]RetentionBackfill, error)
+	BackfillDone(ctx context.Context, b RetentionBackfill) error
+}
+
+// RetentionBackfill is a queued re-stamp of a user's TTLs.
+type RetentionBackfill struct {
+	UserID      string
+	RequestedAt time.Time
+}
+
+// Global, initialised alongside Store in InitDAL. Only the DynamoDB backend
+// has one; retention is off (nil) on the others.
+var Retention RetentionStore
+
+var ErrPolicyNotFound = errors.New("retention policy not found")
+
+// maxRetentionDays caps policies at ten years.
+const maxRetentionDays = 3650
+
+// Validate checks the policy before it is stored. A user policy's members
+// are set to its ID.
+func (p *RetentionPolicy) Validate() error {
+	switch p.Scope {
+	case RetentionUser, RetentionGroup, RetentionCourse:
+	default:
+		return fmt.Errorf("unknown scope %q", p.Scope)
+	}
+	if p.ID == "" || len(p.ID) > 128 || strings.Contains(p.ID, "#") {
+		return fmt.Errorf("invalid policy id %q", p.ID)
+	}
+	if p.Days < 1 || p.Days > maxRetentionDays {
+		return fmt.Errorf("days must be between 1 and %d", maxRetentionDays)
+	}
+	if p.Scope == RetentionUser {
+		if len(p.Members) > 0 && !slices.Equal(p.Members, []string{p.ID}) {
+			return errors.New("a user policy cannot list members")
+		}
+		p.Members = []string{p.ID}
+		return nil
+	}
+	for _, m := range p.Members {
+		if m == "" || strings.Contains(m, "#") {
+			return fmt.Errorf("invalid member %q", m)
+		}
+	}
+	slices.Sort(p.Members)
+	p.Members = slices.Compact(p.Members)
+	return nil
+}
+
+// effectiveRetention picks the policy that governs a user: their own, or
+// else the strictest (shortest) of their groups' and courses'.
+func effectiveRetention(policies []RetentionPolicy) (RetentionPolicy, bool) {
+	var best RetentionPolicy
+	found := false
+	for _, p := range policies {
+		switch {
+		case !found, p.Scope == RetentionUser && best.Scope != RetentionUser:
+			best, found = p, true
+		case (p.Scope == RetentionUser) == (best.Scope == RetentionUser) && p.Days < best.Days:
+			best = p
+		}
+	}
+	return best, found
+}
+
+// retentionCacheTTL is how long a user's resolved retention is reused by
+// the writes on one instance.
+const retentionCacheTTL = 5 * time.Minute
+
+var retentionCache struct {
+	sync.Mutex
+	users map[string]cachedRetention
+}
+
+type cachedRetention struct {
+	keep    time.Duration
+	expires time.Time
+}
+
+// userRetention is how long userID's messages are kept, or 0 for no limit.
+func userRetention(ctx context.Context, userID string) (time.Duration, error) {
+	if Retention == nil {
+		return 0, nil
+	}
+	retentionCache.Lock()
+	c, ok := retentionCache.users[userID]
+	retentionCache.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.keep, nil
+	}
+	policies, err := Retention.UserPolicies(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	keep := time.Duration(0)
+	if p, ok := effectiveRetention(policies); ok {
+		keep = time.Duration(p.Days) * 24 * time.Hour
+	}
+	retentionCache.Lock()
+	if retentionCache.users == nil {
+		retentionCache.users = map[string]cachedRetention{}
+	}
+	retentionCache.users[userID] = cachedRetention{keep: keep, expires: time.Now().Add(retentionCacheTTL)}
+	retentionCache.Unlock()
+	return keep, nil
+}
+
+// forgetRetention drops cached retention after a policy change.
+func forgetRetention(userIDs []string) {
+	retentionCache.Lock()
+	defer retentionCache.Unlock()
+	for _, id := range userIDs {
+		delete(retentionCache.users, id)
+	}
+}
+
+// retentionDAL is what PurgeExpired needs from the DAL: the DynamoDB and
+// in-memory backends implement it.
+type retentionDAL interface {
+	// stampRetention sets expiresAt on all of userID's conversations and
+	// messages for retention keep, or removes it for 0.
+	stampRetention(ctx context.Context, userID string, keep time.Duration) error
+	// purgeUser deletes userID's conversations last active before cutoff
+	// and the older messages of the others, returning how many of each it
+	// deleted.
+	purgeUser(ctx context.Context, userID string, cutoff time.Time) (int, int, error)
+}
+
+// PurgeResult counts what one PurgeExpired run did.
+type PurgeResult struct {
+	Backfilled    int `json:"backfilled"`
+	Conversations int `json:"conversations"`
+	Messages      int `json:"messages"`
+}
+
+// PurgeExpired first re-stamps expiresAt for users whose policy changed,
+// then deletes, for every user under a policy, conversations whose last
+// activity is older than the retention period (with their messages,
+// feedback and redaction events) and older messages of the remaining
+// conversations (with their feedback). Secondary index entries go with
+// the items. Users that fail are logged and left for the next run.
+func PurgeExpired(ctx context.Context) (PurgeResult, error) {
+	var res PurgeResult
+	if Retention == nil {
+		return res, nil
+	}
+	d, ok := Store.(retentionDAL)
+	if !ok {
+		return res, errors.New("this storage backend does not support retention")
+	}
+	failed := 0
+
+	pending, err := Retention.PendingBackfills(ctx)
+	if err != nil {
+		return res, err
+	}
+	for _, b := range pending {
+		forgetRetention([]string{b.UserID})
+		keep, err := userRetention(ctx, b.UserID)
+		if err == nil {
+			err = d.stampRetention(ctx, b.UserID, keep)
+		}
+		if err == nil {
+			err = Retention.BackfillDone(ctx, b)
+		}
+		if err != nil {
+			failed++
+			log.Printf("❌ Retention backfill for user %s: %v", b.UserID, err)
+			continue
+		}
+		res.Backfilled++
+	}
+
+	users := map[string]bool{}
+	token := ""
+	for {
+		page, err := Retention.ListPolicies(ctx, 50, token)
+		if err != nil {
+			return res, err
+		}
+		for _, p := range page.Items {
+			for _, m := range p.Members {
+				users[m] = true
+			}
+		}
+		if page.NextToken == "" {
+			break
+		}
+		token = page.NextToken
+	}
+	for userID := range users {
+		keep, err := userRetention(ctx, userID)
+		if err != nil {
+			failed++
+			log.Printf("❌ Retention for user %s: %v", userID, err)
+			continue
+		}
+		if keep == 0 {
+			continue
+		}
+		convs, msgs, err := d.purgeUser(ctx, userID, time.Now().Add(-keep))
+		res.Conversations += convs
+		res.Messages += msgs
+		if err != nil {
+			failed++
+			log.Printf("❌ Purging expired data of user %s: %v", userID, err)
+		}
+	}
+	if failed > 0 {
+		return res, fmt.Errorf("retention failed for %d user(s)", failed)
+	}
+	return res, nil
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// memoryRetention is an in-memory RetentionStore.
+type memoryRetention struct {
+	policies  map[[2]string]RetentionPolicy
+	backfills map[string]time.Time
+	lookups   int
+}
+
+func newMemoryRetention() *memoryRetention {
+	return &memoryRetention{policies: map[[2]string]RetentionPolicy{}, backfills: map[string]time.Time{}}
+}
+
+func (s *memoryRetention) requestBackfill(members []string) {
+	for _, m := range members {
+		s.backfills[m] = time.Now()
+	}
+}
+
+func (s *memoryRetention) GetPolicy(ctx context.Context, scope, id string) (RetentionPolicy, error) {
+	p, ok := s.policies[[2]string{scope, id}]
+	if !ok {
+		return RetentionPolicy{}, ErrPolicyNotFound
+	}
+	return p, nil
+}
+
+func (s *memoryRetention) ListPolicies(ctx context.Context, limit int32, nextToken string) (ListPage[RetentionPolicy], error) {
+	var page ListPage[RetentionPolicy]
+	for _, p := range s.policies {
+		page.Items = append(page.Items, p)
+	}
+	return page, nil
+}
+
+func (s *memoryRetention) PutPolicy(ctx context.Context, p RetentionPolicy) (RetentionPolicy, error) {
+	key := [2]string{p.Scope, p.ID}
+	s.requestBackfill(s.policies[key].Members)
+	s.requestBackfill(p.Members)
+	p.UpdatedAt = time.Now().UTC()
+	s.policies[key] = p
+	return p, nil
+}
+
+func (s *memoryRetention) DeletePolicy(ctx context.Context, scope, id string) error {
+	key := [2]string{scope, id}
+	p, ok := s.policies[key]
+	if !ok {
+		return ErrPolicyNotFound
+	}
+	s.requestBackfill(p.Members)
+	delete(s.policies, key)
+	return nil
+}
+
+func (s *memoryRetention) UserPolicies(ctx context.Context, userID string) ([]RetentionPolicy, error) {
+	s.lookups++
+	var out []RetentionPolicy
+	for _, p := range s.policies {
+		if slices.Contains(p.Members, userID) {
+			p.Members = nil
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryRetention) PendingBackfills(ctx context.Context) ([]RetentionBackfill, error) {
+	var out []RetentionBackfill
+	for userID, at := range s.backfills {
+		out = append(out, RetentionBackfill{UserID: userID, RequestedAt: at})
+	}
+	return out, nil
+}
+
+func (s *memoryRetention) BackfillDone(ctx context.Context, b RetentionBackfill) error {
+	if s.backfills[b.UserID].Equal(b.RequestedAt) {
+		delete(s.backfills, b.UserID)
+	}
+	return nil
+}
+
+// stampingDAL is the memory DAL recording the retention each backfill
+// stamps.
+type stampingDAL struct {
+	*MemoryDAL
+	stamped map[string]time.Duration
+}
+
+func (d *stampingDAL) stampRetention(ctx context.Context, userID string, keep time.Duration) error {
+	d.stamped[userID] = keep
+	return nil
+}
+
+// setupRetention runs retention against empty in-memory stores.
+func setupRetention(t *testing.T) (*memoryRetention, *stampingDAL) {
+	t.Helper()
+	oldStore, oldRetention := Store, Retention
+	forgetAllRetention := func() {
+		retentionCache.Lock()
+		retentionCache.users = nil
+		retentionCache.Unlock()
+	}
+	t.Cleanup(func() {
+		Store, Retention = oldStore, oldRetention
+		forgetAllRetention()
+	})
+	forgetAllRetention()
+	r := newMemoryRetention()
+	d := &stampingDAL{MemoryDAL: NewMemoryDAL(), stamped: map[string]time.Duration{}}
+	Store, Retention = d, r
+	return r, d
+}
+
+func putPolicy(t *testing.T, p RetentionPolicy) {
+	t.Helper()
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Retention.PutPolicy(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const day = 24 * time.Hour
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	p := RetentionPolicy{Scope: RetentionGroup, ID: "year-9", Days: 30, Members: []string{"b", "a", "b"}}
+	if err := p.Validate(); err != nil || !slices.Equal(p.Members, []string{"a", "b"}) {
+		t.Errorf("group policy: %v, members %v; want them sorted without duplicates", err, p.Members)
+	}
+	p = RetentionPolicy{Scope: RetentionUser, ID: "student-1", Days: 30}
+	if err := p.Validate(); err != nil || !slices.Equal(p.Members, []string{"student-1"}) {
+		t.Errorf("user policy: %v, members %v; want the user", err, p.Members)
+	}
+	for _, bad := range []RetentionPolicy{
+		{Scope: "school", ID: "x", Days: 30},
+		{Scope: RetentionCourse, ID: "", Days: 30},
+		{Scope: RetentionCourse, ID: "a#b", Days: 30},
+		{Scope: RetentionCourse, ID: "maths", Days: 0},
+		{Scope: RetentionCourse, ID: "maths", Days: maxRetentionDays + 1},
+		{Scope: RetentionCourse, ID: "maths", Days: 30, Members: []string{""}},
+		{Scope: RetentionUser, ID: "student-1", Days: 30, Members: []string{"student-2"}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%+v is valid", bad)
+		}
+	}
+}
+
+func TestUserRetention(t *testing.T) {
+	r, _ := setupRetention(t)
+	ctx := context.Background()
+	putPolicy(t, RetentionPolicy{Scope: RetentionGroup, ID: "year-9", Days: 90, Members: []string{"student-1", "student-2"}})
+	putPolicy(t, RetentionPolicy{Scope: RetentionCourse, ID: "maths", Days: 30, Members: []string{"student-1", "student-2"}})
+	putPolicy(t, RetentionPolicy{Scope: RetentionUser, ID: "student-2", Days: 365})
+
+	cases := []struct {
+		user string
+		want time.Duration
+	}{
+		{"student-1", 30 * day},  // the shortest of their group and course
+		{"student-2", 365 * day}, // their own policy, though it is longer
+		{"student-3", 0},         // no policy
+	}
+	for _, c := range cases {
+		if got, err := userRetention(ctx, c.user); err != nil || got != c.want {
+			t.Errorf("userRetention(%s) = %v, %v; want %v", c.user, got, err, c.want)
+		}
+	}
+
+	// Resolved retention is cached until a policy change forgets it.
+	lookups := r.lookups
+	if err := Retention.DeletePolicy(ctx, RetentionCourse, "maths"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := userRetention(ctx, "student-1"); got != 30*day || r.lookups != lookups {
+		t.Errorf("cached retention = %v after %d lookups, want 30 days and none", got, r.lookups-lookups)
+	}
+	forgetRetention([]string{"student-1"})
+	if got, _ := userRetention(ctx, "student-1"); got != 90*day {
+		t.Errorf("after forgetting: %v, want the group's 90 days", got)
+	}
+}
+
+// chatAt writes a conversation whose messages were sent at each of ages
+// ago, and returns its ID.
+func chatAt(t *testing.T, userID string, ages ...time.Duration) string {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	c := Conversation{ID: GenerateULID(), UserID: userID, Title: "chat", CreatedAt: now.Add(-ages[0])}
+	if _, err := Store.ImportConversation(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	for _, age := range ages {
+		m := ChatMessage{ID: GenerateULID(), ConversationID: c.ID, UserID: userID, Role: "user", Content: "hi", CreatedAt: now.Add(-age)}
+		if err := Store.PutMessage(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c.ID
+}
+
+func TestPurgeExpired(t *testing.T) {
+	r, d := setupRetention(t)
+	ctx := context.Background()
+	putPolicy(t, RetentionPolicy{Scope: RetentionCourse, ID: "maths", Days: 30, Members: []string{"student-1", "student-2"}})
+	putPolicy(t, RetentionPolicy{Scope: RetentionUser, ID: "student-2", Days: 60})
+
+	old := chatAt(t, "student-1", 40*day)
+	mixed := chatAt(t, "student-1", 40*day, 35*day, day)
+	trashed := chatAt(t, "student-1", 40*day)
+	if err := Store.TrashConversation(ctx, "student-1", trashed, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	ownPolicy := chatAt(t, "student-2", 40*day)
+	noPolicy := chatAt(t, "student-3", 400*day)
+
+	res, err := PurgeExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (PurgeResult{Backfilled: 2, Conversations: 2, Messages: 2}); res != want {
+		t.Errorf("first run = %+v, want %+v", res, want)
+	}
+	if want := map[string]time.Duration{"student-1": 30 * day, "student-2": 60 * day}; !maps.Equal(d.stamped, want) {
+		t.Errorf("stamped %v, want %v", d.stamped, want)
+	}
+	if pending, _ := r.PendingBackfills(ctx); len(pending) != 0 {
+		t.Errorf("backfills still pending: %+v", pending)
+	}
+
+	exists := func(userID, id string) bool {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		return d.ownsLocked(userID, id, false)
+	}
+	for _, id := range []string{old, trashed} {
+		if exists("student-1", id) {
+			t.Errorf("conversation %s last active 40 days ago was kept", id)
+		}
+	}
+	if !exists("student-2", ownPolicy) || !exists("student-3", noPolicy) {
+		t.Error("a conversation within its user's retention was purged")
+	}
+	c, err := Store.GetConversation(ctx, "student-1", mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := Store.ListMessages(ctx, mixed, 50, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || c.MessageCount != 1 {
+		t.Errorf("mixed conversation has %d messages (count %d), want the recent one", len(page.Items), c.MessageCount)
+	}
+
+	// A second run has nothing to do.
+	if res, err := PurgeExpired(ctx); err != nil || res != (PurgeResult{}) {
+		t.Errorf("second run = %+v, %v; want nothing done", res, err)
+	}
+
+	// Dropping student-2's own policy re-stamps them with the course's
+	// shorter one, and their 40-day-old chat goes.
+	if err := Retention.DeletePolicy(ctx, RetentionUser, "student-2"); err != nil {
+		t.Fatal(err)
+	}
+	res, err = PurgeExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (PurgeResult{Backfilled: 1, Conversations: 1}); res != want {
+		t.Errorf("after the policy change = %+v, want %+v", res, want)
+	}
+	if d.stamped["student-2"] != 30*day || exists("student-2", ownPolicy) {
+		t.Errorf("student-2 stamped %v, conversation kept %v", d.stamped["student-2"], exists("student-2", ownPolicy))
+	}
+}
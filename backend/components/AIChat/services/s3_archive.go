@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3ObjectStore keeps archives in an S3 bucket. ARCHIVE_ENDPOINT points it
+// at another S3-compatible server instead, such as MinIO for local runs
+// (http://localhost:9000), which is addressed path-style.
+type s3ObjectStore struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3ObjectStore(cfg aws.Config, bucket string) *s3ObjectStore {
+	endpoint := os.Getenv("ARCHIVE_ENDPOINT")
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3ObjectStore{client: client, bucket: bucket}
+}
+
+func (s *s3ObjectStore) PutObject(ctx context.Context, key string, body []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/gzip"),
+	})
+	return err
+}
+
+func (s *s3ObjectStore) GetObject(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	var nsk *s3types.NoSuchKey
+	if errors.As(err, &nsk) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (s *s3ObjectStore) DeleteObject(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *s3ObjectStore) ListObjects(ctx context.Context, prefix, token string) (ListPage[ObjectInfo], error) {
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if token != "" {
+		in.ContinuationToken = aws.String(token)
+	}
+	out, err := s.client.ListObjectsV2(ctx, in)
+	if err != nil {
+		return ListPage[ObjectInfo]{}, err
+	}
+	var page ListPage[ObjectInfo]
+	for _, o := range out.Contents {
+		page.Items = append(page.Items, ObjectInfo{Key: aws.ToString(o.Key), LastModified: aws.ToTime(o.LastModified)})
+	}
+	if aws.ToBool(out.IsTruncated) {
+		page.NextToken = aws.ToString(out.NextContinuationToken)
+	}
+	return page, nil
+}
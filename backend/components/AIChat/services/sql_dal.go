@@ -0,0 +1,730 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLDAL is the DAL for SQL databases (SQLite and PostgreSQL). Timestamps
+// are stored as UTC Unix nanoseconds and pages are keyset queries on the
+// same orderings the Dynamo table uses: conversations most recently active
+// first (trash and expired trash by ID and deletedAt), messages by
+// (createdAt, ID), user messages by (createdAt, conversation,
+// ID). NextToken encodes the position after the last returned item and is
+// only set when more items follow.
+type SQLDAL struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// sqlDialect covers what differs between the supported databases.
+type sqlDialect struct {
+	// numbered placeholders ($1, $2) instead of ?.
+	numbered bool
+	// fkCascade: deleting a conversation deletes its messages through a
+	// foreign key, so DeleteConversationCascade need not.
+	fkCascade bool
+}
+
+func (d *SQLDAL) Close() error { return d.db.Close() }
+
+// q rewrites ? placeholders for the dialect. Queries here never contain a
+// literal '?'.
+func (d *SQLDAL) q(query string) string {
+	if !d.dialect.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// sqlConn is satisfied by *sql.DB and by a single *sql.Conn.
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// migrate applies the migrations not yet recorded in schema_migrations,
+// each in its own transaction. Version n is migrations[n-1].
+func migrate(ctx context.Context, db sqlConn, migrations []string) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	var current int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for v := current + 1; v <= len(migrations); v++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[v-1]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", v, err)
+		}
+		// v is an int we control; formatting it in keeps this placeholder-agnostic.
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO schema_migrations (version) VALUES (%d)`, v)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlCursor is the position after the last item of a page. Conversation
+// pages keep activity_at (deleted_at for expired trash) in CreatedAt;
+// trash pages only use ID.
+type sqlCursor struct {
+	CreatedAt      int64  `json:"t,omitempty"`
+	ConversationID string `json:"c,omitempty"`
+	ID             string `json:"i"`
+}
+
+func encodeSQLCursor(scope cursorScope, c sqlCursor) string {
+	b, _ := json.Marshal(c)
+	return sealCursor(scope, b)
+}
+
+func decodeSQLCursor(scope cursorScope, token string) (*sqlCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	b, err := openCursor(scope, token)
+	if err != nil {
+		return nil, err
+	}
+	var c sqlCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+	return &c, nil
+}
+
+func (d *SQLDAL) CreateConversation(ctx context.Context, userID, title string) (string, error) {
+	id := GenerateULID()
+	now := time.Now().UTC().UnixNano()
+	_, err := d.db.ExecContext(ctx,
+		d.q(`INSERT INTO conversations (id, user_id, title, created_at, activity_at) VALUES (?, ?, ?, ?, ?)`),
+		id, userID, title, now, now)
+	return id, err
+}
+
+func (d *SQLDAL) ImportConversation(ctx context.Context, c Conversation) (bool, error) {
+	at := c.CreatedAt.UTC().UnixNano()
+	res, err := d.db.ExecContext(ctx,
+		d.q(`INSERT INTO conversations (id, user_id, title, created_at, activity_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`),
+		c.ID, c.UserID, c.Title, at, at)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return n == 1, err
+	}
+	if _, err := d.GetConversation(ctx, c.UserID, c.ID); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (d *SQLDAL) GetConversation(ctx context.Context, userID, conversationID string) (Conversation, error) {
+	page, _, err := d.conversationPage(ctx, 1,
+		`SELECT `+sqlConversationColumns+` FROM conversations WHERE id = ? AND user_id = ?`, conversationID, userID)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if len(page.Items) == 0 {
+		return Conversation{}, ErrConversationNotFound
+	}
+	return page.Items[0], nil
+}
+
+func (d *SQLDAL) UpdateConversation(ctx context.Context, userID, conversationID string, u ConversationUpdate) (Conversation, error) {
+	q := `UPDATE conversations SET version = version + 1`
+	var args []any
+	if u.Title != nil {
+		q += `, title = ?`
+		args = append(args, *u.Title)
+	}
+	if u.Settings != nil {
+		q += `, settings_language = ?`
+		args = append(args, u.Settings.Language)
+	}
+	q += ` WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	args = append(args, conversationID, userID)
+	if u.IfVersion != nil {
+		q += ` AND version = ?`
+		args = append(args, *u.IfVersion)
+	}
+	err := d.updateOne(ctx, q, args...)
+	if errors.Is(err, ErrConversationNotFound) && u.IfVersion != nil {
+		// Tell a stale version from a missing conversation.
+		if c, gerr := d.GetConversation(ctx, userID, conversationID); gerr == nil && c.DeletedAt == nil {
+			err = ErrVersionConflict
+		}
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+	return d.GetConversation(ctx, userID, conversationID)
+}
+
+// ListConversations pages by (activity_at, ID), newest first; the cursor
+// keeps activity_at in CreatedAt.
+func (d *SQLDAL) ListConversations(ctx context.Context, userID string, limit int32, nextToken string) (ListPage[Conversation], error) {
+	scope := conversationsCursor(userID)
+	if limit < 1 {
+		return ListPage[Conversation]{}, errInvalidLimit
+	}
+	after, err := decodeSQLCursor(scope, nextToken)
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
+	q := `SELECT ` + sqlConversationColumns + ` FROM conversations WHERE user_id = ? AND deleted_at IS NULL`
+	args := []any{userID}
+	if after != nil {
+		q += ` AND (activity_at, id) < (?, ?)`
+		args = append(args, after.CreatedAt, after.ID)
+	}
+	q += ` ORDER BY activity_at DESC, id DESC LIMIT ?`
+	args = append(args, limit+1)
+	page, more, err := d.conversationPage(ctx, limit, q, args...)
+	if more {
+		last := page.Items[limit-1]
+		page.NextToken = encodeSQLCursor(scope, sqlCursor{CreatedAt: last.ActivityAt().UnixNano(), ID: last.ID})
+	}
+	return page, err
+}
+
+// ListTrashedConversations pages by ID, newest first.
+func (d *SQLDAL) ListTrashedConversations(ctx context.Context, userID string, limit int32, nextToken string) (ListPage[Conversation], error) {
+	scope := trashCursor(userID)
+	if limit < 1 {
+		return ListPage[Conversation]{}, errInvalidLimit
+	}
+	after, err := decodeSQLCursor(scope, nextToken)
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
+	q := `SELECT ` + sqlConversationColumns + ` FROM conversations WHERE user_id = ? AND deleted_at IS NOT NULL`
+	args := []any{userID}
+	if after != nil {
+		q += ` AND id < ?`
+		args = append(args, after.ID)
+	}
+	// One extra row tells whether there is another page.
+	q += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit+1)
+	page, more, err := d.conversationPage(ctx, limit, q, args...)
+	if more {
+		page.NextToken = encodeSQLCursor(scope, sqlCursor{ID: page.Items[limit-1].ID})
+	}
+	return page, err
+}
+
+// ListExpiredTrash pages trashed conversations by (deletedAt, ID).
+func (d *SQLDAL) ListExpiredTrash(ctx context.Context, deletedBefore time.Time, limit int32, nextToken string) (ListPage[Conversation], error) {
+	scope := expiredTrashCursor(deletedBefore)
+	if limit < 1 {
+		return ListPage[Conversation]{}, errInvalidLimit
+	}
+	after, err := decodeSQLCursor(scope, nextToken)
+	if err != nil {
+		return ListPage[Conversation]{}, err
+	}
+	q := `SELECT ` + sqlConversationColumns + ` FROM conversations WHERE deleted_at IS NOT NULL AND deleted_at < ?`
+	args := []any{deletedBefore.UTC().UnixNano()}
+	if after != nil {
+		q += ` AND (deleted_at, id) > (?, ?)`
+		args = append(args, after.CreatedAt, after.ID)
+	}
+	q += ` ORDER BY deleted_at, id LIMIT ?`
+	args = append(args, limit+1)
+	page, more, err := d.conversationPage(ctx, limit, q, args...)
+	if more {
+		last := page.Items[limit-1]
+		page.NextToken = encodeSQLCursor(scope, sqlCursor{CreatedAt: last.DeletedAt.UnixNano(), ID: last.ID})
+	}
+	return page, err
+}
+
+const sqlConversationColumns = `id, user_id, title, created_at, last_message_at, deleted_at,
+	last_message_preview, message_count, prompt_tokens, completion_tokens,
+	settings_language, version`
+
+// conversationPage runs a query for limit+1 conversations and reports
+// whether the extra row came back; callers build the NextToken for their
+// ordering.
+func (d *SQLDAL) conversationPage(ctx context.Context, limit int32, q string, args ...any) (ListPage[Conversation], bool, error) {
+	rows, err := d.db.QueryContext(ctx, d.q(q), args...)
+	if err != nil {
+		return ListPage[Conversation]{}, false, err
+	}
+	defer rows.Close()
+	var page ListPage[Conversation]
+	for rows.Next() {
+		var c Conversation
+		var created int64
+		var last, deleted sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Title, &created, &last, &deleted,
+			&c.LastMessagePreview, &c.MessageCount, &c.PromptTokens, &c.CompletionTokens,
+			&c.Settings.Language, &c.Version); err != nil {
+			return ListPage[Conversation]{}, false, err
+		}
+		c.CreatedAt = time.Unix(0, created).UTC()
+		c.LastMessageAt = nullTime(last)
+		c.DeletedAt = nullTime(deleted)
+		page.Items = append(page.Items, c)
+	}
+	if err := rows.Err(); err != nil {
+		return ListPage[Conversation]{}, false, err
+	}
+	if len(page.Items) > int(limit) {
+		page.Items = page.Items[:limit]
+		return page, true, nil
+	}
+	return page, false, nil
+}
+
+// nullTime converts a nullable Unix-nanosecond column.
+func nullTime(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(0, n.Int64).UTC()
+	return &t
+}
+
+func (d *SQLDAL) TrashConversation(ctx context.Context, userID, conversationID string, at time.Time) error {
+	return d.updateOne(ctx, `UPDATE conversations SET deleted_at = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+		at.UTC().UnixNano(), conversationID, userID)
+}
+
+func (d *SQLDAL) RestoreConversation(ctx context.Context, userID, conversationID string, deletedAfter time.Time) error {
+	return d.updateOne(ctx, `UPDATE conversations SET deleted_at = NULL WHERE id = ? AND user_id = ? AND deleted_at >= ?`,
+		conversationID, userID, deletedAfter.UTC().UnixNano())
+}
+
+// updateOne runs a statement that should change exactly one conversation
+// and reports ErrConversationNotFound when it changed none.
+func (d *SQLDAL) updateOne(ctx context.Context, query string, args ...any) error {
+	res, err := d.db.ExecContext(ctx, d.q(query), args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// PutMessage replaces any message with the same conversation, timestamp and
+// ID, like a Dynamo PutItem on the same key.
+func (d *SQLDAL) PutMessage(ctx context.Context, m ChatMessage) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := d.requireActive(ctx, tx, m.UserID, m.ConversationID); err != nil {
+		return err
+	}
+	if err := d.storeMessage(ctx, tx, m); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *SQLDAL) PutExchange(ctx context.Context, userMsg, botMsg ChatMessage) error {
+	if err := checkExchange(userMsg, botMsg); err != nil {
+		return err
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := d.requireActive(ctx, tx, userMsg.UserID, userMsg.ConversationID); err != nil {
+		return err
+	}
+	for _, m := range []ChatMessage{userMsg, botMsg} {
+		if err := d.storeMessage(ctx, tx, m); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PutMessages writes all messages in one transaction, so there are no
+// partial failures.
+func (d *SQLDAL) PutMessages(ctx context.Context, msgs []ChatMessage) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range msgs {
+		if err := d.storeMessage(ctx, tx, m); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// requireActive fails with ErrConversationNotFound unless userID owns the
+// conversation and it is not in the trash.
+func (d *SQLDAL) requireActive(ctx context.Context, tx *sql.Tx, userID, conversationID string) error {
+	var active int
+	err := tx.QueryRowContext(ctx, d.q(`SELECT COUNT(*) FROM conversations WHERE id = ? AND user_id = ? AND deleted_at IS NULL`),
+		conversationID, userID).Scan(&active)
+	if err == nil && active == 0 {
+		err = ErrConversationNotFound
+	}
+	return err
+}
+
+// storeMessage upserts m and, if it is new, adds it to its conversation's
+// activity. It fails with ErrConversationNotFound, leaving tx to be rolled
+// back, when m.UserID does not own the conversation.
+func (d *SQLDAL) storeMessage(ctx context.Context, tx *sql.Tx, m ChatMessage) error {
+	at := m.CreatedAt.UTC().UnixNano()
+	var existing int
+	err := tx.QueryRowContext(ctx, d.q(`SELECT COUNT(*) FROM messages WHERE conversation_id = ? AND created_at = ? AND id = ?`),
+		m.ConversationID, at, m.ID).Scan(&existing)
+	if err != nil {
+		return err
+	}
+	editedAt, edits := sqlEdits(m)
+	_, err = tx.ExecContext(ctx, d.q(`INSERT INTO messages (
+		conversation_id, created_at, id, user_id, role, content,
+		template_id, template_version, experiment_id, variant,
+		model, prompt_tokens, completion_tokens,
+		language, translation, translation_language,
+		edited_at, edits
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (conversation_id, created_at, id) DO UPDATE SET
+		user_id = excluded.user_id, role = excluded.role, content = excluded.content,
+		template_id = excluded.template_id, template_version = excluded.template_version,
+		experiment_id = excluded.experiment_id, variant = excluded.variant,
+		model = excluded.model, prompt_tokens = excluded.prompt_tokens, completion_tokens = excluded.completion_tokens,
+		language = excluded.language, translation = excluded.translation, translation_language = excluded.translation_language,
+		edited_at = excluded.edited_at, edits = excluded.edits`),
+		m.ConversationID, at, m.ID, m.UserID, m.Role, m.Content,
+		m.TemplateID, m.TemplateVersion, m.ExperimentID, m.Variant,
+		m.Model, m.PromptTokens, m.CompletionTokens,
+		m.Language, m.Translation, m.TranslationLanguage,
+		editedAt, edits)
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		var owned int
+		err := tx.QueryRowContext(ctx, d.q(`SELECT COUNT(*) FROM conversations WHERE id = ? AND user_id = ?`),
+			m.ConversationID, m.UserID).Scan(&owned)
+		if err == nil && owned == 0 {
+			err = ErrConversationNotFound
+		}
+		return err
+	}
+	// SET expressions all see the old row, so each CASE compares against
+	// the previous last_message_at.
+	res, err := tx.ExecContext(ctx, d.q(`UPDATE conversations SET
+		message_count = message_count + 1,
+		prompt_tokens = prompt_tokens + ?,
+		completion_tokens = completion_tokens + ?,
+		last_message_preview = CASE WHEN last_message_at IS NULL OR last_message_at <= ? THEN ? ELSE last_message_preview END,
+		activity_at = CASE WHEN last_message_at IS NULL OR last_message_at <= ? THEN ? ELSE activity_at END,
+		last_message_at = CASE WHEN last_message_at IS NULL OR last_message_at <= ? THEN ? ELSE last_message_at END
+	WHERE id = ? AND user_id = ?`),
+		m.PromptTokens, m.CompletionTokens,
+		at, messagePreview(m.Content),
+		at, at,
+		at, at,
+		m.ConversationID, m.UserID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+const sqlMessageColumns = `conversation_id, created_at, id, user_id, role, content,
+	template_id, template_version, experiment_id, variant,
+	model, prompt_tokens, completion_tokens,
+	language, translation, translation_language,
+	edited_at, edits`
+
+// sqlEdits returns the edited_at and edits columns of m.
+func sqlEdits(m ChatMessage) (sql.NullInt64, string) {
+	if m.EditedAt == nil {
+		return sql.NullInt64{}, ""
+	}
+	b, _ := json.Marshal(m.Edits)
+	return sql.NullInt64{Int64: m.EditedAt.UTC().UnixNano(), Valid: true}, string(b)
+}
+
+func (d *SQLDAL) GetMessage(ctx context.Context, conversationID, messageID string) (ChatMessage, error) {
+	return d.getMessage(ctx, d.db, conversationID, messageID)
+}
+
+// sqlQueryer is what getMessage needs from *sql.DB or *sql.Tx.
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (d *SQLDAL) getMessage(ctx context.Context, db sqlQueryer, conversationID, messageID string) (ChatMessage, error) {
+	rows, err := db.QueryContext(ctx, d.q(`SELECT `+sqlMessageColumns+` FROM messages WHERE conversation_id = ? AND id = ?`),
+		conversationID, messageID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return ChatMessage{}, err
+		}
+		return ChatMessage{}, ErrMessageNotFound
+	}
+	return scanSQLMessage(rows)
+}
+
+// ownsActive checks in tx that userID has the conversation and it is not
+// in the trash.
+func (d *SQLDAL) ownsActive(ctx context.Context, tx *sql.Tx, userID, conversationID string) error {
+	var n int
+	err := tx.QueryRowContext(ctx, d.q(`SELECT COUNT(*) FROM conversations WHERE id = ? AND user_id = ? AND deleted_at IS NULL`),
+		conversationID, userID).Scan(&n)
+	if err == nil && n == 0 {
+		err = ErrConversationNotFound
+	}
+	return err
+}
+
+func (d *SQLDAL) UpdateMessage(ctx context.Context, userID, conversationID, messageID, content string, at time.Time) (ChatMessage, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	defer tx.Rollback()
+	if err := d.ownsActive(ctx, tx, userID, conversationID); err != nil {
+		return ChatMessage{}, err
+	}
+	m, err := d.getMessage(ctx, tx, conversationID, messageID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	m = editMessage(m, content, at)
+	editedAt, edits := sqlEdits(m)
+	created := m.CreatedAt.UnixNano()
+	if _, err := tx.ExecContext(ctx, d.q(`UPDATE messages SET content = ?, edited_at = ?, edits = ?
+		WHERE conversation_id = ? AND created_at = ? AND id = ?`),
+		m.Content, editedAt, edits, conversationID, created, messageID); err != nil {
+		return ChatMessage{}, err
+	}
+	if _, err := tx.ExecContext(ctx, d.q(`UPDATE conversations SET last_message_preview = ?
+		WHERE id = ? AND last_message_at = ?`),
+		messagePreview(m.Content), conversationID, created); err != nil {
+		return ChatMessage{}, err
+	}
+	return m, tx.Commit()
+}
+
+// DeleteMessage subtracts the message from the totals and, as it may have
+// been the newest, recomputes lastMessageAt and the preview from the rest.
+func (d *SQLDAL) DeleteMessage(ctx context.Context, userID, conversationID, messageID string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := d.ownsActive(ctx, tx, userID, conversationID); err != nil {
+		return err
+	}
+	m, err := d.getMessage(ctx, tx, conversationID, messageID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, d.q(`DELETE FROM messages WHERE conversation_id = ? AND created_at = ? AND id = ?`),
+		conversationID, m.CreatedAt.UnixNano(), messageID); err != nil {
+		return err
+	}
+	var last sql.NullInt64
+	var preview string
+	err = tx.QueryRowContext(ctx, d.q(`SELECT created_at, content FROM messages WHERE conversation_id = ?
+		ORDER BY created_at DESC, id DESC LIMIT 1`), conversationID).Scan(&last, &preview)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if last.Valid {
+		preview = messagePreview(preview)
+	}
+	if _, err := tx.ExecContext(ctx, d.q(`UPDATE conversations SET
+		message_count = message_count - 1,
+		prompt_tokens = prompt_tokens - ?,
+		completion_tokens = completion_tokens - ?,
+		last_message_at = ?,
+		last_message_preview = ?,
+		activity_at = COALESCE(?, created_at)
+	WHERE id = ?`),
+		m.PromptTokens, m.CompletionTokens, last, preview, last, conversationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *SQLDAL) ListMessages(ctx context.Context, conversationID string, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error) {
+	return d.ListMessagesRange(ctx, conversationID, MessageRange{}, limit, nextToken, newestFirst)
+}
+
+func (d *SQLDAL) ListMessagesRange(ctx context.Context, conversationID string, r MessageRange, limit int32, nextToken string, newestFirst bool) (ListPage[ChatMessage], error) {
+	scope := messagesCursor(conversationID, r, newestFirst)
+	if limit < 1 {
+		return ListPage[ChatMessage]{}, errInvalidLimit
+	}
+	after, err := decodeSQLCursor(scope, nextToken)
+	if err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
+	cmp, order := ">", "ASC"
+	if newestFirst {
+		cmp, order = "<", "DESC"
+	}
+	q := `SELECT ` + sqlMessageColumns + ` FROM messages WHERE conversation_id = ?`
+	args := []any{conversationID}
+	for _, b := range []struct{ id, cmp string }{{r.After, ">"}, {r.Before, "<"}} {
+		if b.id == "" {
+			continue
+		}
+		var at int64
+		err := d.db.QueryRowContext(ctx, d.q(`SELECT created_at FROM messages WHERE conversation_id = ? AND id = ?`), conversationID, b.id).Scan(&at)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ListPage[ChatMessage]{}, ErrMessageNotFound
+		} else if err != nil {
+			return ListPage[ChatMessage]{}, err
+		}
+		q += ` AND (created_at, id) ` + b.cmp + ` (?, ?)`
+		args = append(args, at, b.id)
+	}
+	if after != nil {
+		q += ` AND (created_at, id) ` + cmp + ` (?, ?)`
+		args = append(args, after.CreatedAt, after.ID)
+	}
+	q += ` ORDER BY created_at ` + order + `, id ` + order + ` LIMIT ?`
+	args = append(args, limit+1)
+	return d.messagePage(ctx, scope, limit, q, args...)
+}
+
+func (d *SQLDAL) DeleteConversationCascade(ctx context.Context, userID, conversationID string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, d.q(`DELETE FROM conversations WHERE id = ? AND user_id = ?`), conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConversationNotFound
+	}
+	if !d.dialect.fkCascade {
+		if _, err := tx.ExecContext(ctx, d.q(`DELETE FROM messages WHERE conversation_id = ?`), conversationID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *SQLDAL) ListUserMessagesSince(ctx context.Context, userID string, since time.Time, limit int32, nextToken string) (ListPage[ChatMessage], error) {
+	scope := userMessagesCursor(userID, since)
+	if limit < 1 {
+		return ListPage[ChatMessage]{}, errInvalidLimit
+	}
+	after, err := decodeSQLCursor(scope, nextToken)
+	if err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
+	q := `SELECT ` + sqlMessageColumns + ` FROM messages WHERE user_id = ? AND created_at >= ?`
+	args := []any{userID, since.UTC().UnixNano()}
+	if after != nil {
+		q += ` AND (created_at, conversation_id, id) > (?, ?, ?)`
+		args = append(args, after.CreatedAt, after.ConversationID, after.ID)
+	}
+	q += ` ORDER BY created_at, conversation_id, id LIMIT ?`
+	args = append(args, limit+1)
+	return d.messagePage(ctx, scope, limit, q, args...)
+}
+
+// messagePage runs a query for limit+1 messages and turns the extra row
+// into a NextToken.
+func (d *SQLDAL) messagePage(ctx context.Context, scope cursorScope, limit int32, q string, args ...any) (ListPage[ChatMessage], error) {
+	rows, err := d.db.QueryContext(ctx, d.q(q), args...)
+	if err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
+	defer rows.Close()
+	var page ListPage[ChatMessage]
+	for rows.Next() {
+		m, err := scanSQLMessage(rows)
+		if err != nil {
+			return ListPage[ChatMessage]{}, err
+		}
+		page.Items = append(page.Items, m)
+	}
+	if err := rows.Err(); err != nil {
+		return ListPage[ChatMessage]{}, err
+	}
+	if len(page.Items) > int(limit) {
+		page.Items = page.Items[:limit]
+		last := page.Items[limit-1]
+		page.NextToken = encodeSQLCursor(scope, sqlCursor{CreatedAt: last.CreatedAt.UnixNano(), ConversationID: last.ConversationID, ID: last.ID})
+	}
+	return page, nil
+}
+
+// scanSQLMessage reads a row selected with sqlMessageColumns.
+func scanSQLMessage(rows *sql.Rows) (ChatMessage, error) {
+	var m ChatMessage
+	var created int64
+	var editedAt sql.NullInt64
+	var edits string
+	err := rows.Scan(&m.ConversationID, &created, &m.ID, &m.UserID, &m.Role, &m.Content,
+		&m.TemplateID, &m.TemplateVersion, &m.ExperimentID, &m.Variant,
+		&m.Model, &m.PromptTokens, &m.CompletionTokens,
+		&m.Language, &m.Translation, &m.TranslationLanguage,
+		&editedAt, &edits)
+	m.CreatedAt = time.Unix(0, created).UTC()
+	m.EditedAt = nullTime(editedAt)
+	if err == nil && edits != "" {
+		err = json.Unmarshal([]byte(edits), &m.Edits)
+	}
+	return m, err
+}
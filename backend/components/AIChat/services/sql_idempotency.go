@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// sqlIdempotencyStore keeps idempotency keys in the idempotency_keys table,
+// with the same claim rules as the DynamoDB store. A user's expired keys
+// are deleted when they claim another.
+type sqlIdempotencyStore struct {
+	d *SQLDAL
+}
+
+// Begin claims a new key, or takes over a stale claim of the same request,
+// in one upsert; concurrent duplicates conflict on the primary key and all
+// but one find the row unchanged.
+func (s *sqlIdempotencyStore) Begin(ctx context.Context, userID, key, fingerprint string) (*StoredResponse, error) {
+	now := time.Now().UTC()
+	if _, err := s.d.db.ExecContext(ctx, s.d.q(`DELETE FROM idempotency_keys WHERE user_id = ? AND expires_at < ?`),
+		userID, now.UnixNano()); err != nil {
+		return nil, err
+	}
+	res, err := s.d.db.ExecContext(ctx, s.d.q(`INSERT INTO idempotency_keys
+		(user_id, idem_key, fingerprint, status, started_at, expires_at, response)
+		VALUES (?, ?, ?, ?, ?, ?, '')
+		ON CONFLICT (user_id, idem_key) DO UPDATE SET started_at = excluded.started_at, expires_at = excluded.expires_at
+		WHERE idempotency_keys.status = excluded.status AND idempotency_keys.started_at < ?
+			AND idempotency_keys.fingerprint = excluded.fingerprint`),
+		userID, key, fingerprint, idempotencyPending, now.UnixNano(), now.Add(IdempotencyTTL).UnixNano(),
+		now.Add(-idempotencyLockTimeout).UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	var storedFingerprint, status, response string
+	err = s.d.db.QueryRowContext(ctx, s.d.q(`SELECT fingerprint, status, response FROM idempotency_keys
+		WHERE user_id = ? AND idem_key = ?`), userID, key).Scan(&storedFingerprint, &status, &response)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, ErrIdempotencyInProgress // released since; the retry claims it
+	case err != nil:
+		return nil, err
+	case storedFingerprint != fingerprint:
+		return nil, ErrIdempotencyMismatch
+	case status != idempotencyDone:
+		return nil, ErrIdempotencyInProgress
+	}
+	var resp StoredResponse
+	if err := json.Unmarshal([]byte(response), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (s *sqlIdempotencyStore) Complete(ctx context.Context, userID, key string, resp StoredResponse) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = s.d.db.ExecContext(ctx, s.d.q(`UPDATE idempotency_keys SET status = ?, response = ?, expires_at = ?
+		WHERE user_id = ? AND idem_key = ? AND status = ?`),
+		idempotencyDone, string(b), time.Now().Add(IdempotencyTTL).UnixNano(), userID, key, idempotencyPending)
+	return err
+}
+
+func (s *sqlIdempotencyStore) Release(ctx context.Context, userID, key string) error {
+	_, err := s.d.db.ExecContext(ctx, s.d.q(`DELETE FROM idempotency_keys WHERE user_id = ? AND idem_key = ? AND status = ?`),
+		userID, key, idempotencyPending)
+	return err
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver (pure Go, no cgo)
+)
+
+// sqliteMigrations are applied in order, once each; append, never edit.
+var sqliteMigrations = []string{
+	`CREATE TABLE conversations (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		title      TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX conversations_user ON conversations (user_id, id);
+
+	CREATE TABLE messages (
+		conversation_id      TEXT NOT NULL,
+		created_at           INTEGER NOT NULL,
+		id                   TEXT NOT NULL,
+		user_id              TEXT NOT NULL,
+		role                 TEXT NOT NULL,
+		content              TEXT NOT NULL,
+		template_id          TEXT NOT NULL DEFAULT '',
+		template_version     INTEGER NOT NULL DEFAULT 0,
+		experiment_id        TEXT NOT NULL DEFAULT '',
+		variant              TEXT NOT NULL DEFAULT '',
+		model                TEXT NOT NULL DEFAULT '',
+		prompt_tokens        INTEGER NOT NULL DEFAULT 0,
+		completion_tokens    INTEGER NOT NULL DEFAULT 0,
+		language             TEXT NOT NULL DEFAULT '',
+		translation          TEXT NOT NULL DEFAULT '',
+		translation_language TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (conversation_id, created_at, id)
+	);
+	-- ListUserMessagesSince: per-user time ranges.
+	CREATE INDEX messages_user_time ON messages (user_id, created_at, conversation_id, id);`,
+
+	// v2: trash. deleted_at is set while a conversation is in the trash.
+	`ALTER TABLE conversations ADD COLUMN deleted_at INTEGER;
+	CREATE INDEX conversations_trash ON conversations (deleted_at, id) WHERE deleted_at IS NOT NULL;`,
+
+	// v3: time of the latest exchange, kept by PutExchange.
+	`ALTER TABLE conversations ADD COLUMN last_message_at INTEGER;`,
+
+	// v4: activity totals and ordering, backfilled from the messages.
+	// activity_at is last_message_at, or created_at without messages.
+	`ALTER TABLE conversations ADD COLUMN last_message_preview TEXT NOT NULL DEFAULT '';
+	ALTER TABLE conversations ADD COLUMN message_count INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE conversations ADD COLUMN prompt_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE conversations ADD COLUMN completion_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE conversations ADD COLUMN activity_at INTEGER NOT NULL DEFAULT 0;
+	UPDATE conversations SET
+		message_count = (SELECT COUNT(*) FROM messages m WHERE m.conversation_id = conversations.id),
+		prompt_tokens = (SELECT COALESCE(SUM(m.prompt_tokens), 0) FROM messages m WHERE m.conversation_id = conversations.id),
+		completion_tokens = (SELECT COALESCE(SUM(m.completion_tokens), 0) FROM messages m WHERE m.conversation_id = conversations.id),
+		last_message_at = (SELECT MAX(m.created_at) FROM messages m WHERE m.conversation_id = conversations.id),
+		last_message_preview = COALESCE((SELECT substr(m.content, 1, 120) FROM messages m
+			WHERE m.conversation_id = conversations.id ORDER BY m.created_at DESC, m.id DESC LIMIT 1), '');
+	UPDATE conversations SET activity_at = COALESCE(last_message_at, created_at);
+	CREATE INDEX conversations_activity ON conversations (user_id, activity_at DESC, id DESC) WHERE deleted_at IS NULL;`,
+
+	// v5: settings and optimistic locking for UpdateConversation.
+	`ALTER TABLE conversations ADD COLUMN settings_language TEXT NOT NULL DEFAULT '';
+	ALTER TABLE conversations ADD COLUMN version INTEGER NOT NULL DEFAULT 0;`,
+
+	// v6: message lookup by ID and edit history (JSON array of MessageEdit).
+	`ALTER TABLE messages ADD COLUMN edited_at INTEGER;
+	ALTER TABLE messages ADD COLUMN edits TEXT NOT NULL DEFAULT '';
+	CREATE INDEX messages_id ON messages (conversation_id, id);`,
+
+	// v7: Idempotency-Key claims and stored responses (see sqlIdempotencyStore).
+	`CREATE TABLE idempotency_keys (
+		user_id     TEXT NOT NULL,
+		idem_key    TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		status      TEXT NOT NULL,
+		started_at  INTEGER NOT NULL,
+		expires_at  INTEGER NOT NULL,
+		response    TEXT NOT NULL,
+		PRIMARY KEY (user_id, idem_key)
+	);`,
+}
+
+// NewSQLiteDAL opens (creating if needed) the SQLite database at path, for
+// running the services workflow without AWS, and brings its schema up to
+// date.
+func NewSQLiteDAL(ctx context.Context, path string) (*SQLDAL, error) {
+	// Transactions read before they write; taking the write lock up front
+	// makes concurrent ones wait (busy_timeout) instead of failing with
+	// SQLITE_BUSY when they upgrade.
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(ctx, db, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+	return &SQLDAL{db: db}, nil
+}
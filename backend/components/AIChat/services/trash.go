@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// TrashRetention is how long a deleted conversation stays in the trash and
+// can be restored: TRASH_RETENTION_DAYS, default 30. PurgeTrash removes it
+// for good after that.
+func TrashRetention() (time.Duration, error) {
+	days := 30
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("TRASH_RETENTION_DAYS must be a positive integer, got %q", v)
+		}
+		days = n
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// RestoreConversation takes a conversation out of the trash if it is still
+// within the retention period.
+func RestoreConversation(ctx context.Context, userID, conversationID string) error {
+	retention, err := TrashRetention()
+	if err != nil {
+		return err
+	}
+	return Store.RestoreConversation(ctx, userID, conversationID, time.Now().Add(-retention))
+}
+
+// PurgeTrash deletes every conversation that has been in the trash longer
+// than the retention period, with all of its messages, and returns how
+// many it deleted. A conversation that fails is logged and left for the
+// next run.
+func PurgeTrash(ctx context.Context) (int, error) {
+	retention, err := TrashRetention()
+	if err != nil {
+		return 0, err
+	}
+	before := time.Now().Add(-retention)
+	purged, failed := 0, 0
+	token := ""
+	for {
+		page, err := Store.ListExpiredTrash(ctx, before, 25, token)
+		if err != nil {
+			return purged, err
+		}
+		for _, c := range page.Items {
+			err := Store.DeleteConversationCascade(ctx, c.UserID, c.ID)
+			switch {
+			case err == nil:
+				purged++
+			case errors.Is(err, ErrConversationNotFound):
+				// Purged concurrently.
+			default:
+				failed++
+				log.Printf("❌ Purging conversation %s: %v", c.ID, err)
+			}
+		}
+		if page.NextToken == "" {
+			break
+		}
+		token = page.NextToken
+	}
+	if failed > 0 {
+		return purged, fmt.Errorf("%d conversation(s) could not be purged", failed)
+	}
+	return purged, nil
+}
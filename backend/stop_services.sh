@@ -12,7 +12,7 @@ echo -e "${BLUE}🛑 停止 AI 聊天机器人服务...${NC}"
 
 # 停止所有相关进程
 echo -e "${YELLOW}🔄 停止 Go 进程...${NC}"
-pkill -f "go run.*AIchat.go" 2>/dev/null
+pkill -f "go run . -local :5001" 2>/dev/null
 pkill -f "go run.*ApiGateway.go" 2>/dev/null
 
 # 停止编译后的进程